@@ -0,0 +1,57 @@
+package geerpc
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// accessLog 以 JSON Lines 格式记录每个请求
+type accessLog struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+type accessLogEntry struct {
+	Time     string  `json:"time"`
+	Method   string  `json:"method"`
+	Peer     string  `json:"peer,omitempty"`
+	Seq      uint64  `json:"seq"`
+	BytesIn  int64   `json:"bytes_in"`
+	BytesOut int64   `json:"bytes_out"`
+	Duration float64 `json:"duration_ms"`
+	Code     string  `json:"code"`
+	Error    string  `json:"error,omitempty"`
+}
+
+func newAccessLog(w io.Writer) *accessLog {
+	return &accessLog{enc: json.NewEncoder(w)}
+}
+
+// 写入一条访问日志，l 为 nil 时不做任何事
+func (l *accessLog) log(req *Request, err error, elapsed time.Duration) {
+	if l == nil {
+		return
+	}
+	entry := accessLogEntry{
+		Time:     time.Now().Format(time.RFC3339Nano),
+		Method:   req.serviceMethod(),
+		Seq:      req.H.Seq,
+		BytesIn:  req.bytesIn,
+		BytesOut: atomic.LoadInt64(&req.bytesOut),
+		Duration: float64(elapsed) / float64(time.Millisecond),
+		Code:     CodeOf(err).String(),
+	}
+	if req.Peer != nil && req.Peer.Addr != nil {
+		entry.Peer = req.Peer.Addr.String()
+	}
+	if err != nil {
+		entry.Error = err.Error()
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_ = l.enc.Encode(entry)
+}
@@ -0,0 +1,41 @@
+package geerpc
+
+import (
+	"context"
+	"errors"
+)
+
+// Identity 描述发起请求的调用方
+type Identity struct {
+	Peer *Peer // 客户端的地址与 TLS 证书信息
+	// Credentials 为客户端通过 WithCredentials 随本次调用发送的凭证，未设置时为空
+	Credentials string
+}
+
+// Authorizer 在服务端处理每个请求前被调用，决定 id 是否可以调用 serviceMethod，
+// 返回错误时拒绝该请求，错误不是 *Error 时以 PermissionDenied 返回给客户端
+type Authorizer interface {
+	Authorize(ctx context.Context, id Identity, serviceMethod string) error
+}
+
+// AuthorizerFunc 将普通函数适配为 Authorizer
+type AuthorizerFunc func(ctx context.Context, id Identity, serviceMethod string) error
+
+func (f AuthorizerFunc) Authorize(ctx context.Context, id Identity, serviceMethod string) error {
+	return f(ctx, id, serviceMethod)
+}
+
+// 使用 Authorizer 检查请求，ctx 须携带请求的 Peer 与 metadata，未设置 Authorizer 时不检查
+func (s *Server) authorize(ctx context.Context, serviceMethod string) error {
+	if s.authorizer == nil {
+		return nil
+	}
+	peer, _ := PeerFromContext(ctx)
+	id := Identity{Peer: peer, Credentials: MetadataFromContext(ctx)[CredentialsKey]}
+	err := s.authorizer.Authorize(ctx, id, serviceMethod)
+	var e *Error
+	if err != nil && !errors.As(err, &e) {
+		err = &Error{Code: PermissionDenied, Message: err.Error()}
+	}
+	return err
+}
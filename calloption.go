@@ -0,0 +1,64 @@
+package geerpc
+
+import (
+	"time"
+
+	"geerpc/codec"
+)
+
+// PriorityKey 为在 metadata 中指定优先级时使用的 key，适用于无法设置 Header.Priority 的调用方，例如 HTTP 网关
+const PriorityKey = "geerpc-priority"
+
+// CredentialsKey 为 WithCredentials 在 metadata 中使用的 key
+const CredentialsKey = "authorization"
+
+// CallOption 用于调整单次调用的行为
+type CallOption func(*Call)
+
+// WithMetadata 设置随请求发送的 metadata，多次使用时会合并
+func WithMetadata(md Metadata) CallOption {
+	return func(call *Call) {
+		if call.Metadata == nil {
+			call.Metadata = make(Metadata, len(md))
+		}
+		for k, v := range md {
+			call.Metadata[k] = v
+		}
+	}
+}
+
+// WithResponseMetadata 在调用结束后将服务端返回的 metadata 写入 md
+func WithResponseMetadata(md *Metadata) CallOption {
+	return func(call *Call) {
+		call.responseMetadata = md
+	}
+}
+
+// WithTimeout 设置单次调用的超时时间，与 ctx 的截止时间取较早的一个，并随请求传递给服务端；
+// 用于 Go 时仅传递给服务端
+func WithTimeout(d time.Duration) CallOption {
+	return func(call *Call) {
+		call.timeout = d
+	}
+}
+
+// WithCredentials 设置随本次调用发送的凭证，例如 token，服务端的 Authorizer 通过 Identity.Credentials 获取
+func WithCredentials(credentials string) CallOption {
+	return WithMetadata(Metadata{CredentialsKey: credentials})
+}
+
+// WithPriority 设置调用的优先级，数值越大越优先，通过 Header.Priority 传递给服务端，
+// 服务端可以通过 PriorityFromContext 获取，用于排队（见 WithPriorityScheduling）或过载时的取舍
+func WithPriority(priority int) CallOption {
+	return func(call *Call) {
+		call.priority = int32(priority)
+	}
+}
+
+// WithCodec 使本次调用的请求与响应的 body 使用 t 对应的序列化方式，而不是握手时协商的 codec，
+// t 需已通过 codec.RegisterBodyCodec 注册。用于在一个连接上转发来自不同客户端的调用，例如网关；只作用于非流式调用
+func WithCodec(t codec.Type) CallOption {
+	return func(call *Call) {
+		call.contentType = t
+	}
+}
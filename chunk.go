@@ -0,0 +1,177 @@
+package geerpc
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"geerpc/codec"
+)
+
+// DefaultChunkSize 为 WithChunkedReplies 默认的分块大小
+const DefaultChunkSize = 1 << 20
+
+// DefaultMaxChunkedReplySize 为客户端默认接收的分块响应的最大字节数
+const DefaultMaxChunkedReplySize = 1 << 30
+
+// 分块的流控窗口：服务端最多发送 chunkWindowSize 个未被确认的分块，
+// 客户端每收到 chunkWindowSize/2 个分块后归还窗口
+const chunkWindowSize = 8
+
+// WithChunkedReplies 使服务端将序列化后超过 chunkSize 字节的响应拆分为多个分块发送，chunkSize <= 0 时为 DefaultChunkSize。
+// 每个分块单独获取发送锁，大的响应不会阻塞同一连接上的其他调用；分块响应不受 WithMaxMessageSize 的限制，
+// 客户端按 Option.MaxChunkedReplySize 限制拼接后的大小
+func WithChunkedReplies(chunkSize int) ServerOption {
+	if chunkSize <= 0 {
+		chunkSize = DefaultChunkSize
+	}
+	return func(s *Server) {
+		s.chunkSize = chunkSize
+	}
+}
+
+func newChunkWindow() *streamWindow {
+	return &streamWindow{avail: chunkWindowSize, notify: make(chan struct{}, 1)}
+}
+
+// 发送 req 的响应，客户端支持分块且响应超过分块大小时分块发送，返回写入的字节数
+func (s *Server) sendReply(ctx context.Context, cc codec.Codec, req *Request, sending *sync.Mutex) (int64, error) {
+	rc, ok := cc.(codec.RawCodec)
+	if !ok || (req.window == nil && !req.raw) {
+		return s.sendResponse(cc, req.H, req.Reply.Interface(), sending)
+	}
+	var data []byte
+	if req.raw {
+		data = req.Reply.Elem().Bytes()
+	} else {
+		var err error
+		if data, err = marshalBody(rc, req.H.ContentType, req.Reply.Interface()); err != nil {
+			return 0, err
+		}
+	}
+	if req.window == nil || len(data) <= s.chunkSize {
+		return s.sendRaw(rc, req.H, data, sending)
+	}
+
+	var total int64
+	for len(data) > 0 {
+		if err := req.window.acquire(ctx, nil); err != nil {
+			return total, err
+		}
+		n := s.chunkSize
+		if n > len(data) {
+			n = len(data)
+		}
+		h := &codec.Header{Seq: req.H.Seq, Flags: codec.FlagChunk}
+		if n == len(data) {
+			// 最后一个分块携带响应的 metadata
+			last := *req.H
+			last.Flags |= codec.FlagChunk | codec.FlagLastChunk
+			h = &last
+		}
+		written, err := s.sendRaw(rc, h, data[:n], sending)
+		total += written
+		if err != nil {
+			return total, err
+		}
+		data = data[n:]
+	}
+	return total, nil
+}
+
+func (s *Server) sendRaw(rc codec.RawCodec, h *codec.Header, data []byte, sending *sync.Mutex) (int64, error) {
+	sending.Lock()
+	defer sending.Unlock()
+	cc := rc.(codec.Codec)
+	_, before := codecBytes(cc)
+	err := rc.WriteRaw(h, data)
+	_, after := codecBytes(cc)
+	return after - before, err
+}
+
+// 处理客户端归还的分块窗口
+func receiveChunkWindow(cc codec.Codec, header *codec.Header, reqs *requestSet) error {
+	var n uint32
+	if err := cc.ReadBody(&n); err != nil {
+		return err
+	}
+	if req := reqs.get(header.Seq); req != nil && req.window != nil {
+		req.window.release(int(n))
+	}
+	return nil
+}
+
+// chunkBuffer 为正在接收的分块响应
+type chunkBuffer struct {
+	data     []byte
+	received int // 尚未归还窗口的分块数
+}
+
+// 接收分块响应的一个分块，收到最后一个分块后反序列化并结束 call
+func (t *Transport) receiveChunk(header *codec.Header) {
+	rc, ok := t.cc.(codec.RawCodec)
+	if !ok {
+		_ = t.cc.ReadBody(nil)
+		return
+	}
+	data, err := rc.ReadRawBody()
+	last := header.Flags&codec.FlagLastChunk != 0
+	limit := t.opt.MaxChunkedReplySize
+	if limit <= 0 {
+		limit = DefaultMaxChunkedReplySize
+	}
+
+	t.mu.Lock()
+	call := t.pending[header.Seq]
+	if call == nil {
+		// call 已被取消或结束，服务端的请求随之取消
+		delete(t.chunks, header.Seq)
+		t.mu.Unlock()
+		return
+	}
+	buf := t.chunks[header.Seq]
+	if buf == nil {
+		buf = &chunkBuffer{}
+		t.chunks[header.Seq] = buf
+	}
+	if err == nil && len(buf.data)+len(data) > limit {
+		err = fmt.Errorf("%w: chunked reply exceeds limit %d", codec.ErrMessageTooLarge, limit)
+	}
+	if err == nil {
+		buf.data = append(buf.data, data...)
+	}
+	buf.received++
+	ack := 0
+	if err == nil && !last && buf.received >= chunkWindowSize/2 {
+		ack, buf.received = buf.received, 0
+	}
+	t.mu.Unlock()
+
+	switch {
+	case err != nil:
+		if call := t.removeCall(header.Seq); call != nil {
+			call.Error = codecError(err)
+			call.done()
+		}
+		t.cancelCall(header.Seq)
+	case last:
+		call := t.removeCall(header.Seq)
+		if call == nil {
+			return
+		}
+		call.ResponseMetadata = header.Metadata
+		if call.responseMetadata != nil {
+			*call.responseMetadata = header.Metadata
+		}
+		if call.flags&codec.FlagRaw != 0 {
+			setRawReply(call, buf.data)
+		} else if call.Reply != nil {
+			if err := unmarshalBody(rc, header.ContentType, buf.data, call.Reply); err != nil {
+				call.Error = readBodyError(err)
+			}
+		}
+		call.done()
+	case ack > 0:
+		_ = t.write(&codec.Header{Seq: header.Seq, Flags: codec.FlagChunk | codec.FlagWindowUpdate}, uint32(ack))
+	}
+}
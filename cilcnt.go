@@ -3,7 +3,7 @@ package geerpc
 import (
 	"bufio"
 	"context"
-	"encoding/json"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"geerpc/codec"
@@ -24,7 +24,26 @@ type Call struct {
 	Reply interface{}
 	Error error
 
+	Metadata         Metadata // 随请求发送的 metadata
+	ResponseMetadata Metadata // 服务端随响应返回的 metadata
+
 	Done chan *Call // 调用结束时通知
+
+	responseMetadata *Metadata
+	stream           *ClientStream
+	flags            codec.Flag   // 随请求发送的 Header.Flags
+	deadline         time.Time    // 调用方 context 的截止时间
+	retry            *RetryPolicy // 单次调用的重试策略
+	timeout          time.Duration
+	priority         int32
+	progress         func(Progress) // 服务端发送进度时调用，见 WithProgress
+	contentType      codec.Type     // body 的序列化方式，见 WithCodec
+
+	stats   StatsHandler
+	target  string
+	start   time.Time
+	release func()  // 调用结束时归还 MaxInFlightCalls 的名额
+	client  *Client // 发出 call 的 Client，call 结束时从中移除
 }
 
 type clientResult struct {
@@ -33,256 +52,386 @@ type clientResult struct {
 }
 
 func (call *Call) done() {
-	call.Done <- call
+	if call.stream != nil {
+		call.stream.recv.finish(call.Error)
+	}
+	if call.stats != nil {
+		call.stats.CallEnd(call.target, call.ServerMethod, call.Error, time.Since(call.start))
+	}
+	if call.release != nil {
+		call.release()
+		call.release = nil
+	}
+	if call.client != nil {
+		call.client.untrack(call)
+	}
+	// 多个 call 共用一个 done channel 时，channel 已满则丢弃通知，避免阻塞接收响应的 goroutine
+	select {
+	case call.Done <- call:
+	default:
+		if call.client != nil {
+			call.client.logger.Errorf("rpc client: discarding %s reply due to insufficient done channel capacity", call.ServerMethod)
+		}
+	}
 }
 
 type newClientFunc func(conn net.Conn, opt *Option) (client *Client, err error)
 
-type Client struct {
-	cc  codec.Codec
-	opt *Option
+// DialFunc 建立到服务端的连接，可用于通过代理或 sidecar 连接，或者设置自定义的 socket 选项
+type DialFunc func(ctx context.Context, network, address string) (net.Conn, error)
 
-	sending sync.Mutex
+// Client 在 Transport 上发起调用，多个 Client 可以共享同一个 Transport，各自拥有拦截器并各自关闭
+type Client struct {
+	t      *Transport
+	owned  bool // Close 时是否关闭 t
+	opt    *Option
+	logger Logger
 
 	mu      sync.Mutex
-	seq     uint64
-	pending map[uint64]*Call
+	pending map[uint64]*Call // 经由该 client 发出且尚未结束的 call
+	closing bool             // user has called Close
+	closed  bool             // 已经结束了所有的 call，见 close
+	drained chan struct{}    // CloseGracefully 等待 pending 清空
 
-	closing  bool // user has called Close
-	shutdown bool // server has told us to stop
+	interceptors []ClientInterceptor
 }
 
+// ErrShutdown 表示 client 已关闭或连接已断开
+var ErrShutdown = errors.New("connection is shut down")
+
+// ErrDraining 表示服务端正在关闭该连接，调用没有发送，可以在其他连接上重试
+var ErrDraining = &Error{Code: Unavailable, Message: "rpc client: connection is draining"}
+
+// ErrTooManyCalls 表示尚未完成的调用数达到了 Option.MaxInFlightCalls，且 Option.InFlightFailFast 为 true
+var ErrTooManyCalls = &Error{Code: ResourceExhausted, Message: "rpc client: too many in-flight calls"}
+
+// Close 立即结束所有 pending 状态的 call，以 ErrShutdown 结束，并关闭 client 独占的连接
 func (client *Client) Close() error {
 	client.mu.Lock()
-	defer client.mu.Unlock()
+	if client.closing && client.drained == nil {
+		client.mu.Unlock()
+		return ErrShutdown
+	}
+	client.closing = true
+	client.mu.Unlock()
+
+	return client.close()
+}
 
+// CloseGracefully 拒绝新的调用，等待 pending 状态的 call 全部完成或 ctx 结束后关闭
+func (client *Client) CloseGracefully(ctx context.Context) error {
+	client.mu.Lock()
 	if client.closing {
-		return nil
+		client.mu.Unlock()
+		return ErrShutdown
 	}
-
 	client.closing = true
-
-	if client.shutdown {
-		return nil
+	drained := make(chan struct{})
+	if len(client.pending) == 0 {
+		close(drained)
+	} else {
+		client.drained = drained
 	}
+	client.mu.Unlock()
 
-	client.shutdown = true
-
-	if client.cc != nil {
-		_ = client.cc.Close()
+	select {
+	case <-drained:
+		return client.close()
+	case <-ctx.Done():
+		_ = client.close()
+		return ctx.Err()
 	}
+}
 
+// 独占连接时关闭连接，否则以 ErrShutdown 结束经由该 client 发出的 call，并通知服务端取消
+func (client *Client) close() error {
+	client.mu.Lock()
+	client.closed = true
+	client.mu.Unlock()
+	if client.owned {
+		return client.t.Close()
+	}
+	client.mu.Lock()
+	calls := make([]*Call, 0, len(client.pending))
 	for _, call := range client.pending {
-		call.done()
+		calls = append(calls, call)
 	}
+	client.mu.Unlock()
 
+	for _, call := range calls {
+		if client.t.removeCall(call.Seq) != nil {
+			client.t.cancelCall(call.Seq)
+			call.Error = ErrShutdown
+			call.done()
+		}
+	}
 	return nil
 }
 
+// IsAvailable 返回 client 是否仍可发起调用
 func (client *Client) IsAvailable() bool {
-	return client.closing && client.shutdown
+	client.mu.Lock()
+	closing := client.closing
+	client.mu.Unlock()
+	return !closing && client.t.IsAvailable()
 }
 
-// 注册 RPC
-func (client *Client) registerCall(call *Call) (uint64, error) {
-	client.mu.Lock()
-	defer client.mu.Unlock()
+// IsDraining 返回服务端是否已通知即将关闭 client 使用的连接，见 Transport.IsDraining
+func (client *Client) IsDraining() bool {
+	return client.t.IsDraining()
+}
 
-	seq := client.seq
-	client.seq += 1
-	client.pending[seq] = call
+// Transport 返回 client 使用的连接，可以通过 Transport.NewClient 创建共享该连接的 Client，
+// 通过 NewClient 或 Dial 创建的 client 关闭时同时关闭连接
+func (client *Client) Transport() *Transport {
+	return client.t
+}
 
-	call.Seq = seq
-	return seq, nil
+func newClient(t *Transport, owned bool) *Client {
+	return &Client{
+		t:       t,
+		owned:   owned,
+		opt:     t.opt,
+		logger:  t.logger,
+		pending: make(map[uint64]*Call),
+	}
 }
 
-// 移除对应的 call，并返回
-func (client *Client) removeCall(seq uint64) *Call {
+// 登记经由 client 发出的 call，client 正在关闭时返回 false。在 Transport.mu 内调用
+func (client *Client) track(call *Call) bool {
 	client.mu.Lock()
 	defer client.mu.Unlock()
-
-	call, ok := client.pending[seq]
-	if !ok {
-		return nil
+	if client.closing {
+		return false
 	}
-
-	delete(client.pending, seq)
-	return call
+	client.pending[call.Seq] = call
+	return true
 }
 
-// 服务端或客户端发生错误时调用，将 shutdown 设置为 true，且将错误信息通知所有 pending 状态的 call。
-func (client *Client) terminateCalls(err error) {
-	client.sending.Lock()
-	defer client.sending.Unlock()
-
+// call 结束时移除登记，正在优雅关闭时，最后一个 call 结束后通知 CloseGracefully
+func (client *Client) untrack(call *Call) {
 	client.mu.Lock()
 	defer client.mu.Unlock()
-
-	client.shutdown = true
-	for _, call := range client.pending {
-		call.Error = err
-		call.done()
+	if client.pending[call.Seq] != call {
+		return
 	}
-}
-
-// 接收 RPC 响应
-func (client *Client) receive() {
-	for {
-		if client.shutdown {
-			return
-		}
-
-		header := &codec.Header{}
-		err := client.cc.ReadHeader(header)
-		if err != nil {
-			client.terminateCalls(err)
-			return
-		}
-
-		call := client.removeCall(header.Seq)
-		switch {
-		case call == nil:
-			// 通常表示操作被移除或失败
-			err = client.cc.ReadBody(nil)
-		case header.Error != "":
-			call.Error = fmt.Errorf(header.Error)
-			err = client.cc.ReadBody(nil)
-			call.done()
-		default:
-			err = client.cc.ReadBody(call.Reply)
-			if err != nil {
-				call.Error = errors.New("reading body " + err.Error())
-			}
-			call.done()
-		}
+	delete(client.pending, call.Seq)
+	if len(client.pending) == 0 && client.drained != nil {
+		close(client.drained)
+		client.drained = nil
 	}
 }
 
-func (client *Client) send(call *Call) {
-	client.sending.Lock()
-	defer client.sending.Unlock()
-
-	seq, _ := client.registerCall(call)
-
-	err := client.cc.Write(&codec.Header{
-		ServiceMethod: call.ServerMethod,
-		Seq:           seq,
-	}, call.Args)
+// 经由 client 发送 call，见 Transport.send
+func (client *Client) send(ctx context.Context, call *Call) error {
+	call.client = client
+	return client.t.send(ctx, call)
+}
 
-	if err != nil {
-		call := client.removeCall(seq)
-		if call != nil {
-			call.Error = err
-			call.done()
-		}
+// 读取响应 body 出错时返回给调用方的错误
+func readBodyError(err error) error {
+	if errors.Is(err, codec.ErrMessageTooLarge) || errors.Is(err, codec.ErrChecksumMismatch) {
+		return codecError(err)
 	}
+	return errors.New("reading body " + err.Error())
 }
 
-func (client *Client) Go(serviceMethod string, args, reply interface{}, done chan *Call) *Call {
+func (client *Client) Go(serviceMethod string, args, reply interface{}, done chan *Call, opts ...CallOption) *Call {
 	if done == nil {
-		done = make(chan *Call, 10)
+		done = make(chan *Call, client.doneChanSize())
 	} else if cap(done) == 0 {
 		log.Panic("rpc client: done channel is unbuffered")
 	}
 
 	call := &Call{
-		Seq:          client.seq,
 		ServerMethod: serviceMethod,
 		Args:         args,
 		Reply:        reply,
 		Done:         done,
 	}
 
-	client.send(call)
+	// 存在拦截器时，在后台执行整条调用链
+	if invoker := client.interceptedInvoker(); invoker != nil {
+		go func() {
+			call.Error = invoker(context.Background(), serviceMethod, args, reply, opts...)
+			call.done()
+		}()
+		return call
+	}
+
+	for _, opt := range opts {
+		opt(call)
+	}
+	if call.timeout > 0 {
+		call.deadline = time.Now().Add(call.timeout)
+	}
+	_ = client.send(context.Background(), call)
 	return call
 }
 
-func (client *Client) Call(ctx context.Context, serviceMethod string, args, reply interface{}) error {
-	//call := <-client.Go(serviceMethod, args, reply, make(chan *Call, 1))
-	call := client.Go(serviceMethod, args, reply, make(chan *Call, 1))
+func (client *Client) Call(ctx context.Context, serviceMethod string, args, reply interface{}, opts ...CallOption) error {
+	if invoker := client.interceptedInvoker(); invoker != nil {
+		return invoker(ctx, serviceMethod, args, reply, opts...)
+	}
+	return client.invoke(ctx, serviceMethod, args, reply, opts...)
+}
+
+// 不经过拦截器，发起调用并等待结果，失败时按照重试策略重新调用
+func (client *Client) invoke(ctx context.Context, serviceMethod string, args, reply interface{}, opts ...CallOption) error {
+	settings := &Call{}
+	for _, opt := range opts {
+		opt(settings)
+	}
+	if settings.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, settings.timeout)
+		defer cancel()
+	}
+	policy := settings.retry
+	if policy == nil {
+		policy = client.opt.RetryPolicy
+	}
+
+	for attempt := 0; ; attempt++ {
+		call := &Call{
+			ServerMethod: serviceMethod,
+			Args:         args,
+			Reply:        reply,
+			Done:         make(chan *Call, 1),
+		}
+		call.deadline, _ = ctx.Deadline()
+		for _, opt := range opts {
+			opt(call)
+		}
+
+		err := client.invokeOnce(ctx, call)
+		if err == nil || policy == nil || attempt+1 >= policy.MaxAttempts || !policy.retryable(err) {
+			return err
+		}
+		if client.State() >= StateDraining {
+			// 连接已断开或不再接受新的调用，在同一个 client 上重试不会成功
+			return err
+		}
+		client.logger.Debugf("rpc client: retry %s after error: %v", serviceMethod, err)
+		if !waitRetry(ctx, policy, attempt+1) {
+			return err
+		}
+	}
+}
+
+// 发起一次调用并等待结果
+func (client *Client) invokeOnce(ctx context.Context, call *Call) error {
+	if err := client.send(ctx, call); err != nil {
+		return err
+	}
 
 	select {
 	case <-ctx.Done():
-		client.removeCall(call.Seq)
-		return errors.New("rpc client: call failed: " + ctx.Err().Error())
+		err := &Error{Code: CodeOf(ctx.Err()), Message: "rpc client: call failed: " + ctx.Err().Error()}
+		if client.t.removeCall(call.Seq) != nil {
+			client.t.cancelCall(call.Seq)
+			call.Error = err
+			call.done()
+		}
+		return err
 	case call := <-call.Done:
 		return call.Error
 	}
 }
 
 func NewClient(conn net.Conn, opt *Option) (*Client, error) {
-	f, ok := codec.NewCodecFuncMap[opt.CodecType]
-	if !ok {
-		return nil, errors.New(string("unknown codec " + opt.CodecType))
-	}
-
-	if err := json.NewEncoder(conn).Encode(opt); err != nil {
+	t, err := NewTransport(conn, opt)
+	if err != nil {
 		return nil, err
 	}
-
-	client := &Client{
-		cc:       f(conn),
-		opt:      opt,
-		sending:  sync.Mutex{},
-		mu:       sync.Mutex{},
-		seq:      0,
-		pending:  make(map[uint64]*Call),
-		closing:  false,
-		shutdown: false,
-	}
-
-	go client.receive()
-
-	return client, nil
+	return newClient(t, true), nil
 }
 
 func Dial(network, address string, opt *Option) (client *Client, err error) {
 	return dialTimeout(NewClient, network, address, opt)
 }
 
-func dialTimeout(f newClientFunc, network, address string, opt *Option) (client *Client, err error) {
-	conn, err := net.DialTimeout(network, address, opt.ConnectTimeout)
+// DialContext 连接到 RPC 服务端，建立连接与握手受 ctx 与 ConnectTimeout 中较早的截止时间约束
+func DialContext(ctx context.Context, network, address string, opt *Option) (*Client, error) {
+	return dialContext(ctx, NewClient, network, address, opt)
+}
+
+func dialTimeout(f newClientFunc, network, address string, opt *Option) (*Client, error) {
+	return dialContext(context.Background(), f, network, address, opt)
+}
+
+func dialContext(ctx context.Context, f newClientFunc, network, address string, opt *Option) (*Client, error) {
+	if opt.ConnectTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opt.ConnectTimeout)
+		defer cancel()
+	}
+
+	dial := opt.DialFunc
+	if dial == nil {
+		dial = (&net.Dialer{}).DialContext
+	}
+	conn, err := dial(ctx, network, address)
 	if err != nil {
 		return nil, err
 	}
 
-	defer func() {
-		if err != nil {
-			_ = conn.Close()
-		}
-	}()
-
-	ch := make(chan clientResult)
-
+	// 带缓冲的 channel 保证超时返回后 f 所在的 goroutine 不会阻塞
+	ch := make(chan clientResult, 1)
 	go func() {
-		client, err = f(conn, opt)
+		client, err := f(conn, opt)
 		ch <- clientResult{client, err}
 	}()
 
-	// 不进行超时控制
-	if opt.ConnectTimeout == 0 {
-		result := <-ch
-		return result.client, result.err
-	}
-
 	select {
-	case <-time.After(opt.ConnectTimeout):
-		return nil, fmt.Errorf("rpc client: connect timeout: expect within %s", opt.ConnectTimeout)
+	case <-ctx.Done():
+		// 关闭连接使 f 尽快返回
+		_ = conn.Close()
+		if ctx.Err() == context.DeadlineExceeded {
+			return nil, fmt.Errorf("rpc client: connect timeout: %w", ctx.Err())
+		}
+		return nil, fmt.Errorf("rpc client: dial canceled: %w", ctx.Err())
 	case result := <-ch:
+		if result.err != nil {
+			_ = conn.Close()
+		}
 		return result.client, result.err
 	}
 }
 
+// DialTLS 通过 TLS 连接到 RPC 服务端，TLS 握手同样受 ConnectTimeout 约束
+func DialTLS(network, address string, config *tls.Config, opt *Option) (*Client, error) {
+	if config == nil {
+		config = &tls.Config{}
+	}
+	if config.ServerName == "" && !config.InsecureSkipVerify {
+		host, _, err := net.SplitHostPort(address)
+		if err != nil {
+			host = address
+		}
+		config = config.Clone()
+		config.ServerName = host
+	}
+
+	return dialTimeout(func(conn net.Conn, opt *Option) (*Client, error) {
+		tlsConn := tls.Client(conn, config)
+		if err := tlsConn.Handshake(); err != nil {
+			return nil, err
+		}
+		return NewClient(tlsConn, opt)
+	}, network, address, opt)
+}
+
 func NewHTTPClient(conn net.Conn, opt *Option) (client *Client, err error) {
 	_, _ = io.WriteString(conn, fmt.Sprintf("CONNECT %s HTTP/1.0\n\n", defaultRPCPath))
 	resp, err := http.ReadResponse(bufio.NewReader(conn), &http.Request{Method: "CONNECT"})
-	if err == nil && resp.Status == connected {
-		return NewClient(conn, opt)
-	}
 	if err != nil {
-		err = errors.New("unexpected HTTP response: " + resp.Status)
+		return nil, err
+	}
+	if resp.Status != connected {
+		return nil, errors.New("unexpected HTTP response: " + resp.Status)
 	}
-	return nil, err
+	return NewClient(conn, opt)
 }
 
 func DialHTTP(network, address string, opts *Option) (*Client, error) {
@@ -290,6 +439,11 @@ func DialHTTP(network, address string, opts *Option) (*Client, error) {
 }
 
 func XDial(rpcAddr string, opts *Option) (*Client, error) {
+	return XDialContext(context.Background(), rpcAddr, opts)
+}
+
+// XDialContext 与 XDial 相同，建立连接受 ctx 约束
+func XDialContext(ctx context.Context, rpcAddr string, opts *Option) (*Client, error) {
 	parts := strings.Split(rpcAddr, "@")
 	if len(parts) != 2 {
 		return nil, fmt.Errorf("rpc client err: wrong format '%s', expect protocol@addr", rpcAddr)
@@ -298,9 +452,13 @@ func XDial(rpcAddr string, opts *Option) (*Client, error) {
 	protocol, addr := parts[0], parts[1]
 	switch protocol {
 	case "http":
-		return DialHTTP("tcp", addr, opts)
+		return dialContext(ctx, NewHTTPClient, "tcp", addr, opts)
+	case "h2":
+		return DialHTTP2(ctx, "https://"+addr, nil, opts)
+	case "h2c":
+		return DialHTTP2(ctx, "http://"+addr, nil, opts)
 	default:
 		// tcp, unix or other transport protocol
-		return Dial(protocol, addr, opts)
+		return dialContext(ctx, NewClient, protocol, addr, opts)
 	}
 }
@@ -2,10 +2,14 @@ package geerpc
 
 import (
 	"context"
+	"errors"
+	"io"
 	"net"
 	"os"
 	"runtime"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -30,6 +34,142 @@ func TestClient_diaTimeout(t *testing.T) {
 	})
 }
 
+func TestDialContext(t *testing.T) {
+	// 只接受连接、不进行握手的服务端
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	defer func() { _ = l.Close() }()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*100)
+	defer cancel()
+	start := time.Now()
+	_, err := DialContext(ctx, "tcp", l.Addr().String(), &Option{MagicNumber: MagicNumber, CodecType: DefaultOption.CodecType})
+	_assert(errors.Is(err, context.DeadlineExceeded), "expect DeadlineExceeded, got %v", err)
+	_assert(time.Since(start) < time.Second, "expect dial to return at ctx deadline")
+
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	_, err = XDialContext(ctx, "tcp@"+l.Addr().String(), DefaultOption)
+	_assert(errors.Is(err, context.Canceled), "expect Canceled, got %v", err)
+}
+
+func TestDialFunc(t *testing.T) {
+	server := NewServer()
+	_ = server.Register(Echo{})
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	defer func() { _ = l.Close() }()
+	go server.Accept(l)
+
+	var dialed string
+	opt := &Option{MagicNumber: MagicNumber, CodecType: DefaultOption.CodecType,
+		DialFunc: func(ctx context.Context, network, address string) (net.Conn, error) {
+			// 忽略传入的地址，始终连接到 l
+			dialed = address
+			return (&net.Dialer{}).DialContext(ctx, network, l.Addr().String())
+		}}
+	client, err := Dial("tcp", "example.invalid:1234", opt)
+	_assert(err == nil, "failed to dial with custom dialer: %v", err)
+	defer func() { _ = client.Close() }()
+	_assert(dialed == "example.invalid:1234", "expect DialFunc to receive the address, got %q", dialed)
+
+	var reply string
+	err = client.Call(context.Background(), "Echo.Repeat", 1, &reply)
+	_assert(err == nil && reply == "x", "failed to call through custom dialer: %v", err)
+}
+
+// Flaky 的前 failures 次调用返回 Unavailable
+type Flaky struct {
+	failures int32
+	calls    int32
+}
+
+func (f *Flaky) Get(n int, reply *int) error {
+	atomic.AddInt32(&f.calls, 1)
+	if atomic.AddInt32(&f.failures, -1) >= 0 {
+		return Errorf(Unavailable, "try again")
+	}
+	*reply = n
+	return nil
+}
+
+func TestClient_RetryPolicy(t *testing.T) {
+	flaky := &Flaky{failures: 2}
+	server := NewServer()
+	_ = server.Register(flaky)
+	client := pipeClient(t, server, &Option{MagicNumber: MagicNumber, CodecType: DefaultOption.CodecType,
+		RetryPolicy: &RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond, Jitter: 0.5}})
+	defer func() { _ = client.Close() }()
+
+	var reply int
+	err := client.Call(context.Background(), "Flaky.Get", 7, &reply)
+	_assert(err == nil && reply == 7, "expect call to succeed after retries, got %d, err: %v", reply, err)
+	_assert(atomic.LoadInt32(&flaky.calls) == 3, "expect 3 attempts, got %d", flaky.calls)
+
+	// 单次调用的策略覆盖客户端级别的策略
+	atomic.StoreInt32(&flaky.failures, 1)
+	err = client.Call(context.Background(), "Flaky.Get", 7, &reply, WithRetryPolicy(RetryPolicy{MaxAttempts: 1}))
+	_assert(CodeOf(err) == Unavailable, "expect per-call policy to disable retry, got %v", err)
+	atomic.StoreInt32(&flaky.failures, 1)
+	err = client.Call(context.Background(), "Flaky.Get", 7, &reply, WithRetryPolicy(RetryPolicy{MaxAttempts: 3, RetryableCodes: []Code{Internal}}))
+	_assert(CodeOf(err) == Unavailable, "expect Unavailable not to be retried, got %v", err)
+}
+
+// failConn 在 failures 大于 0 时使写入失败且不写出任何字节，模拟暂时的写入错误
+type failConn struct {
+	net.Conn
+	failures int32
+}
+
+func (c *failConn) Write(p []byte) (int, error) {
+	if atomic.AddInt32(&c.failures, -1) >= 0 {
+		return 0, &net.OpError{Op: "write", Net: "pipe", Err: errors.New("injected failure")}
+	}
+	return c.Conn.Write(p)
+}
+
+func TestClient_RetryWriteFailure(t *testing.T) {
+	server := NewServer()
+	_ = server.Register(Echo{})
+	c1, c2 := net.Pipe()
+	go server.handleConn(c2)
+	conn := &failConn{Conn: c1}
+	client, err := NewClient(conn, &Option{MagicNumber: MagicNumber, CodecType: DefaultOption.CodecType,
+		RetryPolicy: &RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond}})
+	_assert(err == nil, "failed to create client: %v", err)
+	defer func() { _ = client.Close() }()
+
+	// 写入失败的请求以 Unavailable 结束，按重试策略在同一个连接上重试
+	atomic.StoreInt32(&conn.failures, 1)
+	var reply string
+	err = client.Call(context.Background(), "Echo.Repeat", 2, &reply)
+	_assert(err == nil && reply == "xx", "expect write failure to be retried, got %q, %v", reply, err)
+	atomic.StoreInt32(&conn.failures, 1)
+	err = client.Call(context.Background(), "Echo.Repeat", 2, &reply, WithRetryPolicy(RetryPolicy{MaxAttempts: 1}))
+	_assert(CodeOf(err) == Unavailable, "expect write failure to be Unavailable, got %v", err)
+
+	// 连接断开后不再重试
+	_ = c2.Close()
+	for client.State() != StateClosed {
+		time.Sleep(time.Millisecond)
+	}
+	start := time.Now()
+	err = client.Call(context.Background(), "Echo.Repeat", 2, &reply, WithRetryPolicy(RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Second}))
+	_assert(err == ErrShutdown && time.Since(start) < time.Second, "expect closed client not to retry, got %v after %v", err, time.Since(start))
+}
+
+func TestRetryPolicy_Backoff(t *testing.T) {
+	p := &RetryPolicy{InitialBackoff: time.Millisecond * 10, MaxBackoff: time.Millisecond * 50}
+	for attempt, want := range []time.Duration{10, 20, 40, 50, 50} {
+		got := p.backoff(attempt + 1)
+		_assert(got == want*time.Millisecond, "attempt %d: expect %s, got %s", attempt+1, want*time.Millisecond, got)
+	}
+	// 不限制 MaxBackoff 时多次翻倍不能溢出为负数
+	p = &RetryPolicy{InitialBackoff: time.Second, Jitter: 0.5}
+	for _, attempt := range []int{40, 64, 100} {
+		got := p.backoff(attempt)
+		_assert(got > time.Second, "attempt %d: expect positive backoff, got %s", attempt, got)
+	}
+}
+
 type Bar int
 
 func (b *Bar) Timeout(argv int, reply *int) error {
@@ -89,3 +229,385 @@ func TestXDial(t *testing.T) {
 		_assert(err == nil, "failed to connect unix socket")
 	}
 }
+
+func TestClient_Close(t *testing.T) {
+	baz := &Baz{cancelled: make(chan error, 1)}
+	server := NewServer()
+	_ = server.Register(baz)
+	_ = server.Register(Echo{})
+
+	t.Run("close", func(t *testing.T) {
+		client := pipeClient(t, server, DefaultOption)
+		call := client.Go("Baz.Wait", 1, new(int), nil)
+		_assert(client.Close() == nil && !client.IsAvailable(), "failed to close client")
+		<-call.Done
+		_assert(call.Error == ErrShutdown, "expect pending call to fail with ErrShutdown, got %v", call.Error)
+		<-baz.cancelled
+		_assert(client.Close() == ErrShutdown, "expect ErrShutdown on second close")
+
+		err := client.Call(context.Background(), "Echo.Metadata", "", new(string))
+		_assert(err == ErrShutdown, "expect ErrShutdown after close, got %v", err)
+	})
+
+	t.Run("close gracefully", func(t *testing.T) {
+		client := pipeClient(t, server, &Option{MagicNumber: MagicNumber, CodecType: DefaultOption.CodecType, HandleTimeout: time.Millisecond * 100})
+		call := client.Go("Baz.Wait", 1, new(int), nil)
+		time.Sleep(time.Millisecond * 10)
+
+		err := client.CloseGracefully(context.Background())
+		_assert(err == nil, "failed to close gracefully: %v", err)
+		<-call.Done
+		_assert(call.Error != nil && strings.Contains(call.Error.Error(), "handle timeout"), "expect pending call to finish before close, got %v", call.Error)
+		<-baz.cancelled
+	})
+
+	t.Run("close gracefully timeout", func(t *testing.T) {
+		client := pipeClient(t, server, DefaultOption)
+		call := client.Go("Baz.Wait", 1, new(int), nil)
+		time.Sleep(time.Millisecond * 10)
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+		defer cancel()
+		err := client.CloseGracefully(ctx)
+		_assert(err == context.DeadlineExceeded, "expect deadline exceeded, got %v", err)
+		<-call.Done
+		_assert(call.Error == ErrShutdown, "expect pending call to fail with ErrShutdown, got %v", call.Error)
+		<-baz.cancelled
+	})
+}
+
+func TestClient_Use(t *testing.T) {
+	server := NewServer()
+	_ = server.Register(Echo{})
+	client := pipeClient(t, server, DefaultOption)
+	defer func() { _ = client.Close() }()
+
+	var trace []string
+	record := func(name string) ClientInterceptor {
+		return func(ctx context.Context, serviceMethod string, args, reply interface{}, invoker Invoker, opts ...CallOption) error {
+			trace = append(trace, name+":"+serviceMethod)
+			return invoker(ctx, serviceMethod, args, reply, append(opts, WithMetadata(Metadata{"who": name}))...)
+		}
+	}
+	client.Use(record("outer"), record("inner"))
+
+	var reply string
+	err := client.Call(context.Background(), "Echo.Metadata", "who", &reply)
+	_assert(err == nil && reply == "inner", "expect metadata from inner interceptor, got %q, err: %v", reply, err)
+	_assert(strings.Join(trace, ",") == "outer:Echo.Metadata,inner:Echo.Metadata", "unexpected interceptor order %v", trace)
+
+	call := <-client.Go("Echo.Metadata", "who", &reply, nil).Done
+	_assert(call.Error == nil && reply == "inner" && len(trace) == 4, "expect Go to run interceptors, err: %v", call.Error)
+}
+
+func TestClient_State(t *testing.T) {
+	gate := &Gate{entered: make(chan int, 1), release: make(chan struct{})}
+	server := NewServer()
+	_ = server.Register(gate)
+	states := make(chan ConnState, 4)
+	targets := make(chan string, 16)
+	opt := &Option{MagicNumber: MagicNumber, CodecType: DefaultOption.CodecType,
+		OnStateChange: func(target string, s ConnState) {
+			targets <- target
+			states <- s
+		}}
+	client := pipeClient(t, server, opt)
+	_assert(<-states == StateConnecting && <-states == StateReady && client.State() == StateReady,
+		"expect Connecting then Ready after handshake, got %v", client.State())
+	_assert(<-targets == client.Transport().target && <-targets == client.Transport().target, "expect callback to receive the connection target")
+
+	// 共享连接的 client 优雅关闭时为 Draining，连接的状态不变
+	shared := client.Transport().NewClient()
+	call := shared.Go("Gate.Enter", 1, new(int), nil)
+	<-gate.entered
+	_assert(shared.NumPending() == 1 && client.NumPending() == 0 && client.Transport().NumPending() == 1,
+		"unexpected pending calls %d %d", shared.NumPending(), client.NumPending())
+	closed := make(chan error, 1)
+	go func() { closed <- shared.CloseGracefully(context.Background()) }()
+	for shared.State() != StateDraining {
+		time.Sleep(time.Millisecond)
+	}
+	_assert(client.State() == StateReady, "expect connection to stay Ready, got %v", client.State())
+	close(gate.release)
+	<-call.Done
+	_assert(<-closed == nil && shared.State() == StateClosed, "expect shared client to be Closed, got %v", shared.State())
+
+	var watched []ConnState
+	client.OnStateChange(func(s ConnState) { watched = append(watched, s) })
+	_ = client.Close()
+	_assert(<-states == StateClosed && client.State() == StateClosed, "expect Closed after Close, got %v", client.State())
+	_assert(len(watched) == 1 && watched[0] == StateClosed, "unexpected state changes %v", watched)
+
+	// 握手被拒绝时从 Connecting 直接到 Closed
+	c1, c2 := net.Pipe()
+	go server.handleConn(c2)
+	bad := *opt
+	bad.MagicNumber = 1
+	_, err := NewClient(c1, &bad)
+	_assert(err != nil && <-states == StateConnecting && <-states == StateClosed, "expect Connecting then Closed on rejected handshake, err: %v", err)
+
+	// 服务端关闭时连接在已发出的调用完成前为 Draining，完成后关闭
+	gate = &Gate{entered: make(chan int, 1), release: make(chan struct{})}
+	server = NewServer()
+	_ = server.Register(gate)
+	client = pipeClient(t, server, opt)
+	_assert(<-states == StateConnecting && <-states == StateReady, "expect Connecting then Ready after handshake")
+	call = client.Go("Gate.Enter", 1, new(int), nil)
+	<-gate.entered
+	shutdown := make(chan error, 1)
+	go func() { shutdown <- server.Shutdown(context.Background()) }()
+	_assert(<-states == StateDraining && client.State() == StateDraining, "expect Draining after GOAWAY, got %v", client.State())
+	close(gate.release)
+	<-call.Done
+	_assert(call.Error == nil && <-states == StateClosed && client.State() == StateClosed, "expect Closed after drain, got %v, err: %v", client.State(), call.Error)
+	_assert(<-shutdown == nil, "expect shutdown to finish after drain")
+}
+
+func TestClient_PingTimeout(t *testing.T) {
+	c1, c2 := net.Pipe()
+	// 完成握手后不再应答的服务端
+	go func() {
+		var opt Option
+		conn, _, _ := readHandshake(c2, &opt)
+		_ = writeHandshake(c2, handshakeAck{Version: ProtocolVersion, CodecType: opt.CodecType}, false)
+		_, _ = io.Copy(io.Discard, conn)
+	}()
+	client, err := NewClient(c1, &Option{MagicNumber: MagicNumber, CodecType: DefaultOption.CodecType, PingInterval: time.Millisecond * 20})
+	_assert(err == nil, "failed to create client: %v", err)
+	defer func() { _ = client.Close() }()
+	_assert(waitUnavailable(client, time.Second), "expect client to close connection after missed pong")
+}
+
+func TestClient_MaxInFlightCalls(t *testing.T) {
+	server := NewServer()
+	baz := &Baz{cancelled: make(chan error, 10)}
+	_ = server.Register(baz)
+	_ = server.Register(Echo{})
+	ctx := context.Background()
+
+	t.Run("fail fast", func(t *testing.T) {
+		client := pipeClient(t, server, &Option{MagicNumber: MagicNumber, CodecType: DefaultOption.CodecType,
+			MaxInFlightCalls: 1, InFlightFailFast: true})
+		defer func() { _ = client.Close() }()
+		call := client.Go("Baz.Wait", 1, new(int), nil, WithTimeout(time.Millisecond*100))
+		var reply string
+		err := client.Call(ctx, "Echo.Repeat", 1, &reply)
+		_assert(errors.Is(err, ErrTooManyCalls) && CodeOf(err) == ResourceExhausted, "expect ErrTooManyCalls, got %v", err)
+
+		<-call.Done
+		<-baz.cancelled
+		err = client.Call(ctx, "Echo.Repeat", 1, &reply)
+		_assert(err == nil && reply == "x", "expect slot to be released, got %v", err)
+	})
+
+	t.Run("blocking", func(t *testing.T) {
+		client := pipeClient(t, server, &Option{MagicNumber: MagicNumber, CodecType: DefaultOption.CodecType,
+			MaxInFlightCalls: 1})
+		defer func() { _ = client.Close() }()
+		call := client.Go("Baz.Wait", 1, new(int), nil, WithTimeout(time.Millisecond*200))
+		var reply string
+		timeoutCtx, cancel := context.WithTimeout(ctx, time.Millisecond*50)
+		defer cancel()
+		err := client.Call(timeoutCtx, "Echo.Repeat", 1, &reply)
+		_assert(CodeOf(err) == DeadlineExceeded, "expect call to wait for a slot until deadline, got %v", err)
+
+		start := time.Now()
+		err = client.Call(ctx, "Echo.Repeat", 1, &reply)
+		_assert(err == nil && reply == "x" && time.Since(start) > time.Millisecond*50, "expect call to wait for a slot, got %v", err)
+		<-call.Done
+		<-baz.cancelled
+	})
+}
+
+func TestTransport_SharedClients(t *testing.T) {
+	baz := &Baz{cancelled: make(chan error, 1)}
+	server := NewServer()
+	_ = server.Register(baz)
+	_ = server.Register(Echo{})
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	defer func() { _ = l.Close() }()
+	go server.Accept(l)
+
+	pool := NewTransportPool(nil)
+	defer func() { _ = pool.Close() }()
+	c1, err := pool.Client("tcp@" + l.Addr().String())
+	_assert(err == nil, "failed to get client from pool: %v", err)
+	c2, _ := pool.Client("tcp@" + l.Addr().String())
+	_assert(c1.Transport() == c2.Transport(), "expect clients to share one transport")
+
+	// 拦截器只作用于添加它的 client
+	var intercepted int32
+	c1.Use(func(ctx context.Context, serviceMethod string, args, reply interface{}, invoker Invoker, opts ...CallOption) error {
+		atomic.AddInt32(&intercepted, 1)
+		return invoker(ctx, serviceMethod, args, reply, opts...)
+	})
+	var reply string
+	_assert(c1.Call(context.Background(), "Echo.Repeat", 2, &reply) == nil && reply == "xx", "failed to call on c1")
+	_assert(c2.Call(context.Background(), "Echo.Repeat", 3, &reply) == nil && reply == "xxx", "failed to call on c2")
+	_assert(atomic.LoadInt32(&intercepted) == 1, "expect interceptor to run only for c1")
+
+	// 关闭 c2 只结束经由 c2 发出的调用，并通知服务端取消
+	call := c2.Go("Baz.Wait", 1, new(int), nil)
+	_assert(c2.Close() == nil && !c2.IsAvailable(), "failed to close c2")
+	<-call.Done
+	_assert(call.Error == ErrShutdown, "expect pending call to fail with ErrShutdown, got %v", call.Error)
+	<-baz.cancelled
+	_assert(c1.IsAvailable(), "expect c1 to stay available")
+	err = c1.Call(context.Background(), "Echo.Repeat", 1, &reply)
+	_assert(err == nil && reply == "x", "expect c1 to keep working after c2 is closed, got %v", err)
+
+	// 关闭 pool 关闭共享的连接
+	_ = pool.Close()
+	err = c1.Call(context.Background(), "Echo.Repeat", 1, &reply)
+	_assert(err == ErrShutdown, "expect ErrShutdown after pool is closed, got %v", err)
+	_, err = pool.Client("tcp@" + l.Addr().String())
+	_assert(err == ErrShutdown, "expect closed pool to return ErrShutdown, got %v", err)
+}
+
+func TestClient_GoFuncAndFuture(t *testing.T) {
+	server := NewServer()
+	baz := &Baz{cancelled: make(chan error, 10)}
+	_ = server.Register(baz)
+	_ = server.Register(Echo{})
+	client := pipeClient(t, server, &Option{MagicNumber: MagicNumber, CodecType: DefaultOption.CodecType, DoneChanSize: 3})
+	defer func() { _ = client.Close() }()
+
+	call := client.Go("Echo.Repeat", 1, new(string), nil)
+	_assert(cap(call.Done) == 3, "expect done channel size 3, got %d", cap(call.Done))
+	<-call.Done
+
+	called := make(chan *Call, 1)
+	var reply string
+	client.GoFunc("Echo.Repeat", 2, &reply, func(call *Call) { called <- call })
+	call = <-called
+	_assert(call.Error == nil && reply == "xx", "expect callback with reply xx, got %q, %v", reply, call.Error)
+
+	var futureReply string
+	f := client.Async("Echo.Repeat", 3, &futureReply)
+	err := f.Await(context.Background())
+	_assert(err == nil && futureReply == "xxx", "expect future reply xxx, got %q, %v", futureReply, err)
+	<-f.Done()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+	defer cancel()
+	f = client.Async("Baz.Wait", 1, new(int), WithTimeout(time.Millisecond*200))
+	err = f.Await(ctx)
+	_assert(CodeOf(err) == DeadlineExceeded, "expect await to stop at ctx deadline, got %v", err)
+	err = f.Await(context.Background())
+	_assert(err != nil, "expect call to time out")
+	<-baz.cancelled
+
+	// 共用的 done channel 已满时丢弃通知，而不是阻塞接收响应
+	done := make(chan *Call, 1)
+	c1 := client.Go("Echo.Repeat", 1, new(string), done)
+	c2 := client.Go("Echo.Repeat", 1, new(string), done)
+	<-done
+	var r string
+	err = client.Call(context.Background(), "Echo.Repeat", 1, &r)
+	_assert(err == nil && r == "x", "expect client to keep working after overflow, got %v", err)
+	_assert(c1.Error == nil && c2.Error == nil, "expect both calls to succeed")
+}
+
+func TestSessionClient(t *testing.T) {
+	baz := &Baz{cancelled: make(chan error, 1)}
+	server := NewServer()
+	_ = server.Register(baz)
+	_ = server.Register(Echo{})
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	defer func() { _ = l.Close() }()
+	go server.AcceptSession(l)
+
+	sc, err := DialSession(context.Background(), "tcp", l.Addr().String(), DefaultOption)
+	_assert(err == nil, "failed to dial session: %v", err)
+
+	// 进行中的调用占用一个流，其他调用在新的流上进行
+	ctx, cancel := context.WithCancel(context.Background())
+	waited := make(chan error, 1)
+	go func() { waited <- sc.Call(ctx, "Baz.Wait", 1, new(int)) }()
+	var reply string
+	for i := 0; i < 3; i++ {
+		err = sc.Call(context.Background(), "Echo.Repeat", 1<<20, &reply)
+		_assert(err == nil && len(reply) == 1<<20, "failed to call on session: %v", err)
+	}
+	_assert(sc.Session().NumStreams() == 2, "expect idle stream to be reused, got %d streams", sc.Session().NumStreams())
+
+	cancel()
+	_assert(CodeOf(<-waited) == Canceled, "expect call to be canceled")
+	<-baz.cancelled
+
+	// 单独的流不影响 Call 使用的流
+	client, err := sc.OpenClient()
+	_assert(err == nil, "failed to open client: %v", err)
+	_assert(client.Call(context.Background(), "Echo.Repeat", 2, &reply) == nil && reply == "xx", "failed to call on opened client")
+	_ = client.Close()
+
+	_ = sc.Close()
+	_assert(!sc.IsAvailable(), "expect session to be closed")
+	err = sc.Call(context.Background(), "Echo.Repeat", 1, &reply)
+	_assert(err == ErrShutdown, "expect ErrShutdown after close, got %v", err)
+}
+
+func BenchmarkClient_CallParallel(b *testing.B) {
+	server := NewServer()
+	_ = server.Register(new(Foo))
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	defer func() { _ = l.Close() }()
+	go server.Accept(l)
+
+	client, err := Dial("tcp", l.Addr().String(), DefaultOption)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer func() { _ = client.Close() }()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		var reply int
+		for pb.Next() {
+			if err := client.Call(context.Background(), "Foo.Sum", Args{Num1: 1, Num2: 2}, &reply); err != nil {
+				b.Error(err)
+				return
+			}
+		}
+	})
+}
+
+func TestTransportPool_ConcurrentDial(t *testing.T) {
+	server := NewServer()
+	_ = server.Register(Echo{})
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	defer func() { _ = l.Close() }()
+	go server.Accept(l)
+	// 接受连接但不完成握手的服务端
+	silent, _ := net.Listen("tcp", "127.0.0.1:0")
+	defer func() { _ = silent.Close() }()
+
+	pool := NewTransportPool(&Option{MagicNumber: MagicNumber, CodecType: DefaultOption.CodecType, ConnectTimeout: time.Second})
+	defer func() { _ = pool.Close() }()
+	stuck := make(chan error, 1)
+	go func() {
+		_, err := pool.Client("tcp@" + silent.Addr().String())
+		stuck <- err
+	}()
+	time.Sleep(time.Millisecond * 50)
+
+	// 建立其他地址的连接时不阻塞，同一地址的并发调用共享一个连接
+	start := time.Now()
+	clients := make([]*Client, 8)
+	var wg sync.WaitGroup
+	for i := range clients {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			clients[i], _ = pool.Client("tcp@" + l.Addr().String())
+		}(i)
+	}
+	wg.Wait()
+	_assert(time.Since(start) < time.Millisecond*500, "expect dial to another address not to block, took %v", time.Since(start))
+	for _, c := range clients {
+		_assert(c != nil && c.Transport() == clients[0].Transport(), "expect concurrent callers to share one transport")
+	}
+	err := <-stuck
+	_assert(err != nil, "expect dial to silent server to time out")
+}
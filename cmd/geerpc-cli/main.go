@@ -0,0 +1,135 @@
+// geerpc-cli 通过内置的反射服务查询 geerpc 服务端，并以 JSON 格式的参数调用方法，用法：
+//
+//	geerpc-cli tcp@localhost:9999 list                     列出所有服务
+//	geerpc-cli tcp@localhost:9999 list Foo                 列出服务 Foo 的方法
+//	geerpc-cli tcp@localhost:9999 describe Foo.Sum         查看方法的参数与返回值类型
+//	geerpc-cli tcp@localhost:9999 call Foo.Sum '{"Num1": 1, "Num2": 2}'
+//
+// 地址的格式与 geerpc.XDial 相同，支持 tcp、http 与 unix，call 未指定参数时从标准输入读取
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"geerpc"
+	"geerpc/codec"
+)
+
+// metadataFlag 收集多次出现的 -H key=value
+type metadataFlag geerpc.Metadata
+
+func (m metadataFlag) String() string {
+	return fmt.Sprint(geerpc.Metadata(m))
+}
+
+func (m metadataFlag) Set(s string) error {
+	key, value, ok := strings.Cut(s, "=")
+	if !ok {
+		return fmt.Errorf("expect key=value, got %q", s)
+	}
+	m[key] = value
+	return nil
+}
+
+func main() {
+	timeout := flag.Duration("timeout", 10*time.Second, "连接与调用的超时时间")
+	token := flag.String("token", "", "握手时发送的 Option.AuthToken")
+	md := metadataFlag{}
+	flag.Var(md, "H", "随请求发送的 metadata，格式为 key=value，可以指定多次")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: geerpc-cli [flags] protocol@addr list [service]\n")
+		fmt.Fprintf(os.Stderr, "       geerpc-cli [flags] protocol@addr describe Service.Method\n")
+		fmt.Fprintf(os.Stderr, "       geerpc-cli [flags] protocol@addr call Service.Method [json]\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() < 2 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	client, err := geerpc.XDialContext(ctx, flag.Arg(0), &geerpc.Option{
+		MagicNumber: geerpc.MagicNumber,
+		CodecType:   codec.JsonType,
+		AuthToken:   *token,
+	})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "geerpc-cli:", err)
+		os.Exit(1)
+	}
+	defer func() { _ = client.Close() }()
+
+	if err := run(ctx, client, geerpc.Metadata(md), flag.Args()[1:], os.Stdin, os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, "geerpc-cli:", err)
+		os.Exit(1)
+	}
+}
+
+// 执行 args 指定的命令，client 须使用 JSON 编码
+func run(ctx context.Context, client *geerpc.Client, md geerpc.Metadata, args []string, stdin io.Reader, stdout io.Writer) error {
+	opts := []geerpc.CallOption{geerpc.WithMetadata(md)}
+	switch cmd := args[0]; {
+	case cmd == "list" && len(args) == 1:
+		var services []string
+		if err := client.Call(ctx, geerpc.ReflectionServiceName+".ListServices", "", &services, opts...); err != nil {
+			return err
+		}
+		return printJSON(stdout, services)
+	case cmd == "list" && len(args) == 2:
+		var methods []string
+		if err := client.Call(ctx, geerpc.ReflectionServiceName+".ListMethods", args[1], &methods, opts...); err != nil {
+			return err
+		}
+		return printJSON(stdout, methods)
+	case cmd == "describe" && len(args) == 2:
+		var desc geerpc.MethodDesc
+		if err := client.Call(ctx, geerpc.ReflectionServiceName+".DescribeMethod", args[1], &desc, opts...); err != nil {
+			return err
+		}
+		return printJSON(stdout, desc)
+	case cmd == "call" && (len(args) == 2 || len(args) == 3):
+		var argv []byte
+		if len(args) == 3 {
+			argv = []byte(args[2])
+		} else {
+			var err error
+			if argv, err = io.ReadAll(stdin); err != nil {
+				return err
+			}
+		}
+		if !json.Valid(argv) {
+			return errors.New("args is not valid JSON")
+		}
+		var reply json.RawMessage
+		if err := client.Call(ctx, args[1], json.RawMessage(argv), &reply, opts...); err != nil {
+			return err
+		}
+		return printJSON(stdout, reply)
+	}
+	return fmt.Errorf("unknown command %q", strings.Join(args, " "))
+}
+
+func printJSON(w io.Writer, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	if err := json.Indent(&buf, data, "", "  "); err != nil {
+		return err
+	}
+	buf.WriteByte('\n')
+	_, err = buf.WriteTo(w)
+	return err
+}
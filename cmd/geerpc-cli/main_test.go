@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net"
+	"strings"
+	"testing"
+
+	"geerpc"
+	"geerpc/codec"
+)
+
+type Args struct{ Num1, Num2 int }
+
+type Foo struct{}
+
+func (Foo) Sum(args Args, reply *int) error {
+	*reply = args.Num1 + args.Num2
+	return nil
+}
+
+func (Foo) Token(ctx context.Context, _ int, reply *string) error {
+	*reply = geerpc.MetadataFromContext(ctx)["token"]
+	return nil
+}
+
+func TestRun(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := geerpc.NewServer()
+	_ = server.Register(Foo{})
+	go server.Accept(l)
+	defer func() { _ = l.Close() }()
+
+	client, err := geerpc.XDial("tcp@"+l.Addr().String(), &geerpc.Option{
+		MagicNumber: geerpc.MagicNumber,
+		CodecType:   codec.JsonType,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = client.Close() }()
+
+	exec := func(stdin string, args ...string) (string, error) {
+		var out bytes.Buffer
+		md := geerpc.Metadata{"token": "abc"}
+		err := run(context.Background(), client, md, args, strings.NewReader(stdin), &out)
+		return out.String(), err
+	}
+
+	out, err := exec("", "list")
+	var services []string
+	if err != nil || json.Unmarshal([]byte(out), &services) != nil || len(services) != 3 || services[0] != "Foo" {
+		t.Fatalf("unexpected services: %s, err: %v", out, err)
+	}
+
+	out, err = exec("", "list", "Foo")
+	if err != nil || !strings.Contains(out, `"Sum"`) || !strings.Contains(out, `"Token"`) {
+		t.Fatalf("unexpected methods: %s, err: %v", out, err)
+	}
+
+	out, err = exec("", "describe", "Foo.Sum")
+	var desc geerpc.MethodDesc
+	if err != nil || json.Unmarshal([]byte(out), &desc) != nil || len(desc.Args.Fields) != 2 || desc.Reply.Kind != "int" {
+		t.Fatalf("unexpected desc: %s, err: %v", out, err)
+	}
+
+	if out, err = exec("", "call", "Foo.Sum", `{"Num1": 1, "Num2": 2}`); err != nil || out != "3\n" {
+		t.Fatalf("expect 3, got %s, err: %v", out, err)
+	}
+	if out, err = exec(`{"Num1": 3, "Num2": 4}`, "call", "Foo.Sum"); err != nil || out != "7\n" {
+		t.Fatalf("expect args from stdin, got %s, err: %v", out, err)
+	}
+	if out, err = exec("", "call", "Foo.Token", "0"); err != nil || out != "\"abc\"\n" {
+		t.Fatalf("expect metadata to be sent, got %s, err: %v", out, err)
+	}
+
+	if _, err = exec("", "call", "Foo.Sum", "{"); err == nil {
+		t.Fatal("expect error for invalid JSON")
+	}
+	if _, err = exec("", "call", "Foo.Missing", "{}"); geerpc.CodeOf(err) != geerpc.NotFound {
+		t.Fatalf("expect NotFound, got %v", err)
+	}
+	if _, err = exec("", "remove"); err == nil {
+		t.Fatal("expect error for unknown command")
+	}
+}
@@ -0,0 +1,251 @@
+// geerpc-gen 根据 Go interface 的定义生成类型安全的客户端、服务端注册代码与 mock，用法：
+//
+//	//go:generate geerpc-gen -type Arith
+//
+// interface 的每个方法须为 Method(ctx context.Context, args A) (R, error) 的形式，
+// 生成的文件默认为 <type>_geerpc.go，与 interface 位于同一个包
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+func main() {
+	typeName := flag.String("type", "", "interface 的名称，必填")
+	output := flag.String("output", "", "生成的文件，默认为 <type>_geerpc.go")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: geerpc-gen -type Name [-output file] [source.go]\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if *typeName == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	// 通过 go:generate 调用时默认读取所在的文件
+	source := flag.Arg(0)
+	if source == "" {
+		source = os.Getenv("GOFILE")
+	}
+	if source == "" {
+		log.Fatal("geerpc-gen: no source file")
+	}
+	src, err := os.ReadFile(source)
+	if err != nil {
+		log.Fatal("geerpc-gen: ", err)
+	}
+
+	code, err := generate(source, src, *typeName)
+	if err != nil {
+		log.Fatal("geerpc-gen: ", err)
+	}
+	if *output == "" {
+		*output = filepath.Join(filepath.Dir(source), strings.ToLower(*typeName)+"_geerpc.go")
+	}
+	if err := os.WriteFile(*output, code, 0644); err != nil {
+		log.Fatal("geerpc-gen: ", err)
+	}
+}
+
+type method struct {
+	Name  string
+	Args  string // 参数类型
+	Reply string // 返回值类型
+}
+
+type service struct {
+	Package    string
+	Name       string
+	StdImports []string // 标准库的 import，与 context 放在同一组
+	Imports    []string
+	Methods    []method
+}
+
+// 解析 src 中名为 typeName 的 interface，返回生成的代码
+func generate(filename string, src []byte, typeName string) ([]byte, error) {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, filename, src, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	var iface *ast.InterfaceType
+	ast.Inspect(file, func(n ast.Node) bool {
+		if spec, ok := n.(*ast.TypeSpec); ok && spec.Name.Name == typeName {
+			iface, _ = spec.Type.(*ast.InterfaceType)
+			return false
+		}
+		return iface == nil
+	})
+	if iface == nil {
+		return nil, fmt.Errorf("interface %s not found in %s", typeName, filename)
+	}
+
+	svc := &service{Package: file.Name.Name, Name: typeName}
+	used := map[string]bool{"context": true}
+	for _, field := range iface.Methods.List {
+		fn, ok := field.Type.(*ast.FuncType)
+		if !ok || len(field.Names) == 0 {
+			return nil, fmt.Errorf("%s: embedded interfaces are not supported", typeName)
+		}
+		m, err := parseMethod(field.Names[0].Name, fn)
+		if err != nil {
+			return nil, fmt.Errorf("%s.%w", typeName, err)
+		}
+		svc.Methods = append(svc.Methods, m)
+		collectPackages(fn, used)
+	}
+	if len(svc.Methods) == 0 {
+		return nil, fmt.Errorf("interface %s has no methods", typeName)
+	}
+
+	// 只保留方法签名中使用到的 import
+	for _, spec := range file.Imports {
+		path, _ := strconv.Unquote(spec.Path.Value)
+		name := filepath.Base(path)
+		if spec.Name != nil {
+			name = spec.Name.Name
+		}
+		if used[name] && path != "context" {
+			imp := strconv.Quote(path)
+			if spec.Name != nil {
+				imp = spec.Name.Name + " " + imp
+			}
+			if strings.Contains(strings.Split(path, "/")[0], ".") {
+				svc.Imports = append(svc.Imports, imp)
+			} else {
+				svc.StdImports = append(svc.StdImports, imp)
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, svc); err != nil {
+		return nil, err
+	}
+	return format.Source(buf.Bytes())
+}
+
+// 检查方法是否为 Method(ctx context.Context, args A) (R, error) 的形式
+func parseMethod(name string, fn *ast.FuncType) (method, error) {
+	var params, results []ast.Expr
+	for _, field := range fn.Params.List {
+		for i := 0; i < max(len(field.Names), 1); i++ {
+			params = append(params, field.Type)
+		}
+	}
+	if fn.Results != nil {
+		for _, field := range fn.Results.List {
+			for i := 0; i < max(len(field.Names), 1); i++ {
+				results = append(results, field.Type)
+			}
+		}
+	}
+	if len(params) != 2 || types.ExprString(params[0]) != "context.Context" ||
+		len(results) != 2 || types.ExprString(results[1]) != "error" {
+		return method{}, errors.New(name + ": expect signature (ctx context.Context, args A) (R, error)")
+	}
+	return method{Name: name, Args: types.ExprString(params[1]), Reply: types.ExprString(results[0])}, nil
+}
+
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// 记录方法签名中引用的包名
+func collectPackages(fn *ast.FuncType, used map[string]bool) {
+	ast.Inspect(fn, func(n ast.Node) bool {
+		if sel, ok := n.(*ast.SelectorExpr); ok {
+			if ident, ok := sel.X.(*ast.Ident); ok {
+				used[ident.Name] = true
+			}
+		}
+		return true
+	})
+}
+
+var tmpl = template.Must(template.New("geerpc").Funcs(template.FuncMap{
+	"lower": func(s string) string { return strings.ToLower(s[:1]) + s[1:] },
+}).Parse(`// Code generated by geerpc-gen. DO NOT EDIT.
+
+package {{.Package}}
+
+import (
+	"context"
+{{- range .StdImports}}
+	{{.}}
+{{- end}}
+
+	"geerpc"
+{{- range .Imports}}
+	{{.}}
+{{- end}}
+)
+
+// {{.Name}}ServiceName 为 {{.Name}} 注册到 geerpc.Server 的服务名
+const {{.Name}}ServiceName = "{{.Name}}"
+
+// {{.Name}}Client 通过 geerpc.Client 调用远程的 {{.Name}}，实现了 {{.Name}} 接口
+type {{.Name}}Client struct {
+	client *geerpc.Client
+}
+
+// New{{.Name}}Client 返回使用 client 发起调用的 {{.Name}}Client
+func New{{.Name}}Client(client *geerpc.Client) *{{.Name}}Client {
+	return &{{.Name}}Client{client: client}
+}
+{{range .Methods}}
+func (c *{{$.Name}}Client) {{.Name}}(ctx context.Context, args {{.Args}}) ({{.Reply}}, error) {
+	var reply {{.Reply}}
+	err := c.client.Call(ctx, {{$.Name}}ServiceName+".{{.Name}}", args, &reply)
+	return reply, err
+}
+{{end}}
+// {{lower .Name}}Server 将 {{.Name}} 适配为 geerpc 服务的方法形式
+type {{lower .Name}}Server struct {
+	impl {{.Name}}
+}
+{{range .Methods}}
+func (s *{{lower $.Name}}Server) {{.Name}}(ctx context.Context, args {{.Args}}, reply *{{.Reply}}) (err error) {
+	*reply, err = s.impl.{{.Name}}(ctx, args)
+	return err
+}
+{{end}}
+// Register{{.Name}} 将 impl 以服务名 {{.Name}}ServiceName 注册到 server
+func Register{{.Name}}(server *geerpc.Server, impl {{.Name}}) error {
+	return server.RegisterName({{.Name}}ServiceName, &{{lower .Name}}Server{impl: impl})
+}
+
+// Mock{{.Name}} 为 {{.Name}} 的 mock，方法调用对应的函数字段，字段为 nil 时返回 Unimplemented 错误
+type Mock{{.Name}} struct {
+{{- range .Methods}}
+	{{.Name}}Func func(ctx context.Context, args {{.Args}}) ({{.Reply}}, error)
+{{- end}}
+}
+{{range .Methods}}
+func (m *Mock{{$.Name}}) {{.Name}}(ctx context.Context, args {{.Args}}) ({{.Reply}}, error) {
+	if m.{{.Name}}Func == nil {
+		var zero {{.Reply}}
+		return zero, geerpc.Errorf(geerpc.Unimplemented, "Mock{{$.Name}}.{{.Name}} is not implemented")
+	}
+	return m.{{.Name}}Func(ctx, args)
+}
+{{end}}`))
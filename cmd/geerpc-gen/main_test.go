@@ -0,0 +1,72 @@
+package main
+
+import (
+	"go/parser"
+	"go/token"
+	"strings"
+	"testing"
+)
+
+const arithSource = `package arith
+
+import (
+	"context"
+	"time"
+
+	pb "example.com/arith/proto"
+)
+
+type Arith interface {
+	Sum(ctx context.Context, args pb.Args) (int, error)
+	Sleep(ctx context.Context, d time.Duration) (struct{}, error)
+}
+`
+
+func TestGenerate(t *testing.T) {
+	code, err := generate("arith.go", []byte(arithSource), "Arith")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := parser.ParseFile(token.NewFileSet(), "arith_geerpc.go", code, 0); err != nil {
+		t.Fatal("generated code does not parse: ", err)
+	}
+	for _, want := range []string{
+		"// Code generated by geerpc-gen. DO NOT EDIT.",
+		"package arith",
+		`pb "example.com/arith/proto"`,
+		`"time"`,
+		`const ArithServiceName = "Arith"`,
+		"func NewArithClient(client *geerpc.Client) *ArithClient",
+		"func (c *ArithClient) Sum(ctx context.Context, args pb.Args) (int, error)",
+		"func (s *arithServer) Sleep(ctx context.Context, args time.Duration, reply *struct{}) (err error)",
+		"func RegisterArith(server *geerpc.Server, impl Arith) error",
+		"SumFunc   func(ctx context.Context, args pb.Args) (int, error)",
+		"geerpc.Errorf(geerpc.Unimplemented,",
+	} {
+		if !strings.Contains(string(code), want) {
+			t.Fatalf("generated code missing %q:\n%s", want, code)
+		}
+	}
+}
+
+func TestGenerateErrors(t *testing.T) {
+	cases := map[string]string{
+		"not found": `package a
+type Other interface{}`,
+		"no methods": `package a
+type Arith interface{}`,
+		"bad signature": `package a
+type Arith interface {
+	Sum(a, b int) int
+}`,
+		"embedded": `package a
+type Arith interface {
+	Other
+}`,
+	}
+	for name, src := range cases {
+		if _, err := generate("a.go", []byte(src), "Arith"); err == nil {
+			t.Fatalf("%s: expect error", name)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package codec
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"sync/atomic"
+)
+
+// 启用校验和时，扩展字段之后为固定长度的头部与扩展字段的 CRC-32C，body 之后为 body 的 CRC-32C（没有 body 时省略），
+// 校验和计算的是线路上的字节，即加密与压缩之后的数据
+const (
+	frameChecksum uint8 = 1 << 2 // 头部与 body 之后附带了校验和
+
+	checksumSize = 4
+)
+
+// ErrChecksumMismatch 表示消息的校验和不一致，数据在传输中被篡改或损坏
+var ErrChecksumMismatch = errors.New("codec: frame checksum mismatch")
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// ChecksumSetter 开启或关闭消息的校验和，开启后读取时校验每个消息并拒绝没有校验和的消息，
+// NewFrameCodec 返回的 Codec 实现了该接口
+type ChecksumSetter interface {
+	SetChecksum(enabled bool)
+}
+
+func (c *frameCodec) SetChecksum(enabled bool) {
+	c.checksum = enabled
+}
+
+// 读取 data 之后的校验和并与 data 的 CRC-32C 比较
+func (c *frameCodec) verifyChecksum(crc uint32) error {
+	if _, err := io.ReadFull(c.r, c.rsum[:]); err != nil {
+		return err
+	}
+	atomic.AddInt64(&c.read, checksumSize)
+	if binary.BigEndian.Uint32(c.rsum[:]) != crc {
+		return ErrChecksumMismatch
+	}
+	return nil
+}
+
+// 检查头部的校验和标记与连接的设置是否一致，开启校验和后拒绝没有校验和的消息
+func (c *frameCodec) checkChecksumFlag(frameFlags uint8) error {
+	if checksummed := frameFlags&frameChecksum != 0; checksummed != c.checksum {
+		return fmt.Errorf("%w: checksum flag mismatch", ErrInvalidFrame)
+	}
+	return nil
+}
+
+// 写入 data 的 CRC-32C
+func (c *frameCodec) writeChecksum(crc uint32) error {
+	binary.BigEndian.PutUint32(c.wsum[:], crc)
+	_, err := c.w.Write(c.wsum[:])
+	return err
+}
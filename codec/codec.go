@@ -2,14 +2,47 @@ package codec
 
 import (
 	"io"
+	"sync"
+	"time"
+)
+
+// Flag 标识消息的类型
+type Flag uint32
+
+const (
+	FlagStream       Flag = 1 << iota // 消息属于一个流，Seq 即为流的 ID
+	FlagEndStream                     // 流结束标记，客户端发送时表示不再发送消息
+	FlagWindowUpdate                  // 流控窗口更新，body 为归还的窗口大小（uint32）
+	FlagCancel                        // 客户端取消 Seq 对应的请求或流
+	FlagPing                          // 客户端在连接空闲时发送的保活探测，服务端以 FlagPong 应答
+	FlagPong                          // 服务端对 FlagPing 的应答
+	FlagGoAway                        // 服务端即将关闭连接，客户端不应再在该连接上发起新的调用
+	FlagChunk                         // body 为分块发送的响应 body 的一部分，与 FlagWindowUpdate 一同使用时为客户端归还的分块窗口
+	FlagLastChunk                     // 响应 body 的最后一个分块，客户端拼接所有分块后反序列化
+	FlagProgress                      // 请求携带时表示客户端接收进度，响应携带时 body 为调用的进度
+	FlagReverse                       // 消息属于服务端发起的反向调用，Seq 由服务端分配
+	FlagRaw                           // 请求的 body 为原始字节，不经过序列化，服务端同样以原始字节响应
 )
 
 type Header struct {
 	// format "Service.Method"
 	ServiceMethod string
 	// sequence number chosen by client
-	Seq   uint64
-	Error string
+	Seq uint64
+	// 错误码，0 表示调用成功
+	Code uint32
+	// 错误信息与可选的错误详情
+	Error   string
+	Details []byte
+	// 随请求或响应传递的键值对
+	Metadata map[string]string
+	Flags    Flag
+	// 客户端 context 的截止时间，零值表示没有截止时间
+	Deadline time.Time
+	// 请求的优先级，数值越大越优先，默认为 0
+	Priority int32
+	// body 的序列化方式，为空时使用握手时协商的 codec，使一个连接上可以混用多种序列化方式，见 RegisterBodyCodec
+	ContentType Type
 }
 
 type Codec interface {
@@ -19,18 +52,54 @@ type Codec interface {
 	Write(*Header, interface{}) error
 }
 
+// RawCodec 以序列化后的字节读写 body，用于将大的 body 分块发送，NewFrameCodec 返回的 Codec 实现了该接口
+type RawCodec interface {
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+	// WriteRaw 写入 body 为 data 的消息，data 同样受最大字节数的限制
+	WriteRaw(h *Header, data []byte) error
+	// ReadRawBody 读取上一个 header 对应的 body，返回解密与解压后的字节
+	ReadRawBody() ([]byte, error)
+}
+
 type NewCoderFunc func(io.ReadWriteCloser) Codec
 
 type Type string
 
 const (
-	GobType  Type = "application/gob"
-	JsonType Type = "application/json"
+	GobType      Type = "application/gob"
+	JsonType     Type = "application/json"
+	ProtobufType Type = "application/protobuf"
 )
 
 var NewCodecFuncMap map[Type]NewCoderFunc
 
+var (
+	bodyCodecsMu sync.RWMutex
+	bodyCodecs   = map[Type]BodyCodec{}
+)
+
 func init() {
 	NewCodecFuncMap = make(map[Type]NewCoderFunc)
 	NewCodecFuncMap[GobType] = NewGobCodec
+	NewCodecFuncMap[JsonType] = NewJsonCodec
+	NewCodecFuncMap[ProtobufType] = NewProtobufCodec
+
+	RegisterBodyCodec(GobType, gobBody{})
+	RegisterBodyCodec(JsonType, jsonBody{})
+	RegisterBodyCodec(ProtobufType, protobufBody{})
+}
+
+// RegisterBodyCodec 注册 Header.ContentType 为 t 的消息使用的 BodyCodec，重复注册时覆盖
+func RegisterBodyCodec(t Type, b BodyCodec) {
+	bodyCodecsMu.Lock()
+	defer bodyCodecsMu.Unlock()
+	bodyCodecs[t] = b
+}
+
+// GetBodyCodec 返回 t 对应的 BodyCodec，未注册时返回 nil
+func GetBodyCodec(t Type) BodyCodec {
+	bodyCodecsMu.RLock()
+	defer bodyCodecsMu.RUnlock()
+	return bodyCodecs[t]
 }
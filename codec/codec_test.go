@@ -0,0 +1,468 @@
+package codec
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+func TestJsonCodec(t *testing.T) {
+	c1, c2 := net.Pipe()
+	client, server := NewJsonCodec(c1), NewJsonCodec(c2)
+	defer func() { _ = client.Close() }()
+	defer func() { _ = server.Close() }()
+
+	type args struct{ Num1, Num2 int }
+	go func() {
+		_ = client.Write(&Header{ServiceMethod: "Foo.Sum", Seq: 1}, &args{Num1: 1, Num2: 2})
+		_ = client.Write(&Header{ServiceMethod: "Foo.Sum", Seq: 2}, &args{Num1: 3, Num2: 4})
+	}()
+
+	var h Header
+	if err := server.ReadHeader(&h); err != nil || h.ServiceMethod != "Foo.Sum" || h.Seq != 1 {
+		t.Fatalf("unexpected header %+v, err: %v", h, err)
+	}
+	// 丢弃第一个 body
+	if err := server.ReadBody(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	var a args
+	if err := server.ReadHeader(&h); err != nil || h.Seq != 2 {
+		t.Fatalf("unexpected header %+v, err: %v", h, err)
+	}
+	if err := server.ReadBody(&a); err != nil || a.Num1 != 3 || a.Num2 != 4 {
+		t.Fatalf("unexpected body %+v, err: %v", a, err)
+	}
+}
+
+func TestProtobufCodec(t *testing.T) {
+	c1, c2 := net.Pipe()
+	client, server := NewProtobufCodec(c1), NewProtobufCodec(c2)
+	defer func() { _ = client.Close() }()
+	defer func() { _ = server.Close() }()
+
+	go func() {
+		_ = client.Write(&Header{ServiceMethod: "Foo.Echo", Seq: 1, Error: "oops"}, nil)
+		_ = client.Write(&Header{ServiceMethod: "Foo.Echo", Seq: 2}, wrapperspb.String("hello"))
+	}()
+
+	var h Header
+	if err := server.ReadHeader(&h); err != nil || h.Seq != 1 || h.Error != "oops" {
+		t.Fatalf("unexpected header %+v, err: %v", h, err)
+	}
+	if err := server.ReadBody(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	var s wrapperspb.StringValue
+	if err := server.ReadHeader(&h); err != nil || h.ServiceMethod != "Foo.Echo" || h.Seq != 2 || h.Error != "" {
+		t.Fatalf("unexpected header %+v, err: %v", h, err)
+	}
+	if err := server.ReadBody(&s); err != nil || s.GetValue() != "hello" {
+		t.Fatalf("unexpected body %v, err: %v", s.GetValue(), err)
+	}
+}
+
+func TestFrameHeader(t *testing.T) {
+	c1, c2 := net.Pipe()
+	client, server := NewGobCodec(c1), NewGobCodec(c2)
+	defer func() { _ = client.Close() }()
+	defer func() { _ = server.Close() }()
+
+	want := Header{
+		ServiceMethod: "Foo.Sum",
+		Seq:           7,
+		Code:          5,
+		Error:         "not found",
+		Details:       []byte{1, 2, 3},
+		Metadata:      map[string]string{"a": "1", "b": "2"},
+		Flags:         FlagStream | FlagEndStream,
+		Deadline:      time.Unix(0, 1700000000123456789),
+		Priority:      -3,
+		ContentType:   JsonType,
+	}
+	go func() {
+		_ = client.Write(&want, 1)
+		_ = client.Write(&Header{Seq: 8}, nil)
+		_ = client.Write(&Header{Seq: 9}, 2)
+	}()
+
+	var h Header
+	if err := server.ReadHeader(&h); err != nil || !reflect.DeepEqual(h, want) {
+		t.Fatalf("expect %+v, got %+v, err: %v", want, h, err)
+	}
+	// 未读取的 body 在读取下一个 header 时被丢弃
+	if err := server.ReadHeader(&h); err != nil || h.Seq != 8 || !h.Deadline.IsZero() || h.Metadata != nil || h.Priority != 0 {
+		t.Fatalf("unexpected header %+v, err: %v", h, err)
+	}
+	var n int
+	if err := server.ReadBody(&n); err != nil || n != 0 {
+		t.Fatalf("expect empty body, got %d, err: %v", n, err)
+	}
+	if err := server.ReadHeader(&h); err != nil || h.Seq != 9 {
+		t.Fatalf("unexpected header %+v, err: %v", h, err)
+	}
+	if err := server.ReadBody(&n); err != nil || n != 2 {
+		t.Fatalf("expect 2, got %d, err: %v", n, err)
+	}
+}
+
+func TestContentType(t *testing.T) {
+	c1, c2 := net.Pipe()
+	client, server := NewGobCodec(c1), NewGobCodec(c2)
+	defer func() { _ = client.Close() }()
+	defer func() { _ = server.Close() }()
+
+	type args struct{ Num1, Num2 int }
+	if err := client.Write(&Header{ContentType: "application/unknown"}, 1); !errors.Is(err, ErrUnsupportedContentType) {
+		t.Fatalf("expect ErrUnsupportedContentType, got %v", err)
+	}
+	go func() {
+		_ = client.Write(&Header{Seq: 1, ContentType: JsonType}, &args{Num1: 1, Num2: 2})
+		_ = client.Write(&Header{Seq: 2}, &args{Num1: 3, Num2: 4})
+	}()
+
+	// 同一个连接上的消息分别使用 JSON 与 gob
+	var h Header
+	var a args
+	if err := server.ReadHeader(&h); err != nil || h.ContentType != JsonType {
+		t.Fatalf("unexpected header %+v, err: %v", h, err)
+	}
+	data, err := server.(RawCodec).ReadRawBody()
+	if err != nil || string(data) != `{"Num1":1,"Num2":2}` {
+		t.Fatalf("expect JSON body, got %q, err: %v", data, err)
+	}
+	if err := server.ReadHeader(&h); err != nil || h.ContentType != "" {
+		t.Fatalf("unexpected header %+v, err: %v", h, err)
+	}
+	if err := server.ReadBody(&a); err != nil || a.Num1 != 3 {
+		t.Fatalf("expect gob body, got %+v, err: %v", a, err)
+	}
+}
+
+func TestMaxMessageSize(t *testing.T) {
+	c1, c2 := net.Pipe()
+	client, server := NewGobCodec(c1), NewGobCodec(c2)
+	defer func() { _ = client.Close() }()
+	defer func() { _ = server.Close() }()
+	client.(SizeLimiter).SetMaxMessageSize(64)
+	server.(SizeLimiter).SetMaxMessageSize(32)
+
+	big := strings.Repeat("x", 100)
+	if err := client.Write(&Header{Seq: 1}, big); !errors.Is(err, ErrMessageTooLarge) {
+		t.Fatalf("expect ErrMessageTooLarge on write, got %v", err)
+	}
+	go func() {
+		_ = client.Write(&Header{Seq: 2}, strings.Repeat("x", 40))
+		_ = client.Write(&Header{Seq: 3}, "ok")
+	}()
+
+	var h Header
+	var s string
+	if err := server.ReadHeader(&h); err != nil || h.Seq != 2 {
+		t.Fatalf("unexpected header %+v, err: %v", h, err)
+	}
+	if err := server.ReadBody(&s); !errors.Is(err, ErrMessageTooLarge) {
+		t.Fatalf("expect ErrMessageTooLarge on read, got %v", err)
+	}
+	// 超过限制的 body 被丢弃后连接仍可使用
+	if err := server.ReadHeader(&h); err != nil || h.Seq != 3 {
+		t.Fatalf("unexpected header %+v, err: %v", h, err)
+	}
+	if err := server.ReadBody(&s); err != nil || s != "ok" {
+		t.Fatalf("expect ok, got %q, err: %v", s, err)
+	}
+}
+
+func TestCompression(t *testing.T) {
+	c1, c2 := net.Pipe()
+	client, server := NewJsonCodec(c1), NewJsonCodec(c2)
+	defer func() { _ = client.Close() }()
+	defer func() { _ = server.Close() }()
+	gzip := GetCompressor("gzip")
+	client.(CompressorSetter).SetCompressor(gzip, 100)
+	server.(CompressorSetter).SetCompressor(gzip, 100)
+	server.(SizeLimiter).SetMaxMessageSize(4096)
+
+	big := strings.Repeat("x", 4000)
+	go func() {
+		_ = client.Write(&Header{Seq: 1}, big)
+		_ = client.Write(&Header{Seq: 2}, "small")
+		_ = client.Write(&Header{Seq: 3}, big+big)
+	}()
+
+	var h Header
+	var s string
+	if err := server.ReadHeader(&h); err != nil || h.Seq != 1 {
+		t.Fatalf("unexpected header %+v, err: %v", h, err)
+	}
+	if err := server.ReadBody(&s); err != nil || s != big {
+		t.Fatalf("expect decompressed body, got %d bytes, err: %v", len(s), err)
+	}
+	if n := server.(Counter).BytesRead(); n > 1000 {
+		t.Fatalf("expect body to be compressed, read %d bytes", n)
+	}
+	if err := server.ReadHeader(&h); err != nil || h.Seq != 2 {
+		t.Fatalf("unexpected header %+v, err: %v", h, err)
+	}
+	if err := server.ReadBody(&s); err != nil || s != "small" {
+		t.Fatalf("expect small, got %q, err: %v", s, err)
+	}
+	// 解压后的大小同样受限制
+	if err := server.ReadHeader(&h); err != nil || h.Seq != 3 {
+		t.Fatalf("unexpected header %+v, err: %v", h, err)
+	}
+	if err := server.ReadBody(&s); !errors.Is(err, ErrMessageTooLarge) {
+		t.Fatalf("expect ErrMessageTooLarge, got %v", err)
+	}
+}
+
+// bufferConn 将写入的数据保存在内存中，供读取与检查
+type bufferConn struct {
+	bytes.Buffer
+}
+
+func (c *bufferConn) Close() error {
+	return nil
+}
+
+func TestEncryption(t *testing.T) {
+	key := []byte("0123456789abcdef")
+	aead, _ := NewAESGCM(key)
+	conn := &bufferConn{}
+	client, server := NewJsonCodec(conn), NewJsonCodec(conn)
+	client.(CipherSetter).SetCipher(aead, aead)
+	server.(CipherSetter).SetCipher(aead, aead)
+
+	if err := client.Write(&Header{ServiceMethod: "Foo.Secret", Seq: 1}, "top secret"); err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(conn.Bytes(), []byte("secret")) || bytes.Contains(conn.Bytes(), []byte("Foo")) {
+		t.Fatal("expect message to be encrypted on the wire")
+	}
+	var h Header
+	var s string
+	if err := server.ReadHeader(&h); err != nil || h.ServiceMethod != "Foo.Secret" {
+		t.Fatalf("unexpected header %+v, err: %v", h, err)
+	}
+	if err := server.ReadBody(&s); err != nil || s != "top secret" {
+		t.Fatalf("expect top secret, got %q, err: %v", s, err)
+	}
+
+	// 使用不同的密钥或明文发送的消息被拒绝
+	other, _ := NewAESGCM([]byte("fedcba9876543210"))
+	client.(CipherSetter).SetCipher(other, other)
+	_ = client.Write(&Header{Seq: 2}, "x")
+	if err := server.ReadHeader(&h); !errors.Is(err, ErrInvalidFrame) {
+		t.Fatalf("expect ErrInvalidFrame with wrong key, got %v", err)
+	}
+	conn.Reset()
+	_ = NewJsonCodec(conn).Write(&Header{Seq: 3}, "x")
+	if err := server.ReadHeader(&h); !errors.Is(err, ErrInvalidFrame) {
+		t.Fatalf("expect ErrInvalidFrame for plaintext, got %v", err)
+	}
+}
+
+func TestSessionEncryption(t *testing.T) {
+	key := []byte("0123456789abcdef")
+	newPair := func(salt string) (*bufferConn, Codec, Codec) {
+		conn := &bufferConn{}
+		client, server := NewJsonCodec(conn), NewJsonCodec(conn)
+		seal, open, _ := NewSessionAESGCM(key, []byte(salt), true)
+		client.(CipherSetter).SetCipher(seal, open)
+		seal, open, _ = NewSessionAESGCM(key, []byte(salt), false)
+		server.(CipherSetter).SetCipher(seal, open)
+		return conn, client, server
+	}
+
+	conn, client, server := newPair("connection 1")
+	var h Header
+	var s string
+	_ = client.Write(&Header{ServiceMethod: "Foo.Secret", Seq: 1}, "x")
+	frame := append([]byte(nil), conn.Bytes()...)
+	if err := server.ReadHeader(&h); err != nil || h.ServiceMethod != "Foo.Secret" {
+		t.Fatalf("unexpected header %+v, err: %v", h, err)
+	}
+	if err := server.ReadBody(&s); err != nil || s != "x" {
+		t.Fatalf("expect x, got %q, err: %v", s, err)
+	}
+	// 同一连接上重放的消息被拒绝
+	conn.Write(frame)
+	if err := server.ReadHeader(&h); !errors.Is(err, ErrInvalidFrame) {
+		t.Fatalf("expect ErrInvalidFrame for replayed message, got %v", err)
+	}
+
+	// 调换顺序的消息被拒绝
+	conn, client, server = newPair("connection 2")
+	_ = client.Write(&Header{Seq: 1}, "a")
+	first := append([]byte(nil), conn.Bytes()...)
+	conn.Reset()
+	_ = client.Write(&Header{Seq: 2}, "b")
+	conn.Write(first)
+	if err := server.ReadHeader(&h); !errors.Is(err, ErrInvalidFrame) {
+		t.Fatalf("expect ErrInvalidFrame for reordered message, got %v", err)
+	}
+
+	// 其他连接的消息被拒绝
+	conn, client, _ = newPair("connection 3")
+	_, _, server = newPair("connection 4")
+	_ = client.Write(&Header{Seq: 1}, "x")
+	server.(*frameCodec).r.Reset(conn)
+	if err := server.ReadHeader(&h); !errors.Is(err, ErrInvalidFrame) {
+		t.Fatalf("expect ErrInvalidFrame for message of another connection, got %v", err)
+	}
+
+	// 服务端发出的消息无法作为客户端的消息发回服务端
+	conn, _, server = newPair("connection 5")
+	_ = server.Write(&Header{Seq: 1}, "x")
+	if err := server.ReadHeader(&h); !errors.Is(err, ErrInvalidFrame) {
+		t.Fatalf("expect ErrInvalidFrame for reflected message, got %v", err)
+	}
+	if _, _, err := NewSessionAESGCM([]byte("short"), nil, true); err == nil {
+		t.Fatal("expect error for invalid key size")
+	}
+}
+
+func TestChecksum(t *testing.T) {
+	conn := &bufferConn{}
+	client, server := NewGobCodec(conn), NewGobCodec(conn)
+	client.(ChecksumSetter).SetChecksum(true)
+	server.(ChecksumSetter).SetChecksum(true)
+
+	var h Header
+	var s string
+	_ = client.Write(&Header{ServiceMethod: "Foo.Sum", Seq: 1}, "intact")
+	if err := server.ReadHeader(&h); err != nil || h.Seq != 1 {
+		t.Fatalf("unexpected header %+v, err: %v", h, err)
+	}
+	if err := server.ReadBody(&s); err != nil || s != "intact" {
+		t.Fatalf("expect intact, got %q, err: %v", s, err)
+	}
+
+	// body 中的一个字节被修改，校验失败后连接仍可以读取后续的消息
+	_ = client.Write(&Header{Seq: 2}, "corrupted")
+	_ = client.Write(&Header{Seq: 3}, "next")
+	b := conn.Bytes()
+	b[bytes.Index(b, []byte("corrupted"))] ^= 0xff
+	if err := server.ReadHeader(&h); err != nil || h.Seq != 2 {
+		t.Fatalf("unexpected header %+v, err: %v", h, err)
+	}
+	if err := server.ReadBody(&s); !errors.Is(err, ErrChecksumMismatch) {
+		t.Fatalf("expect ErrChecksumMismatch, got %v", err)
+	}
+	if err := server.ReadHeader(&h); err != nil || h.Seq != 3 {
+		t.Fatalf("unexpected header %+v, err: %v", h, err)
+	}
+	if err := server.ReadBody(&s); err != nil || s != "next" {
+		t.Fatalf("expect next, got %q, err: %v", s, err)
+	}
+
+	// 头部被修改或消息没有校验和时被拒绝
+	conn.Reset()
+	_ = client.Write(&Header{Seq: 4}, "x")
+	conn.Bytes()[8] ^= 0xff
+	if err := server.ReadHeader(&h); !errors.Is(err, ErrChecksumMismatch) {
+		t.Fatalf("expect ErrChecksumMismatch for header, got %v", err)
+	}
+	conn.Reset()
+	_ = NewGobCodec(conn).Write(&Header{Seq: 5}, "x")
+	if err := server.ReadHeader(&h); !errors.Is(err, ErrInvalidFrame) {
+		t.Fatalf("expect ErrInvalidFrame without checksum, got %v", err)
+	}
+}
+
+// countingConn 记录写入的次数与字节数
+type countingConn struct {
+	discardConn
+	mu      sync.Mutex
+	writes  int
+	written int
+}
+
+func (c *countingConn) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.writes++
+	c.written += len(p)
+	return len(p), nil
+}
+
+func (c *countingConn) stats() (writes, written int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.writes, c.written
+}
+
+func TestFlushPolicy(t *testing.T) {
+	h := &Header{ServiceMethod: "Foo.Sum"}
+
+	// 默认每条消息刷新一次
+	conn := &countingConn{}
+	cc := NewGobCodec(conn)
+	for i := 0; i < 3; i++ {
+		_ = cc.Write(h, i)
+	}
+	if writes, _ := conn.stats(); writes != 3 {
+		t.Fatalf("expect one write per message, got %d", writes)
+	}
+
+	// 延迟刷新时多条消息合并为一次写入
+	conn = &countingConn{}
+	cc = NewGobCodec(conn)
+	cc.(FlushPolicySetter).SetFlushPolicy(20*time.Millisecond, 0)
+	for i := 0; i < 10; i++ {
+		_ = cc.Write(h, i)
+	}
+	if writes, _ := conn.stats(); writes != 0 {
+		t.Fatalf("expect messages to be buffered, got %d writes", writes)
+	}
+	time.Sleep(60 * time.Millisecond)
+	writes, written := conn.stats()
+	if writes != 1 {
+		t.Fatalf("expect messages to be flushed in one write, got %d", writes)
+	}
+
+	// 达到阈值时立即刷新
+	_ = cc.Write(h, 0)
+	cc.(FlushPolicySetter).SetFlushPolicy(time.Hour, written/10)
+	_ = cc.Write(h, 1)
+	if writes, _ := conn.stats(); writes != 2 {
+		t.Fatalf("expect flush when threshold is reached, got %d writes", writes)
+	}
+
+	// Close 前写出缓冲的消息
+	cc.(FlushPolicySetter).SetFlushPolicy(time.Hour, 1<<20)
+	_ = cc.Write(h, 2)
+	_ = cc.Close()
+	if writes, _ := conn.stats(); writes != 3 {
+		t.Fatalf("expect buffered messages to be flushed on close, got %d writes", writes)
+	}
+}
+
+// discardConn 丢弃写入的数据，用于测量写入路径的开销
+type discardConn struct{}
+
+func (discardConn) Read([]byte) (int, error)    { return 0, io.EOF }
+func (discardConn) Write(p []byte) (int, error) { return len(p), nil }
+func (discardConn) Close() error                { return nil }
+
+func BenchmarkGobCodec_Write(b *testing.B) {
+	type args struct{ Num1, Num2 int }
+	cc := NewGobCodec(discardConn{})
+	h := &Header{ServiceMethod: "Foo.Sum", Seq: 1, Metadata: map[string]string{"trace-id": "abc"}}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if err := cc.Write(h, &args{Num1: i, Num2: i}); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package codec
+
+import (
+	"compress/gzip"
+	"io"
+	"sync"
+)
+
+// DefaultCompressThreshold 为默认的压缩阈值，body 小于该字节数时不压缩
+const DefaultCompressThreshold = 1024
+
+// Compressor 为可插拔的压缩算法，通过 RegisterCompressor 注册后，客户端可以在 Option 中按名称启用
+type Compressor interface {
+	// Name 返回在握手时协商使用的名称，例如 gzip
+	Name() string
+	// Compress 返回将压缩后的数据写入 w 的 io.WriteCloser，Close 时写入剩余数据
+	Compress(w io.Writer) (io.WriteCloser, error)
+	// Decompress 返回从 r 读取解压后数据的 io.Reader
+	Decompress(r io.Reader) (io.Reader, error)
+}
+
+// CompressorSetter 为连接设置压缩算法，body 不小于 threshold 字节时压缩，threshold <= 0 时使用 DefaultCompressThreshold，
+// NewFrameCodec 返回的 Codec 实现了该接口
+type CompressorSetter interface {
+	SetCompressor(c Compressor, threshold int)
+}
+
+var (
+	compressorsMu sync.RWMutex
+	compressors   = map[string]Compressor{}
+)
+
+// RegisterCompressor 注册压缩算法，同名的算法会被替换，snappy、zstd 等算法可以由使用方实现后注册
+func RegisterCompressor(c Compressor) {
+	compressorsMu.Lock()
+	defer compressorsMu.Unlock()
+	compressors[c.Name()] = c
+}
+
+// GetCompressor 返回名称为 name 的压缩算法，不存在时返回 nil
+func GetCompressor(name string) Compressor {
+	compressorsMu.RLock()
+	defer compressorsMu.RUnlock()
+	return compressors[name]
+}
+
+type gzipCompressor struct{}
+
+func (gzipCompressor) Name() string {
+	return "gzip"
+}
+
+func (gzipCompressor) Compress(w io.Writer) (io.WriteCloser, error) {
+	return gzip.NewWriter(w), nil
+}
+
+func (gzipCompressor) Decompress(r io.Reader) (io.Reader, error) {
+	return gzip.NewReader(r)
+}
+
+func init() {
+	RegisterCompressor(gzipCompressor{})
+}
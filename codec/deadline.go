@@ -0,0 +1,70 @@
+package codec
+
+import (
+	"errors"
+	"io"
+	"os"
+	"time"
+)
+
+// TimeoutSetter 为连接设置读写超时，NewFrameCodec 返回的 Codec 实现了该接口，连接需实现 SetReadDeadline 与 SetWriteDeadline。
+// 读取消息时从收到第一个字节开始计时，等待消息的过程不受限制；写入一条消息的时间不超过 write。超时为 0 时不限制
+type TimeoutSetter interface {
+	SetTimeouts(read, write time.Duration)
+}
+
+type deadlineConn interface {
+	SetReadDeadline(t time.Time) error
+	SetWriteDeadline(t time.Time) error
+}
+
+func (c *frameCodec) SetTimeouts(read, write time.Duration) {
+	c.readTimeout, c.writeTimeout = read, write
+}
+
+// 等待下一条消息的第一个字节，随后为读取整条消息设置截止时间
+func (c *frameCodec) startRead() error {
+	conn, ok := c.conn.(deadlineConn)
+	if !ok || c.readTimeout <= 0 {
+		return nil
+	}
+	if err := conn.SetReadDeadline(time.Time{}); err != nil {
+		return err
+	}
+	if _, err := c.r.Peek(1); err != nil {
+		return err
+	}
+	return conn.SetReadDeadline(time.Now().Add(c.readTimeout))
+}
+
+// 为写入一条消息设置截止时间
+func (c *frameCodec) startWrite() error {
+	conn, ok := c.conn.(deadlineConn)
+	if !ok || c.writeTimeout <= 0 {
+		return nil
+	}
+	return conn.SetWriteDeadline(time.Now().Add(c.writeTimeout))
+}
+
+// 写入失败后的处理。clean 表示连接上没有写出该消息的任何字节，此时丢弃缓冲中的消息并恢复加密的序号 sealSeq，
+// 连接可以继续使用，调用方可以重试；否则连接上可能残留不完整的消息，或者写入超时的对端已无法接收，关闭连接
+func (c *frameCodec) writeFailed(err error, clean bool, sealSeq uint64) {
+	if clean && !errors.Is(err, os.ErrDeadlineExceeded) {
+		c.w.Reset(c.cw)
+		c.sealSeq = sealSeq
+		return
+	}
+	_ = c.conn.Close()
+}
+
+// countingWriter 记录写入底层连接的字节数，由 frameCodec.wmu 保护
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	w.n += int64(n)
+	return n, err
+}
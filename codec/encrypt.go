@@ -0,0 +1,117 @@
+package codec
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+)
+
+// CipherSetter 为连接设置加密算法，设置后消息的扩展字段与 body 均经过加密，包括长度在内的固定长度的头部作为附加数据参与认证。
+// seal 用于发送的消息，open 用于接收的消息，两个方向须使用不同的密钥。每个方向以消息的序号作为 nonce，
+// 重放、丢弃或调换顺序的消息无法通过认证。NewFrameCodec 返回的 Codec 实现了该接口
+type CipherSetter interface {
+	SetCipher(seal, open cipher.AEAD)
+}
+
+// NewAESGCM 返回使用预共享密钥 key 的 AES-GCM，key 的长度须为 16、24 或 32 字节
+func NewAESGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// NewSessionAESGCM 返回由预共享密钥 key 与本次连接握手交换的随机数 salt 通过 HKDF-SHA256 派生的一对 AES-GCM，
+// 派生的密钥与 key 的长度相同。client 为 true 时 seal 用于客户端发往服务端的消息，否则用于服务端发往客户端的消息。
+// 每个连接、每个方向使用不同的密钥，在一个连接上截获的消息无法在其他连接或另一个方向上重放
+func NewSessionAESGCM(key, salt []byte, client bool) (seal, open cipher.AEAD, err error) {
+	switch len(key) {
+	case 16, 24, 32:
+	default:
+		return nil, nil, aes.KeySizeError(len(key))
+	}
+	up, err := NewAESGCM(hkdf(key, salt, []byte("geerpc client to server"), len(key)))
+	if err != nil {
+		return nil, nil, err
+	}
+	down, err := NewAESGCM(hkdf(key, salt, []byte("geerpc server to client"), len(key)))
+	if err != nil {
+		return nil, nil, err
+	}
+	if client {
+		return up, down, nil
+	}
+	return down, up, nil
+}
+
+// HKDF-SHA256（RFC 5869），length 不超过 sha256.Size，只需要一轮 expand
+func hkdf(secret, salt, info []byte, length int) []byte {
+	extract := hmac.New(sha256.New, salt)
+	extract.Write(secret)
+	expand := hmac.New(sha256.New, extract.Sum(nil))
+	expand.Write(info)
+	expand.Write([]byte{1})
+	return expand.Sum(nil)[:length]
+}
+
+// 加密的数据属于扩展字段还是 body，作为附加数据的最后一个字节参与认证，两者不能互换
+const (
+	sealExt  byte = 1
+	sealBody byte = 2
+)
+
+// 附加数据为 frame 的头部（包括扩展字段与 body 的长度）与 sealExt 或 sealBody
+type frameAAD [frameHeaderSize + 1]byte
+
+func (c *frameCodec) SetCipher(seal, open cipher.AEAD) {
+	c.aead, c.openAEAD = seal, open
+}
+
+// 消息扩展字段与 body 在线路上允许的最大字节数，加密时包括认证标签
+func (c *frameCodec) wireLimit() int64 {
+	limit := int64(c.maxMessageSize)
+	if c.aead != nil {
+		limit += int64(c.openAEAD.Overhead())
+	}
+	return limit
+}
+
+// 返回 plaintext 加密后的长度
+func (c *frameCodec) sealedSize(plaintext []byte) int {
+	return len(plaintext) + c.aead.Overhead()
+}
+
+// 以发送方向的下一个序号为 nonce 加密 plaintext，附加数据为已写入长度的头部与 part
+func (c *frameCodec) seal(plaintext []byte, part byte) []byte {
+	var aad frameAAD
+	copy(aad[:], c.whdr[:])
+	aad[frameHeaderSize] = part
+	nonce := counterNonce(c.aead, c.sealSeq)
+	c.sealSeq++
+	return c.aead.Seal(nil, nonce, plaintext, aad[:])
+}
+
+// 以接收方向的下一个序号为 nonce 解密 seal 返回的数据
+func (c *frameCodec) open(data []byte, part byte) ([]byte, error) {
+	var aad frameAAD
+	copy(aad[:], c.rhdr[:])
+	aad[frameHeaderSize] = part
+	nonce := counterNonce(c.openAEAD, c.openSeq)
+	c.openSeq++
+	plaintext, err := c.openAEAD.Open(data[:0], nonce, data, aad[:])
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidFrame, err)
+	}
+	return plaintext, nil
+}
+
+// 返回序号为 seq 的 nonce，序号在 nonce 的最后 8 个字节
+func counterNonce(aead cipher.AEAD, seq uint64) []byte {
+	nonce := make([]byte, aead.NonceSize())
+	binary.BigEndian.PutUint64(nonce[len(nonce)-8:], seq)
+	return nonce
+}
@@ -0,0 +1,72 @@
+package codec
+
+import (
+	"time"
+)
+
+// closeFlushTimeout 为 Close 时写出缓冲中尚未刷新的消息的最长时间
+const closeFlushTimeout = time.Second
+
+// FlushPolicySetter 设置写入消息后刷新写缓冲的策略，NewFrameCodec 返回的 Codec 实现了该接口
+type FlushPolicySetter interface {
+	// SetFlushPolicy 的 delay <= 0 时每条消息写入后立即刷新，为默认的策略。
+	// delay > 0 时缓冲的字节数达到 threshold 后立即刷新，否则最多延迟 delay 后刷新，
+	// 多条小消息合并为一次系统调用；threshold <= 0 时只在缓冲写满时立即刷新
+	SetFlushPolicy(delay time.Duration, threshold int)
+}
+
+func (c *frameCodec) SetFlushPolicy(delay time.Duration, threshold int) {
+	c.flushDelay, c.flushThreshold = delay, threshold
+}
+
+// 写入一条消息后按照刷新策略刷新缓冲，调用方需持有 c.wmu
+func (c *frameCodec) flushAfterWrite() error {
+	if c.flushDelay <= 0 || (c.flushThreshold > 0 && c.w.Buffered() >= c.flushThreshold) {
+		return c.w.Flush()
+	}
+	if c.w.Buffered() > 0 && !c.flushPending {
+		c.flushPending = true
+		if c.flushTimer == nil {
+			c.flushTimer = time.AfterFunc(c.flushDelay, c.delayedFlush)
+		} else {
+			c.flushTimer.Reset(c.flushDelay)
+		}
+	}
+	return nil
+}
+
+// 刷新延迟写出的消息，出错时关闭连接，后续的 Write 返回 bufio.Writer 记录的错误
+func (c *frameCodec) delayedFlush() {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	c.flushPending = false
+	if c.w.Buffered() == 0 {
+		return
+	}
+	err := c.startWrite()
+	if err == nil {
+		err = c.w.Flush()
+	}
+	if err != nil {
+		c.writeFailed(err, false, c.sealSeq)
+	}
+}
+
+// 关闭连接前写出延迟刷新的消息，其他 goroutine 正在写入时放弃，避免 Close 阻塞
+func (c *frameCodec) flushOnClose() {
+	if c.flushDelay <= 0 || !c.wmu.TryLock() {
+		return
+	}
+	defer c.wmu.Unlock()
+	if c.flushTimer != nil {
+		c.flushTimer.Stop()
+	}
+	c.flushPending = false
+	if c.w.Buffered() == 0 {
+		return
+	}
+	if conn, ok := c.conn.(deadlineConn); ok {
+		_ = conn.SetWriteDeadline(time.Now().Add(closeFlushTimeout))
+	}
+	_ = c.w.Flush()
+}
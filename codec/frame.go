@@ -0,0 +1,562 @@
+package codec
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/cipher"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 每个消息（frame）由固定长度的头部、变长的扩展字段与 body 组成，整数均为大端序：
+//
+//	magic      uint16  固定为 0x6765
+//	version    uint8   固定为 1
+//	frameFlags uint8   frame 级别的标记，见 frameCompressed、frameEncrypted 与 frameChecksum
+//	flags      uint32  Header.Flags
+//	seq        uint64  Header.Seq
+//	code       uint32  Header.Code
+//	deadline   int64   Header.Deadline 的 Unix 纳秒时间戳，0 表示没有截止时间
+//	extLength  uint32  扩展字段的字节数
+//	bodyLength uint32  body 的字节数，0 表示没有 body
+//
+// 扩展字段依次为 ServiceMethod、Error、Details，各自以 uvarint 长度作为前缀，
+// 随后是 uvarint 编码的 Metadata 键值对个数，以及每个 key 与 value（同样以 uvarint 长度作为前缀），
+// 随后是 varint 编码的 Priority，以及以 uvarint 长度作为前缀的 ContentType，
+// ContentType 为空时省略，两者均为零值时一同省略。
+// 启用加密时扩展字段与 body 分别加密，长度为密文的长度，见 CipherSetter
+const (
+	frameMagic      uint16 = 0x6765
+	frameVersion    uint8  = 1
+	frameHeaderSize        = 36
+
+	frameCompressed uint8 = 1 << 0 // body 经过了压缩
+	frameEncrypted  uint8 = 1 << 1 // 扩展字段与 body 经过了加密
+)
+
+// DefaultMaxMessageSize 为单个消息 body 默认的最大字节数
+const DefaultMaxMessageSize = 4 << 20
+
+var (
+	// ErrInvalidFrame 表示读取到的消息不符合 frame 格式
+	ErrInvalidFrame = errors.New("codec: invalid frame")
+	// ErrMessageTooLarge 表示消息超过了最大字节数的限制
+	ErrMessageTooLarge = errors.New("codec: message too large")
+	// ErrUnsupportedContentType 表示 Header.ContentType 没有对应的 BodyCodec，见 RegisterBodyCodec
+	ErrUnsupportedContentType = errors.New("codec: unsupported content type")
+)
+
+// BodyCodec 负责 body 的序列化，消息的分帧由 NewFrameCodec 返回的 Codec 完成
+type BodyCodec interface {
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+type frameCodec struct {
+	conn io.ReadWriteCloser
+	r    *bufio.Reader
+	w    *bufio.Writer
+	cw   *countingWriter // w 写入的底层连接
+	body BodyCodec
+
+	bodyLength     uint32                // 上一个 header 对应的、尚未读取的 body 长度
+	rhdr           [frameHeaderSize]byte // 读写在不同的 goroutine 中进行，各自使用一个缓冲
+	whdr           [frameHeaderSize]byte
+	rext           []byte // 复用的扩展字段缓冲，解析时会复制其中的字符串
+	wext           []byte
+	maxMessageSize int
+
+	compressor        Compressor
+	compressThreshold int
+	compressed        bool // 上一个 header 对应的 body 是否经过了压缩
+	contentType       Type // 上一个 header 对应的 body 的序列化方式，为空时使用 body
+
+	aead     cipher.AEAD // 加密发送的消息，见 SetCipher
+	openAEAD cipher.AEAD // 解密接收的消息
+	sealSeq  uint64      // 发送方向下一次加密使用的序号
+	openSeq  uint64      // 接收方向下一次解密使用的序号
+
+	checksum bool // 消息附带校验和，见 SetChecksum
+	rsum     [checksumSize]byte
+	wsum     [checksumSize]byte
+
+	readTimeout  time.Duration
+	writeTimeout time.Duration
+
+	wmu            sync.Mutex // 延迟刷新在单独的 goroutine 中进行，与写入互斥
+	flushDelay     time.Duration
+	flushThreshold int
+	flushPending   bool // 已安排延迟刷新
+	flushTimer     *time.Timer
+
+	read    int64 // 已读取的字节数，需原子访问
+	written int64 // 已写入的字节数，需原子访问
+}
+
+// Counter 返回连接上已读取与已写入的消息字节数，NewFrameCodec 返回的 Codec 实现了该接口
+type Counter interface {
+	BytesRead() int64
+	BytesWritten() int64
+}
+
+// SizeLimiter 设置单个消息 body 的最大字节数，n <= 0 时使用 DefaultMaxMessageSize，
+// NewFrameCodec 返回的 Codec 实现了该接口
+type SizeLimiter interface {
+	SetMaxMessageSize(n int)
+}
+
+func (c *frameCodec) SetMaxMessageSize(n int) {
+	if n <= 0 {
+		n = DefaultMaxMessageSize
+	}
+	c.maxMessageSize = n
+}
+
+func (c *frameCodec) SetCompressor(comp Compressor, threshold int) {
+	if threshold <= 0 {
+		threshold = DefaultCompressThreshold
+	}
+	c.compressor, c.compressThreshold = comp, threshold
+}
+
+func (c *frameCodec) BytesRead() int64 {
+	return atomic.LoadInt64(&c.read)
+}
+
+func (c *frameCodec) BytesWritten() int64 {
+	return atomic.LoadInt64(&c.written)
+}
+
+// NewFrameCodec 返回使用二进制 frame 格式分帧、使用 body 序列化消息体的 Codec
+func NewFrameCodec(conn io.ReadWriteCloser, body BodyCodec) Codec {
+	cw := &countingWriter{w: conn}
+	return &frameCodec{
+		conn:           conn,
+		cw:             cw,
+		w:              bufio.NewWriter(cw),
+		r:              bufio.NewReader(conn),
+		body:           body,
+		maxMessageSize: DefaultMaxMessageSize,
+	}
+}
+
+func (c *frameCodec) Close() error {
+	c.flushOnClose()
+	return c.conn.Close()
+}
+
+func (c *frameCodec) ReadHeader(h *Header) error {
+	// 调用方未读取上一个 body 时将其丢弃
+	if c.bodyLength > 0 {
+		if err := c.ReadBody(nil); err != nil {
+			return err
+		}
+	}
+
+	if err := c.startRead(); err != nil {
+		return err
+	}
+	if _, err := io.ReadFull(c.r, c.rhdr[:]); err != nil {
+		return err
+	}
+	b := c.rhdr[:]
+	if binary.BigEndian.Uint16(b[0:]) != frameMagic || b[2] != frameVersion {
+		return ErrInvalidFrame
+	}
+	// 启用加密后拒绝明文的消息，避免被降级
+	if encrypted := b[3]&frameEncrypted != 0; encrypted != (c.aead != nil) {
+		return fmt.Errorf("%w: encrypted flag mismatch", ErrInvalidFrame)
+	}
+	if err := c.checkChecksumFlag(b[3]); err != nil {
+		return err
+	}
+	*h = Header{
+		Flags: Flag(binary.BigEndian.Uint32(b[4:])),
+		Seq:   binary.BigEndian.Uint64(b[8:]),
+		Code:  binary.BigEndian.Uint32(b[16:]),
+	}
+	if deadline := int64(binary.BigEndian.Uint64(b[20:])); deadline != 0 {
+		h.Deadline = time.Unix(0, deadline)
+	}
+	extLength := binary.BigEndian.Uint32(b[28:])
+	c.bodyLength = binary.BigEndian.Uint32(b[32:])
+	c.compressed = b[3]&frameCompressed != 0
+	c.contentType = ""
+	if int64(extLength) > c.wireLimit() {
+		return fmt.Errorf("%w: header of %d bytes exceeds limit %d", ErrMessageTooLarge, extLength, c.maxMessageSize)
+	}
+
+	if cap(c.rext) < int(extLength) {
+		c.rext = make([]byte, extLength)
+	}
+	ext := c.rext[:extLength]
+	if _, err := io.ReadFull(c.r, ext); err != nil {
+		return err
+	}
+	atomic.AddInt64(&c.read, int64(frameHeaderSize+extLength))
+	if c.checksum {
+		if err := c.verifyChecksum(crc32.Update(crc32.Checksum(b, castagnoli), castagnoli, ext)); err != nil {
+			c.bodyLength = 0 // 头部已损坏，body 的长度不可信
+			return err
+		}
+	}
+	if c.aead != nil {
+		var err error
+		if ext, err = c.open(ext, sealExt); err != nil {
+			return err
+		}
+	}
+	if err := unmarshalFrameExt(ext, h); err != nil {
+		return err
+	}
+	c.contentType = h.ContentType
+	return nil
+}
+
+// ReadBody 读取 body，i 为 nil 或消息没有 body 时不做反序列化。
+// body 超过最大字节数时将其丢弃并返回 ErrMessageTooLarge，连接可以继续使用
+func (c *frameCodec) ReadBody(i interface{}) error {
+	if i == nil {
+		return c.discardBody()
+	}
+	body, err := c.bodyCodec(c.contentType)
+	if err != nil {
+		_ = c.discardBody()
+		return err
+	}
+	data, err := c.ReadRawBody()
+	if err != nil || data == nil {
+		return err
+	}
+	return body.Unmarshal(data, i)
+}
+
+// ReadRawBody 读取 body 并返回解密与解压后的字节，消息没有 body 时返回 nil。
+// body 超过最大字节数时将其丢弃并返回 ErrMessageTooLarge，连接可以继续使用
+func (c *frameCodec) ReadRawBody() ([]byte, error) {
+	n := c.bodyLength
+	if n == 0 {
+		return nil, nil
+	}
+	if int64(n) > c.wireLimit() {
+		if err := c.discardBody(); err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("%w: received %d bytes, limit %d", ErrMessageTooLarge, n, c.maxMessageSize)
+	}
+	c.bodyLength = 0
+	data := make([]byte, n)
+	if _, err := io.ReadFull(c.r, data); err != nil {
+		return nil, err
+	}
+	atomic.AddInt64(&c.read, int64(n))
+	if c.checksum {
+		if err := c.verifyChecksum(crc32.Checksum(data, castagnoli)); err != nil {
+			return nil, err
+		}
+	}
+	if c.aead != nil {
+		var err error
+		if data, err = c.open(data, sealBody); err != nil {
+			return nil, err
+		}
+	}
+	if c.compressed {
+		var err error
+		if data, err = c.decompress(data); err != nil {
+			return nil, err
+		}
+	}
+	return data, nil
+}
+
+// 丢弃上一个 header 对应的 body
+func (c *frameCodec) discardBody() error {
+	n := c.bodyLength
+	c.bodyLength = 0
+	if n == 0 {
+		return nil
+	}
+	if c.checksum {
+		n += checksumSize
+	}
+	if c.aead != nil {
+		// 丢弃的 body 同样占用接收方向的一个序号
+		c.openSeq++
+	}
+	if _, err := c.r.Discard(int(n)); err != nil {
+		return err
+	}
+	atomic.AddInt64(&c.read, int64(n))
+	return nil
+}
+
+func (c *frameCodec) Marshal(v interface{}) ([]byte, error) {
+	return c.body.Marshal(v)
+}
+
+func (c *frameCodec) Unmarshal(data []byte, v interface{}) error {
+	return c.body.Unmarshal(data, v)
+}
+
+// 解压 body，解压后的大小同样受最大字节数的限制
+func (c *frameCodec) decompress(data []byte) ([]byte, error) {
+	if c.compressor == nil {
+		return nil, fmt.Errorf("%w: compressed body without compressor", ErrInvalidFrame)
+	}
+	r, err := c.compressor.Decompress(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	data, err = io.ReadAll(io.LimitReader(r, int64(c.maxMessageSize)+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > c.maxMessageSize {
+		return nil, fmt.Errorf("%w: decompressed body exceeds limit %d", ErrMessageTooLarge, c.maxMessageSize)
+	}
+	return data, nil
+}
+
+// body 不小于压缩阈值且压缩后更小时返回压缩后的数据
+func (c *frameCodec) compress(data []byte) ([]byte, bool, error) {
+	if c.compressor == nil || len(data) < c.compressThreshold {
+		return data, false, nil
+	}
+	var buf bytes.Buffer
+	w, err := c.compressor.Compress(&buf)
+	if err != nil {
+		return nil, false, err
+	}
+	if _, err = w.Write(data); err != nil {
+		return nil, false, err
+	}
+	if err = w.Close(); err != nil {
+		return nil, false, err
+	}
+	if buf.Len() >= len(data) {
+		return data, false, nil
+	}
+	return buf.Bytes(), true, nil
+}
+
+// Write 写入一条消息，body 为 nil 时消息没有 body，body 超过最大字节数时不写入并返回 ErrMessageTooLarge
+// h.ContentType 不为空时使用其对应的 BodyCodec 序列化 body
+func (c *frameCodec) Write(h *Header, body interface{}) error {
+	if body == nil {
+		return c.WriteRaw(h, nil)
+	}
+	bc, err := c.bodyCodec(h.ContentType)
+	if err != nil {
+		return err
+	}
+	if enc, ok := bc.(bufferEncoder); ok {
+		buf := getBuffer()
+		defer putBuffer(buf)
+		if err := enc.encode(buf, body); err != nil {
+			return err
+		}
+		return c.WriteRaw(h, buf.Bytes())
+	}
+	data, err := bc.Marshal(body)
+	if err != nil {
+		return err
+	}
+	return c.WriteRaw(h, data)
+}
+
+// 返回 contentType 对应的 BodyCodec，为空时返回连接默认的 BodyCodec
+func (c *frameCodec) bodyCodec(contentType Type) (BodyCodec, error) {
+	if contentType == "" {
+		return c.body, nil
+	}
+	if bc := GetBodyCodec(contentType); bc != nil {
+		return bc, nil
+	}
+	return nil, fmt.Errorf("%w: %q", ErrUnsupportedContentType, contentType)
+}
+
+// WriteRaw 写入 body 为已序列化的 data 的消息，data 超过最大字节数时不写入并返回 ErrMessageTooLarge
+func (c *frameCodec) WriteRaw(h *Header, data []byte) (err error) {
+	if len(data) > c.maxMessageSize {
+		return fmt.Errorf("%w: sending %d bytes, limit %d", ErrMessageTooLarge, len(data), c.maxMessageSize)
+	}
+	data, compressed, err := c.compress(data)
+	if err != nil {
+		return err
+	}
+
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if err = c.startWrite(); err != nil {
+		return err
+	}
+	sent, buffered, sealSeq := c.cw.n, c.w.Buffered(), c.sealSeq
+	defer func() {
+		if flushErr := c.flushAfterWrite(); err == nil {
+			err = flushErr
+		}
+		if err != nil {
+			// 连接上没有写出任何字节且没有其他缓冲中的消息时，连接上不会残留不完整的消息
+			c.writeFailed(err, buffered == 0 && c.cw.n == sent, sealSeq)
+		}
+	}()
+	c.wext = appendFrameExt(c.wext[:0], h)
+	ext := c.wext
+
+	b := c.whdr[:]
+	binary.BigEndian.PutUint16(b[0:], frameMagic)
+	b[2], b[3] = frameVersion, 0
+	if compressed {
+		b[3] |= frameCompressed
+	}
+	if c.aead != nil {
+		b[3] |= frameEncrypted
+	}
+	if c.checksum {
+		b[3] |= frameChecksum
+	}
+	binary.BigEndian.PutUint32(b[4:], uint32(h.Flags))
+	binary.BigEndian.PutUint64(b[8:], h.Seq)
+	binary.BigEndian.PutUint32(b[16:], h.Code)
+	var deadline int64
+	if !h.Deadline.IsZero() {
+		deadline = h.Deadline.UnixNano()
+	}
+	binary.BigEndian.PutUint64(b[20:], uint64(deadline))
+	extLength, bodyLength := len(ext), len(data)
+	if c.aead != nil {
+		extLength = c.sealedSize(ext)
+		if bodyLength > 0 {
+			bodyLength = c.sealedSize(data)
+		}
+	}
+	binary.BigEndian.PutUint32(b[28:], uint32(extLength))
+	binary.BigEndian.PutUint32(b[32:], uint32(bodyLength))
+	if c.aead != nil {
+		// 头部写入长度后再加密，长度同样参与认证
+		ext = c.seal(ext, sealExt)
+		if len(data) > 0 {
+			data = c.seal(data, sealBody)
+		}
+	}
+
+	if _, err = c.w.Write(b); err != nil {
+		return err
+	}
+	if _, err = c.w.Write(ext); err != nil {
+		return err
+	}
+	written := frameHeaderSize + len(ext) + len(data)
+	if c.checksum {
+		if err = c.writeChecksum(crc32.Update(crc32.Checksum(b, castagnoli), castagnoli, ext)); err != nil {
+			return err
+		}
+		written += checksumSize
+	}
+	if _, err = c.w.Write(data); err != nil {
+		return err
+	}
+	if c.checksum && len(data) > 0 {
+		if err = c.writeChecksum(crc32.Checksum(data, castagnoli)); err != nil {
+			return err
+		}
+		written += checksumSize
+	}
+	atomic.AddInt64(&c.written, int64(written))
+	return nil
+}
+
+func appendString(b []byte, s string) []byte {
+	b = binary.AppendUvarint(b, uint64(len(s)))
+	return append(b, s...)
+}
+
+// 将 h 的扩展字段追加到 b 之后
+func appendFrameExt(b []byte, h *Header) []byte {
+	b = appendString(b, h.ServiceMethod)
+	b = appendString(b, h.Error)
+	b = appendString(b, string(h.Details))
+	b = binary.AppendUvarint(b, uint64(len(h.Metadata)))
+	for k, v := range h.Metadata {
+		b = appendString(b, k)
+		b = appendString(b, v)
+	}
+	if h.Priority != 0 || h.ContentType != "" {
+		b = binary.AppendVarint(b, int64(h.Priority))
+	}
+	if h.ContentType != "" {
+		b = appendString(b, string(h.ContentType))
+	}
+	return b
+}
+
+// 读取以 uvarint 长度作为前缀的字符串，返回剩余的数据
+func consumeString(b []byte) (string, []byte, error) {
+	n, size := binary.Uvarint(b)
+	if size <= 0 || uint64(len(b)-size) < n {
+		return "", nil, ErrInvalidFrame
+	}
+	b = b[size:]
+	return string(b[:n]), b[n:], nil
+}
+
+func unmarshalFrameExt(b []byte, h *Header) (err error) {
+	var details string
+	if h.ServiceMethod, b, err = consumeString(b); err != nil {
+		return err
+	}
+	if h.Error, b, err = consumeString(b); err != nil {
+		return err
+	}
+	if details, b, err = consumeString(b); err != nil {
+		return err
+	}
+	if details != "" {
+		h.Details = []byte(details)
+	}
+
+	count, size := binary.Uvarint(b)
+	if size <= 0 || count > uint64(len(b)) {
+		return ErrInvalidFrame
+	}
+	b = b[size:]
+	if count > 0 {
+		h.Metadata = make(map[string]string, count)
+	}
+	for i := uint64(0); i < count; i++ {
+		var k, v string
+		if k, b, err = consumeString(b); err != nil {
+			return err
+		}
+		if v, b, err = consumeString(b); err != nil {
+			return err
+		}
+		h.Metadata[k] = v
+	}
+	if len(b) != 0 {
+		priority, size := binary.Varint(b)
+		if size <= 0 || priority != int64(int32(priority)) {
+			return ErrInvalidFrame
+		}
+		h.Priority = int32(priority)
+		b = b[size:]
+	}
+	if len(b) != 0 {
+		var contentType string
+		if contentType, b, err = consumeString(b); err != nil {
+			return err
+		}
+		h.ContentType = Type(contentType)
+	}
+	if len(b) != 0 {
+		return fmt.Errorf("%w: %d trailing bytes in header", ErrInvalidFrame, len(b))
+	}
+	return nil
+}
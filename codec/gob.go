@@ -1,55 +1,30 @@
 package codec
 
 import (
-	"bufio"
+	"bytes"
 	"encoding/gob"
 	"io"
 )
 
-type GobCodec struct {
-	conn io.ReadWriteCloser
-	buf  *bufio.Writer
-	dec  *gob.Decoder
-	enc  *gob.Encoder
-}
+// gobBody 对每个 body 单独编码并携带类型信息，丢弃或拒绝某个 body 不会影响后续消息的解码
+type gobBody struct{}
 
-func (g *GobCodec) Close() error {
-	return g.conn.Close()
+func (b gobBody) Marshal(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := b.encode(&buf, v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
 }
 
-func (g *GobCodec) ReadHeader(header *Header) error {
-	return g.dec.Decode(header)
+func (gobBody) encode(buf *bytes.Buffer, v interface{}) error {
+	return gob.NewEncoder(buf).Encode(v)
 }
 
-func (g *GobCodec) ReadBody(i interface{}) error {
-	return g.dec.Decode(i)
+func (gobBody) Unmarshal(data []byte, v interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
 }
 
-func (g *GobCodec) Write(header *Header, body interface{}) (err error) {
-	defer func() {
-		_ = g.buf.Flush()
-	}()
-
-	err = g.enc.Encode(header)
-	if err != nil {
-		return err
-	}
-
-	err = g.enc.Encode(body)
-	if err != nil {
-		return err
-	}
-
-	return nil
-}
-
-var _ Codec = (*GobCodec)(nil)
-
 func NewGobCodec(conn io.ReadWriteCloser) Codec {
-	return &GobCodec{
-		conn: conn,
-		buf:  bufio.NewWriter(conn),
-		dec:  gob.NewDecoder(conn),
-		enc:  gob.NewEncoder(conn),
-	}
+	return NewFrameCodec(conn, gobBody{})
 }
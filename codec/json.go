@@ -0,0 +1,20 @@
+package codec
+
+import (
+	"encoding/json"
+	"io"
+)
+
+type jsonBody struct{}
+
+func (jsonBody) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonBody) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+func NewJsonCodec(conn io.ReadWriteCloser) Codec {
+	return NewFrameCodec(conn, jsonBody{})
+}
@@ -0,0 +1,47 @@
+package codec
+
+import (
+	"bufio"
+	"bytes"
+	"sync"
+)
+
+// maxPooledBufferSize 为归还到 bufferPool 的缓冲的最大容量，避免偶尔的大消息长期占用内存
+const maxPooledBufferSize = 64 << 10
+
+// bufferPool 复用序列化 body 使用的缓冲
+var bufferPool = sync.Pool{
+	New: func() interface{} { return new(bytes.Buffer) },
+}
+
+func getBuffer() *bytes.Buffer {
+	return bufferPool.Get().(*bytes.Buffer)
+}
+
+func putBuffer(buf *bytes.Buffer) {
+	if buf.Cap() > maxPooledBufferSize {
+		return
+	}
+	buf.Reset()
+	bufferPool.Put(buf)
+}
+
+// bufferEncoder 由可以将 body 序列化到调用方提供的缓冲中的 BodyCodec 实现，Write 使用 bufferPool 中的缓冲
+type bufferEncoder interface {
+	encode(buf *bytes.Buffer, v interface{}) error
+}
+
+// BufferSizeSetter 设置连接的读写缓冲大小，需在读写之前调用，NewFrameCodec 返回的 Codec 实现了该接口
+type BufferSizeSetter interface {
+	// SetBufferSizes 设置 bufio.Reader 与 bufio.Writer 的大小，<= 0 时保持默认的 4096 字节
+	SetBufferSizes(read, write int)
+}
+
+func (c *frameCodec) SetBufferSizes(read, write int) {
+	if read > 0 {
+		c.r = bufio.NewReaderSize(c.conn, read)
+	}
+	if write > 0 {
+		c.w = bufio.NewWriterSize(c.cw, write)
+	}
+}
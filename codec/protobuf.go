@@ -0,0 +1,31 @@
+package codec
+
+import (
+	"fmt"
+	"io"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// protobufBody 要求 body 实现 proto.Message
+type protobufBody struct{}
+
+func (protobufBody) Marshal(v interface{}) ([]byte, error) {
+	msg, ok := v.(proto.Message)
+	if !ok {
+		return nil, fmt.Errorf("codec: %T is not a proto.Message", v)
+	}
+	return proto.Marshal(msg)
+}
+
+func (protobufBody) Unmarshal(data []byte, v interface{}) error {
+	msg, ok := v.(proto.Message)
+	if !ok {
+		return fmt.Errorf("codec: %T is not a proto.Message", v)
+	}
+	return proto.Unmarshal(data, msg)
+}
+
+func NewProtobufCodec(conn io.ReadWriteCloser) Codec {
+	return NewFrameCodec(conn, protobufBody{})
+}
@@ -0,0 +1,59 @@
+package geerpc
+
+import (
+	"fmt"
+	"net"
+	"sync"
+)
+
+// connLimiter 限制服务端的连接总数与每个 IP 的连接数，上限为 0 时不限制
+type connLimiter struct {
+	mu       sync.Mutex
+	max      int
+	maxPerIP int
+	total    int
+	perIP    map[string]int
+}
+
+// 登记来自 ip 的连接，超过上限时返回错误，成功时调用方需在连接关闭后调用 release
+func (l *connLimiter) acquire(ip string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.max > 0 && l.total >= l.max {
+		return fmt.Errorf("too many connections, limit %d", l.max)
+	}
+	if l.maxPerIP > 0 && l.perIP[ip] >= l.maxPerIP {
+		return fmt.Errorf("too many connections from %s, limit %d", ip, l.maxPerIP)
+	}
+	if l.perIP == nil {
+		l.perIP = make(map[string]int)
+	}
+	l.total++
+	l.perIP[ip]++
+	return nil
+}
+
+func (l *connLimiter) release(ip string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.total--
+	if l.perIP[ip]--; l.perIP[ip] <= 0 {
+		delete(l.perIP, ip)
+	}
+}
+
+// 返回 addr 中的 IP，无法解析时返回 addr 本身
+func remoteIP(addr net.Addr) string {
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	return host
+}
+
+// 返回当前的连接数
+func (l *connLimiter) count() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.total
+}
@@ -1,10 +1,13 @@
 package geerpc
 
 import (
+	"encoding/json"
 	"fmt"
 	"html/template"
-	"log"
 	"net/http"
+	"sort"
+	"sync/atomic"
+	"time"
 )
 
 const debugText = `<html>
@@ -15,11 +18,17 @@ const debugText = `<html>
 	Service {{.Name}}
 	<hr>
 		<table>
-		<th align=center>Method</th><th align=center>Calls</th>
+		<th align=center>Method</th><th align=center>Calls</th><th align=center>Errors</th>
+		<th align=center>Mean</th><th align=center>P50</th><th align=center>P99</th>
 		{{range $name, $mtype := .Method}}
+			{{$latency := $mtype.Latency}}
 			<tr>
 			<td align=left font=fixed>{{$name}}({{$mtype.ArgType}}, {{$mtype.ReplyType}}) error</td>
 			<td align=center>{{$mtype.NumCalls}}</td>
+			<td align=center>{{$latency.Errors}}</td>
+			<td align=center>{{$latency.Mean}}</td>
+			<td align=center>{{$latency.P50}}</td>
+			<td align=center>{{$latency.P99}}</td>
 			</tr>
 		{{end}}
 		</table>
@@ -39,7 +48,6 @@ type debugService struct {
 }
 
 func (s *debugHTTP) ServeHTTP(w http.ResponseWriter, req *http.Request) {
-	log.Println("debug")
 	var services []debugService
 	s.s.serviceMap.Range(func(key, value interface{}) bool {
 		svc := value.(*service)
@@ -54,3 +62,68 @@ func (s *debugHTTP) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 		_, _ = fmt.Fprintln(w, "rpc: error executing template:", err.Error())
 	}
 }
+
+// debugJSON 以 JSON 格式返回与 debugHTTP 相同的信息以及服务端的运行状态，供监控面板与脚本使用
+type debugJSON struct {
+	s *Server
+}
+
+type debugStatus struct {
+	StartTime     time.Time          `json:"start_time"`
+	UptimeSeconds float64            `json:"uptime_seconds"`
+	Connections   int                `json:"connections"`
+	InFlight      int64              `json:"in_flight_requests"`
+	Services      []debugJSONService `json:"services"`
+}
+
+type debugJSONService struct {
+	Name    string            `json:"name"`
+	Methods []debugJSONMethod `json:"methods"`
+}
+
+type debugJSONMethod struct {
+	Name      string  `json:"name"`
+	ArgType   string  `json:"arg_type"`
+	ReplyType string  `json:"reply_type"`
+	Calls     uint64  `json:"calls"`
+	Errors    uint64  `json:"errors"`
+	MeanMs    float64 `json:"mean_ms"`
+	P50Ms     float64 `json:"p50_ms"`
+	P99Ms     float64 `json:"p99_ms"`
+}
+
+func (s *debugJSON) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	status := debugStatus{
+		StartTime:     s.s.started,
+		UptimeSeconds: time.Since(s.s.started).Seconds(),
+		Connections:   s.s.conns.count(),
+		InFlight:      atomic.LoadInt64(&s.s.inflight),
+	}
+	s.s.serviceMap.Range(func(key, value interface{}) bool {
+		svc := debugJSONService{Name: key.(string)}
+		for name, mtype := range value.(*service).method {
+			latency := mtype.Latency()
+			svc.Methods = append(svc.Methods, debugJSONMethod{
+				Name:      name,
+				ArgType:   mtype.ArgType.String(),
+				ReplyType: mtype.ReplyType.String(),
+				Calls:     mtype.NumCalls(),
+				Errors:    latency.Errors,
+				MeanMs:    milliseconds(latency.Mean),
+				P50Ms:     milliseconds(latency.P50),
+				P99Ms:     milliseconds(latency.P99),
+			})
+		}
+		sort.Slice(svc.Methods, func(i, j int) bool { return svc.Methods[i].Name < svc.Methods[j].Name })
+		status.Services = append(status.Services, svc)
+		return true
+	})
+	sort.Slice(status.Services, func(i, j int) bool { return status.Services[i].Name < status.Services[j].Name })
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(status)
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
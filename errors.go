@@ -0,0 +1,149 @@
+package geerpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"syscall"
+
+	"geerpc/codec"
+)
+
+// Code 为在线路上传递的错误码
+type Code uint32
+
+const (
+	OK                 Code = iota // 成功
+	Canceled                       // 调用被取消
+	Unknown                        // 未知错误，handler 返回的普通 error 使用该错误码
+	InvalidArgument                // 参数错误
+	DeadlineExceeded               // 超时
+	NotFound                       // 服务或方法不存在
+	AlreadyExists                  // 资源已存在
+	PermissionDenied               // 没有权限
+	ResourceExhausted              // 资源耗尽，例如被限流
+	FailedPrecondition             // 前置条件不满足
+	Aborted                        // 操作被中止
+	OutOfRange                     // 超出范围
+	Unimplemented                  // 未实现
+	Internal                       // 服务端内部错误，例如 handler panic
+	Unavailable                    // 服务暂不可用，可以重试
+	DataLoss                       // 数据丢失或损坏
+	Unauthenticated                // 未认证
+)
+
+var codeNames = [...]string{
+	OK:                 "OK",
+	Canceled:           "Canceled",
+	Unknown:            "Unknown",
+	InvalidArgument:    "InvalidArgument",
+	DeadlineExceeded:   "DeadlineExceeded",
+	NotFound:           "NotFound",
+	AlreadyExists:      "AlreadyExists",
+	PermissionDenied:   "PermissionDenied",
+	ResourceExhausted:  "ResourceExhausted",
+	FailedPrecondition: "FailedPrecondition",
+	Aborted:            "Aborted",
+	OutOfRange:         "OutOfRange",
+	Unimplemented:      "Unimplemented",
+	Internal:           "Internal",
+	Unavailable:        "Unavailable",
+	DataLoss:           "DataLoss",
+	Unauthenticated:    "Unauthenticated",
+}
+
+func (c Code) String() string {
+	if int(c) < len(codeNames) {
+		return codeNames[c]
+	}
+	return "Code(" + strconv.FormatUint(uint64(c), 10) + ")"
+}
+
+// Error 为带错误码的 RPC 错误，可通过 errors.As 获取
+type Error struct {
+	Code    Code
+	Message string
+	Details []byte // 可选的错误详情，由调用双方约定编码方式
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("rpc error: code = %s desc = %s", e.Code, e.Message)
+}
+
+// Errorf 返回带错误码的错误，handler 返回该错误时错误码会传递给客户端
+func Errorf(code Code, format string, a ...interface{}) error {
+	return &Error{Code: code, Message: fmt.Sprintf(format, a...)}
+}
+
+// CodeOf 返回 err 对应的错误码
+func CodeOf(err error) Code {
+	var e *Error
+	switch {
+	case err == nil:
+		return OK
+	case errors.As(err, &e):
+		return e.Code
+	case errors.Is(err, ErrShutdown):
+		return Unavailable
+	case errors.Is(err, codec.ErrMessageTooLarge):
+		return ResourceExhausted
+	case errors.Is(err, context.DeadlineExceeded):
+		return DeadlineExceeded
+	case errors.Is(err, context.Canceled):
+		return Canceled
+	}
+	return Unknown
+}
+
+// 将 codec 返回的错误转换为 *Error，消息超过大小限制时错误码为 ResourceExhausted，ContentType 不受支持时为 InvalidArgument
+func codecError(err error) error {
+	if errors.Is(err, codec.ErrMessageTooLarge) {
+		return &Error{Code: ResourceExhausted, Message: err.Error()}
+	}
+	if errors.Is(err, codec.ErrUnsupportedContentType) {
+		return &Error{Code: InvalidArgument, Message: err.Error()}
+	}
+	if errors.Is(err, codec.ErrChecksumMismatch) {
+		return &Error{Code: DataLoss, Message: err.Error()}
+	}
+	return err
+}
+
+// 将发送请求时的错误转换为 *Error，连接的读写错误为 Unavailable，服务端不会处理未完整写出的请求，可以按重试策略重试
+func writeError(err error) error {
+	if e := codecError(err); e != err {
+		return e
+	}
+	var ne net.Error
+	if errors.As(err, &ne) || errors.Is(err, io.ErrClosedPipe) || errors.Is(err, io.ErrShortWrite) || errors.Is(err, net.ErrClosed) ||
+		errors.Is(err, syscall.EPIPE) || errors.Is(err, syscall.ECONNRESET) {
+		return &Error{Code: Unavailable, Message: "rpc client: write request: " + err.Error()}
+	}
+	return err
+}
+
+// 将 err 写入响应 header
+func setHeaderError(h *codec.Header, err error) {
+	var e *Error
+	if !errors.As(err, &e) {
+		e = &Error{Code: CodeOf(err), Message: err.Error()}
+	}
+	h.Code = uint32(e.Code)
+	h.Error = e.Message
+	h.Details = e.Details
+}
+
+// 从响应 header 中还原错误，调用成功时返回 nil
+func headerError(h *codec.Header) error {
+	if h.Code == uint32(OK) && h.Error == "" {
+		return nil
+	}
+	code := Code(h.Code)
+	if code == OK {
+		code = Unknown
+	}
+	return &Error{Code: code, Message: h.Error, Details: h.Details}
+}
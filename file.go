@@ -0,0 +1,164 @@
+package geerpc
+
+import (
+	"context"
+	"errors"
+	"hash/crc32"
+	"io"
+)
+
+// FileChunkSize 为文件传输时每条消息携带的最大字节数
+const FileChunkSize = 64 << 10
+
+var crc32c = crc32.MakeTable(crc32.Castagnoli)
+
+// FileChunk 为文件传输使用的流消息，作为流式方法的 args 时 Name 与 Offset 为请求的文件与起始位置；
+// 传输数据时 Offset 为 Data 在文件中的位置，Checksum 为 Data 的 CRC-32C。方法形式为
+// Method(args geerpc.FileChunk, stream *geerpc.ServerStream) error，协议不支持 protobuf 编码
+type FileChunk struct {
+	Name     string
+	Offset   int64
+	Data     []byte
+	Checksum uint32
+}
+
+func newFileChunk(offset int64, data []byte) *FileChunk {
+	return &FileChunk{Offset: offset, Data: data, Checksum: crc32.Checksum(data, crc32c)}
+}
+
+// 校验分块的位置与校验和，通过后将数据写入 w，返回写入后的位置
+func writeFileChunk(w io.Writer, chunk *FileChunk, offset int64) (int64, error) {
+	if chunk.Offset != offset {
+		return offset, Errorf(InvalidArgument, "rpc file: chunk at offset %d, expect %d", chunk.Offset, offset)
+	}
+	if crc32.Checksum(chunk.Data, crc32c) != chunk.Checksum {
+		return offset, Errorf(DataLoss, "rpc file: checksum mismatch at offset %d", chunk.Offset)
+	}
+	n, err := w.Write(chunk.Data)
+	return offset + int64(n), err
+}
+
+// 跳过 r 的前 offset 个字节，r 实现了 io.Seeker 时直接定位
+func skipTo(r io.Reader, offset int64) error {
+	if offset == 0 {
+		return nil
+	}
+	if s, ok := r.(io.Seeker); ok {
+		_, err := s.Seek(offset, io.SeekStart)
+		return err
+	}
+	_, err := io.CopyN(io.Discard, r, offset)
+	return err
+}
+
+// 从 r 读取数据并依次以 send 发送，返回发送结束后的位置
+func sendFileChunks(r io.Reader, offset int64, send func(*FileChunk) error) (int64, error) {
+	buf := make([]byte, FileChunkSize)
+	for {
+		n, err := io.ReadFull(r, buf)
+		if n > 0 {
+			data := make([]byte, n)
+			copy(data, buf[:n])
+			if sendErr := send(newFileChunk(offset, data)); sendErr != nil {
+				return offset, sendErr
+			}
+			offset += int64(n)
+		}
+		if err == io.EOF || errors.Is(err, io.ErrUnexpectedEOF) {
+			return offset, nil
+		}
+		if err != nil {
+			return offset, err
+		}
+	}
+}
+
+// SendFile 通过客户端流方法 serviceMethod 上传 r 的内容，服务端使用 ServerStream.RecvFile 接收。
+// 服务端已有部分数据时从其返回的位置续传，r 实现了 io.Seeker 时直接定位，否则跳过已传输的字节。
+// 返回服务端收到的总字节数
+func (client *Client) SendFile(ctx context.Context, serviceMethod, name string, r io.Reader, opts ...CallOption) (int64, error) {
+	cs, err := client.NewStream(ctx, serviceMethod, new(FileChunk), opts...)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = cs.Close() }()
+	if err = cs.Send(&FileChunk{Name: name}); err != nil {
+		return 0, err
+	}
+	var resume FileChunk
+	if err = cs.Recv(&resume); err != nil {
+		return 0, err
+	}
+	if err = skipTo(r, resume.Offset); err != nil {
+		return resume.Offset, err
+	}
+	offset, err := sendFileChunks(r, resume.Offset, func(chunk *FileChunk) error {
+		return cs.Send(chunk)
+	})
+	if err != nil {
+		return offset, err
+	}
+	if err = cs.CloseSend(); err != nil {
+		return offset, err
+	}
+	// 等待服务端写入完成
+	if err = cs.Recv(new(FileChunk)); err != io.EOF {
+		if err == nil {
+			err = Errorf(Internal, "rpc file: unexpected message after upload")
+		}
+		return offset, err
+	}
+	return offset, nil
+}
+
+// RecvFile 通过服务端流方法 serviceMethod 下载文件 name 自 offset 起的内容并写入 w，服务端使用 ServerStream.SendFile 发送。
+// 续传时 offset 为本地已有的字节数。返回写入后的位置
+func (client *Client) RecvFile(ctx context.Context, serviceMethod, name string, w io.Writer, offset int64, opts ...CallOption) (int64, error) {
+	cs, err := client.Stream(ctx, serviceMethod, &FileChunk{Name: name, Offset: offset}, new(FileChunk), opts...)
+	if err != nil {
+		return offset, err
+	}
+	defer func() { _ = cs.Close() }()
+	for {
+		var chunk FileChunk
+		if err = cs.Recv(&chunk); err == io.EOF {
+			return offset, nil
+		} else if err != nil {
+			return offset, err
+		}
+		if offset, err = writeFileChunk(w, &chunk, offset); err != nil {
+			return offset, err
+		}
+	}
+}
+
+// RecvFile 接收客户端通过 Client.SendFile 上传的数据并写入 w，offset 为已经接收的字节数，客户端从该位置续传。
+// 返回写入后的位置
+func (ss *ServerStream) RecvFile(w io.Writer, offset int64) (int64, error) {
+	if err := ss.Send(&FileChunk{Offset: offset}); err != nil {
+		return offset, err
+	}
+	for {
+		var chunk FileChunk
+		if err := ss.Recv(&chunk); err == io.EOF {
+			return offset, nil
+		} else if err != nil {
+			return offset, err
+		}
+		var err error
+		if offset, err = writeFileChunk(w, &chunk, offset); err != nil {
+			return offset, err
+		}
+	}
+}
+
+// SendFile 将 r 自 offset（通常为客户端请求的 FileChunk.Offset）起的内容发送给通过 Client.RecvFile 下载的客户端，
+// r 实现了 io.Seeker 时直接定位，否则跳过 offset 个字节。返回发送结束后的位置
+func (ss *ServerStream) SendFile(r io.Reader, offset int64) (int64, error) {
+	if err := skipTo(r, offset); err != nil {
+		return offset, err
+	}
+	return sendFileChunks(r, offset, func(chunk *FileChunk) error {
+		return ss.Send(chunk)
+	})
+}
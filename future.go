@@ -0,0 +1,61 @@
+package geerpc
+
+import "context"
+
+// DefaultDoneChanSize 为 Go 默认创建的 done channel 的容量
+const DefaultDoneChanSize = 10
+
+func (client *Client) doneChanSize() int {
+	if client.opt != nil && client.opt.DoneChanSize > 0 {
+		return client.opt.DoneChanSize
+	}
+	return DefaultDoneChanSize
+}
+
+// GoFunc 异步发起调用，调用结束后在新的 goroutine 中以该 call 调用 callback
+func (client *Client) GoFunc(serviceMethod string, args, reply interface{}, callback func(*Call), opts ...CallOption) *Call {
+	call := client.Go(serviceMethod, args, reply, make(chan *Call, 1), opts...)
+	go func() {
+		callback(<-call.Done)
+	}()
+	return call
+}
+
+// Future 为一次异步调用的结果
+type Future struct {
+	call *Call
+	done chan struct{}
+}
+
+// Async 异步发起调用并返回对应的 Future
+func (client *Client) Async(serviceMethod string, args, reply interface{}, opts ...CallOption) *Future {
+	f := &Future{
+		call: client.Go(serviceMethod, args, reply, make(chan *Call, 1), opts...),
+		done: make(chan struct{}),
+	}
+	go func() {
+		<-f.call.Done
+		close(f.done)
+	}()
+	return f
+}
+
+// Done 返回调用结束时关闭的 channel
+func (f *Future) Done() <-chan struct{} {
+	return f.done
+}
+
+// Call 返回对应的 call，调用结束前不应读取其 Reply 与 Error
+func (f *Future) Call() *Call {
+	return f.call
+}
+
+// Await 等待调用结束并返回调用的错误。ctx 结束时返回 ctx 的错误，但不会取消调用
+func (f *Future) Await(ctx context.Context) error {
+	select {
+	case <-f.done:
+		return f.call.Error
+	case <-ctx.Done():
+		return &Error{Code: CodeOf(ctx.Err()), Message: "rpc client: await call: " + ctx.Err().Error()}
+	}
+}
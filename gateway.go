@@ -0,0 +1,200 @@
+package geerpc
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"geerpc/codec"
+)
+
+// defaultGatewayPath 为 HTTP 网关的默认路径，请求形如 POST /rpc/{Service}/{Method}
+const defaultGatewayPath = "/rpc/"
+
+// GatewayMetadataPrefix 为网关中携带 metadata 的 HTTP header 前缀，
+// 请求 header Geerpc-Md-Token: xxx 对应 metadata token=xxx，handler 设置的响应 metadata 以同样的形式返回
+const GatewayMetadataPrefix = "Geerpc-Md-"
+
+// gatewayError 为网关返回的 JSON 错误
+type gatewayError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// gateway 将 JSON 格式的 HTTP 请求转换为对已注册服务的调用，便于使用 curl 调试或供无法使用二进制协议的客户端调用
+type gateway struct {
+	s      *Server
+	prefix string
+}
+
+// Gateway 返回处理 POST {prefix}{Service}/{Method} 的 http.Handler，请求 body 为 JSON 格式的参数，
+// 响应 body 为 JSON 格式的返回值，出错时返回 {"code": ..., "message": ...} 与对应的 HTTP 状态码，
+// 流式方法不支持通过网关调用
+func (s *Server) Gateway(prefix string) http.Handler {
+	return &gateway{s: s, prefix: prefix}
+}
+
+// HandleGateway 在 http.DefaultServeMux 的 /rpc/ 路径上注册 HTTP 网关
+func (s *Server) HandleGateway() {
+	http.Handle(defaultGatewayPath, s.Gateway(defaultGatewayPath))
+	s.logger.Infof("rpc server gateway path: %s", defaultGatewayPath)
+}
+
+func (g *gateway) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeGatewayError(w, http.StatusMethodNotAllowed, Errorf(Unimplemented, "rpc gateway: must POST"))
+		return
+	}
+	peer := gatewayPeer(req)
+	token := strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer ")
+	if err := g.s.authenticate(token, peer); err != nil {
+		writeGatewayError(w, http.StatusUnauthorized, Errorf(Unauthenticated, "rpc gateway: unauthenticated: %v", err))
+		return
+	}
+	path := strings.TrimPrefix(req.URL.Path, g.prefix)
+	slash := strings.LastIndex(path, "/")
+	if slash == -1 || path == req.URL.Path {
+		writeGatewayError(w, http.StatusNotFound, Errorf(NotFound, "rpc gateway: invalid path %q", req.URL.Path))
+		return
+	}
+	serviceMethod := path[:slash] + "." + path[slash+1:]
+	svc, mtype, err := g.s.findService(serviceMethod)
+	if err == nil && mtype.stream {
+		err = Errorf(Unimplemented, "rpc gateway: stream method %s is not supported", serviceMethod)
+	}
+	if err != nil {
+		writeGatewayError(w, httpStatus(CodeOf(err)), err)
+		return
+	}
+
+	argv := mtype.newArgv()
+	argvi := argv.Interface()
+	if argv.Type().Kind() != reflect.Ptr {
+		argvi = argv.Addr().Interface()
+	}
+	maxSize := g.s.maxMessageSize
+	if maxSize <= 0 {
+		maxSize = codec.DefaultMaxMessageSize
+	}
+	err = json.NewDecoder(http.MaxBytesReader(w, req.Body, int64(maxSize))).Decode(argvi)
+	if err != nil && err != io.EOF {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeGatewayError(w, http.StatusRequestEntityTooLarge, Errorf(ResourceExhausted, "rpc gateway: %v", err))
+			return
+		}
+		writeGatewayError(w, http.StatusBadRequest, Errorf(InvalidArgument, "rpc gateway: read argv err: %v", err))
+		return
+	}
+
+	md := make(Metadata)
+	for key, values := range req.Header {
+		if strings.HasPrefix(key, GatewayMetadataPrefix) && len(values) > 0 {
+			md[strings.ToLower(strings.TrimPrefix(key, GatewayMetadataPrefix))] = values[0]
+		}
+	}
+	ctx := NewPeerContext(req.Context(), peer)
+	ctx, rmd := newMetadataContext(ctx, md)
+	// 鉴权与统计使用注册时的服务名，别名无法绕过方法级的权限检查
+	serviceMethod = svc.methodName(mtype)
+	if err = g.s.authorize(ctx, serviceMethod); err != nil {
+		writeGatewayError(w, httpStatus(CodeOf(err)), err)
+		return
+	}
+
+	atomic.AddInt64(&g.s.inflight, 1)
+	defer atomic.AddInt64(&g.s.inflight, -1)
+	replyv := mtype.newReply()
+	start := time.Now()
+	if g.s.stats != nil {
+		g.s.stats.RequestStart(serviceMethod)
+	}
+	err = g.s.call(ctx, svc, mtype, argv, replyv)
+	elapsed := time.Since(start)
+	mtype.latency.record(elapsed, err)
+	if g.s.stats != nil {
+		g.s.stats.RequestEnd(serviceMethod, err, elapsed)
+	}
+
+	for key, value := range rmd.get() {
+		w.Header().Set(GatewayMetadataPrefix+key, value)
+	}
+	if err != nil {
+		writeGatewayError(w, httpStatus(CodeOf(err)), err)
+		return
+	}
+	writeGatewayJSON(w, http.StatusOK, replyv.Interface())
+}
+
+// 根据 HTTP 请求生成 Peer，双向 TLS 时包含经过验证的客户端证书
+func gatewayPeer(req *http.Request) *Peer {
+	p := &Peer{Addr: gatewayAddr(req.RemoteAddr)}
+	if req.TLS != nil && len(req.TLS.VerifiedChains) > 0 && len(req.TLS.VerifiedChains[0]) > 0 {
+		p.Certificates = req.TLS.VerifiedChains[0]
+		p.Identity = p.Certificates[0].Subject.CommonName
+	}
+	return p
+}
+
+// gatewayAddr 为 HTTP 请求的客户端地址
+type gatewayAddr string
+
+var _ net.Addr = gatewayAddr("")
+
+func (gatewayAddr) Network() string  { return "tcp" }
+func (a gatewayAddr) String() string { return string(a) }
+
+func writeGatewayError(w http.ResponseWriter, status int, err error) {
+	var e *Error
+	if !errors.As(err, &e) {
+		e = &Error{Code: CodeOf(err), Message: err.Error()}
+	}
+	writeGatewayJSON(w, status, gatewayError{Code: e.Code.String(), Message: e.Message})
+}
+
+func writeGatewayJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// 将错误码转换为 HTTP 状态码
+func httpStatus(code Code) int {
+	switch code {
+	case OK:
+		return http.StatusOK
+	case Canceled:
+		return 499 // 客户端关闭了连接
+	case InvalidArgument, FailedPrecondition, OutOfRange:
+		return http.StatusBadRequest
+	case DeadlineExceeded:
+		return http.StatusGatewayTimeout
+	case NotFound:
+		return http.StatusNotFound
+	case AlreadyExists, Aborted:
+		return http.StatusConflict
+	case PermissionDenied:
+		return http.StatusForbidden
+	case ResourceExhausted:
+		return http.StatusTooManyRequests
+	case Unimplemented:
+		return http.StatusNotImplemented
+	case Unavailable:
+		return http.StatusServiceUnavailable
+	case Unauthenticated:
+		return http.StatusUnauthorized
+	}
+	return http.StatusInternalServerError
+}
+
+// HandleGateway 在 http.DefaultServeMux 上注册 DefaultServer 的 HTTP 网关
+func HandleGateway() {
+	DefaultServer.HandleGateway()
+}
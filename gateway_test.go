@@ -0,0 +1,57 @@
+package geerpc
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestServer_Gateway(t *testing.T) {
+	server := NewServer(WithMaxMessageSize(64))
+	_ = server.Register(new(Foo))
+	_ = server.Register(Echo{})
+	ts := httptest.NewServer(server.Gateway(defaultGatewayPath))
+	defer ts.Close()
+
+	post := func(path, body string, header http.Header) (*http.Response, map[string]interface{}) {
+		req, _ := http.NewRequest(http.MethodPost, ts.URL+path, strings.NewReader(body))
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		resp, err := http.DefaultClient.Do(req)
+		_assert(err == nil, "failed to post %s: %v", path, err)
+		defer func() { _ = resp.Body.Close() }()
+		var v interface{}
+		_ = json.NewDecoder(resp.Body).Decode(&v)
+		m, _ := v.(map[string]interface{})
+		if m == nil {
+			m = map[string]interface{}{"reply": v}
+		}
+		return resp, m
+	}
+
+	resp, v := post("/rpc/Foo/Sum", `{"Num1": 1, "Num2": 2}`, nil)
+	_assert(resp.StatusCode == http.StatusOK && v["reply"] == float64(3), "expect 3, got %d %v", resp.StatusCode, v)
+
+	resp, v = post("/rpc/Echo/Metadata", `"token"`, http.Header{GatewayMetadataPrefix + "Token": {"abc"}})
+	_assert(v["reply"] == "abc" && resp.Header.Get(GatewayMetadataPrefix+"Echo") == "abc",
+		"expect metadata to pass through headers, got %v %v", v, resp.Header)
+
+	resp, v = post("/rpc/Echo/Fail", `5`, nil)
+	_assert(resp.StatusCode == http.StatusNotFound && v["code"] == "NotFound", "expect NotFound, got %d %v", resp.StatusCode, v)
+
+	resp, v = post("/rpc/Foo/Missing", `{}`, nil)
+	_assert(resp.StatusCode == http.StatusNotFound, "expect 404 for missing method, got %d %v", resp.StatusCode, v)
+
+	resp, v = post("/rpc/Foo/Sum", `{"Num1": "x"}`, nil)
+	_assert(resp.StatusCode == http.StatusBadRequest && v["code"] == "InvalidArgument", "expect 400, got %d %v", resp.StatusCode, v)
+
+	resp, _ = post("/rpc/Foo/Sum", `{"Num1": 1`+strings.Repeat(" ", 100)+`}`, nil)
+	_assert(resp.StatusCode == http.StatusRequestEntityTooLarge, "expect 413, got %d", resp.StatusCode)
+
+	resp, err := http.Get(ts.URL + "/rpc/Foo/Sum")
+	_assert(err == nil && resp.StatusCode == http.StatusMethodNotAllowed, "expect 405 for GET, got %v", err)
+	_ = resp.Body.Close()
+}
@@ -1,3 +1,5 @@
 module geerpc
 
 go 1.19
+
+require google.golang.org/protobuf v1.34.2
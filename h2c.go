@@ -0,0 +1,22 @@
+//go:build go1.24
+
+package geerpc
+
+import "net/http"
+
+// EnableH2C 使 srv 同时接受未加密的 HTTP/2（h2c）连接，客户端通过 DialHTTP2 以 http 地址连接
+func EnableH2C(srv *http.Server) error {
+	if srv.Protocols == nil {
+		srv.Protocols = new(http.Protocols)
+		srv.Protocols.SetHTTP1(true)
+		srv.Protocols.SetHTTP2(true)
+	}
+	srv.Protocols.SetUnencryptedHTTP2(true)
+	return nil
+}
+
+func h2cTransport() (*http.Transport, error) {
+	tr := &http.Transport{Protocols: new(http.Protocols)}
+	tr.Protocols.SetUnencryptedHTTP2(true)
+	return tr, nil
+}
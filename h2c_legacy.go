@@ -0,0 +1,14 @@
+//go:build !go1.24
+
+package geerpc
+
+import "net/http"
+
+// EnableH2C 在 Go 1.24 之前的版本中不可用，返回错误
+func EnableH2C(srv *http.Server) error {
+	return errH2CUnsupported
+}
+
+func h2cTransport() (*http.Transport, error) {
+	return nil, errH2CUnsupported
+}
@@ -0,0 +1,84 @@
+package geerpc
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+)
+
+// 握手的 Option 与应答编码为 JSON，以 4 字节大端序的长度作为前缀，读取方恰好读完握手，不会读入属于后续消息的数据。
+// JSON 不会以 0 字节开头，而长度前缀的第一个字节在 maxHandshakeSize 以内时总为 0，
+// 服务端据此兼容旧版本客户端以换行分隔的 JSON，并以客户端使用的格式应答
+const (
+	handshakeLengthSize = 4
+	maxHandshakeSize    = 64 << 10
+)
+
+// 加密时双方在握手中交换的随机数的字节数，连接的密钥由预共享密钥与双方的随机数派生
+const handshakeNonceSize = 16
+
+// 返回握手使用的随机数
+func newHandshakeNonce() ([]byte, error) {
+	nonce := make([]byte, handshakeNonceSize)
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return nonce, nil
+}
+
+// 将握手 v 写入 w，legacy 为 true 时写入以换行结尾的 JSON
+func writeHandshake(w io.Writer, v interface{}, legacy bool) error {
+	if legacy {
+		return json.NewEncoder(w).Encode(v)
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if len(data) > maxHandshakeSize {
+		return fmt.Errorf("handshake of %d bytes exceeds limit %d", len(data), maxHandshakeSize)
+	}
+	// 长度与 JSON 一次写入，避免在同步的连接上分成两次写入
+	b := make([]byte, handshakeLengthSize, handshakeLengthSize+len(data))
+	binary.BigEndian.PutUint32(b, uint32(len(data)))
+	_, err = w.Write(append(b, data...))
+	return err
+}
+
+// 从 conn 读取握手并解码到 v，返回继续读取 conn 的连接，以及对端是否使用了以换行分隔的 JSON
+func readHandshake(conn net.Conn, v interface{}) (net.Conn, bool, error) {
+	r := bufio.NewReader(conn)
+	b, err := r.Peek(1)
+	if err != nil {
+		return nil, false, err
+	}
+	if b[0] != 0 {
+		dec := json.NewDecoder(r)
+		if err := dec.Decode(v); err != nil {
+			return nil, true, err
+		}
+		rconn, err := afterJSON(dec, &bufferedConn{Conn: conn, r: r})
+		return rconn, true, err
+	}
+
+	var hdr [handshakeLengthSize]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return nil, false, err
+	}
+	n := binary.BigEndian.Uint32(hdr[:])
+	if n > maxHandshakeSize {
+		return nil, false, fmt.Errorf("handshake of %d bytes exceeds limit %d", n, maxHandshakeSize)
+	}
+	data := make([]byte, n)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, false, err
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return nil, false, err
+	}
+	return &bufferedConn{Conn: conn, r: r}, false, nil
+}
@@ -0,0 +1,113 @@
+package geerpc
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sync/atomic"
+)
+
+// HealthServiceName 为内置的健康检查服务的名称，每个 Server 都会注册该服务，
+// 客户端、注册中心的探活与负载均衡器通过 Health.Check 查询服务端的状态
+const HealthServiceName = "Health"
+
+const (
+	healthzPath = "/healthz"
+	readyzPath  = "/readyz"
+)
+
+// ServingStatus 为服务端的服务状态
+type ServingStatus string
+
+const (
+	// Serving 表示服务端正常处理请求
+	Serving ServingStatus = "SERVING"
+	// Draining 表示服务端正在关闭，不再接受新的连接，见 Server.Shutdown
+	Draining ServingStatus = "DRAINING"
+	// Overloaded 表示正在处理的请求数或连接数达到了上限，见 WithOverloadThreshold 与 WithMaxConnections
+	Overloaded ServingStatus = "OVERLOADED"
+)
+
+// HealthStatus 为 Health.Check 的响应
+type HealthStatus struct {
+	Status      ServingStatus
+	InFlight    int64 // 正在处理的请求数
+	Connections int   // 当前的连接数
+}
+
+// WithOverloadThreshold 设置正在处理的请求数达到 n 时健康检查报告 Overloaded，n <= 0 表示不根据请求数判断，
+// 只影响健康检查的结果，不会拒绝请求
+func WithOverloadThreshold(n int) ServerOption {
+	return func(s *Server) {
+		s.overloadThreshold = n
+	}
+}
+
+// Health 返回服务端当前的健康状态
+func (s *Server) Health() HealthStatus {
+	return s.health(atomic.LoadInt64(&s.inflight))
+}
+
+// 返回正在处理的请求数为 inflight 时的健康状态
+func (s *Server) health(inflight int64) HealthStatus {
+	h := HealthStatus{
+		Status:      Serving,
+		InFlight:    inflight,
+		Connections: s.conns.count(),
+	}
+	s.mu.Lock()
+	draining := s.shuttingDown
+	s.mu.Unlock()
+	switch {
+	case draining:
+		h.Status = Draining
+	case s.overloadThreshold > 0 && h.InFlight >= int64(s.overloadThreshold),
+		s.conns.max > 0 && h.Connections >= s.conns.max:
+		h.Status = Overloaded
+	}
+	return h
+}
+
+type healthService struct {
+	s *Server
+}
+
+// Check 返回服务端的健康状态，service 不为空时同时检查该服务是否已注册
+func (h *healthService) Check(service string, reply *HealthStatus) error {
+	if service != "" {
+		if _, ok := h.s.serviceMap.Load(service); !ok {
+			return Errorf(NotFound, "rpc: service not found: %s", service)
+		}
+	}
+	// 不计入健康检查请求本身
+	*reply = h.s.health(atomic.LoadInt64(&h.s.inflight) - 1)
+	return nil
+}
+
+// HealthCheck 调用服务端的 Health.Check，service 为空时只检查服务端本身
+func (client *Client) HealthCheck(ctx context.Context, service string) (HealthStatus, error) {
+	var status HealthStatus
+	err := client.Call(ctx, HealthServiceName+".Check", service, &status)
+	return status, err
+}
+
+// HandleHealthHTTP 在 http.DefaultServeMux 上注册用于存活与就绪探针（例如 Kubernetes）的 HTTP 接口：
+// /healthz 在进程能够响应时返回 200，/readyz 只在状态为 Serving 时返回 200，否则返回 503，body 为当前的状态
+func (s *Server) HandleHealthHTTP() {
+	http.Handle(healthzPath, &healthHTTP{s: s})
+	http.Handle(readyzPath, &healthHTTP{s: s, ready: true})
+}
+
+type healthHTTP struct {
+	s     *Server
+	ready bool // 是否为就绪探针
+}
+
+func (h *healthHTTP) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	status := h.s.Health().Status
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if h.ready && status != Serving {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	_, _ = io.WriteString(w, string(status)+"\n")
+}
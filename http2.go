@@ -0,0 +1,179 @@
+package geerpc
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// http2StatusHeader 为服务端接受 HTTP/2 连接时在响应中设置的 header，用于确认对端是 RPC 服务
+const http2StatusHeader = "Geerpc-Status"
+
+// http2Conn 将 HTTP/2 请求与响应的 body 适配为 net.Conn，设置截止时间不生效
+type http2Conn struct {
+	r      io.ReadCloser
+	w      io.Writer
+	flush  func()
+	local  net.Addr
+	remote net.Addr
+	peer   *Peer // 服务端一侧根据 HTTP 请求生成的 Peer
+	close  func()
+
+	mu     sync.Mutex // 保护 w 与 closed，Close 之后不再写入
+	closed bool
+}
+
+func (c *http2Conn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
+
+func (c *http2Conn) Write(b []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return 0, net.ErrClosed
+	}
+	n, err := c.w.Write(b)
+	if err == nil && c.flush != nil {
+		c.flush()
+	}
+	return n, err
+}
+
+func (c *http2Conn) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return net.ErrClosed
+	}
+	c.closed = true
+	_ = c.r.Close()
+	if c.close != nil {
+		c.close()
+	}
+	return nil
+}
+
+func (c *http2Conn) LocalAddr() net.Addr              { return c.local }
+func (c *http2Conn) RemoteAddr() net.Addr             { return c.remote }
+func (c *http2Conn) SetDeadline(time.Time) error      { return nil }
+func (c *http2Conn) SetReadDeadline(time.Time) error  { return nil }
+func (c *http2Conn) SetWriteDeadline(time.Time) error { return nil }
+
+// 以 HTTP/2 请求的双向 body 作为连接提供服务，直到连接关闭
+func (s *Server) serveHTTP2(w http.ResponseWriter, req *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "500 streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set(http2StatusHeader, "connected")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	conn := &http2Conn{
+		r:      req.Body,
+		w:      w,
+		flush:  flusher.Flush,
+		local:  gatewayAddr(req.Host),
+		remote: gatewayAddr(req.RemoteAddr),
+		peer:   gatewayPeer(req),
+	}
+	s.handleConn(conn)
+	// handler 返回后不能再使用 w
+	_ = conn.Close()
+}
+
+// DialHTTP2 通过 HTTP/2 连接到 rawURL（例如 https://host:port/_geeprc_）上的 RPC 服务，
+// 可以穿过只允许 HTTP/2 的代理，并与其他 HTTP/2 服务共用端口。https 使用 TLS，config 为 nil 时使用默认配置；
+// http 使用未加密的 HTTP/2（h2c），需要 Go 1.24 及以上版本，服务端见 EnableH2C。URL 没有路径时使用默认的 RPC 路径
+func DialHTTP2(ctx context.Context, rawURL string, config *tls.Config, opt *Option) (*Client, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("rpc client: invalid url %q: %w", rawURL, err)
+	}
+	if u.Path == "" {
+		u.Path = defaultRPCPath
+	}
+	if opt.ConnectTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opt.ConnectTimeout)
+		defer cancel()
+	}
+
+	var tr *http.Transport
+	switch u.Scheme {
+	case "https":
+		tr = &http.Transport{TLSClientConfig: config, ForceAttemptHTTP2: true}
+	case "http":
+		if tr, err = h2cTransport(); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("rpc client: unsupported scheme %q", u.Scheme)
+	}
+	if opt.DialFunc != nil {
+		tr.DialContext = opt.DialFunc
+	}
+
+	// 请求在连接的整个生命周期内有效，不受 ctx 约束
+	reqCtx, cancel := context.WithCancel(context.Background())
+	pr, pw := io.Pipe()
+	req, err := http.NewRequestWithContext(reqCtx, http.MethodPost, u.String(), pr)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	closeAll := func() {
+		_ = pw.Close()
+		cancel()
+		tr.CloseIdleConnections()
+	}
+
+	ch := make(chan clientResult, 1)
+	go func() {
+		resp, err := tr.RoundTrip(req)
+		if err != nil {
+			ch <- clientResult{err: err}
+			return
+		}
+		if resp.ProtoMajor != 2 || resp.StatusCode != http.StatusOK || resp.Header.Get(http2StatusHeader) != "connected" {
+			_ = resp.Body.Close()
+			ch <- clientResult{err: fmt.Errorf("rpc client: unexpected HTTP response: %s %s", resp.Proto, resp.Status)}
+			return
+		}
+		conn := &http2Conn{
+			r:      resp.Body,
+			w:      pw,
+			local:  gatewayAddr("http2"),
+			remote: gatewayAddr(u.Host),
+			close:  closeAll,
+		}
+		client, err := NewClient(conn, opt)
+		if err != nil {
+			_ = conn.Close()
+		}
+		ch <- clientResult{client, err}
+	}()
+
+	select {
+	case <-ctx.Done():
+		closeAll()
+		return nil, fmt.Errorf("rpc client: connect http2: %w", ctx.Err())
+	case result := <-ch:
+		if result.err != nil {
+			closeAll()
+		}
+		return result.client, result.err
+	}
+}
+
+// errH2CUnsupported 表示当前的 Go 版本不支持未加密的 HTTP/2
+var errH2CUnsupported = errors.New("rpc: h2c requires go1.24 or later")
@@ -0,0 +1,181 @@
+package geerpc
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"net"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// IdempotencyKey 为在 metadata 中传递幂等键的 key，见 WithIdempotencyKey
+const IdempotencyKey = "idempotency-key"
+
+// WithIdempotencyKey 为调用设置幂等键，重试时沿用同一个 key。服务端启用 WithIdempotency 时，
+// 同一调用方对同一方法使用相同 key 的请求只执行一次，之后的请求直接返回第一次执行的结果，
+// 参数与第一次请求不同时返回 InvalidArgument 错误
+func WithIdempotencyKey(key string) CallOption {
+	return WithMetadata(Metadata{IdempotencyKey: key})
+}
+
+// 默认的幂等缓存配置
+const (
+	DefaultIdempotencySize = 10000
+	DefaultIdempotencyTTL  = 10 * time.Minute
+)
+
+// WithIdempotency 使服务端按 metadata 中的 IdempotencyKey 对请求去重，最多缓存 size 个结果，每个结果保留 ttl，
+// size 与 ttl <= 0 时使用默认值。幂等键按调用方隔离：依次使用客户端证书的身份、WithCredentials 的凭证、
+// 客户端的 IP 区分调用方。缓存的结果只在内存中，对同一服务端有效；流式方法不参与去重
+func WithIdempotency(size int, ttl time.Duration) ServerOption {
+	if size <= 0 {
+		size = DefaultIdempotencySize
+	}
+	if ttl <= 0 {
+		ttl = DefaultIdempotencyTTL
+	}
+	return func(s *Server) {
+		s.idempotency = &idempotencyCache{size: size, ttl: ttl, entries: make(map[string]*list.Element), lru: list.New()}
+	}
+}
+
+// 一次带幂等键的请求的结果，done 关闭后 reply 与 err 才有效
+type idempotencyEntry struct {
+	key     string
+	args    [sha256.Size]byte // 第一次请求的参数摘要，参数无法编码时为零值，不检查参数
+	done    chan struct{}
+	reply   reflect.Value
+	err     error
+	expires time.Time
+}
+
+// idempotencyCache 为按最近使用淘汰的幂等结果缓存
+type idempotencyCache struct {
+	size int
+	ttl  time.Duration
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List // 最近使用的在前
+}
+
+// 返回 key 对应的结果，不存在或已过期时以参数摘要 args 登记一个新的结果并返回 owner 为 true，
+// 由调用方执行请求后通过 finish 填充
+func (c *idempotencyCache) start(key string, args [sha256.Size]byte) (e *idempotencyEntry, owner bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.entries[key]; ok {
+		e = el.Value.(*idempotencyEntry)
+		select {
+		case <-e.done:
+			if time.Now().Before(e.expires) {
+				c.lru.MoveToFront(el)
+				return e, false
+			}
+			c.remove(el)
+		default:
+			// 正在执行
+			return e, false
+		}
+	}
+	e = &idempotencyEntry{key: key, args: args, done: make(chan struct{})}
+	c.entries[key] = c.lru.PushFront(e)
+	for c.lru.Len() > c.size {
+		c.remove(c.lru.Back())
+	}
+	return e, true
+}
+
+// 记录请求的结果，请求未能执行时丢弃该结果，之后使用相同 key 的请求会重新执行
+func (c *idempotencyCache) finish(e *idempotencyEntry, reply reflect.Value, err error) {
+	c.mu.Lock()
+	e.reply, e.err, e.expires = reply, err, time.Now().Add(c.ttl)
+	if !executed(err) {
+		if el, ok := c.entries[e.key]; ok && el.Value == e {
+			c.remove(el)
+		}
+	}
+	c.mu.Unlock()
+	close(e.done)
+}
+
+func (c *idempotencyCache) remove(el *list.Element) {
+	c.lru.Remove(el)
+	delete(c.entries, el.Value.(*idempotencyEntry).key)
+}
+
+// 返回 err 是否表示请求已经执行完成，被取消、限流或排队超时的请求未执行，不缓存其结果。
+// 超时的请求可能只执行了一部分，也不缓存，否则之后的重试在 ttl 内都会得到超时错误
+func executed(err error) bool {
+	switch CodeOf(err) {
+	case Canceled, Unavailable, ResourceExhausted, DeadlineExceeded:
+		return false
+	}
+	return true
+}
+
+// 按 ctx 中的幂等键执行 invoke，相同调用方的相同键已有结果时将其写入 replyv，正在执行时等待其结束，
+// 参数 argv 与第一次请求不同时返回 InvalidArgument 错误
+func (c *idempotencyCache) do(ctx context.Context, serviceMethod string, argv, replyv reflect.Value, invoke func(context.Context) error) error {
+	key := MetadataFromContext(ctx)[IdempotencyKey]
+	if key == "" {
+		return invoke(ctx)
+	}
+	args := digest(argv)
+	e, owner := c.start(idempotencyScope(ctx)+"|"+serviceMethod+"|"+key, args)
+	if owner {
+		err := invoke(ctx)
+		c.finish(e, replyv, err)
+		return err
+	}
+	if e.args != args {
+		return Errorf(InvalidArgument, "rpc server: idempotency key %q of %s reused with different arguments", key, serviceMethod)
+	}
+
+	select {
+	case <-e.done:
+	case <-ctx.Done():
+		return &Error{Code: CodeOf(ctx.Err()), Message: "rpc server: wait for idempotent request: " + ctx.Err().Error()}
+	}
+	if !executed(e.err) {
+		// 第一次请求未能执行，重新执行
+		return c.do(ctx, serviceMethod, argv, replyv, invoke)
+	}
+	if e.err == nil {
+		replyv.Elem().Set(e.reply.Elem())
+	}
+	return e.err
+}
+
+// 返回区分调用方的标识：客户端证书的身份，没有时为凭证的摘要，都没有时为客户端的 IP
+func idempotencyScope(ctx context.Context) string {
+	peer, _ := PeerFromContext(ctx)
+	if peer != nil && peer.Identity != "" {
+		return "identity:" + peer.Identity
+	}
+	if credentials := MetadataFromContext(ctx)[CredentialsKey]; credentials != "" {
+		sum := sha256.Sum256([]byte(credentials))
+		return "credentials:" + string(sum[:])
+	}
+	if peer != nil && peer.Addr != nil {
+		host, _, err := net.SplitHostPort(peer.Addr.String())
+		if err != nil {
+			host = peer.Addr.String()
+		}
+		return "addr:" + host
+	}
+	return ""
+}
+
+// 返回参数的摘要，JSON 编码时 map 的键有序，相同的参数得到相同的摘要。无法编码时返回零值
+func digest(argv reflect.Value) (sum [sha256.Size]byte) {
+	data, err := json.Marshal(argv.Interface())
+	if err != nil {
+		return sum
+	}
+	return sha256.Sum256(data)
+}
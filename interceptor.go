@@ -0,0 +1,100 @@
+package geerpc
+
+import (
+	"context"
+	"reflect"
+	runtimedebug "runtime/debug"
+)
+
+// Invoker 发起一次 RPC 调用
+type Invoker func(ctx context.Context, serviceMethod string, args, reply interface{}, opts ...CallOption) error
+
+// ClientInterceptor 拦截客户端的调用，可在 invoker 前后加入重试、链路追踪、统计等逻辑，
+// 需调用 invoker 才会真正发起请求
+type ClientInterceptor func(ctx context.Context, serviceMethod string, args, reply interface{}, invoker Invoker, opts ...CallOption) error
+
+// Use 为 client 添加拦截器，先添加的拦截器位于调用链的外层
+func (client *Client) Use(interceptors ...ClientInterceptor) {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	client.interceptors = append(client.interceptors, interceptors...)
+}
+
+// 将拦截器依次包裹在 invoker 外层
+func chainInterceptors(interceptors []ClientInterceptor, invoker Invoker) Invoker {
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		interceptor, next := interceptors[i], invoker
+		invoker = func(ctx context.Context, serviceMethod string, args, reply interface{}, opts ...CallOption) error {
+			return interceptor(ctx, serviceMethod, args, reply, next, opts...)
+		}
+	}
+	return invoker
+}
+
+// 返回包含所有拦截器的 invoker，没有拦截器时返回 nil
+func (client *Client) interceptedInvoker() Invoker {
+	client.mu.Lock()
+	interceptors := client.interceptors
+	client.mu.Unlock()
+
+	if len(interceptors) == 0 {
+		return nil
+	}
+	return chainInterceptors(interceptors, client.invoke)
+}
+
+// Handler 执行服务端的方法，args 为请求参数，reply 为返回值的指针，流式方法的 reply 为 *ServerStream
+type Handler func(ctx context.Context, serviceMethod string, args, reply interface{}) error
+
+// ServerInterceptor 拦截服务端的请求，可在 handler 前后加入认证、限流、日志等逻辑，
+// 需调用 handler 才会执行方法，传给 handler 的 ctx 会传递给方法
+type ServerInterceptor func(ctx context.Context, serviceMethod string, args, reply interface{}, handler Handler) error
+
+// 将服务端拦截器依次包裹在 handler 外层
+func chainServerInterceptors(interceptors []ServerInterceptor, handler Handler) Handler {
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		interceptor, next := interceptors[i], handler
+		handler = func(ctx context.Context, serviceMethod string, args, reply interface{}) error {
+			return interceptor(ctx, serviceMethod, args, reply, next)
+		}
+	}
+	return handler
+}
+
+// 经过服务端拦截器调用 svc 的方法 mtype，启用了幂等去重与优先级调度时在拦截器之后依次去重、排队。
+// 拦截器或 handler 发生 panic 时转换为 Internal 错误返回给客户端，避免整个进程崩溃
+func (s *Server) call(ctx context.Context, svc *service, mtype *methodType, argv, replyv reflect.Value) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = s.panicError(svc.methodName(mtype), r)
+		}
+	}()
+	execute := func(ctx context.Context) error {
+		if s.scheduler != nil && !mtype.stream {
+			if err := s.scheduler.acquire(ctx, PriorityFromContext(ctx)); err != nil {
+				return err
+			}
+			defer s.scheduler.release()
+		}
+		return svc.call(ctx, mtype, argv, replyv)
+	}
+	invoke := execute
+	if s.idempotency != nil && !mtype.stream {
+		invoke = func(ctx context.Context) error {
+			return s.idempotency.do(ctx, svc.methodName(mtype), argv, replyv, execute)
+		}
+	}
+	if len(s.interceptors) == 0 {
+		return invoke(ctx)
+	}
+	handler := chainServerInterceptors(s.interceptors, func(ctx context.Context, _ string, _, _ interface{}) error {
+		return invoke(ctx)
+	})
+	return handler(ctx, svc.methodName(mtype), argv.Interface(), replyv.Interface())
+}
+
+// 记录 serviceMethod 处理过程中的 panic 及调用栈，返回发送给客户端的 Internal 错误
+func (s *Server) panicError(serviceMethod string, r interface{}) error {
+	s.logger.Errorf("rpc server: %s panic: %v\n%s", serviceMethod, r, runtimedebug.Stack())
+	return Errorf(Internal, "rpc server: internal error: %s panic: %v", serviceMethod, r)
+}
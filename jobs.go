@@ -0,0 +1,250 @@
+package geerpc
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// JobServiceName 为内置的异步任务服务的名称，通过 WithJobs 启用
+const JobServiceName = "_jobs"
+
+// 默认的异步任务配置
+const (
+	DefaultMaxJobs = 1000
+	DefaultJobTTL  = 10 * time.Minute
+)
+
+// JobState 为异步任务的状态
+type JobState string
+
+const (
+	JobRunning  JobState = "running"
+	JobDone     JobState = "done"
+	JobFailed   JobState = "failed"
+	JobCanceled JobState = "canceled"
+)
+
+// JobRequest 为提交的异步任务，Args 为 JSON 编码的参数
+type JobRequest struct {
+	ServiceMethod string
+	Args          []byte
+}
+
+// JobStatus 为异步任务的状态与结果，Reply 为 JSON 编码的返回值，Code 与 Error 为任务失败时的错误
+type JobStatus struct {
+	ID    string
+	State JobState
+	Reply []byte
+	Code  Code
+	Error string
+}
+
+type job struct {
+	status   JobStatus
+	cancel   context.CancelFunc
+	finished time.Time
+}
+
+// jobService 在后台执行提交的调用，不受 HandleTimeout 限制，保留最多 max 个任务，结束的任务在 ttl 后清理
+type jobService struct {
+	s   *Server
+	max int
+	ttl time.Duration
+
+	mu   sync.Mutex
+	jobs map[string]*job
+}
+
+// WithJobs 启用内置的异步任务服务，客户端通过 SubmitJob 提交执行时间超过 HandleTimeout 的调用，
+// 之后通过 PollJob、AwaitJob 获取结果或通过 CancelJob 取消。服务端最多保留 maxJobs 个任务，
+// 结束的任务保留 ttl 后清理，maxJobs 与 ttl <= 0 时使用默认值。任务的参数与返回值使用 JSON 编码
+func WithJobs(maxJobs int, ttl time.Duration) ServerOption {
+	if maxJobs <= 0 {
+		maxJobs = DefaultMaxJobs
+	}
+	if ttl <= 0 {
+		ttl = DefaultJobTTL
+	}
+	return func(s *Server) {
+		s.jobs = &jobService{s: s, max: maxJobs, ttl: ttl, jobs: make(map[string]*job)}
+	}
+}
+
+// Submit 提交调用 req.ServiceMethod 的任务，返回任务 ID。任务沿用提交请求的 metadata 与 Peer，
+// 同样需通过 Authorizer 的检查
+func (js *jobService) Submit(ctx context.Context, req JobRequest, reply *string) error {
+	svc, mtype, err := js.s.findService(req.ServiceMethod)
+	if err == nil && (mtype.stream || svc.name == JobServiceName) {
+		err = Errorf(Unimplemented, "rpc jobs: method %s cannot run as a job", req.ServiceMethod)
+	}
+	if err != nil {
+		return err
+	}
+	if err = js.s.authorize(ctx, svc.methodName(mtype)); err != nil {
+		return err
+	}
+	argv := mtype.newArgv()
+	argvi := argv.Interface()
+	if argv.Type().Kind() != reflect.Ptr {
+		argvi = argv.Addr().Interface()
+	}
+	if len(req.Args) > 0 {
+		if err = json.Unmarshal(req.Args, argvi); err != nil {
+			return Errorf(InvalidArgument, "rpc jobs: read argv err: %v", err)
+		}
+	}
+
+	id, err := newJobID()
+	if err != nil {
+		return err
+	}
+	jobCtx, cancel := context.WithCancel(context.Background())
+	if p, ok := PeerFromContext(ctx); ok {
+		jobCtx = NewPeerContext(jobCtx, p)
+	}
+	jobCtx, _ = newMetadataContext(jobCtx, MetadataFromContext(ctx).Copy())
+	j := &job{status: JobStatus{ID: id, State: JobRunning}, cancel: cancel}
+
+	js.mu.Lock()
+	js.purge()
+	if len(js.jobs) >= js.max {
+		js.mu.Unlock()
+		cancel()
+		return Errorf(ResourceExhausted, "rpc jobs: too many jobs, max %d", js.max)
+	}
+	js.jobs[id] = j
+	js.mu.Unlock()
+
+	go js.run(j, jobCtx, svc, mtype, argv)
+	*reply = id
+	return nil
+}
+
+func (js *jobService) run(j *job, ctx context.Context, svc *service, mtype *methodType, argv reflect.Value) {
+	replyv := mtype.newReply()
+	err := js.s.call(ctx, svc, mtype, argv, replyv)
+	var reply []byte
+	if err == nil {
+		reply, err = json.Marshal(replyv.Interface())
+	}
+
+	js.mu.Lock()
+	defer js.mu.Unlock()
+	j.cancel()
+	j.finished = time.Now()
+	switch {
+	case j.status.State == JobCanceled:
+	case err != nil:
+		j.status.State, j.status.Code, j.status.Error = JobFailed, CodeOf(err), err.Error()
+	default:
+		j.status.State, j.status.Reply = JobDone, reply
+	}
+}
+
+// Poll 返回任务的状态
+func (js *jobService) Poll(id string, reply *JobStatus) error {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+	js.purge()
+	j, ok := js.jobs[id]
+	if !ok {
+		return Errorf(NotFound, "rpc jobs: job not found: %s", id)
+	}
+	*reply = j.status
+	return nil
+}
+
+// Cancel 取消正在执行的任务，通过 ctx 通知方法；已经结束的任务不受影响。reply 为任务是否被取消
+func (js *jobService) Cancel(id string, reply *bool) error {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+	j, ok := js.jobs[id]
+	if !ok {
+		return Errorf(NotFound, "rpc jobs: job not found: %s", id)
+	}
+	if j.status.State == JobRunning {
+		j.status.State, j.status.Code, j.status.Error = JobCanceled, Canceled, "rpc jobs: job canceled"
+		j.cancel()
+		*reply = true
+	}
+	return nil
+}
+
+// 清理结束超过 ttl 的任务，调用方需持有 js.mu
+func (js *jobService) purge() {
+	for id, j := range js.jobs {
+		if !j.finished.IsZero() && time.Since(j.finished) > js.ttl {
+			delete(js.jobs, id)
+		}
+	}
+}
+
+func newJobID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", Errorf(Internal, "rpc jobs: generate job id: %v", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// SubmitJob 将调用 serviceMethod 作为异步任务提交给启用了 WithJobs 的服务端，args 使用 JSON 编码，返回任务 ID
+func (client *Client) SubmitJob(ctx context.Context, serviceMethod string, args interface{}, opts ...CallOption) (string, error) {
+	data, err := json.Marshal(args)
+	if err != nil {
+		return "", Errorf(InvalidArgument, "rpc jobs: encode args: %v", err)
+	}
+	var id string
+	err = client.Call(ctx, JobServiceName+".Submit", JobRequest{ServiceMethod: serviceMethod, Args: data}, &id, opts...)
+	return id, err
+}
+
+// PollJob 查询任务的状态，任务成功结束时将返回值解码到 reply，失败或被取消时返回任务的错误
+func (client *Client) PollJob(ctx context.Context, id string, reply interface{}) (JobState, error) {
+	var status JobStatus
+	if err := client.Call(ctx, JobServiceName+".Poll", id, &status); err != nil {
+		return "", err
+	}
+	switch status.State {
+	case JobDone:
+		if reply != nil {
+			if err := json.Unmarshal(status.Reply, reply); err != nil {
+				return status.State, Errorf(DataLoss, "rpc jobs: decode reply: %v", err)
+			}
+		}
+	case JobFailed, JobCanceled:
+		return status.State, &Error{Code: status.Code, Message: status.Error}
+	}
+	return status.State, nil
+}
+
+// AwaitJob 每隔 interval 查询一次任务，直到任务结束或 ctx 结束，interval <= 0 时为 100ms
+func (client *Client) AwaitJob(ctx context.Context, id string, reply interface{}, interval time.Duration) error {
+	if interval <= 0 {
+		interval = 100 * time.Millisecond
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		state, err := client.PollJob(ctx, id, reply)
+		if err != nil || state != JobRunning {
+			return err
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return &Error{Code: CodeOf(ctx.Err()), Message: "rpc jobs: await job: " + ctx.Err().Error()}
+		}
+	}
+}
+
+// CancelJob 取消正在执行的任务，返回任务是否被取消
+func (client *Client) CancelJob(ctx context.Context, id string) (bool, error) {
+	var canceled bool
+	err := client.Call(ctx, JobServiceName+".Cancel", id, &canceled)
+	return canceled, err
+}
@@ -0,0 +1,249 @@
+// Package jwtauth 提供校验 JWT 的服务端拦截器，token 由客户端通过 geerpc.WithCredentials 随调用发送，
+// 校验通过后 claims 写入请求的 context，handler 通过 ClaimsFromContext 获取
+package jwtauth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"geerpc"
+)
+
+// Claims 为 JWT 的 payload
+type Claims map[string]interface{}
+
+// Subject 返回 sub，不存在时返回空字符串
+func (c Claims) Subject() string {
+	sub, _ := c["sub"].(string)
+	return sub
+}
+
+// Config 为校验 JWT 的配置
+type Config struct {
+	// Keys 为校验签名的密钥，key 为 JWT header 中的 kid，kid 为空时使用 Keys[""]。
+	// HS256/HS384/HS512 使用 []byte，RS* 与 PS* 使用 *rsa.PublicKey，ES* 使用 *ecdsa.PublicKey，EdDSA 使用 ed25519.PublicKey
+	Keys map[string]interface{}
+	// Issuer 不为空时要求 iss 与其相同
+	Issuer string
+	// Audience 不为空时要求 aud 包含该值
+	Audience string
+	// Leeway 为校验 exp 与 nbf 时允许的时钟偏差
+	Leeway time.Duration
+	// MetadataKey 为 token 所在的 metadata key，为空时使用 geerpc.CredentialsKey，token 可以带有 Bearer 前缀
+	MetadataKey string
+	// Skip 返回 true 的方法不校验 token，例如健康检查
+	Skip func(serviceMethod string) bool
+	// Now 返回当前时间，为 nil 时使用 time.Now
+	Now func() time.Time
+}
+
+var (
+	ErrMalformed        = errors.New("jwtauth: malformed token")
+	ErrUnsupportedAlg   = errors.New("jwtauth: unsupported signing algorithm")
+	ErrUnknownKey       = errors.New("jwtauth: unknown key")
+	ErrInvalidSignature = errors.New("jwtauth: invalid signature")
+	ErrExpired          = errors.New("jwtauth: token is expired")
+	ErrNotValidYet      = errors.New("jwtauth: token is not valid yet")
+	ErrInvalidIssuer    = errors.New("jwtauth: invalid issuer")
+	ErrInvalidAudience  = errors.New("jwtauth: invalid audience")
+)
+
+type claimsKey struct{}
+
+// ClaimsFromContext 返回拦截器校验通过的 claims
+func ClaimsFromContext(ctx context.Context) (Claims, bool) {
+	claims, ok := ctx.Value(claimsKey{}).(Claims)
+	return claims, ok
+}
+
+// Interceptor 返回校验 JWT 的服务端拦截器，token 缺失或无效时以 Unauthenticated 错误拒绝调用
+func Interceptor(cfg Config) geerpc.ServerInterceptor {
+	key := cfg.MetadataKey
+	if key == "" {
+		key = geerpc.CredentialsKey
+	}
+	return func(ctx context.Context, serviceMethod string, args, reply interface{}, handler geerpc.Handler) error {
+		if cfg.Skip != nil && cfg.Skip(serviceMethod) {
+			return handler(ctx, serviceMethod, args, reply)
+		}
+		token := geerpc.MetadataFromContext(ctx)[key]
+		if token == "" {
+			return geerpc.Errorf(geerpc.Unauthenticated, "jwtauth: missing token")
+		}
+		claims, err := cfg.Verify(strings.TrimPrefix(token, "Bearer "))
+		if err != nil {
+			return geerpc.Errorf(geerpc.Unauthenticated, "%v", err)
+		}
+		return handler(context.WithValue(ctx, claimsKey{}, claims), serviceMethod, args, reply)
+	}
+}
+
+type header struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+// Verify 校验 token 的签名与 exp、nbf、iss、aud，返回其 claims
+func (cfg *Config) Verify(token string) (Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrMalformed
+	}
+	var h header
+	if err := decodeSegment(parts[0], &h); err != nil {
+		return nil, err
+	}
+	var claims Claims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, err
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrMalformed
+	}
+	key, ok := cfg.Keys[h.Kid]
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+	if err := verifySignature(h.Alg, key, []byte(parts[0]+"."+parts[1]), sig); err != nil {
+		return nil, err
+	}
+	if err := cfg.validate(claims); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+func decodeSegment(seg string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return ErrMalformed
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return ErrMalformed
+	}
+	return nil
+}
+
+// 校验 exp、nbf、iss 与 aud
+func (cfg *Config) validate(claims Claims) error {
+	now := time.Now()
+	if cfg.Now != nil {
+		now = cfg.Now()
+	}
+	if exp, ok := claims["exp"].(float64); ok && now.After(time.Unix(int64(exp), 0).Add(cfg.Leeway)) {
+		return ErrExpired
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now.Before(time.Unix(int64(nbf), 0).Add(-cfg.Leeway)) {
+		return ErrNotValidYet
+	}
+	if cfg.Issuer != "" {
+		if iss, _ := claims["iss"].(string); iss != cfg.Issuer {
+			return ErrInvalidIssuer
+		}
+	}
+	if cfg.Audience != "" && !hasAudience(claims["aud"], cfg.Audience) {
+		return ErrInvalidAudience
+	}
+	return nil
+}
+
+// aud 可以是字符串或字符串数组
+func hasAudience(aud interface{}, want string) bool {
+	switch aud := aud.(type) {
+	case string:
+		return aud == want
+	case []interface{}:
+		for _, a := range aud {
+			if a == want {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+var hashes = map[string]crypto.Hash{
+	"256": crypto.SHA256,
+	"384": crypto.SHA384,
+	"512": crypto.SHA512,
+}
+
+// 按 alg 校验 signed 的签名 sig，key 的类型须与 alg 匹配，不接受 none
+func verifySignature(alg string, key interface{}, signed, sig []byte) error {
+	if alg == "EdDSA" {
+		pub, ok := key.(ed25519.PublicKey)
+		if !ok {
+			return ErrUnknownKey
+		}
+		if !ed25519.Verify(pub, signed, sig) {
+			return ErrInvalidSignature
+		}
+		return nil
+	}
+	if len(alg) != 5 {
+		return ErrUnsupportedAlg
+	}
+	hash, ok := hashes[alg[2:]]
+	if !ok {
+		return ErrUnsupportedAlg
+	}
+	h := hash.New()
+	h.Write(signed)
+	digest := h.Sum(nil)
+
+	switch alg[:2] {
+	case "HS":
+		secret, ok := key.([]byte)
+		if !ok {
+			return ErrUnknownKey
+		}
+		mac := hmac.New(hash.New, secret)
+		mac.Write(signed)
+		if !hmac.Equal(mac.Sum(nil), sig) {
+			return ErrInvalidSignature
+		}
+	case "RS", "PS":
+		pub, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return ErrUnknownKey
+		}
+		var err error
+		if alg[:2] == "RS" {
+			err = rsa.VerifyPKCS1v15(pub, hash, digest, sig)
+		} else {
+			err = rsa.VerifyPSS(pub, hash, digest, sig, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+		}
+		if err != nil {
+			return ErrInvalidSignature
+		}
+	case "ES":
+		pub, ok := key.(*ecdsa.PublicKey)
+		if !ok {
+			return ErrUnknownKey
+		}
+		// 签名为定长的 r 与 s 拼接而成
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		if len(sig) != 2*size {
+			return ErrInvalidSignature
+		}
+		r, s := new(big.Int).SetBytes(sig[:size]), new(big.Int).SetBytes(sig[size:])
+		if !ecdsa.Verify(pub, digest, r, s) {
+			return ErrInvalidSignature
+		}
+	default:
+		return fmt.Errorf("%w: %s", ErrUnsupportedAlg, alg)
+	}
+	return nil
+}
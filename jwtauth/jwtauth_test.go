@@ -0,0 +1,144 @@
+package jwtauth
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"geerpc"
+)
+
+func encodeSegment(v interface{}) string {
+	data, _ := json.Marshal(v)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func signHS256(secret []byte, kid string, claims Claims) string {
+	signed := encodeSegment(map[string]string{"alg": "HS256", "typ": "JWT", "kid": kid}) + "." + encodeSegment(claims)
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(signed))
+	return signed + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func signES256(key *ecdsa.PrivateKey, claims Claims) string {
+	signed := encodeSegment(map[string]string{"alg": "ES256"}) + "." + encodeSegment(claims)
+	digest := sha256.Sum256([]byte(signed))
+	r, s, _ := ecdsa.Sign(rand.Reader, key, digest[:])
+	sig := make([]byte, 64)
+	r.FillBytes(sig[:32])
+	s.FillBytes(sig[32:])
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func TestVerify(t *testing.T) {
+	secret := []byte("secret")
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	now := time.Unix(1700000000, 0)
+	cfg := &Config{
+		Keys:     map[string]interface{}{"hs": secret, "": &ecKey.PublicKey},
+		Issuer:   "auth.example.com",
+		Audience: "geerpc",
+		Leeway:   time.Minute,
+		Now:      func() time.Time { return now },
+	}
+	valid := Claims{"sub": "alice", "iss": "auth.example.com", "aud": []string{"other", "geerpc"}, "exp": now.Unix() + 60}
+	with := func(key string, value interface{}) Claims {
+		c := Claims{}
+		for k, v := range valid {
+			c[k] = v
+		}
+		c[key] = value
+		return c
+	}
+
+	claims, err := cfg.Verify(signHS256(secret, "hs", valid))
+	if err != nil || claims.Subject() != "alice" {
+		t.Fatalf("expect HS256 token to be valid, got %v, %v", claims, err)
+	}
+	if _, err = cfg.Verify(signES256(ecKey, valid)); err != nil {
+		t.Fatalf("expect ES256 token to be valid, got %v", err)
+	}
+
+	tampered := signHS256(secret, "hs", valid)
+	tampered = tampered[:len(tampered)-2] + "AA"
+	cases := []struct {
+		token string
+		err   error
+	}{
+		{"a.b", ErrMalformed},
+		{tampered, ErrInvalidSignature},
+		{signHS256([]byte("other"), "hs", valid), ErrInvalidSignature},
+		{signHS256(secret, "missing", valid), ErrUnknownKey},
+		{signHS256(secret, "", valid), ErrUnknownKey}, // 密钥类型与 alg 不匹配
+		{signHS256(secret, "hs", with("exp", now.Unix()-120)), ErrExpired},
+		{signHS256(secret, "hs", with("nbf", now.Unix()+120)), ErrNotValidYet},
+		{signHS256(secret, "hs", with("iss", "evil")), ErrInvalidIssuer},
+		{signHS256(secret, "hs", with("aud", "other")), ErrInvalidAudience},
+		{encodeSegment(map[string]string{"alg": "none"}) + "." + encodeSegment(valid) + ".", ErrUnsupportedAlg},
+	}
+	for i, c := range cases {
+		if _, err := cfg.Verify(c.token); !errors.Is(err, c.err) {
+			t.Fatalf("case %d: expect %v, got %v", i, c.err, err)
+		}
+	}
+	// 在 leeway 之内
+	if _, err := cfg.Verify(signHS256(secret, "hs", with("exp", now.Unix()-30))); err != nil {
+		t.Fatalf("expect token within leeway to be valid, got %v", err)
+	}
+}
+
+type Hello struct{}
+
+func (Hello) Whoami(ctx context.Context, _ int, reply *string) error {
+	claims, _ := ClaimsFromContext(ctx)
+	*reply = claims.Subject()
+	return nil
+}
+
+func (Hello) Health(_ int, reply *string) error {
+	*reply = "ok"
+	return nil
+}
+
+func TestInterceptor(t *testing.T) {
+	secret := []byte("secret")
+	server := geerpc.NewServer(geerpc.WithInterceptors(Interceptor(Config{
+		Keys: map[string]interface{}{"": secret},
+		Skip: func(serviceMethod string) bool { return serviceMethod == "Hello.Health" },
+	})))
+	_ = server.Register(Hello{})
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	defer func() { _ = l.Close() }()
+	go server.Accept(l)
+
+	client, err := geerpc.Dial("tcp", l.Addr().String(), geerpc.DefaultOption)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = client.Close() }()
+	ctx := context.Background()
+
+	var reply string
+	token := signHS256(secret, "", Claims{"sub": "bob", "exp": time.Now().Add(time.Hour).Unix()})
+	if err = client.Call(ctx, "Hello.Whoami", 0, &reply, geerpc.WithCredentials("Bearer "+token)); err != nil || reply != "bob" {
+		t.Fatalf("expect claims in context, got %q, %v", reply, err)
+	}
+	if err = client.Call(ctx, "Hello.Whoami", 0, &reply); geerpc.CodeOf(err) != geerpc.Unauthenticated {
+		t.Fatalf("expect Unauthenticated without token, got %v", err)
+	}
+	if err = client.Call(ctx, "Hello.Whoami", 0, &reply, geerpc.WithCredentials("bad")); geerpc.CodeOf(err) != geerpc.Unauthenticated {
+		t.Fatalf("expect Unauthenticated for invalid token, got %v", err)
+	}
+	if err = client.Call(ctx, "Hello.Health", 0, &reply); err != nil || reply != "ok" {
+		t.Fatalf("expect skipped method to be called without token, got %v", err)
+	}
+}
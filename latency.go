@@ -0,0 +1,71 @@
+package geerpc
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// latencySamples 为每个方法保留的最近请求耗时的个数，分位数根据这些样本计算
+const latencySamples = 1024
+
+// latencyStats 统计方法的请求数、错误数与处理耗时
+type latencyStats struct {
+	mu      sync.Mutex
+	count   uint64
+	errors  uint64
+	total   time.Duration
+	samples []time.Duration // 环形缓冲区
+	next    int
+}
+
+// latencySnapshot 为 latencyStats 在某一时刻的统计结果
+type latencySnapshot struct {
+	Count  uint64
+	Errors uint64
+	Total  time.Duration
+	Mean   time.Duration
+	P50    time.Duration
+	P99    time.Duration
+}
+
+func (l *latencyStats) record(elapsed time.Duration, err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.count++
+	if err != nil {
+		l.errors++
+	}
+	l.total += elapsed
+	if len(l.samples) < latencySamples {
+		l.samples = append(l.samples, elapsed)
+		return
+	}
+	l.samples[l.next] = elapsed
+	l.next = (l.next + 1) % latencySamples
+}
+
+func (l *latencyStats) snapshot() latencySnapshot {
+	l.mu.Lock()
+	s := latencySnapshot{Count: l.count, Errors: l.errors, Total: l.total}
+	samples := append([]time.Duration(nil), l.samples...)
+	l.mu.Unlock()
+
+	if s.Count == 0 {
+		return s
+	}
+	s.Mean = s.Total / time.Duration(s.Count)
+	sort.Slice(samples, func(i, j int) bool { return samples[i] < samples[j] })
+	s.P50 = percentile(samples, 0.5)
+	s.P99 = percentile(samples, 0.99)
+	return s
+}
+
+// 返回有序样本 sorted 的 q 分位数
+func percentile(sorted []time.Duration, q float64) time.Duration {
+	i := int(q*float64(len(sorted))+0.5) - 1
+	if i < 0 {
+		i = 0
+	}
+	return sorted[i]
+}
@@ -0,0 +1,123 @@
+// Package loadshed 提供自适应的过载保护拦截器。拦截器统计正在处理的请求数与处理耗时，
+// 服务端过载时从优先级最低的请求开始以 Unavailable 错误拒绝，并随过载程度逐步提高拒绝的优先级，
+// 负载恢复后逐步降低，避免所有请求一起超时。请求的优先级由客户端通过 geerpc.WithPriority 设置
+package loadshed
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"geerpc"
+)
+
+// DefaultInterval 为默认的调整间隔
+const DefaultInterval = 100 * time.Millisecond
+
+// Config 为过载保护的配置，MaxInFlight 与 TargetLatency 至少设置一个
+type Config struct {
+	// MaxInFlight 为正在处理的请求数的上限，达到时认为过载，0 表示不以此判断
+	MaxInFlight int
+	// TargetLatency 为一个调整间隔内请求平均处理耗时的上限，超过时认为过载，0 表示不以此判断
+	TargetLatency time.Duration
+	// Interval 为检查负载并调整拒绝阈值的间隔，0 表示 DefaultInterval
+	Interval time.Duration
+	// Now 返回当前时间，为 nil 时使用 time.Now
+	Now func() time.Time
+}
+
+type shedder struct {
+	cfg      Config
+	inflight int64 // 正在处理的请求数，需原子访问
+
+	mu         sync.Mutex
+	lastAdjust time.Time
+	shedding   bool
+	cutoff     int // 过载时拒绝优先级不高于 cutoff 的请求
+	// 当前间隔内的统计
+	minPriority int
+	seen        bool
+	completed   int
+	elapsed     time.Duration
+}
+
+// Interceptor 返回按 cfg 进行过载保护的服务端拦截器
+func Interceptor(cfg Config) geerpc.ServerInterceptor {
+	s := newShedder(cfg)
+	return func(ctx context.Context, serviceMethod string, args, reply interface{}, handler geerpc.Handler) error {
+		if !s.allow(geerpc.PriorityFromContext(ctx)) {
+			return geerpc.Errorf(geerpc.Unavailable, "loadshed: server is overloaded, %s rejected", serviceMethod)
+		}
+		atomic.AddInt64(&s.inflight, 1)
+		start := s.now()
+		defer func() {
+			atomic.AddInt64(&s.inflight, -1)
+			s.done(s.now().Sub(start))
+		}()
+		return handler(ctx, serviceMethod, args, reply)
+	}
+}
+
+func newShedder(cfg Config) *shedder {
+	if cfg.Interval <= 0 {
+		cfg.Interval = DefaultInterval
+	}
+	s := &shedder{cfg: cfg}
+	s.lastAdjust = s.now()
+	return s
+}
+
+func (s *shedder) now() time.Time {
+	if s.cfg.Now != nil {
+		return s.cfg.Now()
+	}
+	return time.Now()
+}
+
+// 返回优先级为 priority 的请求是否可以处理
+func (s *shedder) allow(priority int) bool {
+	now := s.now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if now.Sub(s.lastAdjust) >= s.cfg.Interval {
+		s.adjust()
+		s.lastAdjust = now
+	}
+	if !s.seen || priority < s.minPriority {
+		s.minPriority, s.seen = priority, true
+	}
+	return !s.shedding || priority > s.cutoff
+}
+
+// 记录一个请求的处理耗时
+func (s *shedder) done(elapsed time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.completed++
+	s.elapsed += elapsed
+}
+
+func (s *shedder) overloaded() bool {
+	if s.cfg.MaxInFlight > 0 && atomic.LoadInt64(&s.inflight) >= int64(s.cfg.MaxInFlight) {
+		return true
+	}
+	// 间隔内没有完成的请求时无法判断耗时，只依据正在处理的请求数
+	return s.cfg.TargetLatency > 0 && s.completed > 0 && s.elapsed/time.Duration(s.completed) > s.cfg.TargetLatency
+}
+
+// 根据上一个间隔的负载调整拒绝的优先级：持续过载时每个间隔提高一级，负载恢复后每个间隔降低一级
+func (s *shedder) adjust() {
+	overloaded := s.overloaded()
+	switch {
+	case overloaded && !s.shedding:
+		s.shedding, s.cutoff = true, s.minPriority
+	case overloaded:
+		s.cutoff++
+	case s.shedding:
+		if s.cutoff--; s.cutoff < s.minPriority {
+			s.shedding = false
+		}
+	}
+	s.seen, s.completed, s.elapsed = false, 0, 0
+}
@@ -0,0 +1,95 @@
+package loadshed
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"geerpc"
+)
+
+func TestShedder(t *testing.T) {
+	now := time.Unix(0, 0)
+	s := newShedder(Config{TargetLatency: 100 * time.Millisecond, Now: func() time.Time { return now }})
+	tick := func() { now = now.Add(DefaultInterval) }
+
+	// 未过载时接受所有请求
+	for _, p := range []int{-1, 0, 5} {
+		if !s.allow(p) {
+			t.Fatalf("expect priority %d to be allowed", p)
+		}
+	}
+	s.done(200 * time.Millisecond)
+
+	// 过载后先拒绝优先级最低的请求
+	tick()
+	if s.allow(-1) || !s.allow(0) || !s.allow(5) {
+		t.Fatal("expect only lowest priority to be rejected")
+	}
+	s.done(300 * time.Millisecond)
+
+	// 持续过载时提高拒绝的优先级
+	tick()
+	if s.allow(-1) || s.allow(0) || !s.allow(5) {
+		t.Fatal("expect priority 0 to be rejected")
+	}
+	s.done(10 * time.Millisecond)
+
+	// 负载恢复后逐步降低
+	tick()
+	if !s.allow(0) || s.allow(-1) {
+		t.Fatal("expect cutoff to be lowered by one")
+	}
+	tick()
+	if !s.allow(-1) || s.shedding {
+		t.Fatal("expect shedding to stop")
+	}
+}
+
+func TestShedder_MaxInFlight(t *testing.T) {
+	now := time.Unix(0, 0)
+	s := newShedder(Config{MaxInFlight: 2, Now: func() time.Time { return now }})
+	s.allow(0)
+	atomic.StoreInt64(&s.inflight, 2)
+	now = now.Add(DefaultInterval)
+	if s.allow(0) || !s.allow(1) {
+		t.Fatal("expect priority 0 to be rejected when too many requests are in flight")
+	}
+}
+
+type Slow struct{ delay time.Duration }
+
+func (s *Slow) Work(_ int, reply *int) error {
+	time.Sleep(s.delay)
+	return nil
+}
+
+func TestInterceptor(t *testing.T) {
+	server := geerpc.NewServer(geerpc.WithInterceptors(Interceptor(Config{
+		TargetLatency: time.Millisecond,
+		Interval:      time.Millisecond,
+	})))
+	_ = server.Register(&Slow{delay: 5 * time.Millisecond})
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	defer func() { _ = l.Close() }()
+	go server.Accept(l)
+
+	client, err := geerpc.Dial("tcp", l.Addr().String(), geerpc.DefaultOption)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = client.Close() }()
+
+	ctx := context.Background()
+	_ = client.Call(ctx, "Slow.Work", 0, new(int), geerpc.WithPriority(-1))
+	time.Sleep(2 * time.Millisecond)
+	err = client.Call(ctx, "Slow.Work", 0, new(int), geerpc.WithPriority(-1))
+	if geerpc.CodeOf(err) != geerpc.Unavailable {
+		t.Fatalf("expect low priority call to be rejected, got %v", err)
+	}
+	if err = client.Call(ctx, "Slow.Work", 0, new(int), geerpc.WithPriority(10)); err != nil {
+		t.Fatalf("expect high priority call to pass, got %v", err)
+	}
+}
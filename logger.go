@@ -0,0 +1,44 @@
+package geerpc
+
+import "log"
+
+// Logger 为 geerpc 输出日志使用的接口，可通过 WithLogger 与 Option.Logger 分别设置到 Server 与 Client，
+// 默认不输出任何日志
+type Logger interface {
+	Debugf(format string, v ...interface{})
+	Infof(format string, v ...interface{})
+	Errorf(format string, v ...interface{})
+}
+
+type nopLogger struct{}
+
+func (nopLogger) Debugf(string, ...interface{}) {}
+func (nopLogger) Infof(string, ...interface{})  {}
+func (nopLogger) Errorf(string, ...interface{}) {}
+
+// NopLogger 不输出任何日志
+var NopLogger Logger = nopLogger{}
+
+type stdLogger struct {
+	l *log.Logger
+}
+
+// NewStdLogger 使用标准库的 *log.Logger 输出日志，l 为 nil 时使用 log 包默认的 Logger
+func NewStdLogger(l *log.Logger) Logger {
+	if l == nil {
+		l = log.Default()
+	}
+	return stdLogger{l}
+}
+
+func (s stdLogger) Debugf(format string, v ...interface{}) { s.l.Printf("[DEBUG] "+format, v...) }
+func (s stdLogger) Infof(format string, v ...interface{})  { s.l.Printf("[INFO] "+format, v...) }
+func (s stdLogger) Errorf(format string, v ...interface{}) { s.l.Printf("[ERROR] "+format, v...) }
+
+// 返回 l，l 为 nil 时返回 NopLogger
+func loggerOrNop(l Logger) Logger {
+	if l == nil {
+		return NopLogger
+	}
+	return l
+}
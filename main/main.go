@@ -37,7 +37,7 @@ func startRegistry(wg *sync.WaitGroup) {
 func startServer(registryAddr string, wg *sync.WaitGroup) {
 	var foo Foo
 	l, _ := net.Listen("tcp", ":0")
-	server := geerpc.NewServer()
+	server := geerpc.NewServer(geerpc.WithLogger(geerpc.NewStdLogger(nil)))
 	_ = server.Register(&foo)
 	registry.Heartbeat(registryAddr, "tcp@"+l.Addr().String(), 0)
 	wg.Done()
@@ -87,7 +87,8 @@ func broadcast(registry string) {
 			defer wg.Done()
 			foo(xc, context.Background(), "broadcast", "Foo.Sum", &Args{Num1: i, Num2: i * i})
 			// expect 2 - 5 timeout
-			ctx, _ := context.WithTimeout(context.Background(), time.Second*2)
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second*2)
+			defer cancel()
 			foo(xc, ctx, "broadcast", "Foo.Sleep", &Args{Num1: i, Num2: i * i})
 		}(i)
 	}
@@ -96,6 +97,7 @@ func broadcast(registry string) {
 
 func main() {
 	log.SetFlags(0)
+	registry.SetLogger(geerpc.NewStdLogger(nil))
 
 	registryAddr := "http://localhost:9999/_geerpc_/registry"
 	var wg sync.WaitGroup
@@ -0,0 +1,77 @@
+package geerpc
+
+import (
+	"context"
+	"strconv"
+	"sync"
+)
+
+// Metadata 为随请求或响应传递的键值对，例如鉴权 token、trace ID、租户信息
+type Metadata map[string]string
+
+// Copy 返回 md 的拷贝
+func (md Metadata) Copy() Metadata {
+	if md == nil {
+		return nil
+	}
+	ret := make(Metadata, len(md))
+	for k, v := range md {
+		ret[k] = v
+	}
+	return ret
+}
+
+type metadataKey struct{}
+
+type responseMetadataKey struct{}
+
+// 由 handler 写入，随响应 header 返回给客户端
+type responseMetadata struct {
+	mu sync.Mutex
+	md Metadata
+}
+
+// MetadataFromContext 返回客户端随请求发送的 metadata
+func MetadataFromContext(ctx context.Context) Metadata {
+	md, _ := ctx.Value(metadataKey{}).(Metadata)
+	return md
+}
+
+type priorityKey struct{}
+
+// PriorityFromContext 返回客户端通过 WithPriority 设置的优先级，
+// 未设置时使用 metadata 中 PriorityKey 的值，均未设置时返回 0
+func PriorityFromContext(ctx context.Context) int {
+	if priority, _ := ctx.Value(priorityKey{}).(int32); priority != 0 {
+		return int(priority)
+	}
+	priority, _ := strconv.Atoi(MetadataFromContext(ctx)[PriorityKey])
+	return priority
+}
+
+// SetResponseMetadata 设置随响应返回给客户端的 metadata，ctx 不是请求的 context 时返回 false
+func SetResponseMetadata(ctx context.Context, key, value string) bool {
+	rmd, ok := ctx.Value(responseMetadataKey{}).(*responseMetadata)
+	if !ok {
+		return false
+	}
+	rmd.mu.Lock()
+	defer rmd.mu.Unlock()
+	if rmd.md == nil {
+		rmd.md = make(Metadata)
+	}
+	rmd.md[key] = value
+	return true
+}
+
+func newMetadataContext(ctx context.Context, md Metadata) (context.Context, *responseMetadata) {
+	rmd := &responseMetadata{}
+	ctx = context.WithValue(ctx, metadataKey{}, md)
+	return context.WithValue(ctx, responseMetadataKey{}, rmd), rmd
+}
+
+func (rmd *responseMetadata) get() Metadata {
+	rmd.mu.Lock()
+	defer rmd.mu.Unlock()
+	return rmd.md.Copy()
+}
@@ -0,0 +1,58 @@
+package metrics
+
+import (
+	"geerpc"
+	"net/http"
+	"time"
+)
+
+// ClientMetrics 实现了 geerpc.StatsHandler，统计每个方法的调用数、错误数与调用耗时，
+// 以及每个服务端正在进行的调用数与重连次数。通过 Option.StatsHandler 设置到 Client 或 XClient
+type ClientMetrics struct {
+	calls      *vec
+	errors     *vec
+	inFlight   *vec
+	reconnects *vec
+	latency    *vec
+}
+
+var _ geerpc.StatsHandler = (*ClientMetrics)(nil)
+
+// NewClientMetrics 创建 ClientMetrics，buckets 为空时使用 DefaultBuckets
+func NewClientMetrics(buckets ...float64) *ClientMetrics {
+	if len(buckets) == 0 {
+		buckets = DefaultBuckets
+	}
+	return &ClientMetrics{
+		calls:      newVec("geerpc_client_calls_total", "Total number of RPCs made by the client.", counterType, "method", "code"),
+		errors:     newVec("geerpc_client_errors_total", "Total number of RPCs that finished with an error.", counterType, "method"),
+		inFlight:   newVec("geerpc_client_in_flight_calls", "Number of RPCs currently in flight per backend.", gaugeType, "target"),
+		reconnects: newVec("geerpc_client_reconnects_total", "Total number of reconnects per backend.", counterType, "target"),
+		latency:    newHistogramVec("geerpc_client_call_seconds", "Latency of RPCs observed by the client.", buckets, "method"),
+	}
+}
+
+func (m *ClientMetrics) CallStart(target, serviceMethod string) {
+	m.inFlight.add(1, target)
+}
+
+func (m *ClientMetrics) CallEnd(target, serviceMethod string, err error, elapsed time.Duration) {
+	m.inFlight.add(-1, target)
+	m.calls.add(1, serviceMethod, geerpc.CodeOf(err).String())
+	if err != nil {
+		m.errors.add(1, serviceMethod)
+	}
+	m.latency.observe(elapsed.Seconds(), serviceMethod)
+}
+
+func (m *ClientMetrics) Reconnect(target string) {
+	m.reconnects.add(1, target)
+}
+
+// ServeHTTP 以 Prometheus 文本格式输出所有指标
+func (m *ClientMetrics) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	for _, v := range []*vec{m.calls, m.errors, m.inFlight, m.reconnects, m.latency} {
+		v.write(w)
+	}
+}
@@ -0,0 +1,58 @@
+package metrics
+
+import (
+	"context"
+	"geerpc"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestClientMetrics(t *testing.T) {
+	server := geerpc.NewServer()
+	_ = server.Register(new(Arith))
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	defer func() { _ = l.Close() }()
+	go server.Accept(l)
+
+	m := NewClientMetrics()
+	client, err := geerpc.Dial("tcp", l.Addr().String(), &geerpc.Option{
+		MagicNumber:  geerpc.MagicNumber,
+		CodecType:    geerpc.DefaultOption.CodecType,
+		StatsHandler: m,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = client.Close() }()
+
+	_ = client.Call(context.Background(), "Arith.Add", [2]int{1, 2}, new(int))
+	_ = client.Call(context.Background(), "Arith.Fail", 1, new(int))
+	call := <-client.Go("Arith.Add", [2]int{1, 2}, new(int), nil).Done
+	if call.Error != nil {
+		t.Fatal(call.Error)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_ = client.Call(ctx, "Arith.Add", [2]int{1, 2}, new(int))
+	time.Sleep(time.Millisecond * 10)
+
+	w := httptest.NewRecorder()
+	m.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	out := w.Body.String()
+	target := l.Addr().String()
+	for _, line := range []string{
+		`geerpc_client_calls_total{method="Arith.Add",code="OK"} 2`,
+		`geerpc_client_calls_total{method="Arith.Add",code="Canceled"} 1`,
+		`geerpc_client_calls_total{method="Arith.Fail",code="Unknown"} 1`,
+		`geerpc_client_errors_total{method="Arith.Fail"} 1`,
+		`geerpc_client_in_flight_calls{target="` + target + `"} 0`,
+		`geerpc_client_call_seconds_count{method="Arith.Add"} 3`,
+	} {
+		if !strings.Contains(out, line+"\n") {
+			t.Fatalf("expect %q in metrics output:\n%s", line, out)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+// Package metrics 采集 geerpc 的指标，并以 Prometheus 文本格式输出
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultBuckets 为耗时直方图默认的分桶，单位为秒
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+type metricType string
+
+const (
+	counterType   metricType = "counter"
+	gaugeType     metricType = "gauge"
+	histogramType metricType = "histogram"
+)
+
+// series 为一组标签值对应的时间序列
+type series struct {
+	labels  []string
+	value   float64
+	buckets []uint64 // 直方图每个分桶的累计计数
+	sum     float64
+	count   uint64
+}
+
+// vec 为同名、同类型、不同标签值的一组时间序列
+type vec struct {
+	name    string
+	help    string
+	typ     metricType
+	labels  []string
+	buckets []float64 // 仅直方图使用
+
+	mu     sync.Mutex // protect following
+	series map[string]*series
+}
+
+func newVec(name, help string, typ metricType, labels ...string) *vec {
+	return &vec{name: name, help: help, typ: typ, labels: labels, series: make(map[string]*series)}
+}
+
+func newHistogramVec(name, help string, buckets []float64, labels ...string) *vec {
+	v := newVec(name, help, histogramType, labels...)
+	v.buckets = buckets
+	return v
+}
+
+// 调用方需持有 v.mu
+func (v *vec) with(labelValues []string) *series {
+	key := strings.Join(labelValues, "\xff")
+	s, ok := v.series[key]
+	if !ok {
+		s = &series{labels: labelValues}
+		if v.typ == histogramType {
+			s.buckets = make([]uint64, len(v.buckets))
+		}
+		v.series[key] = s
+	}
+	return s
+}
+
+func (v *vec) add(delta float64, labelValues ...string) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.with(labelValues).value += delta
+}
+
+func (v *vec) observe(value float64, labelValues ...string) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	s := v.with(labelValues)
+	for i, upper := range v.buckets {
+		if value <= upper {
+			s.buckets[i]++
+		}
+	}
+	s.sum += value
+	s.count++
+}
+
+// 返回标签值对应的值，不存在时返回 0
+func (v *vec) get(labelValues ...string) float64 {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if s, ok := v.series[strings.Join(labelValues, "\xff")]; ok {
+		if v.typ == histogramType {
+			return float64(s.count)
+		}
+		return s.value
+	}
+	return 0
+}
+
+// 以 Prometheus 文本格式写出所有时间序列，按标签值排序以保证输出稳定
+func (v *vec) write(w io.Writer) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	_, _ = fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", v.name, v.help, v.name, v.typ)
+	keys := make([]string, 0, len(v.series))
+	for key := range v.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		s := v.series[key]
+		if v.typ != histogramType {
+			_, _ = fmt.Fprintf(w, "%s%s %s\n", v.name, v.formatLabels(s.labels, ""), formatFloat(s.value))
+			continue
+		}
+		for i, upper := range v.buckets {
+			_, _ = fmt.Fprintf(w, "%s_bucket%s %d\n", v.name, v.formatLabels(s.labels, formatFloat(upper)), s.buckets[i])
+		}
+		_, _ = fmt.Fprintf(w, "%s_bucket%s %d\n", v.name, v.formatLabels(s.labels, "+Inf"), s.count)
+		_, _ = fmt.Fprintf(w, "%s_sum%s %s\n", v.name, v.formatLabels(s.labels, ""), formatFloat(s.sum))
+		_, _ = fmt.Fprintf(w, "%s_count%s %d\n", v.name, v.formatLabels(s.labels, ""), s.count)
+	}
+}
+
+// le 不为空时追加直方图分桶的 le 标签
+func (v *vec) formatLabels(values []string, le string) string {
+	pairs := make([]string, 0, len(values)+1)
+	for i, value := range values {
+		pairs = append(pairs, v.labels[i]+"="+strconv.Quote(value))
+	}
+	if le != "" {
+		pairs = append(pairs, `le="`+le+`"`)
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatFloat(f float64) string {
+	if math.IsInf(f, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
@@ -0,0 +1,52 @@
+package metrics
+
+import (
+	"geerpc"
+	"net/http"
+	"time"
+)
+
+// ServerMetrics 实现了 geerpc.ServerStatsHandler，按方法统计请求数、错误数、正在处理的请求数与处理耗时。
+// ServerMetrics 同时是一个 http.Handler，通常挂载在 /metrics 供 Prometheus 抓取
+type ServerMetrics struct {
+	requests *vec
+	errors   *vec
+	inFlight *vec
+	latency  *vec
+}
+
+var _ geerpc.ServerStatsHandler = (*ServerMetrics)(nil)
+
+// NewServerMetrics 创建 ServerMetrics，buckets 为空时使用 DefaultBuckets
+func NewServerMetrics(buckets ...float64) *ServerMetrics {
+	if len(buckets) == 0 {
+		buckets = DefaultBuckets
+	}
+	return &ServerMetrics{
+		requests: newVec("geerpc_server_requests_total", "Total number of RPCs handled by the server.", counterType, "method", "code"),
+		errors:   newVec("geerpc_server_errors_total", "Total number of RPCs that finished with an error.", counterType, "method"),
+		inFlight: newVec("geerpc_server_in_flight_requests", "Number of RPCs currently being handled.", gaugeType, "method"),
+		latency:  newHistogramVec("geerpc_server_handling_seconds", "Time spent handling RPCs.", buckets, "method"),
+	}
+}
+
+func (m *ServerMetrics) RequestStart(serviceMethod string) {
+	m.inFlight.add(1, serviceMethod)
+}
+
+func (m *ServerMetrics) RequestEnd(serviceMethod string, err error, elapsed time.Duration) {
+	m.inFlight.add(-1, serviceMethod)
+	m.requests.add(1, serviceMethod, geerpc.CodeOf(err).String())
+	if err != nil {
+		m.errors.add(1, serviceMethod)
+	}
+	m.latency.observe(elapsed.Seconds(), serviceMethod)
+}
+
+// ServeHTTP 以 Prometheus 文本格式输出所有指标
+func (m *ServerMetrics) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	for _, v := range []*vec{m.requests, m.errors, m.inFlight, m.latency} {
+		v.write(w)
+	}
+}
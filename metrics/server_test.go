@@ -0,0 +1,67 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"geerpc"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+type Arith int
+
+func (a *Arith) Add(args [2]int, reply *int) error {
+	*reply = args[0] + args[1]
+	return nil
+}
+
+func (a *Arith) Fail(args int, reply *int) error {
+	return errors.New("failed")
+}
+
+func TestServerMetrics(t *testing.T) {
+	m := NewServerMetrics()
+	server := geerpc.NewServer(geerpc.WithServerStatsHandler(m))
+	_ = server.Register(new(Arith))
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	defer func() { _ = l.Close() }()
+	go server.Accept(l)
+
+	client, err := geerpc.Dial("tcp", l.Addr().String(), geerpc.DefaultOption)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = client.Close() }()
+	for i := 0; i < 2; i++ {
+		_ = client.Call(context.Background(), "Arith.Add", [2]int{i, 1}, new(int))
+	}
+	_ = client.Call(context.Background(), "Arith.Fail", 1, new(int))
+
+	// 响应发出后才会调用 RequestEnd，等待所有请求被统计
+	for deadline := time.Now().Add(time.Second); m.latency.get("Arith.Fail") == 0 || m.latency.get("Arith.Add") < 2; {
+		if time.Now().After(deadline) {
+			t.Fatal("expect requests to be recorded")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	w := httptest.NewRecorder()
+	m.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	out := w.Body.String()
+	for _, line := range []string{
+		`geerpc_server_requests_total{method="Arith.Add",code="OK"} 2`,
+		`geerpc_server_requests_total{method="Arith.Fail",code="Unknown"} 1`,
+		`geerpc_server_errors_total{method="Arith.Fail"} 1`,
+		`geerpc_server_in_flight_requests{method="Arith.Add"} 0`,
+		`geerpc_server_handling_seconds_bucket{method="Arith.Add",le="+Inf"} 2`,
+		`geerpc_server_handling_seconds_count{method="Arith.Fail"} 1`,
+		"# TYPE geerpc_server_handling_seconds histogram",
+	} {
+		if !strings.Contains(out, line+"\n") {
+			t.Fatalf("expect %q in metrics output:\n%s", line, out)
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package mux
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+// 返回通过 TCP 连接的客户端与服务端会话
+func sessionPair(t *testing.T, config *Config) (client, server *Session) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = l.Close() }()
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, _ := l.Accept()
+		accepted <- conn
+	}()
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, server = Client(conn, config), Server(<-accepted, config)
+	t.Cleanup(func() {
+		_ = client.Close()
+		_ = server.Close()
+	})
+	return client, server
+}
+
+func TestSession_Echo(t *testing.T) {
+	client, server := sessionPair(t, &Config{AcceptBacklog: 4, MaxStreamWindow: 4 << 10, MaxFrameSize: 1 << 10})
+	go func() {
+		for {
+			conn, err := server.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				_, _ = io.Copy(conn, conn)
+				_ = conn.Close()
+			}()
+		}
+	}()
+
+	// 数据远大于接收窗口，需要依赖窗口更新
+	data := bytes.Repeat([]byte("geerpc"), 100<<10)
+	stream, err := client.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		_, _ = stream.Write(data)
+	}()
+	got := make([]byte, len(data))
+	if _, err := io.ReadFull(stream, got); err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("failed to echo %d bytes: %v", len(data), err)
+	}
+	_ = stream.Close()
+	if _, err := stream.Read(got); !errors.Is(err, ErrStreamClosed) {
+		t.Fatalf("expect ErrStreamClosed after close, got %v", err)
+	}
+
+	_ = server.Close()
+	select {
+	case <-client.CloseChan():
+	case <-time.After(time.Second):
+		t.Fatal("expect client session to be closed with the server")
+	}
+	if _, err := client.Open(); !errors.Is(err, ErrSessionShutdown) {
+		t.Fatalf("expect ErrSessionShutdown, got %v", err)
+	}
+}
+
+func TestSession_IndependentStreams(t *testing.T) {
+	client, server := sessionPair(t, &Config{AcceptBacklog: 4, MaxStreamWindow: 4 << 10, MaxFrameSize: 1 << 10})
+
+	// 对端不读取 slow 上的数据，slow 的窗口耗尽后写入阻塞
+	slow, _ := client.Open()
+	if _, err := server.AcceptStream(); err != nil {
+		t.Fatal(err)
+	}
+	_ = slow.SetWriteDeadline(time.Now().Add(50 * time.Millisecond))
+	n, err := slow.Write(make([]byte, 64<<10))
+	if !errors.Is(err, os.ErrDeadlineExceeded) || n != 4<<10 {
+		t.Fatalf("expect write to block after filling the window, wrote %d: %v", n, err)
+	}
+
+	fast, _ := client.Open()
+	peer, _ := server.AcceptStream()
+	if _, err := fast.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 4)
+	_ = peer.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := io.ReadFull(peer, buf); err != nil || string(buf) != "ping" {
+		t.Fatalf("expect other streams not to be blocked: %q %v", buf, err)
+	}
+
+	// 关闭写入后对端读完数据得到 io.EOF
+	_ = fast.Close()
+	if _, err := peer.Read(buf); err != io.EOF {
+		t.Fatalf("expect io.EOF after remote close, got %v", err)
+	}
+
+	_ = peer.SetReadDeadline(time.Now().Add(10 * time.Millisecond))
+	if _, err := peer.Read(buf); err != io.EOF {
+		t.Fatalf("expect io.EOF to take precedence over deadline, got %v", err)
+	}
+}
+
+func TestSession_GoAwayAndBacklog(t *testing.T) {
+	client, server := sessionPair(t, &Config{AcceptBacklog: 1, MaxStreamWindow: 4 << 10, MaxFrameSize: 1 << 10})
+
+	// 超过积压上限的流被重置
+	_, _ = client.Open()
+	rejected, _ := client.Open()
+	_ = rejected.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := rejected.Read(make([]byte, 1)); !errors.Is(err, ErrStreamReset) {
+		t.Fatalf("expect stream over backlog to be reset, got %v", err)
+	}
+
+	if err := server.GoAway(); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(time.Second)
+	for {
+		_, err := client.Open()
+		if errors.Is(err, ErrRemoteGoAway) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expect ErrRemoteGoAway, got %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
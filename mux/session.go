@@ -0,0 +1,367 @@
+// Package mux 在一个连接上复用多个独立的双向字节流，每个流有各自的流量控制，
+// 较大的数据会拆分为多个帧与其他流的数据交替发送，一个流上的大消息不会阻塞其他流。
+//
+// 每个帧由固定长度的头部与 payload 组成，整数均为大端序，格式参考 yamux：
+//
+//	version  uint8   固定为 0
+//	type     uint8   帧的类型，见 typeData 等
+//	flags    uint16  见 flagSYN 等
+//	streamID uint32  流的 ID，客户端打开的流为奇数，服务端为偶数，会话级别的帧为 0
+//	length   uint32  数据帧为 payload 的字节数，窗口更新帧为增加的窗口大小
+package mux
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+)
+
+const (
+	protoVersion uint8 = 0
+	headerSize         = 12
+
+	typeData         uint8 = 0 // 携带流的数据
+	typeWindowUpdate uint8 = 1 // 增加对端在流上的发送窗口
+	typeGoAway       uint8 = 2 // 对端不再接受新的流
+
+	flagSYN uint16 = 1 << 0 // 打开流
+	flagFIN uint16 = 1 << 2 // 发送方不再写入数据
+	flagRST uint16 = 1 << 3 // 立即关闭流
+)
+
+var (
+	// ErrSessionShutdown 表示会话已关闭
+	ErrSessionShutdown = errors.New("mux: session shutdown")
+	// ErrRemoteGoAway 表示对端不再接受新的流
+	ErrRemoteGoAway = errors.New("mux: remote end is not accepting streams")
+	// ErrStreamClosed 表示流已经在本端关闭
+	ErrStreamClosed = errors.New("mux: stream closed")
+	// ErrStreamReset 表示流被对端重置
+	ErrStreamReset = errors.New("mux: stream reset")
+	// ErrProtocol 表示对端发送的帧不符合协议
+	ErrProtocol = errors.New("mux: protocol error")
+)
+
+// Config 为会话的配置
+type Config struct {
+	// AcceptBacklog 为尚未被 Accept 的流的最大数量，超过时重置对端新打开的流
+	AcceptBacklog int
+	// MaxStreamWindow 为每个流的接收窗口，对端最多发送这么多尚未被读取的数据
+	MaxStreamWindow uint32
+	// MaxFrameSize 为单个数据帧 payload 的最大字节数，越小不同流的数据交替得越细
+	MaxFrameSize int
+}
+
+// DefaultConfig 返回默认的配置
+func DefaultConfig() *Config {
+	return &Config{
+		AcceptBacklog:   256,
+		MaxStreamWindow: 256 << 10,
+		MaxFrameSize:    16 << 10,
+	}
+}
+
+// Session 为一个连接上的多路复用会话，实现了 net.Listener，Accept 返回对端打开的流
+type Session struct {
+	conn   net.Conn
+	config Config
+
+	writeMu sync.Mutex // 保证帧的头部与 payload 连续写入
+
+	mu       sync.Mutex // protect following
+	streams  map[uint32]*Stream
+	nextID   uint32
+	goAway   bool  // 本端不再接受新的流
+	remoteGA bool  // 对端不再接受新的流
+	err      error // 会话关闭的原因
+
+	accept chan *Stream
+	done   chan struct{} // 会话关闭时关闭
+}
+
+// Client 返回 conn 上客户端一侧的会话，config 为 nil 时使用 DefaultConfig
+func Client(conn net.Conn, config *Config) *Session {
+	return newSession(conn, config, 1)
+}
+
+// Server 返回 conn 上服务端一侧的会话，config 为 nil 时使用 DefaultConfig
+func Server(conn net.Conn, config *Config) *Session {
+	return newSession(conn, config, 2)
+}
+
+func newSession(conn net.Conn, config *Config, firstID uint32) *Session {
+	if config == nil {
+		config = DefaultConfig()
+	}
+	s := &Session{
+		conn:    conn,
+		config:  *config,
+		streams: make(map[uint32]*Stream),
+		nextID:  firstID,
+		accept:  make(chan *Stream, config.AcceptBacklog),
+		done:    make(chan struct{}),
+	}
+	go s.recvLoop()
+	return s
+}
+
+// Open 打开一个新的流
+func (s *Session) Open() (*Stream, error) {
+	s.mu.Lock()
+	if s.err != nil {
+		s.mu.Unlock()
+		return nil, ErrSessionShutdown
+	}
+	if s.remoteGA {
+		s.mu.Unlock()
+		return nil, ErrRemoteGoAway
+	}
+	id := s.nextID
+	s.nextID += 2
+	stream := newStream(s, id)
+	s.streams[id] = stream
+	s.mu.Unlock()
+
+	if err := s.writeFrame(typeData, flagSYN, id, 0, nil); err != nil {
+		s.removeStream(id)
+		return nil, err
+	}
+	return stream, nil
+}
+
+// AcceptStream 等待并返回对端打开的流
+func (s *Session) AcceptStream() (*Stream, error) {
+	select {
+	case stream := <-s.accept:
+		return stream, nil
+	case <-s.done:
+		return nil, ErrSessionShutdown
+	}
+}
+
+// Accept 等待并返回对端打开的流，实现 net.Listener
+func (s *Session) Accept() (net.Conn, error) {
+	stream, err := s.AcceptStream()
+	if err != nil {
+		return nil, err
+	}
+	return stream, nil
+}
+
+// Addr 返回底层连接的本地地址，实现 net.Listener
+func (s *Session) Addr() net.Addr {
+	return s.conn.LocalAddr()
+}
+
+// Conn 返回会话使用的底层连接
+func (s *Session) Conn() net.Conn {
+	return s.conn
+}
+
+// GoAway 通知对端不再接受新的流，已经打开的流不受影响
+func (s *Session) GoAway() error {
+	s.mu.Lock()
+	s.goAway = true
+	s.mu.Unlock()
+	return s.writeFrame(typeGoAway, 0, 0, 0, nil)
+}
+
+// NumStreams 返回会话上打开的流的数量
+func (s *Session) NumStreams() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.streams)
+}
+
+// IsClosed 返回会话是否已关闭
+func (s *Session) IsClosed() bool {
+	select {
+	case <-s.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// CloseChan 返回会话关闭时关闭的 channel
+func (s *Session) CloseChan() <-chan struct{} {
+	return s.done
+}
+
+// Close 关闭会话与底层连接，所有的流随之关闭
+func (s *Session) Close() error {
+	s.shutdown(ErrSessionShutdown)
+	return nil
+}
+
+// 以 err 关闭会话，重复调用时忽略
+func (s *Session) shutdown(err error) {
+	s.mu.Lock()
+	if s.err != nil {
+		s.mu.Unlock()
+		return
+	}
+	s.err = err
+	streams := s.streams
+	s.streams = make(map[uint32]*Stream)
+	s.mu.Unlock()
+
+	close(s.done)
+	_ = s.conn.Close()
+	for _, stream := range streams {
+		stream.abort(ErrSessionShutdown)
+	}
+}
+
+func (s *Session) removeStream(id uint32) {
+	s.mu.Lock()
+	delete(s.streams, id)
+	s.mu.Unlock()
+}
+
+// 写入一个帧，数据帧的 length 为 payload 的长度
+func (s *Session) writeFrame(typ uint8, flags uint16, id, length uint32, payload []byte) error {
+	var hdr [headerSize]byte
+	hdr[0] = protoVersion
+	hdr[1] = typ
+	binary.BigEndian.PutUint16(hdr[2:4], flags)
+	binary.BigEndian.PutUint32(hdr[4:8], id)
+	binary.BigEndian.PutUint32(hdr[8:12], length)
+
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	select {
+	case <-s.done:
+		return ErrSessionShutdown
+	default:
+	}
+	bufs := net.Buffers{hdr[:], payload}
+	if _, err := bufs.WriteTo(s.conn); err != nil {
+		go s.shutdown(err)
+		return err
+	}
+	return nil
+}
+
+// 读取对端的帧并分发给对应的流，连接出错或收到不符合协议的帧时关闭会话
+func (s *Session) recvLoop() {
+	r := bufio.NewReader(s.conn)
+	var hdr [headerSize]byte
+	for {
+		if _, err := io.ReadFull(r, hdr[:]); err != nil {
+			s.shutdown(err)
+			return
+		}
+		if hdr[0] != protoVersion {
+			s.shutdown(fmt.Errorf("%w: unsupported version %d", ErrProtocol, hdr[0]))
+			return
+		}
+		typ := hdr[1]
+		flags := binary.BigEndian.Uint16(hdr[2:4])
+		id := binary.BigEndian.Uint32(hdr[4:8])
+		length := binary.BigEndian.Uint32(hdr[8:12])
+
+		var err error
+		switch typ {
+		case typeData:
+			err = s.handleData(r, flags, id, length)
+		case typeWindowUpdate:
+			if stream := s.stream(id); stream != nil {
+				stream.grow(length)
+			}
+		case typeGoAway:
+			s.mu.Lock()
+			s.remoteGA = true
+			s.mu.Unlock()
+		default:
+			err = fmt.Errorf("%w: unknown frame type %d", ErrProtocol, typ)
+		}
+		if err != nil {
+			s.shutdown(err)
+			return
+		}
+	}
+}
+
+func (s *Session) stream(id uint32) *Stream {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.streams[id]
+}
+
+func (s *Session) handleData(r *bufio.Reader, flags uint16, id, length uint32) error {
+	if length > s.config.MaxStreamWindow {
+		return fmt.Errorf("%w: frame of %d bytes exceeds the stream window", ErrProtocol, length)
+	}
+	var stream *Stream
+	if flags&flagSYN != 0 {
+		var err error
+		if stream, err = s.incoming(id); err != nil {
+			return err
+		}
+	} else {
+		stream = s.stream(id)
+	}
+
+	if stream == nil {
+		// 本端已经关闭的流，丢弃数据并重置，避免对端等待窗口更新
+		if _, err := r.Discard(int(length)); err != nil {
+			return err
+		}
+		if length > 0 && flags&flagRST == 0 {
+			s.reset(id)
+		}
+		return nil
+	}
+	if length > 0 {
+		if err := stream.receive(r, length); err != nil {
+			return err
+		}
+	}
+	switch {
+	case flags&flagRST != 0:
+		stream.abort(ErrStreamReset)
+		s.removeStream(id)
+	case flags&flagFIN != 0:
+		stream.remoteClose()
+	}
+	return nil
+}
+
+// 注册对端打开的流并交给 Accept，积压过多或不再接受新的流时重置
+func (s *Session) incoming(id uint32) (*Stream, error) {
+	s.mu.Lock()
+	if id%2 == s.nextID%2 {
+		s.mu.Unlock()
+		return nil, fmt.Errorf("%w: stream %d opened with wrong parity", ErrProtocol, id)
+	}
+	if _, ok := s.streams[id]; ok {
+		s.mu.Unlock()
+		return nil, fmt.Errorf("%w: duplicate stream %d", ErrProtocol, id)
+	}
+	if s.goAway {
+		s.mu.Unlock()
+		s.reset(id)
+		return nil, nil
+	}
+	stream := newStream(s, id)
+	select {
+	case s.accept <- stream:
+		s.streams[id] = stream
+		s.mu.Unlock()
+		return stream, nil
+	default:
+		s.mu.Unlock()
+		s.reset(id)
+		return nil, nil
+	}
+}
+
+// 在新的 goroutine 中重置流，接收循环不会因为写入阻塞
+func (s *Session) reset(id uint32) {
+	go func() { _ = s.writeFrame(typeData, flagRST, id, 0, nil) }()
+}
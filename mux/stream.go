@@ -0,0 +1,262 @@
+package mux
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// Stream 为会话上的一个双向字节流，实现了 net.Conn
+type Stream struct {
+	id      uint32
+	session *Session
+
+	mu         sync.Mutex // protect following
+	buf        bytes.Buffer
+	recvWindow uint32 // 对端还可以发送的字节数
+	consumed   uint32 // 已读取但尚未通过窗口更新告知对端的字节数
+	sendWindow uint32 // 本端还可以发送的字节数
+	readErr    error  // 缓冲的数据读完之后 Read 返回的错误
+	writeErr   error
+	closed     bool
+	readDL     time.Time
+	writeDL    time.Time
+
+	readable chan struct{} // 收到数据、状态变化或截止时间变化时通知
+	writable chan struct{} // 窗口增加、状态变化或截止时间变化时通知
+}
+
+func newStream(s *Session, id uint32) *Stream {
+	return &Stream{
+		id:         id,
+		session:    s,
+		recvWindow: s.config.MaxStreamWindow,
+		sendWindow: s.config.MaxStreamWindow,
+		readable:   make(chan struct{}, 1),
+		writable:   make(chan struct{}, 1),
+	}
+}
+
+// ID 返回流的 ID
+func (st *Stream) ID() uint32 {
+	return st.id
+}
+
+// Session 返回流所属的会话
+func (st *Stream) Session() *Session {
+	return st.session
+}
+
+func notify(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
+
+// 等待 ch 的通知，deadline 不为零且先到达时返回 os.ErrDeadlineExceeded
+func wait(ch chan struct{}, deadline time.Time) error {
+	if deadline.IsZero() {
+		<-ch
+		return nil
+	}
+	d := time.Until(deadline)
+	if d <= 0 {
+		return os.ErrDeadlineExceeded
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ch:
+		return nil
+	case <-timer.C:
+		return os.ErrDeadlineExceeded
+	}
+}
+
+// Read 读取对端写入的数据，对端关闭写入且数据读完后返回 io.EOF
+func (st *Stream) Read(p []byte) (int, error) {
+	for {
+		st.mu.Lock()
+		if st.closed {
+			st.mu.Unlock()
+			return 0, ErrStreamClosed
+		}
+		if st.buf.Len() > 0 {
+			n, _ := st.buf.Read(p)
+			st.consumed += uint32(n)
+			// 读取了一半窗口的数据后再更新，避免发送过多的窗口更新帧
+			var delta uint32
+			if st.consumed >= st.session.config.MaxStreamWindow/2 {
+				delta, st.consumed = st.consumed, 0
+				st.recvWindow += delta
+			}
+			st.mu.Unlock()
+			if delta > 0 {
+				_ = st.session.writeFrame(typeWindowUpdate, 0, st.id, delta, nil)
+			}
+			return n, nil
+		}
+		if err := st.readErr; err != nil {
+			st.mu.Unlock()
+			return 0, err
+		}
+		deadline := st.readDL
+		st.mu.Unlock()
+
+		if err := wait(st.readable, deadline); err != nil {
+			return 0, err
+		}
+	}
+}
+
+// Write 写入数据，数据按照对端的接收窗口与 Config.MaxFrameSize 拆分为多个帧
+func (st *Stream) Write(p []byte) (int, error) {
+	var total int
+	for len(p) > 0 {
+		st.mu.Lock()
+		if err := st.writeErr; err != nil {
+			st.mu.Unlock()
+			return total, err
+		}
+		if st.sendWindow == 0 {
+			deadline := st.writeDL
+			st.mu.Unlock()
+			if err := wait(st.writable, deadline); err != nil {
+				return total, err
+			}
+			continue
+		}
+		n := len(p)
+		if n > st.session.config.MaxFrameSize {
+			n = st.session.config.MaxFrameSize
+		}
+		if uint32(n) > st.sendWindow {
+			n = int(st.sendWindow)
+		}
+		st.sendWindow -= uint32(n)
+		st.mu.Unlock()
+
+		if err := st.session.writeFrame(typeData, 0, st.id, uint32(n), p[:n]); err != nil {
+			return total, err
+		}
+		total += n
+		p = p[n:]
+	}
+	return total, nil
+}
+
+// Close 关闭流，通知对端不再写入数据，本端随后不能再读写
+func (st *Stream) Close() error {
+	st.mu.Lock()
+	if st.closed {
+		st.mu.Unlock()
+		return nil
+	}
+	st.closed = true
+	aborted := st.writeErr != nil
+	st.setErr(ErrStreamClosed)
+	st.mu.Unlock()
+
+	st.session.removeStream(st.id)
+	if aborted {
+		return nil
+	}
+	return st.session.writeFrame(typeData, flagFIN, st.id, 0, nil)
+}
+
+// 设置尚未设置的读写错误，并唤醒等待的读写，调用方需持有 st.mu
+func (st *Stream) setErr(err error) {
+	if st.readErr == nil {
+		st.readErr = err
+	}
+	if st.writeErr == nil {
+		st.writeErr = err
+	}
+	notify(st.readable)
+	notify(st.writable)
+}
+
+// 流被重置或会话关闭时结束读写
+func (st *Stream) abort(err error) {
+	st.mu.Lock()
+	st.setErr(err)
+	st.mu.Unlock()
+}
+
+// 对端关闭了写入，缓冲的数据读完之后 Read 返回 io.EOF
+func (st *Stream) remoteClose() {
+	st.mu.Lock()
+	if st.readErr == nil {
+		st.readErr = io.EOF
+	}
+	notify(st.readable)
+	st.mu.Unlock()
+}
+
+// 读取对端发送的 length 字节数据，超过接收窗口时返回错误
+func (st *Stream) receive(r *bufio.Reader, length uint32) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if length > st.recvWindow {
+		return fmt.Errorf("%w: stream %d received %d bytes exceeding window %d", ErrProtocol, st.id, length, st.recvWindow)
+	}
+	st.recvWindow -= length
+	if st.readErr != nil {
+		// 本端不再读取，丢弃数据
+		_, err := r.Discard(int(length))
+		return err
+	}
+	if _, err := io.CopyN(&st.buf, r, int64(length)); err != nil {
+		return err
+	}
+	notify(st.readable)
+	return nil
+}
+
+// 对端读取了数据，增加发送窗口
+func (st *Stream) grow(delta uint32) {
+	st.mu.Lock()
+	st.sendWindow += delta
+	notify(st.writable)
+	st.mu.Unlock()
+}
+
+// LocalAddr 返回底层连接的本地地址
+func (st *Stream) LocalAddr() net.Addr {
+	return st.session.conn.LocalAddr()
+}
+
+// RemoteAddr 返回底层连接的对端地址
+func (st *Stream) RemoteAddr() net.Addr {
+	return st.session.conn.RemoteAddr()
+}
+
+// SetDeadline 设置读写的截止时间
+func (st *Stream) SetDeadline(t time.Time) error {
+	_ = st.SetReadDeadline(t)
+	return st.SetWriteDeadline(t)
+}
+
+// SetReadDeadline 设置 Read 的截止时间，零值表示不限制
+func (st *Stream) SetReadDeadline(t time.Time) error {
+	st.mu.Lock()
+	st.readDL = t
+	notify(st.readable)
+	st.mu.Unlock()
+	return nil
+}
+
+// SetWriteDeadline 设置 Write 的截止时间，零值表示不限制
+func (st *Stream) SetWriteDeadline(t time.Time) error {
+	st.mu.Lock()
+	st.writeDL = t
+	notify(st.writable)
+	st.mu.Unlock()
+	return nil
+}
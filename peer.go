@@ -0,0 +1,57 @@
+package geerpc
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+)
+
+// Peer 描述发起请求的客户端
+type Peer struct {
+	Addr net.Addr
+	// Identity 为经过验证的客户端证书的 CommonName，未使用双向 TLS 时为空
+	Identity string
+	// Certificates 为经过验证的客户端证书链，叶子证书在前
+	Certificates []*x509.Certificate
+}
+
+type peerKey struct{}
+
+// NewPeerContext 返回携带 peer 的 context
+func NewPeerContext(ctx context.Context, p *Peer) context.Context {
+	return context.WithValue(ctx, peerKey{}, p)
+}
+
+// PeerFromContext 返回 ctx 中携带的 Peer
+func PeerFromContext(ctx context.Context) (*Peer, bool) {
+	p, ok := ctx.Value(peerKey{}).(*Peer)
+	return p, ok
+}
+
+// 根据连接生成 Peer，TLS 连接会先完成握手以获取经过验证的客户端证书，HTTP/2 连接使用 HTTP 请求的信息，
+// 多路复用会话上的流使用建立会话时生成的 Peer
+func newPeer(conn net.Conn) (*Peer, error) {
+	if hc, ok := conn.(*http2Conn); ok && hc.peer != nil {
+		return hc.peer, nil
+	}
+	if sc, ok := conn.(*sessionConn); ok {
+		return sc.peer, nil
+	}
+	p := &Peer{Addr: conn.RemoteAddr()}
+
+	tlsConn, ok := conn.(*tls.Conn)
+	if !ok {
+		return p, nil
+	}
+	if err := tlsConn.Handshake(); err != nil {
+		return nil, err
+	}
+
+	state := tlsConn.ConnectionState()
+	if len(state.VerifiedChains) > 0 && len(state.VerifiedChains[0]) > 0 {
+		p.Certificates = state.VerifiedChains[0]
+		p.Identity = p.Certificates[0].Subject.CommonName
+	}
+	return p, nil
+}
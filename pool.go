@@ -0,0 +1,59 @@
+package geerpc
+
+import (
+	"sync"
+
+	"geerpc/codec"
+)
+
+// requestFreeListSize 为每个连接保留的可复用 Request 的最大数量
+const requestFreeListSize = 64
+
+// headerPool 复用客户端发送请求使用的 Header，Header 在写入连接后不再被引用
+var headerPool = sync.Pool{
+	New: func() interface{} { return new(codec.Header) },
+}
+
+func getHeader() *codec.Header {
+	return headerPool.Get().(*codec.Header)
+}
+
+func putHeader(h *codec.Header) {
+	*h = codec.Header{}
+	headerPool.Put(h)
+}
+
+// 返回一个 Request，优先复用连接上已经结束的请求。alloc 与 get 都只在读取请求的 goroutine 中调用，
+// 通过 get 取得的刚结束的请求不会同时被复用
+func (set *requestSet) alloc() *Request {
+	set.mu.Lock()
+	n := len(set.free)
+	if n == 0 {
+		set.mu.Unlock()
+		return &Request{H: new(codec.Header)}
+	}
+	req := set.free[n-1]
+	set.free = set.free[:n-1]
+	set.mu.Unlock()
+
+	h := req.H
+	*h = codec.Header{}
+	*req = Request{H: h}
+	return req
+}
+
+// 归还处理结束且不再被引用的 req
+func (set *requestSet) release(req *Request) {
+	set.mu.Lock()
+	defer set.mu.Unlock()
+	if len(set.free) < requestFreeListSize {
+		set.free = append(set.free, req)
+	}
+}
+
+// 设置读写缓冲的大小，cc 未实现 codec.BufferSizeSetter 时忽略
+func setBufferSizes(cc codec.Codec, read, write int) {
+	if s, ok := cc.(codec.BufferSizeSetter); ok {
+		s.SetBufferSizes(read, write)
+	}
+}
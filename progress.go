@@ -0,0 +1,58 @@
+package geerpc
+
+import (
+	"context"
+	"sync"
+
+	"geerpc/codec"
+)
+
+// Progress 为长时间调用的中间进度
+type Progress struct {
+	Percent float64 // 0 到 100
+	Message string
+}
+
+// WithProgress 接收服务端通过 ReportProgress 发送的进度，f 在接收响应的 goroutine 中调用，应尽快返回
+func WithProgress(f func(Progress)) CallOption {
+	return func(call *Call) {
+		call.progress = f
+		call.flags |= codec.FlagProgress
+	}
+}
+
+type progressKey struct{}
+
+// progressReporter 向客户端发送一个请求的进度
+type progressReporter struct {
+	s       *Server
+	cc      codec.Codec
+	seq     uint64
+	sending *sync.Mutex
+}
+
+// ReportProgress 向客户端发送当前调用的进度，客户端未通过 WithProgress 接收进度时返回 false
+func ReportProgress(ctx context.Context, percent float64, message string) bool {
+	r, ok := ctx.Value(progressKey{}).(*progressReporter)
+	if !ok || ctx.Err() != nil {
+		return false
+	}
+	h := &codec.Header{Seq: r.seq, Flags: codec.FlagProgress}
+	_, err := r.s.sendResponse(r.cc, h, &Progress{Percent: percent, Message: message}, r.sending)
+	return err == nil
+}
+
+// 处理服务端发送的进度
+func (t *Transport) receiveProgress(header *codec.Header) {
+	t.mu.Lock()
+	call := t.pending[header.Seq]
+	t.mu.Unlock()
+	if call == nil || call.progress == nil {
+		_ = t.cc.ReadBody(nil)
+		return
+	}
+	var p Progress
+	if err := t.cc.ReadBody(&p); err == nil {
+		call.progress(p)
+	}
+}
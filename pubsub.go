@@ -0,0 +1,128 @@
+package geerpc
+
+import (
+	"context"
+	"io"
+	"reflect"
+	"sync"
+)
+
+// PubSubServiceName 为内置的订阅服务的名称，通过 WithPublisher 启用
+const PubSubServiceName = "_pubsub"
+
+// subscriberBuffer 为每个订阅者缓存的消息数，订阅者消费过慢、缓存已满时丢弃新的消息
+const subscriberBuffer = 64
+
+type subscriber struct {
+	ch chan interface{}
+}
+
+// Publisher 向订阅了主题的连接推送消息，消息与其他调用在同一连接上多路复用，见 Client.Subscribe
+type Publisher struct {
+	mu     sync.Mutex
+	topics map[string]map[*subscriber]struct{}
+}
+
+// NewPublisher 返回没有订阅者的 Publisher
+func NewPublisher() *Publisher {
+	return &Publisher{topics: make(map[string]map[*subscriber]struct{})}
+}
+
+// WithPublisher 启用内置的订阅服务，客户端订阅的主题由 p 推送消息。
+// 订阅为流式调用，同样受 HandleTimeout 与客户端 ctx 的截止时间限制
+func WithPublisher(p *Publisher) ServerOption {
+	return func(s *Server) {
+		s.publisher = p
+	}
+}
+
+// Publish 向订阅了 topic 的所有订阅者推送 msg，返回接收了消息的订阅者数，缓存已满的订阅者不计入
+func (p *Publisher) Publish(topic string, msg interface{}) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	n := 0
+	for sub := range p.topics[topic] {
+		select {
+		case sub.ch <- msg:
+			n++
+		default:
+		}
+	}
+	return n
+}
+
+// Subscribers 返回 topic 的订阅者数
+func (p *Publisher) Subscribers(topic string) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.topics[topic])
+}
+
+func (p *Publisher) add(topic string, sub *subscriber) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.topics[topic] == nil {
+		p.topics[topic] = make(map[*subscriber]struct{})
+	}
+	p.topics[topic][sub] = struct{}{}
+}
+
+func (p *Publisher) remove(topic string, sub *subscriber) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.topics[topic], sub)
+	if len(p.topics[topic]) == 0 {
+		delete(p.topics, topic)
+	}
+}
+
+type pubsubService struct {
+	p *Publisher
+}
+
+// Subscribe 将发布到 topic 的消息推送给客户端，直到客户端取消订阅或连接断开
+func (ps *pubsubService) Subscribe(ctx context.Context, topic string, stream *ServerStream) error {
+	sub := &subscriber{ch: make(chan interface{}, subscriberBuffer)}
+	ps.p.add(topic, sub)
+	defer ps.p.remove(topic, sub)
+	for {
+		select {
+		case msg := <-sub.ch:
+			if err := stream.Send(msg); err != nil {
+				return err
+			}
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// Subscribe 订阅服务端 Publisher 的 topic，返回接收消息的 channel，msg 为消息类型的指针，例如 new(string)，
+// channel 中的值与 msg 类型相同。ctx 结束、连接断开或订阅失败时 channel 被关闭
+func (client *Client) Subscribe(ctx context.Context, topic string, msg interface{}, opts ...CallOption) (<-chan interface{}, error) {
+	cs, err := client.Stream(ctx, PubSubServiceName+".Subscribe", topic, msg, opts...)
+	if err != nil {
+		return nil, err
+	}
+	msgType := reflect.TypeOf(msg).Elem()
+	ch := make(chan interface{}, subscriberBuffer)
+	go func() {
+		defer close(ch)
+		defer func() { _ = cs.Close() }()
+		for {
+			v := reflect.New(msgType).Interface()
+			if err := cs.Recv(v); err != nil {
+				if err != io.EOF {
+					client.logger.Debugf("rpc client: subscription to %s ended: %v", topic, err)
+				}
+				return
+			}
+			select {
+			case ch <- v:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return ch, nil
+}
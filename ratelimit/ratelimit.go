@@ -0,0 +1,163 @@
+// Package ratelimit 提供基于令牌桶的服务端限流拦截器，可以同时限制总请求速率、每个方法以及每个调用方的请求速率，
+// 超过限制的调用立即以 ResourceExhausted 错误返回，而不是在服务端排队
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"geerpc"
+)
+
+// RetryAfterKey 为被限流时响应 metadata 中建议的重试等待时间的 key，值为毫秒数
+const RetryAfterKey = "retry-after-ms"
+
+// Limit 为令牌桶的速率，Rate 为每秒补充的令牌数，Burst 为桶的容量，Rate <= 0 表示不限制
+type Limit struct {
+	Rate  float64
+	Burst int
+}
+
+// 桶的容量至少为 1，否则请求永远无法通过
+func (l Limit) burst() float64 {
+	return math.Max(1, float64(l.Burst))
+}
+
+// Config 为限流的配置，请求须同时满足所有适用的限制
+type Config struct {
+	Global    Limit            // 所有请求
+	PerMethod map[string]Limit // 每个方法，key 为 Service.Method
+	PerPeer   Limit            // 每个调用方
+	// Identity 返回调用方的标识，为 nil 时使用 TLS 客户端证书的 CommonName，没有证书时使用客户端的 IP
+	Identity func(ctx context.Context) string
+	// Now 返回当前时间，为 nil 时使用 time.Now
+	Now func() time.Time
+}
+
+// maxPeers 为保留的调用方令牌桶的数量，超过时清理已经补满的令牌桶
+const maxPeers = 4096
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// 按经过的时间补充令牌，返回当前的令牌数
+func (b *bucket) refill(l Limit, now time.Time) float64 {
+	if b.last.IsZero() {
+		b.tokens = l.burst()
+	} else {
+		b.tokens = math.Min(l.burst(), b.tokens+now.Sub(b.last).Seconds()*l.Rate)
+	}
+	b.last = now
+	return b.tokens
+}
+
+type limiter struct {
+	cfg Config
+
+	mu      sync.Mutex
+	global  bucket
+	methods map[string]*bucket
+	peers   map[string]*bucket
+}
+
+// Interceptor 返回按 cfg 限流的服务端拦截器
+func Interceptor(cfg Config) geerpc.ServerInterceptor {
+	l := &limiter{cfg: cfg, methods: make(map[string]*bucket), peers: make(map[string]*bucket)}
+	return func(ctx context.Context, serviceMethod string, args, reply interface{}, handler geerpc.Handler) error {
+		if wait, ok := l.allow(ctx, serviceMethod); !ok {
+			ms := wait.Milliseconds() + 1
+			geerpc.SetResponseMetadata(ctx, RetryAfterKey, strconv.FormatInt(ms, 10))
+			return geerpc.Errorf(geerpc.ResourceExhausted, "ratelimit: too many requests to %s, retry after %dms", serviceMethod, ms)
+		}
+		return handler(ctx, serviceMethod, args, reply)
+	}
+}
+
+// 检查请求是否满足所有限制，满足时从每个令牌桶中取走一个令牌，否则返回需要等待的时间
+func (l *limiter) allow(ctx context.Context, serviceMethod string) (time.Duration, bool) {
+	now := time.Now()
+	if l.cfg.Now != nil {
+		now = l.cfg.Now()
+	}
+
+	type check struct {
+		b *bucket
+		l Limit
+	}
+	var checks []check
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.cfg.Global.Rate > 0 {
+		checks = append(checks, check{&l.global, l.cfg.Global})
+	}
+	if limit := l.cfg.PerMethod[serviceMethod]; limit.Rate > 0 {
+		checks = append(checks, check{l.bucket(l.methods, serviceMethod), limit})
+	}
+	if l.cfg.PerPeer.Rate > 0 {
+		if len(l.peers) >= maxPeers {
+			l.sweep(now)
+		}
+		checks = append(checks, check{l.bucket(l.peers, l.identity(ctx)), l.cfg.PerPeer})
+	}
+
+	var wait time.Duration
+	for _, c := range checks {
+		if tokens := c.b.refill(c.l, now); tokens < 1 {
+			if w := time.Duration((1 - tokens) / c.l.Rate * float64(time.Second)); w > wait {
+				wait = w
+			}
+		}
+	}
+	if wait > 0 {
+		return wait, false
+	}
+	for _, c := range checks {
+		c.b.tokens--
+	}
+	return 0, true
+}
+
+func (l *limiter) bucket(m map[string]*bucket, key string) *bucket {
+	b, ok := m[key]
+	if !ok {
+		b = &bucket{}
+		m[key] = b
+	}
+	return b
+}
+
+// 删除已经补满的调用方令牌桶，它们与新建的令牌桶等价
+func (l *limiter) sweep(now time.Time) {
+	for key, b := range l.peers {
+		if b.refill(l.cfg.PerPeer, now) >= l.cfg.PerPeer.burst() {
+			delete(l.peers, key)
+		}
+	}
+}
+
+func (l *limiter) identity(ctx context.Context) string {
+	if l.cfg.Identity != nil {
+		return l.cfg.Identity(ctx)
+	}
+	peer, ok := geerpc.PeerFromContext(ctx)
+	if !ok {
+		return ""
+	}
+	if peer.Identity != "" {
+		return peer.Identity
+	}
+	if peer.Addr == nil {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(peer.Addr.String())
+	if err != nil {
+		return peer.Addr.String()
+	}
+	return host
+}
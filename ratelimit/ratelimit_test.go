@@ -0,0 +1,104 @@
+package ratelimit
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"geerpc"
+)
+
+func peerContext(ip string) context.Context {
+	return geerpc.NewPeerContext(context.Background(), &geerpc.Peer{Addr: &net.TCPAddr{IP: net.ParseIP(ip), Port: 1234}})
+}
+
+func TestLimiter(t *testing.T) {
+	now := time.Unix(0, 0)
+	l := &limiter{cfg: Config{
+		Global:    Limit{Rate: 10, Burst: 4},
+		PerMethod: map[string]Limit{"Foo.Slow": {Rate: 1, Burst: 1}},
+		PerPeer:   Limit{Rate: 2, Burst: 2},
+		Now:       func() time.Time { return now },
+	}, methods: map[string]*bucket{}, peers: map[string]*bucket{}}
+	a, b := peerContext("10.0.0.1"), peerContext("10.0.0.2")
+
+	for i, c := range []struct {
+		ctx    context.Context
+		method string
+		ok     bool
+	}{
+		{a, "Foo.Slow", true},
+		{a, "Foo.Slow", false}, // 方法的令牌已用完
+		{a, "Foo.Fast", true},
+		{a, "Foo.Fast", false}, // 调用方的令牌已用完
+		{b, "Foo.Fast", true},
+		{b, "Foo.Fast", true},
+		{b, "Foo.Fast", false}, // 全局的令牌已用完
+	} {
+		if _, ok := l.allow(c.ctx, c.method); ok != c.ok {
+			t.Fatalf("case %d: expect %v", i, c.ok)
+		}
+	}
+
+	// 拒绝的请求不消耗令牌，等待时间取决于最慢的令牌桶
+	wait, _ := l.allow(a, "Foo.Slow")
+	if wait != time.Second {
+		t.Fatalf("expect to wait 1s, got %v", wait)
+	}
+	now = now.Add(time.Second)
+	if _, ok := l.allow(a, "Foo.Slow"); !ok {
+		t.Fatal("expect tokens to be refilled")
+	}
+}
+
+type idKey struct{}
+
+func TestLimiter_Sweep(t *testing.T) {
+	now := time.Unix(0, 0)
+	l := &limiter{cfg: Config{
+		PerPeer:  Limit{Rate: 1},
+		Identity: func(ctx context.Context) string { return ctx.Value(idKey{}).(string) },
+		Now:      func() time.Time { return now },
+	}, methods: map[string]*bucket{}, peers: map[string]*bucket{}}
+	for i := 0; i < maxPeers; i++ {
+		l.allow(context.WithValue(context.Background(), idKey{}, strings.Repeat("x", i+1)), "Foo.Sum")
+	}
+	now = now.Add(time.Second)
+	l.allow(context.WithValue(context.Background(), idKey{}, "new"), "Foo.Sum")
+	if len(l.peers) != 1 {
+		t.Fatalf("expect refilled buckets to be removed, got %d", len(l.peers))
+	}
+}
+
+type Foo int
+
+func (f Foo) Sum(args [2]int, reply *int) error {
+	*reply = args[0] + args[1]
+	return nil
+}
+
+func TestInterceptor(t *testing.T) {
+	server := geerpc.NewServer(geerpc.WithInterceptors(Interceptor(Config{Global: Limit{Rate: 0.001, Burst: 1}})))
+	_ = server.Register(new(Foo))
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	defer func() { _ = l.Close() }()
+	go server.Accept(l)
+
+	client, err := geerpc.Dial("tcp", l.Addr().String(), geerpc.DefaultOption)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = client.Close() }()
+
+	var reply int
+	if err = client.Call(context.Background(), "Foo.Sum", [2]int{1, 2}, &reply); err != nil || reply != 3 {
+		t.Fatalf("expect first call to pass, got %v", err)
+	}
+	var md geerpc.Metadata
+	err = client.Call(context.Background(), "Foo.Sum", [2]int{1, 2}, &reply, geerpc.WithResponseMetadata(&md))
+	if geerpc.CodeOf(err) != geerpc.ResourceExhausted || md[RetryAfterKey] == "" {
+		t.Fatalf("expect ResourceExhausted with retry-after, got %v, %v", err, md)
+	}
+}
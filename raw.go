@@ -0,0 +1,119 @@
+package geerpc
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+
+	"geerpc/codec"
+)
+
+var bytesType = reflect.TypeOf([]byte(nil))
+
+// WithRawBody 使调用的 args 与 reply 分别为 []byte 与 *[]byte，原样作为请求与响应的 body 发送，不经过 codec 的序列化。
+// 服务端的方法需为 func(args []byte, reply *[]byte) error 的形式，用于已经持有序列化后的数据（例如 protobuf 或 flatbuffers）的调用方
+func WithRawBody() CallOption {
+	return func(call *Call) {
+		call.flags |= codec.FlagRaw
+	}
+}
+
+// RawCall 以原始字节调用 serviceMethod，args 原样发送，返回服务端方法写入 reply 的字节，见 WithRawBody
+func (client *Client) RawCall(ctx context.Context, serviceMethod string, args []byte, opts ...CallOption) ([]byte, error) {
+	var reply []byte
+	opts = append(opts[:len(opts):len(opts)], WithRawBody())
+	if err := client.Call(ctx, serviceMethod, args, &reply, opts...); err != nil {
+		return nil, err
+	}
+	return reply, nil
+}
+
+// 返回方法能否以原始字节调用
+func (m *methodType) acceptsRaw() bool {
+	return !m.stream && m.ArgType == bytesType && m.ReplyType == reflect.PtrTo(bytesType)
+}
+
+// 写入 call 的请求，原始字节调用直接写入 args
+func writeCall(cc codec.Codec, h *codec.Header, call *Call) error {
+	if call.flags&codec.FlagRaw == 0 {
+		return cc.Write(h, call.Args)
+	}
+	rc, ok := cc.(codec.RawCodec)
+	if !ok {
+		return Errorf(Unimplemented, "rpc client: codec does not support raw bodies")
+	}
+	args, ok := call.Args.([]byte)
+	if !ok {
+		return Errorf(InvalidArgument, "rpc client: raw call expects []byte args, got %T", call.Args)
+	}
+	if _, ok := call.Reply.(*[]byte); !ok {
+		return Errorf(InvalidArgument, "rpc client: raw call expects *[]byte reply, got %T", call.Reply)
+	}
+	return rc.WriteRaw(h, args)
+}
+
+// 读取 call 的响应 body，原始字节调用将 body 原样写入 reply
+func readReply(cc codec.Codec, call *Call) error {
+	if call.flags&codec.FlagRaw == 0 {
+		return cc.ReadBody(call.Reply)
+	}
+	rc, ok := cc.(codec.RawCodec)
+	if !ok {
+		_ = cc.ReadBody(nil)
+		return Errorf(Unimplemented, "rpc client: codec does not support raw bodies")
+	}
+	data, err := rc.ReadRawBody()
+	if err != nil {
+		return err
+	}
+	setRawReply(call, data)
+	return nil
+}
+
+// 将原始字节的响应写入 call.Reply，writeCall 已检查其类型
+func setRawReply(call *Call, data []byte) {
+	if reply, ok := call.Reply.(*[]byte); ok {
+		*reply = data
+	}
+}
+
+// 以原始字节读取 req 的参数
+func readRawArgs(cc codec.Codec, req *Request) error {
+	rc, ok := cc.(codec.RawCodec)
+	if !ok || !req.mtype.acceptsRaw() {
+		if err := cc.ReadBody(nil); err != nil {
+			return err
+		}
+		return Errorf(InvalidArgument, "rpc server: %s does not accept raw bytes", req.H.ServiceMethod)
+	}
+	data, err := rc.ReadRawBody()
+	if err != nil {
+		return readArgvError(err)
+	}
+	req.Arg.SetBytes(data)
+	return nil
+}
+
+// 使用 contentType 对应的 BodyCodec 序列化 v，contentType 为空时使用连接的 codec
+func marshalBody(rc codec.RawCodec, contentType codec.Type, v interface{}) ([]byte, error) {
+	if contentType == "" {
+		return rc.Marshal(v)
+	}
+	bc := codec.GetBodyCodec(contentType)
+	if bc == nil {
+		return nil, fmt.Errorf("%w: %q", codec.ErrUnsupportedContentType, contentType)
+	}
+	return bc.Marshal(v)
+}
+
+// 使用 contentType 对应的 BodyCodec 反序列化 data，contentType 为空时使用连接的 codec
+func unmarshalBody(rc codec.RawCodec, contentType codec.Type, data []byte, v interface{}) error {
+	if contentType == "" {
+		return rc.Unmarshal(data, v)
+	}
+	bc := codec.GetBodyCodec(contentType)
+	if bc == nil {
+		return fmt.Errorf("%w: %q", codec.ErrUnsupportedContentType, contentType)
+	}
+	return bc.Unmarshal(data, v)
+}
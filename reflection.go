@@ -0,0 +1,106 @@
+package geerpc
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// ReflectionServiceName 为内置的反射服务的名称，每个 Server 都会注册该服务，
+// 供通用工具（例如 geerpc-cli）在运行时查询服务端提供的服务与方法
+const ReflectionServiceName = "_reflection"
+
+// MethodDesc 描述一个方法的参数与返回值
+type MethodDesc struct {
+	Name       string // 形如 Service.Method
+	HasContext bool   // 第一个参数是否为 context.Context
+	Stream     bool   // 是否为服务端流式方法，此时 Reply 为 nil
+	Args       *TypeSchema
+	Reply      *TypeSchema
+}
+
+// TypeSchema 为通过反射得到的类型结构
+type TypeSchema struct {
+	Name   string         // Go 的类型名，例如 geerpc.Args、[]int
+	Kind   string         // reflect.Kind 的名称，例如 struct、slice、int
+	Fields []*FieldSchema // Kind 为 struct 时的导出字段，递归引用的结构体不再展开
+	Key    *TypeSchema    // Kind 为 map 时 key 的类型
+	Elem   *TypeSchema    // Kind 为 ptr、slice、array、map 时元素的类型
+}
+
+// FieldSchema 为结构体的字段
+type FieldSchema struct {
+	Name string
+	Type *TypeSchema
+}
+
+type reflectionService struct {
+	s *Server
+}
+
+// ListServices 返回名称以 prefix 开头的服务，prefix 为空时返回所有服务
+func (r *reflectionService) ListServices(prefix string, reply *[]string) error {
+	r.s.serviceMap.Range(func(key, _ interface{}) bool {
+		if name := key.(string); strings.HasPrefix(name, prefix) {
+			*reply = append(*reply, name)
+		}
+		return true
+	})
+	sort.Strings(*reply)
+	return nil
+}
+
+// ListMethods 返回服务 name 的所有方法
+func (r *reflectionService) ListMethods(name string, reply *[]string) error {
+	svci, ok := r.s.serviceMap.Load(name)
+	if !ok {
+		return Errorf(NotFound, "rpc: service not found: %s", name)
+	}
+	for method := range svci.(*service).method {
+		*reply = append(*reply, method)
+	}
+	sort.Strings(*reply)
+	return nil
+}
+
+// DescribeMethod 返回方法 serviceMethod 的参数与返回值的类型结构
+func (r *reflectionService) DescribeMethod(serviceMethod string, reply *MethodDesc) error {
+	_, mtype, err := r.s.findService(serviceMethod)
+	if err != nil {
+		return err
+	}
+	*reply = MethodDesc{
+		Name:       serviceMethod,
+		HasContext: mtype.hasCtx,
+		Stream:     mtype.stream,
+		Args:       newTypeSchema(mtype.ArgType, map[reflect.Type]bool{}),
+	}
+	if !mtype.stream {
+		reply.Reply = newTypeSchema(mtype.ReplyType.Elem(), map[reflect.Type]bool{})
+	}
+	return nil
+}
+
+// 生成 t 的类型结构，seen 记录正在展开的结构体以避免无限递归
+func newTypeSchema(t reflect.Type, seen map[reflect.Type]bool) *TypeSchema {
+	schema := &TypeSchema{Name: t.String(), Kind: t.Kind().String()}
+	switch t.Kind() {
+	case reflect.Ptr, reflect.Slice, reflect.Array:
+		schema.Elem = newTypeSchema(t.Elem(), seen)
+	case reflect.Map:
+		schema.Key = newTypeSchema(t.Key(), seen)
+		schema.Elem = newTypeSchema(t.Elem(), seen)
+	case reflect.Struct:
+		if seen[t] {
+			return schema
+		}
+		seen[t] = true
+		defer delete(seen, t)
+		for i := 0; i < t.NumField(); i++ {
+			if f := t.Field(i); f.IsExported() {
+				schema.Fields = append(schema.Fields, &FieldSchema{Name: f.Name, Type: newTypeSchema(f.Type, seen)})
+			}
+		}
+	}
+	return schema
+}
@@ -0,0 +1,52 @@
+package geerpc
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+type Node struct {
+	Val      int
+	Next     *Node
+	Children map[string][]Node
+	hidden   int
+}
+
+func TestNewTypeSchema(t *testing.T) {
+	schema := newTypeSchema(reflect.TypeOf(Node{}), map[reflect.Type]bool{})
+	_assert(schema.Name == "geerpc.Node" && schema.Kind == "struct" && len(schema.Fields) == 3,
+		"unexpected schema: %+v", schema)
+	next := schema.Fields[1].Type
+	_assert(next.Kind == "ptr" && next.Elem.Name == "geerpc.Node" && next.Elem.Fields == nil,
+		"expect recursive struct not to be expanded, got %+v", next.Elem)
+	children := schema.Fields[2].Type
+	_assert(children.Kind == "map" && children.Key.Kind == "string" && children.Elem.Elem.Name == "geerpc.Node",
+		"unexpected map schema: %+v", children)
+}
+
+func TestServer_Reflection(t *testing.T) {
+	server := NewServer()
+	_ = server.Register(new(Foo))
+	_ = server.Register(Echo{})
+	client := pipeClient(t, server, DefaultOption)
+	defer func() { _ = client.Close() }()
+	ctx := context.Background()
+
+	var services []string
+	err := client.Call(ctx, ReflectionServiceName+".ListServices", "", &services)
+	_assert(err == nil && reflect.DeepEqual(services, []string{"Echo", "Foo", HealthServiceName, ReflectionServiceName}),
+		"unexpected services: %v, err: %v", services, err)
+
+	var methods []string
+	err = client.Call(ctx, ReflectionServiceName+".ListMethods", "Foo", &methods)
+	_assert(err == nil && reflect.DeepEqual(methods, []string{"Deadline", "Panic", "Sum"}),
+		"unexpected methods: %v, err: %v", methods, err)
+	err = client.Call(ctx, ReflectionServiceName+".ListMethods", "Bar", &methods)
+	_assert(CodeOf(err) == NotFound, "expect NotFound, got %v", err)
+
+	var desc MethodDesc
+	err = client.Call(ctx, ReflectionServiceName+".DescribeMethod", "Foo.Deadline", &desc)
+	_assert(err == nil && desc.HasContext && desc.Args.Name == "geerpc.Args" && len(desc.Args.Fields) == 2 &&
+		desc.Reply.Kind == "bool", "unexpected method desc: %+v, err: %v", desc, err)
+}
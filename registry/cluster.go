@@ -0,0 +1,89 @@
+package registry
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// 标记请求由其他注册中心复制而来，收到后不再继续复制，避免在集群中循环转发
+const replicatedHeader = "X-Geerpc-Replicated"
+
+// SetPeers 设置集群中其他注册中心的地址。收到的注册、心跳与注销请求会复制到所有 peer，
+// 设置时会从 peer 拉取已有的服务实例，使新加入集群的注册中心无需等待下一次心跳
+func (r *GeeRegistry) SetPeers(peers ...string) {
+	r.mu.Lock()
+	r.peers = peers
+	r.mu.Unlock()
+
+	for _, peer := range peers {
+		resp, err := http.Get(peer)
+		if err != nil {
+			logger.Errorf("rpc registry: sync from peer %s err: %v", peer, err)
+			continue
+		}
+		var servers []ServerItem
+		err = json.NewDecoder(resp.Body).Decode(&servers)
+		_ = resp.Body.Close()
+		if err != nil {
+			logger.Errorf("rpc registry: sync from peer %s err: %v", peer, err)
+			continue
+		}
+		for _, server := range servers {
+			r.putServer(server)
+		}
+	}
+}
+
+// 将请求异步复制到所有 peer
+func (r *GeeRegistry) replicate(method string, body []byte, addr string) {
+	r.mu.Lock()
+	peers := r.peers
+	r.mu.Unlock()
+
+	for _, peer := range peers {
+		go func(peer string) {
+			req, _ := http.NewRequest(method, peer, bytes.NewReader(body))
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("X-Geerpc-Server", addr)
+			req.Header.Set(replicatedHeader, "1")
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				logger.Errorf("rpc registry: replicate to peer %s err: %v", peer, err)
+				return
+			}
+			_ = resp.Body.Close()
+		}(peer)
+	}
+}
+
+// 向注册中心发送请求。registry 可以是逗号分隔的多个地址，依次尝试直到某一个成功，
+// 注册中心之间会互相复制，因此只需要一个注册中心收到即可。out 不为 nil 时将响应的 JSON body 解码到 out，
+// 响应没有 body 时 out 保持不变
+func sendToRegistry(registry, method string, body []byte, addr string, out interface{}) error {
+	var err error
+	for _, url := range strings.Split(registry, ",") {
+		req, _ := http.NewRequest(method, strings.TrimSpace(url), bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Geerpc-Server", addr)
+		var resp *http.Response
+		if resp, err = http.DefaultClient.Do(req); err != nil {
+			continue
+		}
+		if resp.StatusCode == http.StatusOK {
+			if out != nil {
+				if err = json.NewDecoder(resp.Body).Decode(out); err == io.EOF {
+					err = nil
+				}
+			}
+			_ = resp.Body.Close()
+			return err
+		}
+		_ = resp.Body.Close()
+		err = fmt.Errorf("rpc registry: %s %s: unexpected status %s", method, url, resp.Status)
+	}
+	return err
+}
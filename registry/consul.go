@@ -0,0 +1,77 @@
+package registry
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ConsulService 描述注册到 Consul 的服务实例
+type ConsulService struct {
+	ID            string // 实例 ID，为空时使用 Name-Address-Port
+	Name          string // 服务名
+	Tags          []string
+	Address       string
+	Port          int
+	Weight        int           // 通过健康检查时的权重，0 表示使用 Consul 的默认值
+	CheckInterval time.Duration // TCP 健康检查的间隔，0 表示不注册健康检查
+}
+
+func (s *ConsulService) id() string {
+	if s.ID != "" {
+		return s.ID
+	}
+	return s.Name + "-" + s.Address + "-" + strconv.Itoa(s.Port)
+}
+
+// RegisterConsul 通过 Consul agent 注册服务实例，consulAddr 例如 http://127.0.0.1:8500
+func RegisterConsul(consulAddr string, service ConsulService) error {
+	reg := map[string]interface{}{
+		"ID":      service.id(),
+		"Name":    service.Name,
+		"Tags":    service.Tags,
+		"Address": service.Address,
+		"Port":    service.Port,
+	}
+	if service.Weight > 0 {
+		reg["Weights"] = map[string]int{"Passing": service.Weight, "Warning": 1}
+	}
+	if service.CheckInterval > 0 {
+		reg["Check"] = map[string]string{
+			"TCP":                            net.JoinHostPort(service.Address, strconv.Itoa(service.Port)),
+			"Interval":                       service.CheckInterval.String(),
+			"DeregisterCriticalServiceAfter": (service.CheckInterval * 10).String(),
+		}
+	}
+	body, err := json.Marshal(reg)
+	if err != nil {
+		return err
+	}
+	return consulPut(consulAddr, "/v1/agent/service/register", body)
+}
+
+// DeregisterConsul 从 Consul agent 注销服务实例，id 与注册时的实例 ID 一致
+func DeregisterConsul(consulAddr, id string) error {
+	return consulPut(consulAddr, "/v1/agent/service/deregister/"+id, nil)
+}
+
+func consulPut(consulAddr, path string, body []byte) error {
+	req, err := http.NewRequest(http.MethodPut, strings.TrimSuffix(consulAddr, "/")+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	_ = res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("rpc registry: consul %s: unexpected status %s", path, res.Status)
+	}
+	return nil
+}
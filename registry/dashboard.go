@@ -0,0 +1,189 @@
+package registry
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+	"sort"
+	"time"
+)
+
+const (
+	dashboardPath = "/dashboard"
+	maxHistory    = 100
+)
+
+// EventType 为服务实例变化的类型
+type EventType string
+
+const (
+	// EventJoin 表示实例注册（第一次收到心跳）
+	EventJoin EventType = "join"
+	// EventLeave 表示实例主动注销，见 Deregister
+	EventLeave EventType = "leave"
+	// EventExpire 表示实例的租约到期（没有及时发送心跳）而被删除
+	EventExpire EventType = "expire"
+	// EventEvict 表示实例被手动剔除，见 Evict
+	EventEvict EventType = "evict"
+	// EventUnhealthy 表示实例探活失败达到阈值，见 StartHealthCheck
+	EventUnhealthy EventType = "unhealthy"
+	// EventRecovered 表示实例探活恢复
+	EventRecovered EventType = "recovered"
+)
+
+// Event 为一次服务实例的变化
+type Event struct {
+	Time    time.Time
+	Type    EventType
+	Service string
+	Addr    string
+}
+
+// 记录一次服务实例的变化，只保留最近的 maxHistory 条，调用方需持有 r.mu
+func (r *GeeRegistry) record(typ EventType, key serverKey) {
+	if len(r.history) >= maxHistory {
+		r.history = append(r.history[:0], r.history[1:]...)
+	}
+	r.history = append(r.history, Event{Time: time.Now(), Type: typ, Service: key.service, Addr: key.addr})
+}
+
+// History 返回最近的服务实例变化，按时间从早到晚排列
+func (r *GeeRegistry) History() []Event {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Event(nil), r.history...)
+}
+
+// Evict 手动剔除 addr 注册的 service 服务的实例并复制到集群中的其他注册中心，service 为空时剔除 addr 注册的所有服务，
+// 返回实例是否存在。实例仍在发送心跳时会在下一次心跳重新注册
+func (r *GeeRegistry) Evict(service, addr string) bool {
+	r.mu.Lock()
+	ok := r.removeServers(service, addr, EventEvict)
+	r.mu.Unlock()
+	if ok {
+		body, _ := json.Marshal(ServerItem{Service: service, Addr: addr})
+		r.replicate("DELETE", body, addr)
+	}
+	return ok
+}
+
+const dashboardText = `<html>
+	<body>
+	<title>GeeRPC Registry</title>
+	<hr>
+	Servers ({{len .Servers}})
+	<hr>
+		<table>
+		<th align=center>Service</th><th align=center>Addr</th><th align=center>Status</th><th align=center>Last Heartbeat</th><th align=center>Lease</th>
+		<th align=center>Weight</th><th align=center>Zone</th><th align=center>Version</th>
+		<th align=center>Codecs</th><th align=center>Metadata</th><th></th>
+		{{range .Servers}}
+			<tr>
+			<td align=left>{{.Service}}</td>
+			<td align=left font=fixed>{{.Addr}}</td>
+			<td align=center>{{.Status}}</td>
+			<td align=center>{{.LastHeartbeat.Format "2006-01-02 15:04:05"}} ({{.Age}} ago)</td>
+			<td align=center>{{.Lease}}</td>
+			<td align=center>{{.Weight}}</td>
+			<td align=center>{{.Zone}}</td>
+			<td align=center>{{.Version}}</td>
+			<td align=center>{{range .Codecs}}{{.}} {{end}}</td>
+			<td align=left>{{range $k, $v := .Metadata}}{{$k}}={{$v}} {{end}}</td>
+			<td><form method="POST"><input type="hidden" name="service" value="{{.Service}}"><input type="hidden" name="addr" value="{{.Addr}}"><input type="submit" value="Evict"></form></td>
+			</tr>
+		{{end}}
+		</table>
+	<hr>
+	History
+	<hr>
+		<table>
+		<th align=center>Time</th><th align=center>Event</th><th align=center>Service</th><th align=center>Addr</th>
+		{{range .History}}
+			<tr>
+			<td align=center>{{.Time.Format "2006-01-02 15:04:05"}}</td>
+			<td align=center>{{.Type}}</td>
+			<td align=left>{{.Service}}</td>
+			<td align=left font=fixed>{{.Addr}}</td>
+			</tr>
+		{{end}}
+		</table>
+	</body>
+	</html>`
+
+var dashboard = template.Must(template.New("RPC registry dashboard").Parse(dashboardText))
+
+// dashboardHTTP 以 HTML 页面展示注册的服务实例与最近的变化，POST 表单的 service 与 addr 字段时剔除该实例
+type dashboardHTTP struct {
+	r *GeeRegistry
+}
+
+type dashboardServer struct {
+	ServerItem
+	Status        string
+	LastHeartbeat time.Time
+	Lease         time.Duration
+	Age           time.Duration
+}
+
+type dashboardData struct {
+	Servers []dashboardServer
+	History []Event // 从晚到早排列
+}
+
+func (d *dashboardHTTP) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case "GET":
+	case "POST":
+		addr := req.FormValue("addr")
+		if addr == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		service := req.FormValue("service")
+		if !d.r.Evict(service, addr) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		logger.Infof("rpc registry: %s %s evicted from dashboard", service, addr)
+		http.Redirect(w, req, req.URL.Path, http.StatusSeeOther)
+		return
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	// 先删除超时的实例，使页面与客户端看到的一致
+	d.r.snapshot()
+	var data dashboardData
+	now := time.Now()
+	d.r.mu.Lock()
+	for _, server := range d.r.servers {
+		status := "healthy"
+		if server.unhealthy {
+			status = "unhealthy"
+		}
+		data.Servers = append(data.Servers, dashboardServer{
+			ServerItem:    *server,
+			Status:        status,
+			LastHeartbeat: server.start,
+			Lease:         server.lease,
+			Age:           now.Sub(server.start).Truncate(time.Second),
+		})
+	}
+	for i := len(d.r.history) - 1; i >= 0; i-- {
+		data.History = append(data.History, d.r.history[i])
+	}
+	d.r.mu.Unlock()
+	sort.Slice(data.Servers, func(i, j int) bool {
+		if data.Servers[i].Addr != data.Servers[j].Addr {
+			return data.Servers[i].Addr < data.Servers[j].Addr
+		}
+		return data.Servers[i].Service < data.Servers[j].Service
+	})
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := dashboard.Execute(w, data); err != nil {
+		_, _ = fmt.Fprintln(w, "rpc registry: error executing template:", err.Error())
+	}
+}
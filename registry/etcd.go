@@ -0,0 +1,142 @@
+package registry
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// EtcdRegistration 表示一次通过租约注册到 etcd 的服务实例
+type EtcdRegistration struct {
+	endpoint string
+	key      string
+	addr     string
+	ttl      time.Duration
+
+	mu      sync.Mutex // protect following
+	leaseID string
+	closed  bool
+	done    chan struct{}
+}
+
+// RegisterEtcd 以租约的方式将 addr 写入 etcd 的 prefix+addr，并在后台自动续约。
+// endpoint 为 etcd 的 HTTP 地址，例如 http://127.0.0.1:2379，ttl 为租约时长
+func RegisterEtcd(endpoint, prefix, addr string, ttl time.Duration) (*EtcdRegistration, error) {
+	if ttl < time.Second {
+		ttl = time.Second
+	}
+	r := &EtcdRegistration{
+		endpoint: strings.TrimSuffix(endpoint, "/"),
+		key:      prefix + addr,
+		addr:     addr,
+		ttl:      ttl,
+		done:     make(chan struct{}),
+	}
+	if err := r.register(); err != nil {
+		return nil, err
+	}
+	go r.keepalive()
+	return r, nil
+}
+
+// 申请租约并写入 key
+func (r *EtcdRegistration) register() error {
+	var lease struct {
+		ID    string `json:"ID"`
+		Error string `json:"error"`
+	}
+	err := etcdPost(r.endpoint, "/v3/lease/grant", map[string]interface{}{"TTL": int64(r.ttl / time.Second)}, &lease)
+	if err != nil {
+		return err
+	}
+	if lease.ID == "" {
+		return fmt.Errorf("rpc registry: etcd lease grant failed: %s", lease.Error)
+	}
+
+	put := map[string]interface{}{
+		"key":   base64.StdEncoding.EncodeToString([]byte(r.key)),
+		"value": base64.StdEncoding.EncodeToString([]byte(r.addr)),
+		"lease": lease.ID,
+	}
+	if err := etcdPost(r.endpoint, "/v3/kv/put", put, nil); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	r.leaseID = lease.ID
+	r.mu.Unlock()
+	return nil
+}
+
+// 每隔 ttl/3 续约一次，租约失效（例如 etcd 重启）时重新注册
+func (r *EtcdRegistration) keepalive() {
+	t := time.NewTicker(r.ttl / 3)
+	defer t.Stop()
+	for {
+		select {
+		case <-r.done:
+			return
+		case <-t.C:
+		}
+
+		r.mu.Lock()
+		id := r.leaseID
+		r.mu.Unlock()
+
+		var resp struct {
+			Result struct {
+				TTL string `json:"TTL"`
+			} `json:"result"`
+		}
+		err := etcdPost(r.endpoint, "/v3/lease/keepalive", map[string]interface{}{"ID": id}, &resp)
+		if err == nil && resp.Result.TTL != "" && resp.Result.TTL != "0" {
+			continue
+		}
+		if err == nil {
+			err = r.register()
+		}
+		if err != nil {
+			logger.Errorf("rpc registry: etcd keepalive err: %v", err)
+		}
+	}
+}
+
+// Close 停止续约并撤销租约，key 随租约一起被删除
+func (r *EtcdRegistration) Close() error {
+	r.mu.Lock()
+	if r.closed {
+		r.mu.Unlock()
+		return nil
+	}
+	r.closed = true
+	id := r.leaseID
+	r.mu.Unlock()
+
+	close(r.done)
+	return etcdPost(r.endpoint, "/v3/lease/revoke", map[string]interface{}{"ID": id}, nil)
+}
+
+// 调用 etcd 的 gRPC gateway 接口
+func etcdPost(endpoint, path string, req, resp interface{}) error {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	res, err := http.Post(endpoint+path, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer func() { _ = res.Body.Close() }()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("rpc registry: etcd %s: unexpected status %s", path, res.Status)
+	}
+	if resp == nil {
+		return nil
+	}
+	return json.NewDecoder(res.Body).Decode(resp)
+}
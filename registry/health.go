@@ -0,0 +1,140 @@
+package registry
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"geerpc"
+)
+
+// Prober 探测 addr 对应的服务实例是否可用，addr 的格式与注册时相同，例如 tcp@127.0.0.1:9999
+type Prober func(addr string) error
+
+// TCPProber 返回一个通过建立连接探活的 Prober，http@ 前缀的地址使用 tcp 连接
+func TCPProber(timeout time.Duration) Prober {
+	return func(addr string) error {
+		network, address := "tcp", addr
+		if i := strings.Index(addr, "@"); i >= 0 {
+			network, address = addr[:i], addr[i+1:]
+		}
+		if network == "http" {
+			network = "tcp"
+		}
+		conn, err := net.DialTimeout(network, address, timeout)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	}
+}
+
+// RPCProber 返回一个通过服务端内置的 Health.Check 探活的 Prober，
+// 服务端正在关闭或过载（状态不为 geerpc.Serving）时同样视为失败
+func RPCProber(timeout time.Duration) Prober {
+	return func(addr string) error {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		client, err := geerpc.XDialContext(ctx, addr, geerpc.DefaultOption)
+		if err != nil {
+			return err
+		}
+		defer func() { _ = client.Close() }()
+		status, err := client.HealthCheck(ctx, "")
+		if err != nil {
+			return err
+		}
+		if status.Status != geerpc.Serving {
+			return fmt.Errorf("rpc registry: %s is %s", addr, status.Status)
+		}
+		return nil
+	}
+}
+
+// HealthCheck 为注册中心的主动探活配置
+type HealthCheck struct {
+	Interval  time.Duration // 探活间隔
+	Threshold int           // 连续失败多少次后不再返回该实例，小于等于 0 时为 1
+	Probe     Prober        // 为 nil 时使用超时时间为 Interval 的 TCPProber
+}
+
+// StartHealthCheck 定期探测所有注册的服务实例，连续失败达到阈值的实例不再返回给客户端，
+// 探测成功后恢复。返回的函数用于停止探活
+func (r *GeeRegistry) StartHealthCheck(hc HealthCheck) (stop func()) {
+	if hc.Threshold <= 0 {
+		hc.Threshold = 1
+	}
+	if hc.Probe == nil {
+		hc.Probe = TCPProber(hc.Interval)
+	}
+
+	done := make(chan struct{})
+	var once sync.Once
+	go func() {
+		t := time.NewTicker(hc.Interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-t.C:
+				r.probeAll(hc)
+			}
+		}
+	}()
+	return func() { once.Do(func() { close(done) }) }
+}
+
+// 并发探测所有服务实例并更新探活状态
+func (r *GeeRegistry) probeAll(hc HealthCheck) {
+	r.mu.Lock()
+	// 同一地址注册的多个服务只探测一次
+	seen := make(map[string]bool)
+	addrs := make([]string, 0, len(r.servers))
+	for key := range r.servers {
+		if !seen[key.addr] {
+			seen[key.addr] = true
+			addrs = append(addrs, key.addr)
+		}
+	}
+	r.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for _, addr := range addrs {
+		wg.Add(1)
+		go func(addr string) {
+			defer wg.Done()
+			r.updateHealth(addr, hc.Probe(addr) == nil, hc.Threshold)
+		}(addr)
+	}
+	wg.Wait()
+}
+
+// 根据 addr 的探测结果更新该地址注册的所有服务的探活状态
+func (r *GeeRegistry) updateHealth(addr string, ok bool, threshold int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for key, server := range r.servers {
+		if key.addr != addr {
+			continue
+		}
+		if ok {
+			server.failures = 0
+		} else {
+			server.failures++
+		}
+		if unhealthy := server.failures >= threshold; unhealthy != server.unhealthy {
+			server.unhealthy = unhealthy
+			if unhealthy {
+				r.record(EventUnhealthy, key)
+			} else {
+				r.record(EventRecovered, key)
+			}
+			r.notify()
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package registry
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+const (
+	defaultHeartbeatInterval = time.Minute
+	minHeartbeatBackoff      = time.Second // 心跳失败后第一次重试的等待时间，之后每次翻倍，最多为心跳间隔
+	heartbeatJitter          = 0.1         // 心跳间隔的随机抖动比例，避免大量实例同时发送心跳
+)
+
+// Heartbeater 定期向服务中心发送心跳，发送失败时以指数退避重试，直到调用 Stop。
+// 注册中心返回了租约时，按照租约的 RenewInterval 续约
+type Heartbeater struct {
+	// OnError 在每次发送心跳失败时调用，需在 Start 之前设置
+	OnError func(err error)
+
+	registry string
+	server   ServerItem
+	interval time.Duration
+
+	mu    sync.Mutex
+	lease Lease // 最近一次心跳得到的租约
+
+	once sync.Once
+	stop chan struct{}
+	done chan struct{}
+}
+
+// NewHeartbeater 创建 Heartbeater，interval 为 0 时使用 server.TTL 的三分之一，没有设置 TTL 时使用默认的心跳间隔
+func NewHeartbeater(registry string, server ServerItem, interval time.Duration) *Heartbeater {
+	if interval == 0 && server.TTL > 0 {
+		interval = server.TTL / 3
+	}
+	if interval == 0 {
+		interval = defaultHeartbeatInterval
+	}
+	return &Heartbeater{
+		registry: registry,
+		server:   server,
+		interval: interval,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+// Start 立即发送一次心跳，并在后台定期发送
+func (h *Heartbeater) Start() {
+	err := h.beat()
+	go h.run(err)
+}
+
+// Lease 返回最近一次心跳成功时注册中心授予的租约，还没有成功过或注册中心不支持租约时为零值
+func (h *Heartbeater) Lease() Lease {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.lease
+}
+
+// Stop 停止发送心跳，不会从服务中心注销，需要立即下线时调用 Deregister
+func (h *Heartbeater) Stop() {
+	h.once.Do(func() { close(h.stop) })
+	<-h.done
+}
+
+func (h *Heartbeater) run(err error) {
+	defer close(h.done)
+
+	var backoff time.Duration
+	for {
+		interval := h.renewInterval()
+		wait := h.jittered(interval)
+		if err != nil {
+			if backoff == 0 {
+				backoff = minHeartbeatBackoff
+			}
+			if backoff > interval {
+				backoff = interval
+			}
+			wait = h.jittered(backoff)
+			backoff *= 2
+		} else {
+			backoff = 0
+		}
+
+		t := time.NewTimer(wait)
+		select {
+		case <-h.stop:
+			t.Stop()
+			return
+		case <-t.C:
+		}
+		err = h.beat()
+	}
+}
+
+func (h *Heartbeater) beat() error {
+	lease, err := renewLease(h.registry, h.server)
+	if err != nil {
+		if h.OnError != nil {
+			h.OnError(err)
+		}
+		return err
+	}
+	h.mu.Lock()
+	h.lease = lease
+	h.mu.Unlock()
+	return nil
+}
+
+// 返回下一次心跳的间隔，租约要求更频繁的续约时使用租约的间隔
+func (h *Heartbeater) renewInterval() time.Duration {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.lease.RenewInterval > 0 && h.lease.RenewInterval < h.interval {
+		return h.lease.RenewInterval
+	}
+	return h.interval
+}
+
+// 返回在 d 上下浮动 heartbeatJitter 的随机时长
+func (h *Heartbeater) jittered(d time.Duration) time.Duration {
+	return d + time.Duration((rand.Float64()*2-1)*heartbeatJitter*float64(d))
+}
@@ -1,123 +1,352 @@
 package registry
 
 import (
-	"log"
+	"context"
+	"encoding/json"
+	"io"
 	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 )
 
 const (
-	defaultPath    = "/_geerpc_/registry"
-	defaultTimeout = time.Minute * 5
+	defaultPath         = "/_geerpc_/registry"
+	defaultTimeout      = time.Minute * 5
+	defaultWatchTimeout = time.Second * 30 // watch 请求在服务列表没有变化时的最长等待时间
 )
 
+// ServerItem 为注册到服务中心的服务实例，POST 时可以通过 JSON body 携带实例信息。
+// 同一地址提供多个服务时分别以不同的 Service 注册，注册中心以 Service 与 Addr 区分实例
 type ServerItem struct {
-	Addr  string
-	start time.Time // 上次访问的时间
+	Service   string            `json:"service,omitempty"` // 实例提供的服务名，为空表示不区分服务
+	Addr      string            `json:"addr"`
+	Weight    int               `json:"weight,omitempty"`
+	Zone      string            `json:"zone,omitempty"`
+	Version   string            `json:"version,omitempty"`
+	Codecs    []string          `json:"codecs,omitempty"` // 支持的编解码方式，例如 application/gob
+	Metadata  map[string]string `json:"metadata,omitempty"`
+	TTL       time.Duration     `json:"ttl,omitempty"` // 申请的租约时长（纳秒），0 表示使用注册中心的 timeout，见 Lease
+	start     time.Time         // 上次访问的时间
+	lease     time.Duration     // 实际的租约时长，超过该时长没有心跳的实例被删除
+	failures  int               // 连续探活失败的次数
+	unhealthy bool              // 探活失败达到阈值，不再返回给客户端
+}
+
+// Lease 为注册或心跳后注册中心返回的租约，实例需要在 ExpiresAt 之前再次发送心跳续约，
+// 建议每隔 RenewInterval 发送一次，允许偶尔丢失一次心跳
+type Lease struct {
+	Service       string        `json:"service,omitempty"`
+	Addr          string        `json:"addr"`
+	TTL           time.Duration `json:"ttl"`
+	RenewInterval time.Duration `json:"renew_interval"`
+	ExpiresAt     time.Time     `json:"expires_at"`
 }
 
 type GeeRegistry struct {
-	timeout time.Duration
-	mu      sync.Mutex // protect following
-	servers map[string]*ServerItem
+	timeout time.Duration // 租约的最长时长，也是没有申请 TTL 的实例的租约时长
+	mu      sync.Mutex    // protect following
+	servers map[serverKey]*ServerItem
+	version uint64        // 可用服务列表每变化一次加一
+	changed chan struct{} // 可用服务列表变化时关闭并替换，用于唤醒 watch 请求
+	peers   []string      // 集群中其他注册中心的地址
+	history []Event       // 最近的服务实例变化，最多 maxHistory 条
 }
 
-// NewGeeRegistry returns a new GeeRegistry
+// NewGeeRegistry returns a new GeeRegistry，实例超过 timeout 没有心跳即被删除，
+// 实例可以通过 ServerItem.TTL 申请更短的租约
 func NewGeeRegistry(timeout time.Duration) *GeeRegistry {
 	return &GeeRegistry{
 		timeout: timeout,
 		mu:      sync.Mutex{},
-		servers: make(map[string]*ServerItem),
+		servers: make(map[serverKey]*ServerItem),
+		changed: make(chan struct{}),
 	}
 }
 
-// 添加服务实例，如果服务已经存在，则更新 start
-func (r *GeeRegistry) putServer(addr string) {
+// 服务实例在注册中心中的唯一标识
+type serverKey struct {
+	service, addr string
+}
+
+func (s *ServerItem) key() serverKey {
+	return serverKey{s.Service, s.Addr}
+}
+
+// 通知 watch 请求可用服务列表发生了变化，调用方需持有 r.mu
+func (r *GeeRegistry) notify() {
+	r.version++
+	close(r.changed)
+	r.changed = make(chan struct{})
+}
+
+// 添加服务实例，如果服务已经存在，则更新实例信息与 start，返回实例的租约
+func (r *GeeRegistry) putServer(item ServerItem) Lease {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
-	server, ok := r.servers[addr]
+	// 仅心跳时服务列表没有变化，不需要通知 watch 请求
+	old, ok := r.servers[item.key()]
 	if ok {
-		server.start = time.Now()
-	} else {
-		r.servers[addr] = &ServerItem{
-			Addr:  addr,
-			start: time.Now(),
+		item.start, item.failures, item.unhealthy = old.start, old.failures, old.unhealthy
+	}
+	item.lease = r.timeout
+	if item.TTL > 0 && item.TTL < r.timeout {
+		item.lease = item.TTL
+	}
+	changed := !ok || !reflect.DeepEqual(*old, item)
+	item.start = time.Now()
+	r.servers[item.key()] = &item
+	if !ok {
+		r.record(EventJoin, item.key())
+	}
+	if changed {
+		r.notify()
+	}
+	return Lease{
+		Service:       item.Service,
+		Addr:          item.Addr,
+		TTL:           item.lease,
+		RenewInterval: item.lease / 3,
+		ExpiresAt:     item.start.Add(item.lease),
+	}
+}
+
+// 删除服务实例，service 为空时删除 addr 注册的所有服务
+func (r *GeeRegistry) removeServer(service, addr string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.removeServers(service, addr, EventLeave)
+}
+
+// 删除 addr 注册的 service 服务的实例，service 为空时删除 addr 注册的所有服务，返回是否删除了实例，调用方需持有 r.mu
+func (r *GeeRegistry) removeServers(service, addr string, typ EventType) bool {
+	if service != "" {
+		return r.deleteServer(serverKey{service, addr}, typ)
+	}
+	var removed bool
+	for key := range r.servers {
+		if key.addr == addr {
+			removed = r.deleteServer(key, typ) || removed
 		}
 	}
+	return removed
+}
+
+// 删除服务实例并记录事件，返回实例是否存在，调用方需持有 r.mu
+func (r *GeeRegistry) deleteServer(key serverKey, typ EventType) bool {
+	if _, ok := r.servers[key]; !ok {
+		return false
+	}
+	delete(r.servers, key)
+	r.record(typ, key)
+	r.notify()
+	return true
 }
 
-// 返回可用的服务列表，如果存在超时的服务，则删除
-func (r *GeeRegistry) aliveServers() []string {
+// 返回可用的服务列表、当前版本与下一个服务超时的时间，如果存在超时的服务，则删除
+func (r *GeeRegistry) snapshot() ([]ServerItem, uint64, time.Time) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
-	var aliveServers []string
+	aliveServers := make([]ServerItem, 0, len(r.servers))
+	var nextExpiry time.Time
 	nowTime := time.Now()
 	for _, server := range r.servers {
-		if nowTime.Sub(server.start) >= r.timeout {
-			delete(r.servers, server.Addr)
+		if nowTime.Sub(server.start) >= server.lease {
+			r.deleteServer(server.key(), EventExpire)
 		} else {
-			aliveServers = append(aliveServers, server.Addr)
+			if !server.unhealthy {
+				aliveServers = append(aliveServers, *server)
+			}
+			if expiry := server.start.Add(server.lease); nextExpiry.IsZero() || expiry.Before(nextExpiry) {
+				nextExpiry = expiry
+			}
+		}
+	}
+	sort.Slice(aliveServers, func(i, j int) bool {
+		if aliveServers[i].Addr != aliveServers[j].Addr {
+			return aliveServers[i].Addr < aliveServers[j].Addr
+		}
+		return aliveServers[i].Service < aliveServers[j].Service
+	})
+	return aliveServers, r.version, nextExpiry
+}
+
+// 返回提供 service 服务的实例，service 为空时返回所有实例
+func filterService(servers []ServerItem, service string) []ServerItem {
+	if service == "" {
+		return servers
+	}
+	filtered := make([]ServerItem, 0, len(servers))
+	for _, server := range servers {
+		if server.Service == service {
+			filtered = append(filtered, server)
+		}
+	}
+	return filtered
+}
+
+// 等待可用服务列表的版本超过 version，或者 timeout 后返回当前的服务列表
+func (r *GeeRegistry) watch(ctx context.Context, version uint64, timeout time.Duration) ([]ServerItem, uint64) {
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	for {
+		servers, current, nextExpiry := r.snapshot()
+		if current != version {
+			return servers, current
+		}
+
+		r.mu.Lock()
+		changed := r.changed
+		r.mu.Unlock()
+
+		// 服务超时不会主动通知，需要在最早超时的时间醒来重新检查
+		var expired <-chan time.Time
+		if !nextExpiry.IsZero() {
+			expired = time.After(time.Until(nextExpiry))
+		}
+		select {
+		case <-changed:
+		case <-expired:
+		case <-deadline.C:
+			return servers, current
+		case <-ctx.Done():
+			return servers, current
 		}
 	}
-	return aliveServers
 }
 
-// ServeHTTP 采用 HTTP 协议提供服务，且所有的有用信息都承载在 HTTP Header 中
-// Get：返回所有可用的服务列表，通过自定义字段 X-Geerpc-Servers 承载
-// Post：添加服务实例或发送心跳，通过自定义字段 X-Geerpc-Server 承载
+// ServeHTTP 采用 HTTP 协议提供服务
+// Get：以 JSON body 返回所有可用的服务实例，同时通过自定义字段 X-Geerpc-Servers 返回去重的地址列表以兼容旧的客户端，
+// 服务列表的版本通过自定义字段 X-Geerpc-Registry-Version 承载。带有 service 参数时只返回提供该服务的实例。
+// 路径以 /watch 结尾时为长轮询，请求的 version 参数与当前版本相同时，等待服务列表变化后再返回，没有 version 参数时立即返回
+// Post：添加服务实例或发送心跳，实例信息通过 JSON body 承载，没有 body 时使用自定义字段 X-Geerpc-Server，
+// 以 JSON body 返回实例的租约 Lease
+// Delete：注销服务实例，与 Post 相同通过 JSON body 或自定义字段 X-Geerpc-Server 承载，没有指定服务时注销该地址的所有服务
 func (r *GeeRegistry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	switch req.Method {
 	case "GET":
-		w.Header().Set("X-Geerpc-Servers", strings.Join(r.aliveServers(), ","))
+		var servers []ServerItem
+		var version uint64
+		if v := req.URL.Query().Get("version"); strings.HasSuffix(req.URL.Path, "/watch") && v != "" {
+			current, _ := strconv.ParseUint(v, 10, 64)
+			servers, version = r.watch(req.Context(), current, defaultWatchTimeout)
+		} else {
+			servers, version, _ = r.snapshot()
+		}
+		servers = filterService(servers, req.URL.Query().Get("service"))
+		addrs := make([]string, 0, len(servers))
+		seen := make(map[string]bool)
+		for _, server := range servers {
+			if !seen[server.Addr] {
+				seen[server.Addr] = true
+				addrs = append(addrs, server.Addr)
+			}
+		}
+		w.Header().Set("X-Geerpc-Servers", strings.Join(addrs, ","))
+		w.Header().Set("X-Geerpc-Registry-Version", strconv.FormatUint(version, 10))
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(servers)
 	case "POST":
-		addr := req.Header.Get("X-Geerpc-Server")
-		if addr == "" {
-			w.WriteHeader(http.StatusInternalServerError)
+		item, body, ok := readServerItem(w, req)
+		if !ok {
 			return
 		}
-		r.putServer(addr)
+		lease := r.putServer(item)
+		if req.Header.Get(replicatedHeader) == "" {
+			body, _ = json.Marshal(item)
+			r.replicate("POST", body, item.Addr)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(lease)
+	case "DELETE":
+		item, body, ok := readServerItem(w, req)
+		if !ok {
+			return
+		}
+		r.removeServer(item.Service, item.Addr)
+		if req.Header.Get(replicatedHeader) == "" {
+			r.replicate("DELETE", body, item.Addr)
+		}
 	default:
 		w.WriteHeader(http.StatusMethodNotAllowed)
 	}
 }
 
+// 从请求的 JSON body 或自定义字段 X-Geerpc-Server 读取服务实例，同时返回 body，失败时写入错误响应并返回 false
+func readServerItem(w http.ResponseWriter, req *http.Request) (ServerItem, []byte, bool) {
+	var item ServerItem
+	body, _ := io.ReadAll(req.Body)
+	if err := json.Unmarshal(body, &item); err != nil && len(body) != 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		return item, nil, false
+	}
+	if item.Addr == "" {
+		item.Addr = req.Header.Get("X-Geerpc-Server")
+	}
+	if item.Addr == "" {
+		w.WriteHeader(http.StatusInternalServerError)
+		return item, nil, false
+	}
+	return item, body, true
+}
+
 func (r *GeeRegistry) HandleHTTP(registryPath string) {
 	http.Handle(registryPath, r)
-	log.Println("[GeeRegistry.HandleHTTP] starting")
+	http.Handle(registryPath+"/watch", r)
+	http.Handle(registryPath+dashboardPath, &dashboardHTTP{r})
+	logger.Infof("rpc registry: serving on %s, dashboard on %s", registryPath, registryPath+dashboardPath)
 }
 
-// Heartbeat 向服务中心发送心跳
-func Heartbeat(registry, addr string, duration time.Duration) {
-	if duration == 0 {
-		duration = 1 * time.Minute
-	}
+// Heartbeat 向服务中心发送心跳，registry 可以是逗号分隔的多个注册中心地址
+func Heartbeat(registry, addr string, duration time.Duration) *Heartbeater {
+	return HeartbeatServer(registry, ServerItem{Addr: addr}, duration)
+}
 
-	var err error
-	err = sendHeartbeat(registry, addr)
-	go func() {
-		t := time.NewTicker(duration)
-		for err == nil {
-			<-t.C
-			err = sendHeartbeat(registry, addr)
-		}
-	}()
+// HeartbeatServer 向服务中心发送心跳，并携带权重、zone 等实例信息
+func HeartbeatServer(registry string, server ServerItem, duration time.Duration) *Heartbeater {
+	h := NewHeartbeater(registry, server, duration)
+	h.Start()
+	return h
 }
 
 // 发送心跳
-func sendHeartbeat(registry, addr string) error {
-	log.Println(addr, "send heart beat to registry", registry)
-	httpClient := &http.Client{}
-	req, _ := http.NewRequest("POST", registry, nil)
-	req.Header.Set("X-Geerpc-Server", addr)
-	if _, err := httpClient.Do(req); err != nil {
-		log.Println("rpc server: heart beat err:", err)
+func sendHeartbeat(registry string, server ServerItem) error {
+	_, err := renewLease(registry, server)
+	return err
+}
+
+// 发送心跳并返回注册中心授予的租约，旧版本的注册中心不返回租约，此时租约为零值
+func renewLease(registry string, server ServerItem) (Lease, error) {
+	logger.Debugf("rpc registry: %s send heart beat to registry %s", server.Addr, registry)
+	var lease Lease
+	body, err := json.Marshal(server)
+	if err != nil {
+		return lease, err
+	}
+	if err := sendToRegistry(registry, "POST", body, server.Addr, &lease); err != nil {
+		logger.Errorf("rpc registry: %s heart beat err: %v", server.Addr, err)
+		return lease, err
+	}
+	return lease, nil
+}
+
+// Deregister 从服务中心注销 addr 注册的所有服务，服务端优雅退出时调用，使客户端不再等待超时才移除该实例
+func Deregister(registry, addr string) error {
+	return sendToRegistry(registry, "DELETE", nil, addr, nil)
+}
+
+// DeregisterService 从服务中心注销 addr 注册的 service 服务，addr 注册的其他服务不受影响
+func DeregisterService(registry, service, addr string) error {
+	body, err := json.Marshal(ServerItem{Service: service, Addr: addr})
+	if err != nil {
 		return err
 	}
-	return nil
+	return sendToRegistry(registry, "DELETE", body, addr, nil)
 }
 
 var DefaultGeeRegister = NewGeeRegistry(defaultTimeout)
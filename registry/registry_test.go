@@ -0,0 +1,368 @@
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"geerpc"
+)
+
+func TestGeeRegistry_Deregister(t *testing.T) {
+	r := NewGeeRegistry(time.Minute)
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	if err := sendHeartbeat(ts.URL, ServerItem{Addr: "tcp@a"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := sendHeartbeat(ts.URL, ServerItem{Addr: "tcp@b"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := Deregister(ts.URL, "tcp@a"); err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := http.Get(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+	if servers := resp.Header.Get("X-Geerpc-Servers"); servers != "tcp@b" {
+		t.Fatalf("expect tcp@b, got %q", servers)
+	}
+}
+
+func TestGeeRegistry_Metadata(t *testing.T) {
+	r := NewGeeRegistry(time.Minute)
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	server := ServerItem{Addr: "tcp@a", Weight: 3, Zone: "us-east-1a", Version: "v2", Codecs: []string{"application/gob"}}
+	if err := sendHeartbeat(ts.URL, server); err != nil {
+		t.Fatal(err)
+	}
+	// 旧的客户端只通过 header 携带地址
+	req, _ := http.NewRequest("POST", ts.URL, nil)
+	req.Header.Set("X-Geerpc-Server", "tcp@b")
+	if resp, err := http.DefaultClient.Do(req); err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("failed to register by header: %v", err)
+	}
+
+	resp, err := http.Get(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	var servers []ServerItem
+	if err := json.NewDecoder(resp.Body).Decode(&servers); err != nil {
+		t.Fatal(err)
+	}
+	if len(servers) != 2 || !reflect.DeepEqual(servers[0], server) || servers[1].Addr != "tcp@b" {
+		t.Fatalf("unexpected servers %+v", servers)
+	}
+}
+
+func TestGeeRegistry_Watch(t *testing.T) {
+	r := NewGeeRegistry(time.Millisecond * 200)
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	watch := func(version string) ([]ServerItem, string) {
+		resp, err := http.Get(ts.URL + "/watch?version=" + version)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer func() { _ = resp.Body.Close() }()
+		var servers []ServerItem
+		_ = json.NewDecoder(resp.Body).Decode(&servers)
+		return servers, resp.Header.Get("X-Geerpc-Registry-Version")
+	}
+
+	_, version := watch("")
+	go func() {
+		time.Sleep(time.Millisecond * 50)
+		_ = sendHeartbeat(ts.URL, ServerItem{Addr: "tcp@a"})
+	}()
+	servers, version := watch(version)
+	if len(servers) != 1 || servers[0].Addr != "tcp@a" {
+		t.Fatalf("expect watch to return tcp@a, got %+v", servers)
+	}
+
+	// 没有心跳时服务超时，watch 同样会被唤醒
+	start := time.Now()
+	servers, _ = watch(version)
+	if len(servers) != 0 || time.Since(start) > time.Second {
+		t.Fatalf("expect watch to return after expiry, got %+v in %s", servers, time.Since(start))
+	}
+}
+
+func TestGeeRegistry_Peers(t *testing.T) {
+	a, b := NewGeeRegistry(time.Minute), NewGeeRegistry(time.Minute)
+	tsA, tsB := httptest.NewServer(a), httptest.NewServer(b)
+	defer tsA.Close()
+	defer tsB.Close()
+
+	_ = sendHeartbeat(tsA.URL, ServerItem{Addr: "tcp@old"})
+	a.SetPeers(tsB.URL)
+	b.SetPeers(tsA.URL)
+	if servers, _, _ := b.snapshot(); len(servers) != 1 {
+		t.Fatalf("expect b to sync from a, got %+v", servers)
+	}
+
+	expect := func(r *GeeRegistry, want int) {
+		t.Helper()
+		deadline := time.Now().Add(time.Second)
+		for {
+			servers, _, _ := r.snapshot()
+			if len(servers) == want {
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("expect %d servers, got %+v", want, servers)
+			}
+			time.Sleep(time.Millisecond * 10)
+		}
+	}
+
+	// 第一个注册中心不可用时使用下一个
+	dead := httptest.NewServer(http.NotFoundHandler())
+	dead.Close()
+	if err := sendHeartbeat(dead.URL+","+tsA.URL, ServerItem{Addr: "tcp@a"}); err != nil {
+		t.Fatal(err)
+	}
+	expect(b, 2)
+
+	if err := Deregister(tsB.URL, "tcp@a"); err != nil {
+		t.Fatal(err)
+	}
+	expect(a, 1)
+}
+
+func TestHeartbeater(t *testing.T) {
+	r := NewGeeRegistry(time.Minute)
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+	dead := httptest.NewServer(http.NotFoundHandler())
+	dead.Close()
+
+	errs := make(chan error, 1)
+	h := NewHeartbeater(dead.URL, ServerItem{Addr: "tcp@a"}, time.Millisecond*10)
+	h.OnError = func(err error) {
+		select {
+		case errs <- err:
+		default:
+		}
+	}
+	h.Start()
+	if err := <-errs; err == nil {
+		t.Fatal("expect OnError to be called")
+	}
+	// 失败后继续重试，而不是直接退出
+	<-errs
+	h.Stop()
+
+	h = Heartbeat(ts.URL, "tcp@b", time.Millisecond*10)
+	h.Stop()
+	r.removeServer("", "tcp@b")
+	time.Sleep(time.Millisecond * 50)
+	if servers, _, _ := r.snapshot(); len(servers) != 0 {
+		t.Fatalf("expect no heartbeat after Stop, got %+v", servers)
+	}
+}
+
+func TestGeeRegistry_HealthCheck(t *testing.T) {
+	r := NewGeeRegistry(time.Minute)
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	defer func() { _ = l.Close() }()
+	dead, _ := net.Listen("tcp", "127.0.0.1:0")
+	_ = dead.Close()
+
+	r.putServer(ServerItem{Addr: "tcp@" + l.Addr().String()})
+	r.putServer(ServerItem{Addr: "tcp@" + dead.Addr().String()})
+	hc := HealthCheck{Interval: time.Millisecond * 10, Threshold: 2}
+	hc.Probe = TCPProber(time.Second)
+
+	r.probeAll(hc)
+	if servers, _, _ := r.snapshot(); len(servers) != 2 {
+		t.Fatalf("expect server to stay before reaching threshold, got %+v", servers)
+	}
+	r.probeAll(hc)
+	servers, _, _ := r.snapshot()
+	if len(servers) != 1 || servers[0].Addr != "tcp@"+l.Addr().String() {
+		t.Fatalf("expect unhealthy server to be evicted, got %+v", servers)
+	}
+
+	// 心跳不会让探活失败的实例重新出现，探活成功后才恢复
+	r.putServer(ServerItem{Addr: "tcp@" + dead.Addr().String()})
+	if servers, _, _ := r.snapshot(); len(servers) != 1 {
+		t.Fatalf("expect heartbeat not to restore unhealthy server, got %+v", servers)
+	}
+	r.updateHealth("tcp@"+dead.Addr().String(), true, hc.Threshold)
+	if servers, _, _ := r.snapshot(); len(servers) != 2 {
+		t.Fatalf("expect server to recover, got %+v", servers)
+	}
+
+	stop := r.StartHealthCheck(hc)
+	defer stop()
+	time.Sleep(time.Millisecond * 100)
+	if servers, _, _ := r.snapshot(); len(servers) != 1 {
+		t.Fatalf("expect background health check to evict server, got %+v", servers)
+	}
+}
+
+func TestRPCProber(t *testing.T) {
+	server := geerpc.NewServer()
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	go server.Accept(l)
+	addr := "tcp@" + l.Addr().String()
+
+	probe := RPCProber(time.Second)
+	if err := probe(addr); err != nil {
+		t.Fatalf("expect serving server to pass, got %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_ = server.Shutdown(ctx)
+	if err := probe(addr); err == nil {
+		t.Fatal("expect shut down server to fail")
+	}
+}
+
+func TestGeeRegistry_Dashboard(t *testing.T) {
+	r := NewGeeRegistry(time.Minute)
+	ts := httptest.NewServer(&dashboardHTTP{r})
+	defer ts.Close()
+
+	r.putServer(ServerItem{Addr: "tcp@a", Zone: "z1", Metadata: map[string]string{"env": "prod"}})
+	r.putServer(ServerItem{Addr: "tcp@b"})
+	r.removeServer("", "tcp@b")
+
+	resp, err := http.Get(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	for _, want := range []string{"tcp@a", "z1", "env=prod", "join", "leave"} {
+		if !strings.Contains(string(body), want) {
+			t.Fatalf("expect dashboard to contain %q, got %s", want, body)
+		}
+	}
+
+	resp, err = http.PostForm(ts.URL, url.Values{"addr": {"tcp@a"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+	if servers, _, _ := r.snapshot(); len(servers) != 0 {
+		t.Fatalf("expect tcp@a to be evicted, got %+v", servers)
+	}
+	resp, _ = http.PostForm(ts.URL, url.Values{"addr": {"tcp@a"}})
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("expect 404 for unknown server, got %s", resp.Status)
+	}
+
+	var types []EventType
+	for _, e := range r.History() {
+		types = append(types, e.Type)
+	}
+	if !reflect.DeepEqual(types, []EventType{EventJoin, EventJoin, EventLeave, EventEvict}) {
+		t.Fatalf("unexpected history %v", types)
+	}
+}
+
+func TestGeeRegistry_Lease(t *testing.T) {
+	r := NewGeeRegistry(time.Minute)
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	// 超过注册中心 timeout 的 TTL 被限制为 timeout
+	lease, err := renewLease(ts.URL, ServerItem{Addr: "tcp@a", TTL: time.Hour})
+	if err != nil || lease.TTL != time.Minute || lease.RenewInterval != time.Minute/3 {
+		t.Fatalf("unexpected lease %+v, err: %v", lease, err)
+	}
+
+	h := NewHeartbeater(ts.URL, ServerItem{Addr: "tcp@b", TTL: 60 * time.Millisecond}, 0)
+	h.Start()
+	if lease := h.Lease(); lease.Addr != "tcp@b" || lease.TTL != 60*time.Millisecond || lease.RenewInterval != 20*time.Millisecond {
+		t.Fatalf("unexpected lease %+v", lease)
+	}
+	// 按照租约续约时实例一直存在
+	time.Sleep(150 * time.Millisecond)
+	if servers, _, _ := r.snapshot(); len(servers) != 2 {
+		t.Fatalf("expect tcp@b to be renewed, got %+v", servers)
+	}
+	// 停止续约后租约到期即被删除，而不是等待注册中心的 timeout
+	h.Stop()
+	time.Sleep(100 * time.Millisecond)
+	if servers, _, _ := r.snapshot(); len(servers) != 1 || servers[0].Addr != "tcp@a" {
+		t.Fatalf("expect tcp@b to expire, got %+v", servers)
+	}
+	if e := r.History(); e[len(e)-1].Type != EventExpire {
+		t.Fatalf("expect expire event, got %+v", e)
+	}
+}
+
+func TestGeeRegistry_Service(t *testing.T) {
+	r := NewGeeRegistry(time.Minute)
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	for _, item := range []ServerItem{
+		{Service: "Foo", Addr: "tcp@a"},
+		{Service: "Bar", Addr: "tcp@a"},
+		{Service: "Foo", Addr: "tcp@b"},
+	} {
+		if err := sendHeartbeat(ts.URL, item); err != nil {
+			t.Fatal(err)
+		}
+	}
+	get := func(query string) ([]ServerItem, string) {
+		t.Helper()
+		resp, err := http.Get(ts.URL + query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer func() { _ = resp.Body.Close() }()
+		var servers []ServerItem
+		if err := json.NewDecoder(resp.Body).Decode(&servers); err != nil {
+			t.Fatal(err)
+		}
+		return servers, resp.Header.Get("X-Geerpc-Servers")
+	}
+
+	if servers, addrs := get("?service=Foo"); len(servers) != 2 || addrs != "tcp@a,tcp@b" {
+		t.Fatalf("unexpected Foo servers %+v (%s)", servers, addrs)
+	}
+	if servers, addrs := get(""); len(servers) != 3 || addrs != "tcp@a,tcp@b" {
+		t.Fatalf("unexpected servers %+v (%s)", servers, addrs)
+	}
+
+	// 只注销 tcp@a 的 Foo，Bar 不受影响
+	if err := DeregisterService(ts.URL, "Foo", "tcp@a"); err != nil {
+		t.Fatal(err)
+	}
+	if servers, _ := get("?service=Bar"); len(servers) != 1 || servers[0].Addr != "tcp@a" {
+		t.Fatalf("unexpected Bar servers %+v", servers)
+	}
+	if servers, _ := get("?service=Foo"); len(servers) != 1 || servers[0].Addr != "tcp@b" {
+		t.Fatalf("unexpected Foo servers %+v", servers)
+	}
+	// 不指定服务时注销该地址的所有服务
+	if err := Deregister(ts.URL, "tcp@a"); err != nil {
+		t.Fatal(err)
+	}
+	if servers, _ := get(""); len(servers) != 1 || servers[0].Addr != "tcp@b" {
+		t.Fatalf("unexpected servers %+v", servers)
+	}
+}
@@ -0,0 +1,82 @@
+package geerpc
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"time"
+)
+
+// RetryPolicy 为 Client.Call 的重试策略，调用失败且错误码可重试时在同一个 client 上重新调用，
+// 只应在方法幂等时启用。通过 Option.RetryPolicy 设置客户端级别的策略，或通过 WithRetryPolicy 设置单次调用的策略
+type RetryPolicy struct {
+	MaxAttempts    int           // 最大尝试次数（包括第一次），小于等于 1 时不重试
+	InitialBackoff time.Duration // 第一次重试前的等待时间，之后每次翻倍
+	MaxBackoff     time.Duration // 等待时间的上限，0 表示不限制
+	Jitter         float64       // 等待时间随机浮动的比例，取值 0~1，避免多个客户端同时重试
+	RetryableCodes []Code        // 可重试的错误码，为空时仅重试 Unavailable
+}
+
+func (p *RetryPolicy) retryable(err error) bool {
+	code := CodeOf(err)
+	if len(p.RetryableCodes) == 0 {
+		return code == Unavailable
+	}
+	for _, c := range p.RetryableCodes {
+		if c == code {
+			return true
+		}
+	}
+	return false
+}
+
+// 返回第 attempt 次重试前的等待时间，attempt 从 1 开始
+func (p *RetryPolicy) backoff(attempt int) time.Duration {
+	// MaxBackoff 不限制时以 time.Duration 的最大值为上限，避免翻倍溢出为负数
+	limit := p.MaxBackoff
+	if limit <= 0 {
+		limit = math.MaxInt64
+	}
+	d := p.InitialBackoff
+	for i := 1; i < attempt && d > 0 && d < limit; i++ {
+		if d > limit/2 {
+			d = limit
+		} else {
+			d *= 2
+		}
+	}
+	if d > limit {
+		d = limit
+	}
+	if p.Jitter > 0 {
+		f := float64(d) * (1 + p.Jitter*(rand.Float64()*2-1))
+		if f >= math.MaxInt64 {
+			return math.MaxInt64
+		}
+		d = time.Duration(f)
+	}
+	return d
+}
+
+// WithRetryPolicy 为单次调用设置重试策略，覆盖 Option.RetryPolicy，MaxAttempts 为 1 时关闭重试
+func WithRetryPolicy(policy RetryPolicy) CallOption {
+	return func(call *Call) {
+		call.retry = &policy
+	}
+}
+
+// 按照重试策略等待下一次重试，ctx 结束时返回 false
+func waitRetry(ctx context.Context, policy *RetryPolicy, attempt int) bool {
+	d := policy.backoff(attempt)
+	if d <= 0 {
+		return ctx.Err() == nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
@@ -0,0 +1,174 @@
+package geerpc
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+
+	"geerpc/codec"
+)
+
+// ReverseClient 由服务端在已建立的连接上调用客户端通过 HandleCallbacks 注册的服务，
+// 适用于通知以及客户端位于 NAT 之后的 agent 等场景，通过 ReverseClientFromContext 获取
+type ReverseClient struct {
+	cc      codec.Codec
+	sending *sync.Mutex
+
+	mu      sync.Mutex
+	seq     uint64
+	pending map[uint64]*Call
+	closed  bool // 连接已断开
+}
+
+type reverseClientKey struct{}
+
+func newReverseClient(cc codec.Codec, sending *sync.Mutex) *ReverseClient {
+	return &ReverseClient{cc: cc, sending: sending, pending: make(map[uint64]*Call)}
+}
+
+// ReverseClientFromContext 返回处理请求的连接对应的 ReverseClient，handler 可以保存它以便之后主动通知客户端
+func ReverseClientFromContext(ctx context.Context) (*ReverseClient, bool) {
+	rc, ok := ctx.Value(reverseClientKey{}).(*ReverseClient)
+	return rc, ok
+}
+
+// Call 调用客户端的方法 serviceMethod 并等待结果，ctx 的截止时间随请求传递给客户端。
+// 客户端没有通过 HandleCallbacks 注册服务时返回 Unimplemented 错误，连接断开时返回 ErrShutdown
+func (rc *ReverseClient) Call(ctx context.Context, serviceMethod string, args, reply interface{}) error {
+	call := &Call{ServerMethod: serviceMethod, Args: args, Reply: reply, Done: make(chan *Call, 1)}
+	rc.mu.Lock()
+	if rc.closed {
+		rc.mu.Unlock()
+		return ErrShutdown
+	}
+	rc.seq++
+	seq := rc.seq
+	rc.pending[seq] = call
+	rc.mu.Unlock()
+
+	h := &codec.Header{ServiceMethod: serviceMethod, Seq: seq, Flags: codec.FlagReverse}
+	h.Deadline, _ = ctx.Deadline()
+	rc.sending.Lock()
+	err := rc.cc.Write(h, args)
+	rc.sending.Unlock()
+	if err != nil {
+		rc.remove(seq)
+		return codecError(err)
+	}
+
+	select {
+	case call := <-call.Done:
+		return call.Error
+	case <-ctx.Done():
+		rc.remove(seq)
+		return &Error{Code: CodeOf(ctx.Err()), Message: "rpc server: reverse call failed: " + ctx.Err().Error()}
+	}
+}
+
+func (rc *ReverseClient) remove(seq uint64) *Call {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	call := rc.pending[seq]
+	delete(rc.pending, seq)
+	return call
+}
+
+// 处理客户端对反向调用的响应
+func (rc *ReverseClient) receive(cc codec.Codec, header *codec.Header) error {
+	call := rc.remove(header.Seq)
+	if call == nil {
+		return cc.ReadBody(nil)
+	}
+	var err error
+	if call.Error = headerError(header); call.Error != nil {
+		err = cc.ReadBody(nil)
+	} else if err = cc.ReadBody(call.Reply); err != nil {
+		call.Error = readBodyError(err)
+	}
+	call.done()
+	if errors.Is(err, codec.ErrMessageTooLarge) {
+		return nil
+	}
+	return err
+}
+
+// 连接断开时结束所有等待中的反向调用
+func (rc *ReverseClient) terminate() {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	rc.closed = true
+	for seq, call := range rc.pending {
+		delete(rc.pending, seq)
+		call.Error = ErrShutdown
+		call.done()
+	}
+}
+
+// HandleCallbacks 使用 s 中注册的服务处理服务端通过 ReverseClient 发起的反向调用，s 的拦截器同样生效
+func (t *Transport) HandleCallbacks(s *Server) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.callbacks = s
+}
+
+// HandleCallbacks 使用 s 中注册的服务处理服务端发起的反向调用，见 Transport.HandleCallbacks
+func (client *Client) HandleCallbacks(s *Server) {
+	client.t.HandleCallbacks(s)
+}
+
+// 处理服务端发起的反向调用，方法在新的 goroutine 中执行
+func (t *Transport) receiveReverse(header *codec.Header) {
+	t.mu.Lock()
+	s := t.callbacks
+	t.mu.Unlock()
+
+	h := &codec.Header{ServiceMethod: header.ServiceMethod, Seq: header.Seq, Flags: codec.FlagReverse}
+	fail := func(err error) {
+		setHeaderError(h, err)
+		_ = t.write(h, invalidRequest)
+	}
+	if s == nil {
+		_ = t.cc.ReadBody(nil)
+		fail(Errorf(Unimplemented, "rpc client: callbacks are not enabled"))
+		return
+	}
+	svc, mtype, err := s.findService(header.ServiceMethod)
+	if err == nil && mtype.stream {
+		err = Errorf(Unimplemented, "rpc client: stream method %s is not supported in callbacks", header.ServiceMethod)
+	}
+	if err != nil {
+		_ = t.cc.ReadBody(nil)
+		fail(err)
+		return
+	}
+	argv := mtype.newArgv()
+	args := argv.Interface()
+	if argv.Kind() != reflect.Ptr {
+		args = argv.Addr().Interface()
+	}
+	if err = t.cc.ReadBody(args); err != nil {
+		fail(Errorf(InvalidArgument, "rpc client: read callback argv err: %v", err))
+		return
+	}
+
+	// header 在接收下一条消息时被复用
+	deadline, md := header.Deadline, header.Metadata
+	go func() {
+		ctx := context.Background()
+		if !deadline.IsZero() {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithDeadline(ctx, deadline)
+			defer cancel()
+		}
+		ctx, _ = newMetadataContext(ctx, md)
+		replyv := mtype.newReply()
+		if err := s.call(ctx, svc, mtype, argv, replyv); err != nil {
+			fail(err)
+			return
+		}
+		if err := t.write(h, replyv.Interface()); errors.Is(err, codec.ErrMessageTooLarge) {
+			fail(codecError(err))
+		}
+	}()
+}
@@ -0,0 +1,99 @@
+package geerpc
+
+import (
+	"container/heap"
+	"context"
+	"sync"
+)
+
+// scheduler 限制同时执行的 handler 数，没有空闲的 worker 时请求按优先级排队，优先级相同时先到先得
+type scheduler struct {
+	mu    sync.Mutex
+	free  int
+	seq   uint64
+	queue waitQueue
+}
+
+type waiter struct {
+	priority int
+	seq      uint64
+	index    int // 在 queue 中的位置，出队后为 -1
+	ready    chan struct{}
+}
+
+func newScheduler(workers int) *scheduler {
+	return &scheduler{free: workers}
+}
+
+// 等待一个空闲的 worker，ctx 结束时放弃排队
+func (s *scheduler) acquire(ctx context.Context, priority int) error {
+	s.mu.Lock()
+	if s.free > 0 && len(s.queue) == 0 {
+		s.free--
+		s.mu.Unlock()
+		return nil
+	}
+	s.seq++
+	w := &waiter{priority: priority, seq: s.seq, ready: make(chan struct{})}
+	heap.Push(&s.queue, w)
+	s.mu.Unlock()
+
+	select {
+	case <-w.ready:
+		return nil
+	case <-ctx.Done():
+		s.mu.Lock()
+		if w.index >= 0 {
+			heap.Remove(&s.queue, w.index)
+			s.mu.Unlock()
+			return &Error{Code: CodeOf(ctx.Err()), Message: "rpc server: request dequeued: " + ctx.Err().Error()}
+		}
+		s.mu.Unlock()
+		// 已经分配到 worker，直接执行
+		return nil
+	}
+}
+
+// 归还 worker，优先交给排队中优先级最高的请求
+func (s *scheduler) release() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.queue) == 0 {
+		s.free++
+		return
+	}
+	w := heap.Pop(&s.queue).(*waiter)
+	close(w.ready)
+}
+
+// waitQueue 为按优先级从高到低、seq 从小到大排列的堆
+type waitQueue []*waiter
+
+func (q waitQueue) Len() int { return len(q) }
+
+func (q waitQueue) Less(i, j int) bool {
+	if q[i].priority != q[j].priority {
+		return q[i].priority > q[j].priority
+	}
+	return q[i].seq < q[j].seq
+}
+
+func (q waitQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].index, q[j].index = i, j
+}
+
+func (q *waitQueue) Push(x interface{}) {
+	w := x.(*waiter)
+	w.index = len(*q)
+	*q = append(*q, w)
+}
+
+func (q *waitQueue) Pop() interface{} {
+	old := *q
+	w := old[len(old)-1]
+	old[len(old)-1] = nil
+	w.index = -1
+	*q = old[:len(old)-1]
+	return w
+}
@@ -1,22 +1,34 @@
 package geerpc
 
 import (
+	"bufio"
+	"context"
+	"crypto/cipher"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"geerpc/codec"
 	"io"
-	"log"
 	"net"
 	"net/http"
+	"os"
 	"reflect"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 const MagicNumber = 0x3bef5c
 
+// ProtocolVersion 为当前的协议版本，服务端在握手应答中返回
+const ProtocolVersion = 1
+
+// DefaultHandshakeTimeout 为服务端等待连接完成握手的默认时间，见 WithHandshakeTimeout
+const DefaultHandshakeTimeout = 10 * time.Second
+
 const (
 	connected        = "200 connected to Gee RPC"
 	defaultRPCPath   = "/_geeprc_"
@@ -28,6 +40,55 @@ type Option struct {
 	CodecType      codec.Type
 	ConnectTimeout time.Duration
 	HandleTimeout  time.Duration
+	// IdleTimeout 为服务端关闭空闲连接的时间，连接上没有正在处理的请求且超过该时间未收到消息时关闭，0 表示不关闭
+	IdleTimeout time.Duration
+	// ReadTimeout 为读取一条消息的最长时间，从收到消息的第一个字节开始计时，超时后关闭连接，0 表示不限制
+	ReadTimeout time.Duration
+	// WriteTimeout 为写入一条消息的最长时间，超时后关闭连接，0 表示不限制
+	WriteTimeout time.Duration
+	// MaxConcurrentRequests 为服务端在该连接上同时处理的最大请求数，超过时以 Unavailable 错误拒绝，0 表示不限制
+	MaxConcurrentRequests int
+	// PingInterval 为客户端在连接空闲时发送 ping 的间隔，超过一个间隔未收到 pong 时认为连接已断开，0 表示不发送
+	PingInterval time.Duration `json:"-"`
+
+	// Compressor 为双方压缩消息使用的算法名称，例如 gzip，需已通过 codec.RegisterCompressor 注册，为空时不压缩
+	Compressor string
+	// CompressThreshold 为压缩阈值，body 小于该字节数时不压缩，0 表示 codec.DefaultCompressThreshold
+	CompressThreshold int
+	// Checksum 为 true 时双方为每个消息附带 CRC-32C 校验和，数据在传输中损坏时调用以 DataLoss 错误结束，
+	// 而不是反序列化出错误的数据
+	Checksum bool `json:",omitempty"`
+	// OnStateChange 在连接的状态变化时以连接的服务端地址调用，握手开始前以 StateConnecting 调用，
+	// 握手成功后以 StateReady 调用，握手失败时以 StateClosed 调用。
+	// 使用同一个 Option 的所有连接共用该回调，由 target 区分，见 ConnState 与 Transport.OnStateChange
+	OnStateChange func(target string, state ConnState) `json:"-"`
+	// LegacyHandshake 为 true 时客户端以换行分隔的 JSON 发送握手，用于连接只支持该格式的旧版本服务端，
+	// 服务端自动识别两种格式
+	LegacyHandshake bool `json:"-"`
+	// AuthToken 为客户端在握手时发送的凭证，由服务端的 AuthFunc 校验，未使用 TLS 时以明文传输
+	AuthToken string `json:",omitempty"`
+
+	DialFunc       DialFunc     `json:"-"` // 客户端建立连接的方式，为 nil 时使用 net.Dialer
+	RetryPolicy    *RetryPolicy `json:"-"` // 客户端对所有调用的重试策略，为 nil 时不重试
+	MaxMessageSize int          `json:"-"` // 客户端收发的单个消息 body 的最大字节数，0 表示 codec.DefaultMaxMessageSize
+	EncryptionKey  []byte       `json:"-"` // 与服务端预共享的 AES 密钥，不为空时使用 AES-GCM 加密消息，见 WithEncryptionKey
+	StatsHandler   StatsHandler `json:"-"` // 客户端的指标采集，不会发送给服务端
+	Logger         Logger       `json:"-"` // 客户端的日志，为 nil 时不输出日志
+
+	// MaxInFlightCalls 限制一个连接上尚未完成的调用数（共享 Transport 的 Client 共用该上限），达到上限时新的调用等待其他调用完成，0 表示不限制
+	MaxInFlightCalls int `json:"-"`
+	// InFlightFailFast 为 true 时，达到 MaxInFlightCalls 的调用不再等待，立即以 ErrTooManyCalls 结束
+	InFlightFailFast bool `json:"-"`
+	// DoneChanSize 为 Go 的 done 参数为 nil 时创建的 channel 的容量，0 表示 DefaultDoneChanSize
+	DoneChanSize int `json:"-"`
+	// MaxChunkedReplySize 为客户端接收的分块响应（见 WithChunkedReplies）拼接后的最大字节数，0 表示 DefaultMaxChunkedReplySize
+	MaxChunkedReplySize int `json:"-"`
+	// ReadBufferSize 与 WriteBufferSize 为客户端连接读写缓冲的大小，0 表示默认的 4096 字节，见 WithBufferSizes
+	ReadBufferSize  int `json:"-"`
+	WriteBufferSize int `json:"-"`
+	// FlushDelay 与 FlushThreshold 为客户端写入请求后刷新写缓冲的策略，FlushDelay 为 0 时每个请求立即刷新，见 WithFlushPolicy
+	FlushDelay     time.Duration `json:"-"`
+	FlushThreshold int           `json:"-"`
 }
 
 var DefaultOption = &Option{
@@ -36,177 +97,968 @@ var DefaultOption = &Option{
 	ConnectTimeout: time.Second * 10,
 }
 
+// handshake 为客户端发送的握手请求，Encrypted 表示客户端是否使用预共享密钥加密消息
+type handshake struct {
+	*Option
+	Encrypted bool   `json:",omitempty"`
+	Nonce     []byte `json:",omitempty"` // 加密时客户端生成的随机数，与服务端的随机数一起派生本次连接的密钥
+	Chunked   bool   `json:",omitempty"` // 客户端能否接收分块发送的响应，见 WithChunkedReplies
+}
+
+// handshakeAck 为服务端对客户端 Option 的应答，Error 不为空时表示握手失败，服务端随后关闭连接
+type handshakeAck struct {
+	Version    int
+	CodecType  codec.Type
+	Compressor string `json:",omitempty"`
+	Checksum   bool   `json:",omitempty"` // 双方是否为消息附带校验和，见 Option.Checksum
+	Nonce      []byte `json:",omitempty"` // 加密时服务端生成的随机数，见 handshake.Nonce
+	Error      string `json:",omitempty"`
+	Code       Code   `json:",omitempty"` // 握手失败的错误码，例如认证失败时为 Unauthenticated
+}
+
+// 发生错误时作为响应的 body，编码为长度为 0 的 body
+var invalidRequest interface{}
+
 type Request struct {
 	H          *codec.Header
 	Arg, Reply reflect.Value
+	Peer       *Peer
 	mtype      *methodType
 	svc        *service
+	stream     *ServerStream
+	window     *streamWindow // 分块发送响应的流控窗口，为 nil 时不分块
+	progress   bool          // 客户端是否接收进度，见 ReportProgress
+	raw        bool          // 请求与响应的 body 为原始字节，见 WithRawBody
+	cancel     context.CancelFunc
+	bytesIn    int64 // 请求消息的字节数
+	bytesOut   int64 // 响应消息的字节数，流式调用时为所有消息之和，需原子访问
+}
+
+// 返回请求调用的方法。找到了服务时服务名为注册时的名称而不是调用方使用的别名，
+// 鉴权、统计与访问日志与拦截器看到相同的名称，别名无法绕过方法级的权限检查
+func (req *Request) serviceMethod() string {
+	if req.svc == nil || req.mtype == nil {
+		return req.H.ServiceMethod
+	}
+	return req.svc.methodName(req.mtype)
+}
+
+// requestSet 记录一个连接上正在处理的请求，用于取消请求以及向流投递消息
+type requestSet struct {
+	mu         sync.Mutex
+	reqs       map[uint64]*Request
+	lastActive time.Time  // 上次收到消息或请求结束的时间
+	free       []*Request // 可以复用的已结束的请求，见 alloc
+}
+
+func (set *requestSet) add(req *Request) {
+	set.mu.Lock()
+	defer set.mu.Unlock()
+	if set.reqs == nil {
+		set.reqs = make(map[uint64]*Request)
+	}
+	set.reqs[req.H.Seq] = req
+}
+
+// 返回正在处理的请求数
+func (set *requestSet) len() int {
+	set.mu.Lock()
+	defer set.mu.Unlock()
+	return len(set.reqs)
+}
+
+// 记录连接上的活动
+func (set *requestSet) touch() {
+	set.mu.Lock()
+	defer set.mu.Unlock()
+	set.lastActive = time.Now()
+}
+
+// 返回连接空闲的时长，存在正在处理的请求时返回 0
+func (set *requestSet) idle() time.Duration {
+	set.mu.Lock()
+	defer set.mu.Unlock()
+	if len(set.reqs) > 0 {
+		return 0
+	}
+	return time.Since(set.lastActive)
+}
+
+func (set *requestSet) get(seq uint64) *Request {
+	set.mu.Lock()
+	defer set.mu.Unlock()
+	return set.reqs[seq]
+}
+
+func (set *requestSet) remove(seq uint64) {
+	set.mu.Lock()
+	defer set.mu.Unlock()
+	delete(set.reqs, seq)
+	set.lastActive = time.Now()
 }
 
 type Server struct {
 	serviceMap sync.Map
+	registerMu sync.Mutex // 串行化服务的注册、注销与替换，serviceMap 的读取不需要加锁
+	tlsConfig  *tls.Config
+	clientCAs  *x509.CertPool // 见 WithClientCAs，在 NewServer 中应用到 tlsConfig
+	configErr  error          // 选项组合无效时的错误，此时 Server 拒绝所有连接
+	stats      ServerStatsHandler
+	logger     Logger
+	accessLog  *accessLog
+
+	maxMessageSize  int
+	readBufferSize  int
+	writeBufferSize int
+	flushDelay      time.Duration
+	flushThreshold  int
+	encryptionKey   []byte
+	conns           connLimiter
+
+	handshakeTimeout  time.Duration // 0 表示不限制
+	overloadThreshold int           // 健康检查报告 Overloaded 的正在处理的请求数，见 WithOverloadThreshold
+
+	authFunc     AuthFunc
+	authorizer   Authorizer
+	interceptors []ServerInterceptor
+	scheduler    *scheduler
+	idempotency  *idempotencyCache
+	jobs         *jobService
+	publisher    *Publisher
+	chunkSize    int       // 分块发送响应的阈值，0 表示不分块
+	started      time.Time // NewServer 的时间，用于计算运行时长
+	inflight     int64     // 正在处理的请求数
+
+	mu           sync.Mutex // 保护以下字段
+	listeners    map[net.Listener]struct{}
+	activeConns  map[*serverConn]struct{}
+	shuttingDown bool
+}
+
+// ServerStatsHandler 在服务端处理每个请求的开始与结束时被调用，用于采集指标，见 geerpc/metrics
+type ServerStatsHandler interface {
+	RequestStart(serviceMethod string)
+	// RequestEnd 的 err 为 handler 返回的错误，超时或取消时为对应的 *Error
+	RequestEnd(serviceMethod string, err error, elapsed time.Duration)
+}
+
+// AuthFunc 校验客户端在握手时发送的 Option.AuthToken，返回错误时拒绝该连接，
+// peer 为客户端的地址与 TLS 证书信息
+type AuthFunc func(token string, peer *Peer) error
+
+// ServerOption 用于在 NewServer 时配置 Server
+type ServerOption func(*Server)
+
+// WithTLSConfig 使 Server.Accept 使用 TLS 包装接收到的连接
+func WithTLSConfig(config *tls.Config) ServerOption {
+	return func(s *Server) {
+		s.tlsConfig = config
+	}
+}
+
+// WithLogger 为 Server 设置 Logger，默认不输出日志
+func WithLogger(l Logger) ServerOption {
+	return func(s *Server) {
+		s.logger = l
+	}
+}
+
+// WithAccessLog 为每个请求以 JSON 格式向 w 写入一行访问日志，包括方法、客户端地址、seq、收发字节数、耗时与错误
+func WithAccessLog(w io.Writer) ServerOption {
+	return func(s *Server) {
+		s.accessLog = newAccessLog(w)
+	}
+}
+
+// WithMaxMessageSize 限制服务端收发的单个消息 body 的最大字节数，默认为 codec.DefaultMaxMessageSize，
+// 超过限制的请求与响应以 ResourceExhausted 错误返回给客户端
+func WithMaxMessageSize(n int) ServerOption {
+	return func(s *Server) {
+		s.maxMessageSize = n
+	}
+}
+
+// WithBufferSizes 设置每个连接读写缓冲的大小，<= 0 时使用默认的 4096 字节。
+// 较大的写缓冲可以减少大消息的系统调用次数，较小的缓冲可以减少大量空闲连接占用的内存
+func WithBufferSizes(read, write int) ServerOption {
+	return func(s *Server) {
+		s.readBufferSize, s.writeBufferSize = read, write
+	}
+}
+
+// WithFlushPolicy 设置写入响应后刷新连接写缓冲的策略，delay <= 0 时每条响应写入后立即刷新（默认）。
+// delay > 0 时缓冲的字节数达到 threshold 后立即刷新，否则最多延迟 delay 后刷新，
+// 高 QPS 的小消息可以合并为一次系统调用，代价是响应最多增加 delay 的延迟，见 codec.FlushPolicySetter
+func WithFlushPolicy(delay time.Duration, threshold int) ServerOption {
+	return func(s *Server) {
+		s.flushDelay, s.flushThreshold = delay, threshold
+	}
+}
+
+// WithHandshakeTimeout 设置连接完成握手的最长时间，默认为 DefaultHandshakeTimeout，d <= 0 时不限制。
+// 超时的连接收到错误码为 DeadlineExceeded 的握手应答后被关闭
+func WithHandshakeTimeout(d time.Duration) ServerOption {
+	return func(s *Server) {
+		s.handshakeTimeout = d
+	}
+}
+
+// WithEncryptionKey 要求客户端使用预共享的 AES 密钥 key 加密消息，适用于无法使用 TLS 的部署，
+// key 的长度须为 16、24 或 32 字节，客户端通过 Option.EncryptionKey 设置相同的密钥。
+// 每个连接、每个方向的密钥由 key 与双方在握手中交换的随机数派生，消息按顺序编号，无法被重放或调换顺序
+func WithEncryptionKey(key []byte) ServerOption {
+	return func(s *Server) {
+		s.encryptionKey = key
+	}
+}
+
+// WithMaxConnections 限制服务端同时保持的连接数，超过时在握手应答中拒绝新的连接
+func WithMaxConnections(n int) ServerOption {
+	return func(s *Server) {
+		s.conns.max = n
+	}
+}
+
+// WithMaxConnectionsPerIP 限制来自同一个 IP 的连接数，避免单个客户端耗尽服务端的文件描述符
+func WithMaxConnectionsPerIP(n int) ServerOption {
+	return func(s *Server) {
+		s.conns.maxPerIP = n
+	}
+}
+
+// WithServerStatsHandler 为 Server 设置 ServerStatsHandler
+func WithServerStatsHandler(h ServerStatsHandler) ServerOption {
+	return func(s *Server) {
+		s.stats = h
+	}
+}
+
+// WithAuthFunc 使用 f 校验每个连接的 Option.AuthToken，认证失败的连接在开始处理请求前被拒绝，
+// 客户端收到错误码为 Unauthenticated 的错误。HTTP 网关从 Authorization: Bearer <token> 中读取凭证
+func WithAuthFunc(f AuthFunc) ServerOption {
+	return func(s *Server) {
+		s.authFunc = f
+	}
+}
+
+// WithAuthorizer 使用 a 对每个请求做方法级的权限检查，例如只允许管理员调用 User.Delete
+func WithAuthorizer(a Authorizer) ServerOption {
+	return func(s *Server) {
+		s.authorizer = a
+	}
+}
+
+// WithInterceptors 为 Server 添加拦截器，先添加的拦截器位于调用链的外层，见 ServerInterceptor
+func WithInterceptors(interceptors ...ServerInterceptor) ServerOption {
+	return func(s *Server) {
+		s.interceptors = append(s.interceptors, interceptors...)
+	}
+}
+
+// WithPriorityScheduling 限制同时执行的 handler 数为 workers，没有空闲的 worker 时请求按优先级（见 WithPriority）排队，
+// 保证健康检查、控制面等高优先级的调用不会被大量的低优先级请求阻塞；流式方法不参与排队
+func WithPriorityScheduling(workers int) ServerOption {
+	return func(s *Server) {
+		s.scheduler = newScheduler(workers)
+	}
+}
+
+// WithClientCAs 要求客户端提供由 pool 签发的证书，需与 WithTLSConfig 一同使用，与选项的顺序无关。
+// 没有 WithTLSConfig 时 Server 拒绝所有连接，而不是在不验证客户端证书的情况下提供服务
+func WithClientCAs(pool *x509.CertPool) ServerOption {
+	return func(s *Server) {
+		s.clientCAs = pool
+	}
 }
 
 func (s *Server) Register(rcvr interface{}) error {
-	service := newService(rcvr)
+	return s.register(newService(rcvr))
+}
+
+// RegisterName 与 Register 相同，但使用 name 作为服务名，rcvr 的类型可以是未导出的，例如 geerpc-gen 生成的适配器
+func (s *Server) RegisterName(name string, rcvr interface{}) error {
+	if name == "" {
+		return errors.New("rpc: no service name")
+	}
+	return s.register(newNamedService(name, rcvr))
+}
+
+// RegisterAlias 使 alias 成为已注册的服务 name 的别名，调用 alias.Method 与调用 name.Method 相同，
+// 两者共用同一个实现与调用统计。用于重命名服务时同时接受旧的与新的服务名，直到所有客户端完成迁移
+func (s *Server) RegisterAlias(alias, name string) error {
+	if alias == "" {
+		return errors.New("rpc: no service alias")
+	}
+	s.registerMu.Lock()
+	defer s.registerMu.Unlock()
+	svci, ok := s.serviceMap.Load(name)
+	if !ok {
+		return errors.New("rpc: service not found: " + name)
+	}
+	if _, dup := s.serviceMap.LoadOrStore(alias, svci); dup {
+		return errors.New("rpc: service already defined: " + alias)
+	}
+	s.logger.Debugf("rpc server: register %s as alias of %s", alias, name)
+	return nil
+}
+
+// Unregister 注销服务 name，name 为服务名时同时注销其所有别名，为别名时只注销该别名。
+// 之后的调用返回 NotFound，已经开始处理的调用不受影响，会在原来的实现上完成
+func (s *Server) Unregister(name string) error {
+	s.registerMu.Lock()
+	defer s.registerMu.Unlock()
+	svci, ok := s.serviceMap.Load(name)
+	if !ok {
+		return errors.New("rpc: service not found: " + name)
+	}
+	if svc := svci.(*service); svc.name == name {
+		s.rebind(svc, nil)
+	} else {
+		s.serviceMap.Delete(name)
+	}
+	s.logger.Debugf("rpc server: unregister %s", name)
+	return nil
+}
+
+// Replace 以 rcvr 原子地替换同名的服务，服务不存在时与 Register 相同，见 ReplaceName
+func (s *Server) Replace(rcvr interface{}) error {
+	return s.replace(newService(rcvr))
+}
+
+// ReplaceName 以 rcvr 原子地替换服务 name，原服务的别名同时指向新的实现，服务不存在时与 RegisterName 相同。
+// name 为别名时替换其指向的服务，新的实现沿用原来的服务名。
+// 替换之后的调用由新的实现处理，已经开始处理的调用在原来的实现上完成，用于在长期运行的进程中重新加载插件或模块
+func (s *Server) ReplaceName(name string, rcvr interface{}) error {
+	if name == "" {
+		return errors.New("rpc: no service name")
+	}
+	return s.replace(newNamedService(name, rcvr))
+}
+
+func (s *Server) replace(svc *service) error {
+	svc.logger = s.logger
+	s.registerMu.Lock()
+	defer s.registerMu.Unlock()
+	if old, ok := s.serviceMap.Load(svc.name); ok {
+		// 通过别名替换时保留原来的服务名，使授权与统计仍按服务名进行
+		svc.name = old.(*service).name
+		s.rebind(old.(*service), svc)
+	}
+	s.serviceMap.Store(svc.name, svc)
+	for name := range svc.method {
+		s.logger.Debugf("rpc server: replace %s.%s", svc.name, name)
+	}
+	return nil
+}
+
+// 将指向 old 的服务名与别名改为指向 svc，svc 为 nil 时删除，调用方需持有 s.registerMu
+func (s *Server) rebind(old, svc *service) {
+	s.serviceMap.Range(func(key, value interface{}) bool {
+		if value.(*service) == old {
+			if svc == nil {
+				s.serviceMap.Delete(key)
+			} else {
+				s.serviceMap.Store(key, svc)
+			}
+		}
+		return true
+	})
+}
+
+func (s *Server) register(service *service) error {
+	service.logger = s.logger
+	s.registerMu.Lock()
+	defer s.registerMu.Unlock()
 	if _, dup := s.serviceMap.LoadOrStore(service.name, service); dup {
 		return errors.New("rpc: service already defined: " + service.name)
 	}
+	for name := range service.method {
+		s.logger.Debugf("rpc server: register %s.%s", service.name, name)
+	}
 	return nil
 }
 
-// Accept 监听连接处理
+// Accept 监听连接处理，选项无效时（见 WithClientCAs）直接关闭 list
 func (s *Server) Accept(list net.Listener) {
+	if s.configErr != nil || !s.trackListener(list, true) {
+		_ = list.Close()
+		return
+	}
+	defer s.trackListener(list, false)
 	for {
 		conn, err := list.Accept()
 		if err != nil {
 			return
 		}
+		if s.tlsConfig != nil {
+			conn = tls.Server(conn, s.tlsConfig)
+		}
 		go s.handleConn(conn)
 	}
 }
 
+// ServeConn 在 conn 上提供服务，直到连接断开，用于自行接收连接的传输方式，例如 WebSocket 或多路复用的流。
+// conn 不是 net.Conn 时没有客户端地址，ReadTimeout 与 WriteTimeout 也不生效
+func (s *Server) ServeConn(conn io.ReadWriteCloser) {
+	c, ok := conn.(net.Conn)
+	if !ok {
+		c = rwcConn{conn}
+	}
+	s.handleConn(c)
+}
+
+// rwcConn 将 io.ReadWriteCloser 适配为 net.Conn，设置截止时间不生效
+type rwcConn struct {
+	io.ReadWriteCloser
+}
+
+func (rwcConn) LocalAddr() net.Addr              { return rwcAddr{} }
+func (rwcConn) RemoteAddr() net.Addr             { return rwcAddr{} }
+func (rwcConn) SetDeadline(time.Time) error      { return nil }
+func (rwcConn) SetReadDeadline(time.Time) error  { return nil }
+func (rwcConn) SetWriteDeadline(time.Time) error { return nil }
+
+type rwcAddr struct{}
+
+func (rwcAddr) Network() string { return "rwc" }
+func (rwcAddr) String() string  { return "rwc" }
+
 // 处理连接
 func (s *Server) handleConn(conn net.Conn) {
 	defer func() {
-		log.Printf("[server] conn close %s", conn.RemoteAddr().String())
+		s.logger.Debugf("rpc server: conn close %s", conn.RemoteAddr().String())
 		_ = conn.Close()
 	}()
 
-	opt := Option{}
-	if err := json.NewDecoder(conn).Decode(&opt); err != nil {
-		return
+	ip := remoteIP(conn.RemoteAddr())
+	limitErr := s.conns.acquire(ip)
+	if limitErr == nil {
+		defer s.conns.release(ip)
 	}
 
-	if opt.MagicNumber != MagicNumber {
+	// 握手（包括 TLS 握手）须在 handshakeTimeout 内完成，避免不发送 Option 的连接一直占用资源
+	_ = conn.SetReadDeadline(s.handshakeDeadline())
+	peer, err := newPeer(conn)
+	if err != nil {
+		s.logger.Errorf("rpc server: handshake with %s failed: %v", conn.RemoteAddr().String(), err)
 		return
 	}
 
-	f := codec.NewCodecFuncMap[opt.CodecType]
-	if f == nil {
+	opt := Option{}
+	hs := handshake{Option: &opt}
+	ack := handshakeAck{Version: ProtocolVersion}
+	var seal, open cipher.AEAD
+	rconn, legacy, err := readHandshake(conn, &hs)
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		ack.Code, ack.Error = DeadlineExceeded, fmt.Sprintf("no option received within %s", s.handshakeTimeout)
+	} else if err != nil {
+		ack.Code, ack.Error = InvalidArgument, "invalid option: "+err.Error()
+	} else if s.configErr != nil {
+		ack.Code, ack.Error = Internal, s.configErr.Error()
+	} else if s.isShuttingDown() {
+		ack.Code, ack.Error = Unavailable, "server is shutting down"
+	} else if limitErr != nil {
+		ack.Code, ack.Error = ResourceExhausted, limitErr.Error()
+	} else if opt.MagicNumber != MagicNumber {
+		ack.Code, ack.Error = InvalidArgument, fmt.Sprintf("invalid magic number %x", opt.MagicNumber)
+	} else if codec.NewCodecFuncMap[opt.CodecType] == nil {
+		ack.Code, ack.Error = InvalidArgument, fmt.Sprintf("unsupported codec type %q", opt.CodecType)
+	} else if opt.Compressor != "" && codec.GetCompressor(opt.Compressor) == nil {
+		ack.Code, ack.Error = InvalidArgument, fmt.Sprintf("unsupported compressor %q", opt.Compressor)
+	} else if hs.Encrypted && s.encryptionKey == nil {
+		ack.Code, ack.Error = FailedPrecondition, "encryption is not enabled"
+	} else if !hs.Encrypted && s.encryptionKey != nil {
+		ack.Code, ack.Error = FailedPrecondition, "encryption is required"
+	} else if hs.Encrypted && len(hs.Nonce) != handshakeNonceSize {
+		ack.Code, ack.Error = FailedPrecondition, "encryption requires a handshake nonce"
+	} else if seal, open, ack.Nonce, err = s.newCipher(hs.Nonce); err != nil {
+		ack.Code, ack.Error = Internal, "invalid encryption key"
+	} else if err = s.authenticate(opt.AuthToken, peer); err != nil {
+		ack.Code, ack.Error = Unauthenticated, "unauthenticated: "+err.Error()
+	} else {
+		ack.CodecType, ack.Compressor, ack.Checksum = opt.CodecType, opt.Compressor, opt.Checksum
+	}
+	// 握手失败时同样应答，客户端可以立即得到失败的原因，而不是等待到超时
+	_ = conn.SetWriteDeadline(s.handshakeDeadline())
+	if err := writeHandshake(conn, ack, legacy); err != nil {
+		return
+	}
+	if ack.Error != "" {
+		s.logger.Errorf("rpc server: handshake with %s failed: %s", conn.RemoteAddr().String(), ack.Error)
 		return
 	}
+	_ = conn.SetDeadline(time.Time{})
 
-	s.serveCodec(f(conn), opt.HandleTimeout)
+	cc := codec.NewCodecFuncMap[opt.CodecType](rconn)
+	setBufferSizes(cc, s.readBufferSize, s.writeBufferSize)
+	setFlushPolicy(cc, s.flushDelay, s.flushThreshold)
+	setMaxMessageSize(cc, s.maxMessageSize)
+	setCompressor(cc, opt.Compressor, opt.CompressThreshold)
+	setCipher(cc, seal, open)
+	setChecksum(cc, opt.Checksum)
+	setTimeouts(cc, opt.ReadTimeout, opt.WriteTimeout)
+	s.serveCodec(cc, peer, &opt, hs.Chunked && s.chunkSize > 0)
 }
 
-func (s *Server) serveCodec(f codec.Codec, timeout time.Duration) {
+// 返回握手的截止时间，不限制时返回零值
+func (s *Server) handshakeDeadline() time.Time {
+	if s.handshakeTimeout <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(s.handshakeTimeout)
+}
+
+// 返回 dec 解码完成后继续读取 conn 的连接，用于以换行分隔的 JSON 握手。
+// json.Decoder 可能已经读入了属于后续消息的数据，同时跳过 json.Encoder 在 JSON 之后写入的换行符
+func afterJSON(dec *json.Decoder, conn net.Conn) (net.Conn, error) {
+	r := bufio.NewReader(io.MultiReader(dec.Buffered(), conn))
+	if b, err := r.ReadByte(); err != nil {
+		return nil, err
+	} else if b != '\n' {
+		_ = r.UnreadByte()
+	}
+	return &bufferedConn{Conn: conn, r: r}, nil
+}
+
+// bufferedConn 先读取 r 中缓存的数据，再读取连接
+type bufferedConn struct {
+	net.Conn
+	r io.Reader
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
+
+// 为 cc 设置消息的最大字节数，cc 未实现 codec.SizeLimiter 时忽略
+func setMaxMessageSize(cc codec.Codec, n int) {
+	if l, ok := cc.(codec.SizeLimiter); ok {
+		l.SetMaxMessageSize(n)
+	}
+}
+
+// 为 cc 设置压缩算法，name 为空或 cc 未实现 codec.CompressorSetter 时忽略
+func setCompressor(cc codec.Codec, name string, threshold int) {
+	if s, ok := cc.(codec.CompressorSetter); ok && name != "" {
+		s.SetCompressor(codec.GetCompressor(name), threshold)
+	}
+}
+
+// 为 cc 设置发送与接收的加密算法，seal 为 nil 或 cc 未实现 codec.CipherSetter 时忽略
+func setCipher(cc codec.Codec, seal, open cipher.AEAD) {
+	if s, ok := cc.(codec.CipherSetter); ok && seal != nil {
+		s.SetCipher(seal, open)
+	}
+}
+
+// 为 cc 开启消息的校验和，enabled 为 false 或 cc 未实现 codec.ChecksumSetter 时忽略
+func setChecksum(cc codec.Codec, enabled bool) {
+	if s, ok := cc.(codec.ChecksumSetter); ok && enabled {
+		s.SetChecksum(true)
+	}
+}
+
+// 为 cc 设置刷新写缓冲的策略，cc 未实现 codec.FlushPolicySetter 时忽略
+func setFlushPolicy(cc codec.Codec, delay time.Duration, threshold int) {
+	if s, ok := cc.(codec.FlushPolicySetter); ok {
+		s.SetFlushPolicy(delay, threshold)
+	}
+}
+
+// 为 cc 设置读写超时，cc 未实现 codec.TimeoutSetter 时忽略
+func setTimeouts(cc codec.Codec, read, write time.Duration) {
+	if s, ok := cc.(codec.TimeoutSetter); ok {
+		s.SetTimeouts(read, write)
+	}
+}
+
+// 使用 AuthFunc 校验 token，未设置 AuthFunc 时不校验
+func (s *Server) authenticate(token string, peer *Peer) error {
+	if s.authFunc == nil {
+		return nil
+	}
+	return s.authFunc(token, peer)
+}
+
+// 返回服务端发送与接收使用的加密算法与服务端的握手随机数，没有设置密钥时返回 nil
+func (s *Server) newCipher(clientNonce []byte) (seal, open cipher.AEAD, nonce []byte, err error) {
+	if s.encryptionKey == nil {
+		return nil, nil, nil, nil
+	}
+	if nonce, err = newHandshakeNonce(); err != nil {
+		return nil, nil, nil, err
+	}
+	salt := append(append([]byte(nil), clientNonce...), nonce...)
+	seal, open, err = codec.NewSessionAESGCM(s.encryptionKey, salt, false)
+	return seal, open, nonce, err
+}
+
+// 返回 cc 已读取与已写入的字节数，cc 未实现 codec.Counter 时返回 0
+func codecBytes(cc codec.Codec) (read, written int64) {
+	if c, ok := cc.(codec.Counter); ok {
+		return c.BytesRead(), c.BytesWritten()
+	}
+	return 0, 0
+}
+
+// chunked 表示是否向该连接分块发送大的响应
+func (s *Server) serveCodec(f codec.Codec, peer *Peer, opt *Option, chunked bool) {
 	sending := new(sync.Mutex)
 	wg := new(sync.WaitGroup)
+	timeout := opt.HandleTimeout
+
+	reqs := new(requestSet)
+	reqs.touch()
+	sc := &serverConn{cc: f, sending: sending, reqs: reqs}
+	if !s.trackConn(sc, true) {
+		return
+	}
+	defer s.trackConn(sc, false)
+
+	// 连接断开时取消所有正在处理的请求，并结束等待中的反向调用
+	reverse := newReverseClient(f, sending)
+	defer reverse.terminate()
+	ctx, cancel := context.WithCancel(NewPeerContext(context.Background(), peer))
+	ctx = context.WithValue(ctx, reverseClientKey{}, reverse)
+	defer cancel()
+	if opt.IdleTimeout > 0 {
+		go s.closeIdle(ctx, f, reqs, opt.IdleTimeout)
+	}
 
 	for {
-		req, err := s.readRequest(f)
+		read, _ := codecBytes(f)
+		req, err := s.readRequest(f, reqs, sending, reverse)
+		if req != nil {
+			n, _ := codecBytes(f)
+			req.bytesIn = n - read
+		}
+		if req == nil && err == nil {
+			// 取消请求或发往已建立的流的消息
+			continue
+		}
 		if err != nil {
-			break
+			if req == nil {
+				break
+			}
+			// 服务不存在或参数错误，返回错误后继续处理后续请求
+			req.Peer = peer
+			s.rejectRequest(f, req, err, sending)
+			reqs.release(req)
+			continue
+		}
+		req.Peer = peer
+		if limit := opt.MaxConcurrentRequests; limit > 0 && reqs.len() >= limit {
+			s.rejectRequest(f, req, Errorf(Unavailable, "rpc server: too many concurrent requests, limit %d", limit), sending)
+			reqs.release(req)
+			continue
+		}
+		if req.mtype.stream {
+			req.stream = newServerStream(s, f, req, sending)
+		} else if chunked {
+			req.window = newChunkWindow()
 		}
+		// 在读取后续消息前登记请求，以便处理取消与流消息
+		var reqCtx context.Context
+		reqCtx, req.cancel = context.WithCancel(ctx)
+		reqs.add(req)
 
 		wg.Add(1)
-		go s.handleRequest(f, req, sending, wg, timeout)
+		go s.handleRequest(reqCtx, f, req, sending, wg, timeout, reqs)
 	}
+	cancel()
 	wg.Wait()
 }
 
-func (s *Server) readRequest(cc codec.Codec) (*Request, error) {
+// 不处理 req，直接返回错误
+func (s *Server) rejectRequest(cc codec.Codec, req *Request, err error, sending *sync.Mutex) {
+	req.bytesOut = s.sendError(cc, req, err, sending)
+	s.accessLog.log(req, err, 0)
+}
+
+// 以 err 响应请求，返回发送的字节数
+func (s *Server) sendError(cc codec.Codec, req *Request, err error, sending *sync.Mutex) int64 {
+	setHeaderError(req.H, err)
+	if req.H.Flags&codec.FlagStream != 0 {
+		// 流式调用需要结束标记，客户端才会结束流
+		req.H.Flags |= codec.FlagEndStream
+	}
+	n, _ := s.sendResponse(cc, req.H, invalidRequest, sending)
+	return n
+}
+
+// 连接上没有正在处理的请求且超过 timeout 未收到消息时关闭连接
+func (s *Server) closeIdle(ctx context.Context, cc codec.Codec, reqs *requestSet, timeout time.Duration) {
+	ticker := time.NewTicker(timeout / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if idle := reqs.idle(); idle >= timeout {
+				s.logger.Infof("rpc server: close connection idle for %s", idle)
+				_ = cc.Close()
+				return
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// 读取请求，取消请求与 ping 等控制帧、分块窗口更新、反向调用的响应、发往已建立的流的消息在此直接处理，并返回 nil, nil
+func (s *Server) readRequest(cc codec.Codec, reqs *requestSet, sending *sync.Mutex, reverse *ReverseClient) (*Request, error) {
 	// 读取 Header
-	header := &codec.Header{}
+	req := reqs.alloc()
+	header := req.H
 	if err := cc.ReadHeader(header); err != nil {
 		return nil, err
 	}
+	reqs.touch()
 
-	// 读取 request
-	req := &Request{H: header}
+	if handled, err := s.readControl(cc, header, reqs, sending, reverse); handled {
+		reqs.release(req)
+		return nil, err
+	}
+
+	// 读取 request，进度标记只用于请求，不随响应返回
+	req.progress = header.Flags&codec.FlagProgress != 0
+	req.raw = header.Flags&codec.FlagRaw != 0
+	header.Flags &^= codec.FlagProgress | codec.FlagRaw
 	var err error
 	req.svc, req.mtype, err = s.findService(header.ServiceMethod)
 	if err != nil {
+		if err := cc.ReadBody(nil); err != nil {
+			return nil, err
+		}
 		return req, err
 	}
 
 	req.Arg = req.mtype.newArgv()
 	req.Reply = req.mtype.newReply()
+	if req.raw {
+		if err := readRawArgs(cc, req); err != nil {
+			return req, err
+		}
+		s.logger.Debugf("rpc server: read raw request %s seq:%v", req.H.ServiceMethod, req.H.Seq)
+		return req, nil
+	}
 
 	args := req.Arg.Interface()
 	if req.Arg.Kind() != reflect.Ptr {
 		args = req.Arg.Addr().Interface()
 	}
 	if err := cc.ReadBody(args); err != nil {
-		return req, err
+		return req, readArgvError(err)
 	}
 
-	log.Printf("[server] read request %s seq:%v", req.svc.name, req.H.Seq)
+	s.logger.Debugf("rpc server: read request %s seq:%v", req.H.ServiceMethod, req.H.Seq)
 	return req, nil
 }
 
-func (s *Server) sendResponse(cc codec.Codec, h *codec.Header, body interface{}, sending *sync.Mutex) error {
-	sending.Lock()
-	defer sending.Unlock()
-	log.Println("Sending response")
-	if err := cc.Write(h, body); err != nil {
-		return err
+// 读取请求参数出错时返回给客户端的错误
+func readArgvError(err error) error {
+	code := InvalidArgument
+	switch {
+	case errors.Is(err, codec.ErrMessageTooLarge):
+		code = ResourceExhausted
+	case errors.Is(err, codec.ErrChecksumMismatch):
+		code = DataLoss
+	}
+	return Errorf(code, "rpc server: read argv err: %v", err)
+}
+
+// 处理控制帧与发往已建立的流的消息，header 不是新的请求时返回 true
+func (s *Server) readControl(cc codec.Codec, header *codec.Header, reqs *requestSet, sending *sync.Mutex, reverse *ReverseClient) (bool, error) {
+	if header.Flags&codec.FlagPing != 0 {
+		if err := cc.ReadBody(nil); err != nil {
+			return true, err
+		}
+		_, err := s.sendResponse(cc, &codec.Header{Seq: header.Seq, Flags: codec.FlagPong}, nil, sending)
+		return true, err
 	}
 
-	return nil
+	if header.Flags&codec.FlagCancel != 0 {
+		if req := reqs.get(header.Seq); req != nil {
+			req.cancel()
+		}
+		return true, cc.ReadBody(nil)
+	}
+
+	if header.Flags&codec.FlagChunk != 0 {
+		return true, receiveChunkWindow(cc, header, reqs)
+	}
+
+	if header.Flags&codec.FlagReverse != 0 {
+		return true, reverse.receive(cc, header)
+	}
+
+	if header.Flags&codec.FlagStream != 0 {
+		if req := reqs.get(header.Seq); req != nil && req.stream != nil {
+			return true, req.stream.receive(cc, header)
+		}
+		if header.ServiceMethod == "" {
+			// 流已结束
+			return true, cc.ReadBody(nil)
+		}
+	}
+	return false, nil
+}
+
+// 发送响应，返回写入的字节数
+func (s *Server) sendResponse(cc codec.Codec, h *codec.Header, body interface{}, sending *sync.Mutex) (int64, error) {
+	sending.Lock()
+	defer sending.Unlock()
+	_, before := codecBytes(cc)
+	err := cc.Write(h, body)
+	_, after := codecBytes(cc)
+	return after - before, err
 }
 
-func (s *Server) handleRequest(cc codec.Codec, req *Request, sending *sync.Mutex, wg *sync.WaitGroup, timeout time.Duration) {
-	log.Printf("[server] handle request seq:%v, %v\n", req.H.Seq, req.H.ServiceMethod)
+func (s *Server) handleRequest(ctx context.Context, cc codec.Codec, req *Request, sending *sync.Mutex, wg *sync.WaitGroup, timeout time.Duration, reqs *requestSet) {
+	s.logger.Debugf("rpc server: handle request seq:%v, %v", req.H.Seq, req.H.ServiceMethod)
 	defer wg.Done()
+	// handler 与发送响应都已结束时归还 req，超时后 handler 可能仍在使用 req，流式调用的 req 由 ServerStream 引用
+	var reusable bool
+	defer func() {
+		if reusable {
+			reqs.release(req)
+		}
+	}()
+	atomic.AddInt64(&s.inflight, 1)
+	defer atomic.AddInt64(&s.inflight, -1)
+	defer req.cancel()
+	defer reqs.remove(req.H.Seq)
+
+	var cancel context.CancelFunc
+	// 取 HandleTimeout 与客户端传递的截止时间中较早的一个
+	deadline, hasDeadline := req.H.Deadline, !req.H.Deadline.IsZero()
+	if timeout != 0 && (!hasDeadline || time.Now().Add(timeout).Before(deadline)) {
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+		hasDeadline = false
+	}
+	if hasDeadline {
+		ctx, cancel = context.WithDeadline(ctx, deadline)
+		defer cancel()
+	}
+	ctx, rmd := newMetadataContext(ctx, req.H.Metadata)
+	if req.H.Priority != 0 {
+		ctx = context.WithValue(ctx, priorityKey{}, req.H.Priority)
+	}
+	if req.progress {
+		ctx = context.WithValue(ctx, progressKey{}, &progressReporter{s: s, cc: cc, seq: req.H.Seq, sending: sending})
+	}
+
+	var err error
+	start := time.Now()
+	if s.stats != nil {
+		s.stats.RequestStart(req.serviceMethod())
+	}
+	defer func() {
+		elapsed := time.Since(start)
+		req.mtype.latency.record(elapsed, err)
+		if s.stats != nil {
+			s.stats.RequestEnd(req.serviceMethod(), err, elapsed)
+		}
+		s.accessLog.log(req, err, elapsed)
+	}()
+	// 在 handler 之外运行的用户代码（例如 Authorizer）发生 panic 时同样以 Internal 错误响应，避免整个进程崩溃，
+	// 拦截器与 handler 的 panic 由 Server.call 处理
+	defer func() {
+		if r := recover(); r != nil {
+			err = s.panicError(req.serviceMethod(), r)
+			atomic.AddInt64(&req.bytesOut, s.sendError(cc, req, err, sending))
+		}
+	}()
+	if err = s.authorize(ctx, req.serviceMethod()); err != nil {
+		atomic.AddInt64(&req.bytesOut, s.sendError(cc, req, err, sending))
+		reusable = !req.mtype.stream
+		return
+	}
+	if req.mtype.stream {
+		err = s.handleStream(ctx, req, rmd)
+		return
+	}
 
-	called := make(chan struct{})
-	sent := make(chan struct{})
+	// 超时后 handler 仍可能在运行，使用带缓冲的 channel 避免其阻塞
+	called := make(chan error, 1)
+	sent := make(chan struct{}, 1)
 
 	go func() {
-		err := req.svc.call(req.mtype, req.Arg, req.Reply)
-		called <- struct{}{}
+		err := s.call(ctx, req.svc, req.mtype, req.Arg, req.Reply)
+		req.H.Metadata = rmd.get()
+		called <- err
+		if ctx.Err() != nil {
+			// 超时或连接断开，不再发送响应
+			sent <- struct{}{}
+			return
+		}
 		if err != nil {
-			req.H.Error = err.Error()
-			_ = s.sendResponse(cc, req.H, nil, sending)
+			setHeaderError(req.H, err)
+			n, _ := s.sendResponse(cc, req.H, invalidRequest, sending)
+			atomic.AddInt64(&req.bytesOut, n)
 			sent <- struct{}{}
 			return
 		}
-		_ = s.sendResponse(cc, req.H, req.Reply.Interface(), sending)
+		n, err := s.sendReply(ctx, cc, req, sending)
+		if errors.Is(err, codec.ErrMessageTooLarge) {
+			// 响应超过大小限制时改为返回错误，避免客户端一直等待
+			setHeaderError(req.H, codecError(err))
+			n, _ = s.sendResponse(cc, req.H, invalidRequest, sending)
+		}
+		atomic.AddInt64(&req.bytesOut, n)
 		sent <- struct{}{}
 	}()
 
-	if timeout == 0 {
-		<-called
-		<-sent
-		return
-	}
-
 	select {
-	case <-time.After(timeout):
-		req.H.Error = fmt.Sprintf("rpc server: request handle timeout: expect within %s", timeout)
-		_ = s.sendResponse(cc, req.H, req.Reply, sending)
-	case <-called:
+	case <-ctx.Done():
+		err = &Error{Code: CodeOf(ctx.Err()), Message: "rpc server: " + ctx.Err().Error()}
+		if ctx.Err() == context.DeadlineExceeded {
+			h := codec.Header{
+				ServiceMethod: req.H.ServiceMethod,
+				Seq:           req.H.Seq,
+			}
+			if hasDeadline {
+				setHeaderError(&h, Errorf(DeadlineExceeded, "rpc server: request deadline exceeded"))
+			} else {
+				setHeaderError(&h, Errorf(DeadlineExceeded, "rpc server: request handle timeout: expect within %s", timeout))
+			}
+			n, _ := s.sendResponse(cc, &h, invalidRequest, sending)
+			atomic.AddInt64(&req.bytesOut, n)
+		}
+	case err = <-called:
 		<-sent
+		reusable = true
 	}
-
-	_ = s.sendResponse(cc, req.H, req.Reply.Interface(), sending)
 }
 
 func (s *Server) findService(serviceMethod string) (svc *service, mtype *methodType, err error) {
 	dot := strings.LastIndex(serviceMethod, ".")
 	if dot == -1 {
-		return nil, nil, errors.New("rpc: invalid service method")
+		return nil, nil, Errorf(NotFound, "rpc: invalid service method %q", serviceMethod)
 	}
 
 	serviceName, methodName := serviceMethod[:dot], serviceMethod[dot+1:]
 	svci, ok := s.serviceMap.Load(serviceName)
 	if !ok {
-		return nil, nil, errors.New("rpc: service not found: " + serviceName)
+		return nil, nil, Errorf(NotFound, "rpc: service not found: %s", serviceName)
 	}
 
 	svc = svci.(*service)
 	mtype = svc.method[methodName]
 	if mtype == nil {
-		return nil, nil, errors.New("rpc: method not found: " + serviceName + "." + methodName)
+		return nil, nil, Errorf(NotFound, "rpc: method not found: %s.%s", serviceName, methodName)
 	}
 
 	return svc, mtype, nil
 }
 
+// ServeHTTP 接受 HTTP/1.x 的 CONNECT 请求，或 HTTP/2 的 POST 与 CONNECT 请求（见 DialHTTP2），
+// 之后在该连接上提供 RPC 服务
 func (s *Server) ServeHTTP(w http.ResponseWriter, req *http.Request) {
-	log.Printf("connection established")
+	if req.ProtoMajor == 2 && (req.Method == http.MethodPost || req.Method == http.MethodConnect) {
+		s.serveHTTP2(w, req)
+		return
+	}
 	if req.Method != "CONNECT" {
 		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
 		w.WriteHeader(http.StatusMethodNotAllowed)
@@ -215,7 +1067,7 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	}
 	conn, _, err := w.(http.Hijacker).Hijack()
 	if err != nil {
-		log.Print("rpc hijacking ", req.RemoteAddr, ": ", err.Error())
+		s.logger.Errorf("rpc hijacking %s: %v", req.RemoteAddr, err)
 		return
 	}
 	_, _ = io.WriteString(conn, "HTTP/1.0 "+connected+"\n\n")
@@ -225,11 +1077,35 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 func (s *Server) HandleHTTP() {
 	http.Handle(defaultRPCPath, s)
 	http.Handle(defaultDebugPath, &debugHTTP{s})
-	log.Println("rpc server debug path:", defaultDebugPath)
+	http.Handle(defaultDebugPath+"/json", &debugJSON{s})
+	s.logger.Infof("rpc server debug path: %s", defaultDebugPath)
 }
 
-func NewServer() *Server {
-	return &Server{}
+func NewServer(opts ...ServerOption) *Server {
+	s := &Server{started: time.Now(), handshakeTimeout: DefaultHandshakeTimeout}
+	for _, opt := range opts {
+		opt(s)
+	}
+	s.logger = loggerOrNop(s.logger)
+	if s.clientCAs != nil {
+		if s.tlsConfig == nil {
+			s.configErr = errors.New("rpc server: WithClientCAs requires WithTLSConfig")
+			s.logger.Errorf("%v", s.configErr)
+		} else {
+			s.tlsConfig = s.tlsConfig.Clone()
+			s.tlsConfig.ClientCAs = s.clientCAs
+			s.tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+	}
+	_ = s.register(newNamedService(ReflectionServiceName, &reflectionService{s}))
+	_ = s.register(newNamedService(HealthServiceName, &healthService{s}))
+	if s.jobs != nil {
+		_ = s.register(newNamedService(JobServiceName, s.jobs))
+	}
+	if s.publisher != nil {
+		_ = s.register(newNamedService(PubSubServiceName, &pubsubService{s.publisher}))
+	}
+	return s
 }
 
 var DefaultServer = NewServer()
@@ -238,10 +1114,35 @@ func Accept(list net.Listener) {
 	DefaultServer.Accept(list)
 }
 
+// ServeConn 使用 DefaultServer 在 conn 上提供服务
+func ServeConn(conn io.ReadWriteCloser) {
+	DefaultServer.ServeConn(conn)
+}
+
 func Register(rcvr interface{}) error {
 	return DefaultServer.Register(rcvr)
 }
 
+func RegisterName(name string, rcvr interface{}) error {
+	return DefaultServer.RegisterName(name, rcvr)
+}
+
+func RegisterAlias(alias, name string) error {
+	return DefaultServer.RegisterAlias(alias, name)
+}
+
+func Unregister(name string) error {
+	return DefaultServer.Unregister(name)
+}
+
+func Replace(rcvr interface{}) error {
+	return DefaultServer.Replace(rcvr)
+}
+
+func ReplaceName(name string, rcvr interface{}) error {
+	return DefaultServer.ReplaceName(name, rcvr)
+}
+
 func HandleHTTP() {
 	DefaultServer.HandleHTTP()
 }
@@ -0,0 +1,1269 @@
+package geerpc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"geerpc/codec"
+)
+
+type Baz struct {
+	cancelled chan error
+}
+
+func (b *Baz) Wait(ctx context.Context, argv int, reply *int) error {
+	<-ctx.Done()
+	b.cancelled <- ctx.Err()
+	return ctx.Err()
+}
+
+// 通过 net.Pipe 建立一对连接，返回连接到 server 的 client
+func pipeClient(t *testing.T, server *Server, opt *Option) *Client {
+	c1, c2 := net.Pipe()
+	go server.handleConn(c2)
+	client, err := NewClient(c1, opt)
+	_assert(err == nil, "failed to create client: %v", err)
+	return client
+}
+
+func TestServer_HandshakeAck(t *testing.T) {
+	server := NewServer()
+	c1, c2 := net.Pipe()
+	go server.handleConn(c2)
+	_, err := NewClient(c1, &Option{MagicNumber: 1, CodecType: DefaultOption.CodecType})
+	_assert(err != nil && strings.Contains(err.Error(), "invalid magic number"), "expect handshake to be rejected, got %v", err)
+	_assert(CodeOf(err) == InvalidArgument, "expect InvalidArgument, got %v", CodeOf(err))
+
+	client := pipeClient(t, server, DefaultOption)
+	_assert(client.Close() == nil, "failed to close client")
+}
+
+func TestServer_HandshakeFraming(t *testing.T) {
+	server := NewServer()
+	_ = server.Register(Echo{})
+	opt := &Option{MagicNumber: MagicNumber, CodecType: DefaultOption.CodecType}
+
+	// 握手与第一个请求在同一次写入中到达，请求的字节不会被握手的解码读入
+	for _, legacy := range []bool{false, true} {
+		c1, c2 := net.Pipe()
+		go server.handleConn(c2)
+		var buf bytes.Buffer
+		_ = writeHandshake(&buf, handshake{Option: opt}, legacy)
+		cc := codec.NewGobCodec(struct {
+			io.Reader
+			io.WriteCloser
+		}{c1, nopWriteCloser{&buf}})
+		_ = cc.Write(&codec.Header{ServiceMethod: "Echo.Repeat", Seq: 1}, 3)
+		go func() { _, _ = c1.Write(buf.Bytes()) }()
+
+		var ack handshakeAck
+		conn, gotLegacy, err := readHandshake(c1, &ack)
+		_assert(err == nil && ack.Error == "" && gotLegacy == legacy, "unexpected handshake ack %+v, legacy %v: %v", ack, gotLegacy, err)
+		cc = codec.NewGobCodec(conn)
+		var h codec.Header
+		var reply string
+		_assert(cc.ReadHeader(&h) == nil && cc.ReadBody(&reply) == nil, "failed to read reply")
+		_assert(h.Error == "" && reply == "xxx", "unexpected reply %q: %s", reply, h.Error)
+		_ = c1.Close()
+	}
+
+	// 旧版本格式的客户端
+	client := pipeClient(t, server, &Option{MagicNumber: MagicNumber, CodecType: DefaultOption.CodecType, LegacyHandshake: true})
+	defer func() { _ = client.Close() }()
+	var reply string
+	err := client.Call(context.Background(), "Echo.Repeat", 2, &reply)
+	_assert(err == nil && reply == "xx", "failed to call with legacy handshake: %v", err)
+
+	// 超过长度限制的握手被拒绝
+	c1, c2 := net.Pipe()
+	go server.handleConn(c2)
+	go func() { _, _ = c1.Write([]byte{0, 0x10, 0, 0}) }()
+	var ack handshakeAck
+	_, _, err = readHandshake(c1, &ack)
+	_assert(err == nil && ack.Code == InvalidArgument && strings.Contains(ack.Error, "exceeds limit"), "unexpected ack %+v: %v", ack, err)
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+func TestServer_HandshakeRejection(t *testing.T) {
+	server := NewServer(WithHandshakeTimeout(time.Millisecond * 50))
+	readAck := func(conn net.Conn) handshakeAck {
+		var ack handshakeAck
+		_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+		_, _, err := readHandshake(conn, &ack)
+		_assert(err == nil, "expect handshake ack, got %v", err)
+		return ack
+	}
+
+	// 不是 JSON 的 Option 立即得到应答
+	c1, c2 := net.Pipe()
+	go server.handleConn(c2)
+	go func() { _, _ = c1.Write([]byte("GET / HTTP/1.1\r\n")) }()
+	ack := readAck(c1)
+	_assert(ack.Code == InvalidArgument && strings.Contains(ack.Error, "invalid option"), "unexpected ack %+v", ack)
+
+	// 不发送 Option 的连接在超时后被拒绝
+	c1, c2 = net.Pipe()
+	go server.handleConn(c2)
+	ack = readAck(c1)
+	_assert(ack.Code == DeadlineExceeded, "expect DeadlineExceeded, got %+v", ack)
+	_, err := c1.Read(make([]byte, 1))
+	_assert(err == io.EOF, "expect connection to be closed, got %v", err)
+}
+
+func TestServer_ServeConn(t *testing.T) {
+	server := NewServer()
+	_ = server.Register(Echo{})
+	c1, c2 := net.Pipe()
+	// 隐藏 net.Conn 的方法，模拟自定义的传输方式
+	go server.ServeConn(struct{ io.ReadWriteCloser }{c2})
+	client, err := NewClient(c1, DefaultOption)
+	_assert(err == nil, "failed to create client: %v", err)
+	defer func() { _ = client.Close() }()
+
+	var reply string
+	err = client.Call(context.Background(), "Echo.Repeat", 2, &reply)
+	_assert(err == nil && reply == "xx", "failed to call over custom transport: %v", err)
+}
+
+type echoAlias struct{ Echo }
+
+func TestServer_RegisterName(t *testing.T) {
+	server := NewServer()
+	_assert(server.RegisterName("", Echo{}) != nil, "expect error for empty service name")
+	// 未导出的类型也可以通过 RegisterName 注册
+	_assert(server.RegisterName("Alias", echoAlias{}) == nil, "failed to register by name")
+	_assert(server.RegisterName("Alias", echoAlias{}) != nil, "expect error for duplicate service name")
+	client := pipeClient(t, server, DefaultOption)
+	defer func() { _ = client.Close() }()
+
+	var reply string
+	err := client.Call(context.Background(), "Alias.Repeat", 3, &reply)
+	_assert(err == nil && reply == "xxx", "failed to call service registered by name: %v", err)
+
+	// 别名与原服务名共用同一个实现
+	_assert(server.RegisterAlias("OldAlias", "Missing") != nil, "expect error for alias of unknown service")
+	_assert(server.RegisterAlias("Alias", "Alias") != nil, "expect error for alias of an existing name")
+	_assert(server.RegisterAlias("OldAlias", "Alias") == nil, "failed to register alias")
+	err = client.Call(context.Background(), "OldAlias.Repeat", 2, &reply)
+	_assert(err == nil && reply == "xx", "failed to call service by alias: %v", err)
+}
+
+func TestServer_Replace(t *testing.T) {
+	old := &Gate{entered: make(chan int, 1), release: make(chan struct{})}
+	server := NewServer()
+	_ = server.Register(old)
+	_assert(server.RegisterAlias("OldGate", "Gate") == nil, "failed to register alias")
+	client := pipeClient(t, server, DefaultOption)
+	defer func() { _ = client.Close() }()
+
+	inflight := client.Go("Gate.Enter", 1, new(int), nil)
+	<-old.entered
+
+	// 替换后新的调用（包括经由别名的调用）由新的实现处理，已开始的调用在原来的实现上完成
+	replaced := &Gate{entered: make(chan int, 2), release: make(chan struct{})}
+	close(replaced.release)
+	_assert(server.Replace(replaced) == nil, "failed to replace service")
+	var reply int
+	err := client.Call(context.Background(), "Gate.Enter", 2, &reply)
+	_assert(err == nil && reply == 2 && <-replaced.entered == 2, "expect new calls to hit the new implementation: %v", err)
+	err = client.Call(context.Background(), "OldGate.Enter", 3, &reply)
+	_assert(err == nil && reply == 3 && <-replaced.entered == 3, "expect alias to follow the replacement: %v", err)
+	close(old.release)
+	call := <-inflight.Done
+	_assert(call.Error == nil && *call.Reply.(*int) == 1, "expect in-flight call to complete on the old implementation: %v", call.Error)
+
+	// 通过别名替换时沿用原来的服务名
+	again := &Gate{entered: make(chan int, 1), release: make(chan struct{})}
+	close(again.release)
+	_assert(server.ReplaceName("OldGate", again) == nil, "failed to replace service by alias")
+	err = client.Call(context.Background(), "Gate.Enter", 6, &reply)
+	_assert(err == nil && <-again.entered == 6, "expect service name to follow the replacement by alias: %v", err)
+	svci, _ := server.serviceMap.Load("OldGate")
+	_assert(svci.(*service).name == "Gate", "expect replacement to keep the service name, got %s", svci.(*service).name)
+
+	// 注销别名只影响别名，注销服务名时同时注销别名
+	_assert(server.RegisterAlias("NewGate", "Gate") == nil, "failed to register alias")
+	_assert(server.Unregister("NewGate") == nil, "failed to unregister alias")
+	_assert(client.Call(context.Background(), "Gate.Enter", 4, &reply) == nil, "expect service to survive alias removal")
+	_assert(server.Unregister("Gate") == nil, "failed to unregister service")
+	_assert(server.Unregister("Gate") != nil, "expect error for unknown service")
+	for _, name := range []string{"Gate", "OldGate", "NewGate"} {
+		err = client.Call(context.Background(), name+".Enter", 5, &reply)
+		_assert(CodeOf(err) == NotFound, "expect NotFound for %s, got %v", name, err)
+	}
+}
+
+func TestServer_ContextCancelled(t *testing.T) {
+	baz := &Baz{cancelled: make(chan error, 1)}
+	server := NewServer()
+	_ = server.Register(baz)
+
+	t.Run("handle timeout", func(t *testing.T) {
+		client := pipeClient(t, server, &Option{MagicNumber: MagicNumber, CodecType: DefaultOption.CodecType, HandleTimeout: time.Millisecond * 100})
+		defer func() { _ = client.Close() }()
+
+		var reply int
+		err := client.Call(context.Background(), "Baz.Wait", 1, &reply)
+		_assert(err != nil && strings.Contains(err.Error(), "handle timeout"), "expect a timeout error, got %v", err)
+		_assert(<-baz.cancelled == context.DeadlineExceeded, "expect handler context to exceed deadline")
+	})
+
+	t.Run("client disconnect", func(t *testing.T) {
+		client := pipeClient(t, server, DefaultOption)
+		client.Go("Baz.Wait", 1, new(int), nil)
+		time.Sleep(time.Millisecond * 100)
+		_ = client.Close()
+		select {
+		case err := <-baz.cancelled:
+			_assert(err == context.Canceled, "expect handler context to be cancelled, got %v", err)
+		case <-time.After(time.Second):
+			t.Fatal("handler context is not cancelled after client disconnect")
+		}
+	})
+}
+
+type Echo struct{}
+
+func (e Echo) Metadata(ctx context.Context, key string, reply *string) error {
+	*reply = MetadataFromContext(ctx)[key]
+	SetResponseMetadata(ctx, "echo", *reply)
+	return nil
+}
+
+func TestServer_Metadata(t *testing.T) {
+	server := NewServer()
+	_ = server.Register(Echo{})
+	client := pipeClient(t, server, DefaultOption)
+	defer func() { _ = client.Close() }()
+
+	var reply string
+	var md Metadata
+	err := client.Call(context.Background(), "Echo.Metadata", "trace-id", &reply,
+		WithMetadata(Metadata{"trace-id": "abc"}), WithResponseMetadata(&md))
+	_assert(err == nil && reply == "abc", "expect request metadata to reach handler, got %q, err: %v", reply, err)
+	_assert(md["echo"] == "abc", "expect response metadata, got %v", md)
+}
+
+// 复用的请求不能带有上一个请求的 metadata、优先级或错误
+func TestServer_RequestReuse(t *testing.T) {
+	server := NewServer(WithBufferSizes(16, 16))
+	_ = server.Register(Echo{})
+	client := pipeClient(t, server, &Option{MagicNumber: MagicNumber, CodecType: DefaultOption.CodecType, ReadBufferSize: 16, WriteBufferSize: 16})
+	defer func() { _ = client.Close() }()
+
+	for i := 0; i < 3; i++ {
+		var reply string
+		var md Metadata
+		err := client.Call(context.Background(), "Echo.Metadata", "trace-id", &reply,
+			WithMetadata(Metadata{"trace-id": "abc"}), WithResponseMetadata(&md))
+		_assert(err == nil && reply == "abc" && md["echo"] == "abc", "failed to call with metadata: %q %v", reply, err)
+
+		md = nil
+		err = client.Call(context.Background(), "Echo.Metadata", "trace-id", &reply, WithResponseMetadata(&md))
+		_assert(err == nil && reply == "" && md["echo"] == "", "expect metadata not to leak into next request, got %q %v", reply, md)
+
+		var priority int
+		_assert(client.Call(context.Background(), "Echo.Priority", 0, &priority, WithPriority(5)) == nil && priority == 5, "failed to call with priority")
+		_assert(client.Call(context.Background(), "Echo.Priority", 0, &priority) == nil && priority == 0, "expect priority to be reset, got %d", priority)
+		err = client.Call(context.Background(), "Echo.Fail", NotFound, &reply)
+		_assert(CodeOf(err) == NotFound, "expect NotFound, got %v", err)
+		err = client.Call(context.Background(), "Echo.Repeat", 1000, &reply)
+		_assert(err == nil && len(reply) == 1000, "expect error not to leak into next request, got %v", err)
+	}
+}
+
+func TestServer_FlushPolicy(t *testing.T) {
+	server := NewServer(WithFlushPolicy(5*time.Millisecond, 4096))
+	_ = server.Register(Echo{})
+	client := pipeClient(t, server, &Option{MagicNumber: MagicNumber, CodecType: DefaultOption.CodecType, FlushDelay: 5 * time.Millisecond})
+	defer func() { _ = client.Close() }()
+
+	// 小消息延迟刷新，大消息达到阈值立即刷新，均能正常完成
+	calls := make([]*Call, 0, 20)
+	for i := 0; i < 20; i++ {
+		calls = append(calls, client.Go("Echo.Repeat", i*500, new(string), nil))
+	}
+	for i, call := range calls {
+		<-call.Done
+		_assert(call.Error == nil && len(*call.Reply.(*string)) == i*500, "call %d failed: %v", i, call.Error)
+	}
+}
+
+func (e Echo) Raw(args []byte, reply *[]byte) error {
+	*reply = append([]byte("raw:"), args...)
+	return nil
+}
+
+func TestClient_RawCall(t *testing.T) {
+	server := NewServer(WithChunkedReplies(1024))
+	_ = server.Register(Echo{})
+	client := pipeClient(t, server, DefaultOption)
+	defer func() { _ = client.Close() }()
+
+	// body 原样传递，不是合法的 gob 数据
+	reply, err := client.RawCall(context.Background(), "Echo.Raw", []byte{0xff, 0x00, 0x01})
+	_assert(err == nil && string(reply) == "raw:\xff\x00\x01", "failed to raw call: %q %v", reply, err)
+
+	// 超过分块大小的响应分块发送
+	large := []byte(strings.Repeat("y", 4096))
+	reply, err = client.RawCall(context.Background(), "Echo.Raw", large)
+	_assert(err == nil && string(reply) == "raw:"+string(large), "failed to receive chunked raw reply: %v", err)
+
+	_, err = client.RawCall(context.Background(), "Echo.Repeat", []byte("1"))
+	_assert(CodeOf(err) == InvalidArgument, "expect InvalidArgument for typed method, got %v", err)
+
+	var s string
+	err = client.Call(context.Background(), "Echo.Raw", []byte("x"), &s, WithRawBody())
+	_assert(CodeOf(err) == InvalidArgument, "expect InvalidArgument for non-bytes reply, got %v", err)
+
+	// 原始字节的方法仍可以通过 codec 调用
+	err = client.Call(context.Background(), "Echo.Raw", []byte("typed"), &reply)
+	_assert(err == nil && string(reply) == "raw:typed", "failed to call raw method with codec: %v", err)
+}
+
+func TestClient_WithCodec(t *testing.T) {
+	server := NewServer(WithChunkedReplies(1024))
+	_ = server.Register(Echo{})
+	_ = server.Register(new(Foo))
+	client := pipeClient(t, server, DefaultOption)
+	defer func() { _ = client.Close() }()
+
+	// 同一个连接上混用 gob、JSON 与原始字节
+	var sum int
+	err := client.Call(context.Background(), "Foo.Sum", Args{Num1: 1, Num2: 2}, &sum, WithCodec(codec.JsonType))
+	_assert(err == nil && sum == 3, "failed to call with json: %v", err)
+	err = client.Call(context.Background(), "Foo.Sum", Args{Num1: 3, Num2: 4}, &sum)
+	_assert(err == nil && sum == 7, "failed to call with gob: %v", err)
+	raw, err := client.RawCall(context.Background(), "Echo.Raw", []byte("x"))
+	_assert(err == nil && string(raw) == "raw:x", "failed to raw call: %v", err)
+
+	// 分块发送的响应同样使用请求的序列化方式
+	var reply string
+	err = client.Call(context.Background(), "Echo.Repeat", 4096, &reply, WithCodec(codec.JsonType))
+	_assert(err == nil && len(reply) == 4096, "failed to receive chunked json reply: %v", err)
+
+	err = client.Call(context.Background(), "Foo.Sum", Args{}, &sum, WithCodec("application/unknown"))
+	_assert(CodeOf(err) == InvalidArgument, "expect InvalidArgument for unknown codec, got %v", err)
+	err = client.Call(context.Background(), "Foo.Sum", Args{Num1: 5}, &sum)
+	_assert(err == nil && sum == 5, "expect connection to keep working, got %v", err)
+}
+
+func (e Echo) Fail(code Code, reply *string) error {
+	return &Error{Code: code, Message: "fail", Details: []byte("detail")}
+}
+
+func (e Echo) Priority(ctx context.Context, _ int, reply *int) error {
+	*reply = PriorityFromContext(ctx)
+	return nil
+}
+
+func TestServer_CallOptions(t *testing.T) {
+	baz := &Baz{cancelled: make(chan error, 1)}
+	server := NewServer()
+	_ = server.Register(Echo{})
+	_ = server.Register(baz)
+	client := pipeClient(t, server, DefaultOption)
+	defer func() { _ = client.Close() }()
+
+	var priority int
+	err := client.Call(context.Background(), "Echo.Priority", 0, &priority, WithPriority(5))
+	_assert(err == nil && priority == 5, "expect priority 5, got %d, err: %v", priority, err)
+
+	start := time.Now()
+	err = client.Call(context.Background(), "Baz.Wait", 1, new(int), WithTimeout(time.Millisecond*50))
+	_assert(CodeOf(err) == DeadlineExceeded && time.Since(start) < shutdownDrainGrace, "expect DeadlineExceeded, got %v", err)
+	// 服务端因截止时间或客户端的取消帧而结束
+	_assert(<-baz.cancelled != nil, "expect handler context to be done")
+
+	call := <-client.Go("Baz.Wait", 1, new(int), nil, WithTimeout(time.Millisecond*50)).Done
+	_assert(CodeOf(call.Error) == DeadlineExceeded, "expect server to enforce Go timeout, got %v", call.Error)
+	<-baz.cancelled
+}
+
+func TestServer_ErrorCode(t *testing.T) {
+	server := NewServer()
+	_ = server.Register(Echo{})
+	_ = server.Register(new(Foo))
+	client := pipeClient(t, server, DefaultOption)
+	defer func() { _ = client.Close() }()
+
+	var reply string
+	err := client.Call(context.Background(), "Echo.Fail", PermissionDenied, &reply)
+	var e *Error
+	_assert(errors.As(err, &e) && e.Code == PermissionDenied && e.Message == "fail" && string(e.Details) == "detail",
+		"expect a structured error, got %v", err)
+
+	err = client.Call(context.Background(), "Echo.Missing", 1, &reply)
+	_assert(CodeOf(err) == NotFound, "expect NotFound, got %v", err)
+
+	// 连接在返回 NotFound 后仍可用
+	var sum int
+	err = client.Call(context.Background(), "Foo.Panic", Args{}, &sum)
+	_assert(CodeOf(err) == Internal, "expect Internal, got %v", err)
+	err = client.Call(context.Background(), "Foo.Sum", Args{Num1: 1, Num2: 2}, &sum)
+	_assert(err == nil && sum == 3, "failed to call Foo.Sum after errors: %v", err)
+}
+
+func (e Echo) Repeat(n int, reply *string) error {
+	*reply = strings.Repeat("x", n)
+	return nil
+}
+
+func TestServer_MaxMessageSize(t *testing.T) {
+	server := NewServer(WithMaxMessageSize(1024))
+	_ = server.Register(Echo{})
+	client := pipeClient(t, server, &Option{MagicNumber: MagicNumber, CodecType: DefaultOption.CodecType, MaxMessageSize: 2048})
+	defer func() { _ = client.Close() }()
+
+	var reply string
+	err := client.Call(context.Background(), "Echo.Metadata", strings.Repeat("k", 4096), &reply)
+	_assert(CodeOf(err) == ResourceExhausted, "expect client to reject large request, got %v", err)
+	err = client.Call(context.Background(), "Echo.Metadata", strings.Repeat("k", 1500), &reply)
+	_assert(CodeOf(err) == ResourceExhausted, "expect server to reject large request, got %v", err)
+	err = client.Call(context.Background(), "Echo.Repeat", 1500, &reply)
+	_assert(CodeOf(err) == ResourceExhausted, "expect server to reject large response, got %v", err)
+	err = client.Call(context.Background(), "Echo.Repeat", 10, &reply)
+	_assert(err == nil && len(reply) == 10, "failed to call after rejected messages: %v", err)
+
+	small := pipeClient(t, server, &Option{MagicNumber: MagicNumber, CodecType: DefaultOption.CodecType, MaxMessageSize: 256})
+	defer func() { _ = small.Close() }()
+	err = small.Call(context.Background(), "Echo.Repeat", 500, &reply)
+	_assert(CodeOf(err) == ResourceExhausted, "expect client to reject large response, got %v", err)
+	err = small.Call(context.Background(), "Echo.Repeat", 10, &reply)
+	_assert(err == nil && len(reply) == 10, "failed to call after rejected response: %v", err)
+}
+
+func TestServer_Compression(t *testing.T) {
+	server := NewServer()
+	_ = server.Register(Echo{})
+	client := pipeClient(t, server, &Option{MagicNumber: MagicNumber, CodecType: DefaultOption.CodecType, Compressor: "gzip"})
+	defer func() { _ = client.Close() }()
+
+	var reply string
+	err := client.Call(context.Background(), "Echo.Repeat", 100000, &reply)
+	_assert(err == nil && reply == strings.Repeat("x", 100000), "failed to call with compression: %v", err)
+	read, _ := codecBytes(client.t.cc)
+	_assert(read < 10000, "expect response to be compressed, read %d bytes", read)
+
+	_, err = NewClient(nil, &Option{MagicNumber: MagicNumber, CodecType: DefaultOption.CodecType, Compressor: "snappy"})
+	_assert(err != nil, "expect unknown compressor to be rejected")
+}
+
+func TestServer_Encryption(t *testing.T) {
+	key := []byte("0123456789abcdef0123456789abcdef")
+	server := NewServer(WithEncryptionKey(key))
+	_ = server.Register(Echo{})
+	client := pipeClient(t, server, &Option{MagicNumber: MagicNumber, CodecType: DefaultOption.CodecType, Compressor: "gzip", EncryptionKey: key})
+	defer func() { _ = client.Close() }()
+
+	var reply string
+	err := client.Call(context.Background(), "Echo.Repeat", 5000, &reply)
+	_assert(err == nil && len(reply) == 5000, "failed to call with encryption: %v", err)
+
+	c1, c2 := net.Pipe()
+	go server.handleConn(c2)
+	_, err = NewClient(c1, DefaultOption)
+	_assert(err != nil && strings.Contains(err.Error(), "encryption is required"), "expect plaintext client to be rejected, got %v", err)
+
+	// 不交换随机数、使用固定密钥的客户端被拒绝
+	c1, c2 = net.Pipe()
+	go server.handleConn(c2)
+	_ = writeHandshake(c1, handshake{Option: &Option{MagicNumber: MagicNumber, CodecType: DefaultOption.CodecType}, Encrypted: true}, false)
+	var ack handshakeAck
+	_, _, err = readHandshake(c1, &ack)
+	_assert(err == nil && ack.Code == FailedPrecondition && len(ack.Nonce) == 0, "expect client without nonce to be rejected, got %+v, %v", ack, err)
+	_ = c1.Close()
+}
+
+// corruptConn 在 corrupt 为 true 时修改一次写入数据中的 pattern，模拟传输中损坏数据的中间设备
+type corruptConn struct {
+	net.Conn
+	pattern []byte
+	corrupt int32
+}
+
+func (c *corruptConn) Write(p []byte) (int, error) {
+	if i := bytes.Index(p, c.pattern); i >= 0 && atomic.CompareAndSwapInt32(&c.corrupt, 1, 0) {
+		p = append([]byte(nil), p...)
+		p[i] ^= 0xff
+	}
+	return c.Conn.Write(p)
+}
+
+func TestServer_Checksum(t *testing.T) {
+	server := NewServer()
+	_ = server.Register(Echo{})
+	c1, c2 := net.Pipe()
+	conn := &corruptConn{Conn: c2, pattern: []byte("xxxx")}
+	go server.handleConn(conn)
+	client, err := NewClient(c1, &Option{MagicNumber: MagicNumber, CodecType: DefaultOption.CodecType, Checksum: true})
+	_assert(err == nil, "failed to create client: %v", err)
+	defer func() { _ = client.Close() }()
+
+	var reply string
+	err = client.Call(context.Background(), "Echo.Repeat", 100, &reply)
+	_assert(err == nil && reply == strings.Repeat("x", 100), "failed to call with checksum: %v", err)
+
+	// 损坏的响应以 DataLoss 结束，连接仍然可用
+	atomic.StoreInt32(&conn.corrupt, 1)
+	err = client.Call(context.Background(), "Echo.Repeat", 100, &reply)
+	_assert(CodeOf(err) == DataLoss, "expect DataLoss for corrupted reply, got %v", err)
+	err = client.Call(context.Background(), "Echo.Repeat", 100, &reply)
+	_assert(err == nil && reply == strings.Repeat("x", 100), "failed to call after corrupted reply: %v", err)
+}
+
+// 等待 client 变为不可用，超时返回 false
+func waitUnavailable(client *Client, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if !client.IsAvailable() {
+			return true
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+	return false
+}
+
+func TestServer_IdleTimeout(t *testing.T) {
+	server := NewServer()
+	_ = server.Register(Echo{})
+
+	idle := pipeClient(t, server, &Option{MagicNumber: MagicNumber, CodecType: DefaultOption.CodecType, IdleTimeout: time.Millisecond * 100})
+	defer func() { _ = idle.Close() }()
+	_assert(waitUnavailable(idle, time.Second), "expect idle connection to be closed by server")
+
+	// ping 使连接保持活跃
+	alive := pipeClient(t, server, &Option{MagicNumber: MagicNumber, CodecType: DefaultOption.CodecType,
+		IdleTimeout: time.Millisecond * 100, PingInterval: time.Millisecond * 20})
+	defer func() { _ = alive.Close() }()
+	time.Sleep(time.Millisecond * 300)
+	var reply string
+	err := alive.Call(context.Background(), "Echo.Repeat", 1, &reply)
+	_assert(err == nil && reply == "x", "expect pinged connection to stay open, err: %v", err)
+}
+
+func TestServer_MaxConcurrentRequests(t *testing.T) {
+	baz := &Baz{cancelled: make(chan error, 1)}
+	server := NewServer()
+	_ = server.Register(baz)
+	_ = server.Register(Echo{})
+	client := pipeClient(t, server, &Option{MagicNumber: MagicNumber, CodecType: DefaultOption.CodecType, MaxConcurrentRequests: 1})
+	defer func() { _ = client.Close() }()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- client.Call(ctx, "Baz.Wait", 1, new(int)) }()
+	time.Sleep(time.Millisecond * 50)
+
+	var reply string
+	err := client.Call(context.Background(), "Echo.Repeat", 1, &reply)
+	_assert(CodeOf(err) == Unavailable, "expect Unavailable when saturated, got %v", err)
+
+	cancel()
+	<-done
+	<-baz.cancelled
+	time.Sleep(time.Millisecond * 10)
+	err = client.Call(context.Background(), "Echo.Repeat", 1, &reply)
+	_assert(err == nil && reply == "x", "failed to call after the slot is released: %v", err)
+}
+
+func TestServer_MaxConnections(t *testing.T) {
+	for name, opt := range map[string]ServerOption{
+		"total":  WithMaxConnections(1),
+		"per ip": WithMaxConnectionsPerIP(1),
+	} {
+		t.Run(name, func(t *testing.T) {
+			server := NewServer(opt)
+			first := pipeClient(t, server, DefaultOption)
+
+			c1, c2 := net.Pipe()
+			go server.handleConn(c2)
+			_, err := NewClient(c1, DefaultOption)
+			_assert(err != nil && strings.Contains(err.Error(), "too many connections"), "expect connection to be rejected, got %v", err)
+
+			// 连接关闭后释放名额
+			_ = first.Close()
+			deadline := time.Now().Add(time.Second)
+			for {
+				c1, c2 := net.Pipe()
+				go server.handleConn(c2)
+				client, err := NewClient(c1, DefaultOption)
+				if err == nil {
+					_ = client.Close()
+					break
+				}
+				_assert(time.Now().Before(deadline), "expect connection to be accepted after release, got %v", err)
+				time.Sleep(time.Millisecond * 10)
+			}
+		})
+	}
+}
+
+func TestServer_ReadTimeout(t *testing.T) {
+	server := NewServer()
+	_ = server.Register(Echo{})
+	opt := &Option{MagicNumber: MagicNumber, CodecType: DefaultOption.CodecType, ReadTimeout: time.Millisecond * 50}
+
+	// 等待请求的过程不受 ReadTimeout 限制
+	client := pipeClient(t, server, opt)
+	defer func() { _ = client.Close() }()
+	time.Sleep(time.Millisecond * 150)
+	var reply string
+	err := client.Call(context.Background(), "Echo.Repeat", 1, &reply)
+	_assert(err == nil && reply == "x", "expect idle connection to stay open, err: %v", err)
+
+	// 只发送部分消息后停止的客户端被断开
+	c1, c2 := net.Pipe()
+	go server.handleConn(c2)
+	_ = json.NewEncoder(c1).Encode(handshake{Option: opt})
+	var ack handshakeAck
+	dec := json.NewDecoder(c1)
+	_assert(dec.Decode(&ack) == nil && ack.Error == "", "unexpected handshake ack %+v", ack)
+	conn, _ := afterJSON(dec, c1)
+	_, _ = conn.Write([]byte{0x67, 0x65, 1})
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+	_, err = conn.Read(make([]byte, 1))
+	_assert(err == io.EOF, "expect stalled connection to be closed, got %v", err)
+}
+
+func TestServer_CancelPropagation(t *testing.T) {
+	baz := &Baz{cancelled: make(chan error, 1)}
+	server := NewServer()
+	_ = server.Register(baz)
+	client := pipeClient(t, server, DefaultOption)
+	defer func() { _ = client.Close() }()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(time.Millisecond*50, cancel)
+	var reply int
+	err := client.Call(ctx, "Baz.Wait", 1, &reply)
+	_assert(CodeOf(err) == Canceled, "expect Canceled, got %v", err)
+
+	// 连接保持打开，handler 的 context 应因取消帧而结束
+	select {
+	case err := <-baz.cancelled:
+		_assert(err == context.Canceled, "expect handler context to be cancelled, got %v", err)
+	case <-time.After(time.Second):
+		t.Fatal("handler context is not cancelled by the client")
+	}
+	_assert(client.IsAvailable(), "expect client to stay available")
+}
+
+func TestServer_DeadlinePropagation(t *testing.T) {
+	server := NewServer()
+	_ = server.Register(new(Foo))
+	// 不使用 DefaultOption，其 HandleTimeout 可能被其他测试修改
+	client := pipeClient(t, server, &Option{MagicNumber: MagicNumber, CodecType: DefaultOption.CodecType})
+	defer func() { _ = client.Close() }()
+
+	var hasDeadline bool
+	err := client.Call(context.Background(), "Foo.Deadline", Args{}, &hasDeadline)
+	_assert(err == nil && !hasDeadline, "expect no deadline, err: %v", err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	err = client.Call(ctx, "Foo.Deadline", Args{}, &hasDeadline)
+	_assert(err == nil && hasDeadline, "expect deadline to be propagated, err: %v", err)
+}
+
+func TestServer_Logger(t *testing.T) {
+	var buf strings.Builder
+	server := NewServer(WithLogger(NewStdLogger(log.New(&buf, "", 0))))
+	_ = server.Register(Echo{})
+	_assert(strings.Contains(buf.String(), "[DEBUG] rpc server: register Echo.Metadata"), "expect register log, got %q", buf.String())
+
+	buf.Reset()
+	quiet := NewServer()
+	_ = quiet.Register(Echo{})
+	_assert(buf.Len() == 0, "expect no log by default, got %q", buf.String())
+}
+
+// lineWriter 将每次写入作为一行发送到 channel
+type lineWriter chan string
+
+func (w lineWriter) Write(p []byte) (int, error) {
+	w <- string(p)
+	return len(p), nil
+}
+
+func TestServer_AccessLog(t *testing.T) {
+	lines := make(lineWriter, 2)
+	server := NewServer(WithAccessLog(lines))
+	_ = server.Register(Echo{})
+	client := pipeClient(t, server, DefaultOption)
+	defer func() { _ = client.Close() }()
+
+	var reply string
+	_ = client.Call(context.Background(), "Echo.Metadata", "trace-id", &reply)
+	var entry accessLogEntry
+	_assert(json.Unmarshal([]byte(<-lines), &entry) == nil, "expect a JSON access log")
+	_assert(entry.Method == "Echo.Metadata" && entry.Peer == "pipe" && entry.Code == "OK", "unexpected access log %+v", entry)
+	_assert(entry.BytesIn > 0 && entry.BytesOut > 0 && entry.Duration > 0, "expect bytes and duration in access log %+v", entry)
+
+	err := client.Call(context.Background(), "Echo.Missing", "", &reply)
+	entry = accessLogEntry{}
+	_assert(json.Unmarshal([]byte(<-lines), &entry) == nil, "expect a JSON access log")
+	_assert(entry.Method == "Echo.Missing" && entry.Code == "NotFound" && entry.Error == err.Error(), "unexpected access log %+v for %v", entry, err)
+}
+
+func TestServer_Health(t *testing.T) {
+	baz := &Baz{cancelled: make(chan error, 1)}
+	server := NewServer(WithOverloadThreshold(1))
+	_ = server.Register(baz)
+	client := pipeClient(t, server, DefaultOption)
+	defer func() { _ = client.Close() }()
+	ctx := context.Background()
+
+	status, err := client.HealthCheck(ctx, "Baz")
+	_assert(err == nil && status.Status == Serving && status.Connections == 1, "unexpected health %+v, err: %v", status, err)
+	_, err = client.HealthCheck(ctx, "Bar")
+	_assert(CodeOf(err) == NotFound, "expect NotFound for unknown service, got %v", err)
+
+	readyz := func() int {
+		w := httptest.NewRecorder()
+		(&healthHTTP{s: server, ready: true}).ServeHTTP(w, httptest.NewRequest(http.MethodGet, readyzPath, nil))
+		return w.Code
+	}
+	for atomic.LoadInt64(&server.inflight) != 0 {
+		time.Sleep(time.Millisecond)
+	}
+	_assert(readyz() == http.StatusOK, "expect ready when serving")
+
+	// 正在处理的请求数达到阈值
+	waitCtx, cancel := context.WithCancel(ctx)
+	done := make(chan error, 1)
+	go func() { done <- client.Call(waitCtx, "Baz.Wait", 1, new(int)) }()
+	for atomic.LoadInt64(&server.inflight) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	_assert(server.Health().Status == Overloaded, "expect Overloaded, got %+v", server.Health())
+	_assert(readyz() == http.StatusServiceUnavailable, "expect not ready when overloaded")
+	cancel()
+	<-done
+	<-baz.cancelled
+
+	shutdownCtx, stop := context.WithCancel(ctx)
+	stop()
+	_ = server.Shutdown(shutdownCtx)
+	_assert(server.Health().Status == Draining, "expect Draining, got %+v", server.Health())
+	w := httptest.NewRecorder()
+	(&healthHTTP{s: server}).ServeHTTP(w, httptest.NewRequest(http.MethodGet, healthzPath, nil))
+	_assert(w.Code == http.StatusOK && w.Body.String() == "DRAINING\n", "unexpected healthz %d %q", w.Code, w.Body.String())
+}
+
+func TestServer_DebugJSON(t *testing.T) {
+	server := NewServer()
+	baz := &Baz{cancelled: make(chan error, 1)}
+	_ = server.Register(baz)
+	_ = server.Register(new(Foo))
+	client := pipeClient(t, server, DefaultOption)
+	var reply int
+	_ = client.Call(context.Background(), "Foo.Sum", &Args{Num1: 1, Num2: 2}, &reply)
+	client.Go("Baz.Wait", 1, new(int), nil)
+	for atomic.LoadInt64(&server.inflight) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	w := httptest.NewRecorder()
+	(&debugJSON{server}).ServeHTTP(w, httptest.NewRequest(http.MethodGet, defaultDebugPath+"/json", nil))
+	var status debugStatus
+	err := json.NewDecoder(w.Body).Decode(&status)
+	_assert(err == nil && status.Connections == 1 && status.InFlight == 1 && status.UptimeSeconds > 0,
+		"unexpected status: %+v, err: %v", status, err)
+	_assert(len(status.Services) == 4 && status.Services[1].Name == "Foo", "unexpected services: %+v", status.Services)
+	for _, m := range status.Services[1].Methods {
+		_assert(m.Name != "Sum" || (m.Calls == 1 && m.ArgType == "geerpc.Args"), "unexpected method: %+v", m)
+	}
+
+	w = httptest.NewRecorder()
+	(&debugHTTP{server}).ServeHTTP(w, httptest.NewRequest(http.MethodGet, defaultDebugPath, nil))
+	_assert(strings.Contains(w.Body.String(), "P99"), "expect latency in debug page: %s", w.Body.String())
+
+	_ = client.Close()
+	<-baz.cancelled
+}
+
+func TestServer_AuthFunc(t *testing.T) {
+	server := NewServer(WithAuthFunc(func(token string, peer *Peer) error {
+		if token != "secret" {
+			return errors.New("invalid token")
+		}
+		_assert(peer != nil && peer.Addr != nil, "expect peer in AuthFunc")
+		return nil
+	}))
+	_ = server.Register(Echo{})
+
+	c1, c2 := net.Pipe()
+	go server.handleConn(c2)
+	_, err := NewClient(c1, &Option{MagicNumber: MagicNumber, CodecType: DefaultOption.CodecType, AuthToken: "wrong"})
+	_assert(CodeOf(err) == Unauthenticated && strings.Contains(err.Error(), "invalid token"),
+		"expect Unauthenticated, got %v", err)
+
+	client := pipeClient(t, server, &Option{MagicNumber: MagicNumber, CodecType: DefaultOption.CodecType, AuthToken: "secret"})
+	defer func() { _ = client.Close() }()
+	var reply string
+	err = client.Call(context.Background(), "Echo.Repeat", 1, &reply)
+	_assert(err == nil && reply == "x", "failed to call after authentication: %v", err)
+
+	ts := httptest.NewServer(server.Gateway(defaultGatewayPath))
+	defer ts.Close()
+	for token, status := range map[string]int{"wrong": http.StatusUnauthorized, "secret": http.StatusOK} {
+		req, _ := http.NewRequest(http.MethodPost, ts.URL+"/rpc/Echo/Repeat", strings.NewReader("1"))
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := http.DefaultClient.Do(req)
+		_assert(err == nil && resp.StatusCode == status, "expect %d for token %s, got %v", status, token, err)
+		_ = resp.Body.Close()
+	}
+}
+
+func TestServer_Authorizer(t *testing.T) {
+	server := NewServer(WithAuthorizer(AuthorizerFunc(func(ctx context.Context, id Identity, serviceMethod string) error {
+		_assert(id.Peer != nil, "expect peer in Identity")
+		if strings.HasPrefix(serviceMethod, "Echo.") || id.Credentials == "admin" {
+			return nil
+		}
+		return errors.New("admin only")
+	})))
+	_ = server.Register(Echo{})
+	_ = server.Register(new(Foo))
+	_ = server.Register(new(Counter))
+	client := pipeClient(t, server, DefaultOption)
+	defer func() { _ = client.Close() }()
+	ctx := context.Background()
+
+	var reply string
+	err := client.Call(ctx, "Echo.Repeat", 1, &reply)
+	_assert(err == nil && reply == "x", "expect Echo to be allowed, got %v", err)
+
+	var sum int
+	err = client.Call(ctx, "Foo.Sum", &Args{Num1: 1, Num2: 2}, &sum, WithCredentials("guest"))
+	_assert(CodeOf(err) == PermissionDenied && strings.Contains(err.Error(), "admin only"), "expect PermissionDenied, got %v", err)
+	err = client.Call(ctx, "Foo.Sum", &Args{Num1: 1, Num2: 2}, &sum, WithCredentials("admin"))
+	_assert(err == nil && sum == 3, "expect admin to be allowed, got %v", err)
+
+	// 经由别名的调用以注册时的服务名鉴权
+	_assert(server.RegisterAlias("OldFoo", "Foo") == nil, "failed to register alias")
+	err = client.Call(ctx, "OldFoo.Sum", &Args{Num1: 1, Num2: 2}, &sum, WithCredentials("guest"))
+	_assert(CodeOf(err) == PermissionDenied, "expect alias call to be denied, got %v", err)
+	_assert(server.RegisterAlias("Echo.Foo", "Foo") == nil, "failed to register alias")
+	err = client.Call(ctx, "Echo.Foo.Sum", &Args{Num1: 1, Num2: 2}, &sum)
+	_assert(CodeOf(err) == PermissionDenied, "expect alias with an allowed prefix to be denied, got %v", err)
+
+	stream, err := client.Stream(ctx, "Counter.Count", 5, new(int))
+	_assert(err == nil, "failed to open stream: %v", err)
+	err = stream.Recv(new(int))
+	_assert(CodeOf(err) == PermissionDenied, "expect stream to be rejected, got %v", err)
+}
+
+func TestServer_AuthorizerPanic(t *testing.T) {
+	server := NewServer(WithAuthorizer(AuthorizerFunc(func(ctx context.Context, id Identity, serviceMethod string) error {
+		panic("boom")
+	})))
+	_ = server.Register(Echo{})
+	client := pipeClient(t, server, DefaultOption)
+	defer func() { _ = client.Close() }()
+
+	var reply string
+	for i := 0; i < 2; i++ {
+		err := client.Call(context.Background(), "Echo.Repeat", 1, &reply)
+		_assert(CodeOf(err) == Internal && strings.Contains(err.Error(), "panic: boom"), "expect Internal, got %v", err)
+	}
+}
+
+func TestServer_Interceptors(t *testing.T) {
+	var trace []string
+	record := func(name string) ServerInterceptor {
+		return func(ctx context.Context, serviceMethod string, args, reply interface{}, handler Handler) error {
+			trace = append(trace, name+" "+serviceMethod)
+			return handler(ctx, serviceMethod, args, reply)
+		}
+	}
+	deny := func(ctx context.Context, serviceMethod string, args, reply interface{}, handler Handler) error {
+		if _, ok := reply.(*ServerStream); ok {
+			return Errorf(Unimplemented, "no streams")
+		}
+		return handler(ctx, serviceMethod, args, reply)
+	}
+	server := NewServer(WithInterceptors(record("outer"), record("inner")), WithInterceptors(deny))
+	_ = server.Register(Echo{})
+	_ = server.Register(new(Counter))
+	client := pipeClient(t, server, DefaultOption)
+	defer func() { _ = client.Close() }()
+
+	var reply string
+	err := client.Call(context.Background(), "Echo.Repeat", 2, &reply)
+	_assert(err == nil && reply == "xx", "failed to call through interceptors: %v", err)
+	_assert(strings.Join(trace, ",") == "outer Echo.Repeat,inner Echo.Repeat", "unexpected order: %v", trace)
+
+	stream, _ := client.Stream(context.Background(), "Counter.Count", 1, new(int))
+	err = stream.Recv(new(int))
+	_assert(CodeOf(err) == Unimplemented, "expect stream to be intercepted, got %v", err)
+}
+
+func TestServer_InterceptorPanic(t *testing.T) {
+	server := NewServer(WithInterceptors(func(ctx context.Context, serviceMethod string, args, reply interface{}, handler Handler) error {
+		panic("boom")
+	}))
+	_ = server.Register(Echo{})
+	client := pipeClient(t, server, DefaultOption)
+	defer func() { _ = client.Close() }()
+
+	var reply string
+	err := client.Call(context.Background(), "Echo.Repeat", 1, &reply)
+	_assert(CodeOf(err) == Internal && strings.Contains(err.Error(), "Echo.Repeat panic: boom"), "expect Internal, got %v", err)
+	// 服务端在 panic 之后继续处理请求
+	err = client.Call(context.Background(), "Echo.Repeat", 1, &reply)
+	_assert(CodeOf(err) == Internal, "expect server to keep serving, got %v", err)
+}
+
+type Gate struct {
+	entered chan int
+	release chan struct{}
+}
+
+func (g *Gate) Enter(n int, reply *int) error {
+	g.entered <- n
+	<-g.release
+	*reply = n
+	return nil
+}
+
+func TestServer_PriorityScheduling(t *testing.T) {
+	gate := &Gate{entered: make(chan int, 3), release: make(chan struct{})}
+	server := NewServer(WithPriorityScheduling(1))
+	_ = server.Register(gate)
+	client := pipeClient(t, server, DefaultOption)
+	defer func() { _ = client.Close() }()
+
+	queued := func(n int) {
+		for i := 0; i < 100; i++ {
+			server.scheduler.mu.Lock()
+			l := len(server.scheduler.queue)
+			server.scheduler.mu.Unlock()
+			if l == n {
+				return
+			}
+			time.Sleep(time.Millisecond * 10)
+		}
+		t.Fatalf("expect %d queued requests", n)
+	}
+	// 占用唯一的 worker
+	first := client.Go("Gate.Enter", 0, new(int), nil)
+	_assert(<-gate.entered == 0, "expect first request to run")
+
+	// 排队超时的请求被移出队列
+	err := client.Call(context.Background(), "Gate.Enter", -1, new(int), WithTimeout(time.Millisecond*50))
+	_assert(CodeOf(err) == DeadlineExceeded, "expect DeadlineExceeded while queued, got %v", err)
+	queued(0)
+
+	low := client.Go("Gate.Enter", 1, new(int), nil)
+	queued(1)
+	high := client.Go("Gate.Enter", 2, new(int), nil, WithPriority(10))
+	queued(2)
+	close(gate.release)
+	for _, call := range []*Call{first, low, high} {
+		<-call.Done
+		_assert(call.Error == nil, "expect no error, got %v", call.Error)
+	}
+	_assert(<-gate.entered == 2 && <-gate.entered == 1, "expect high priority request to run first")
+}
+
+func TestServer_Shutdown(t *testing.T) {
+	gate := &Gate{entered: make(chan int, 1), release: make(chan struct{})}
+	server := NewServer()
+	_ = server.Register(gate)
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	go server.Accept(l)
+	client, err := Dial("tcp", l.Addr().String(), DefaultOption)
+	_assert(err == nil, "dial error: %v", err)
+	defer func() { _ = client.Close() }()
+
+	call := client.Go("Gate.Enter", 1, new(int), nil)
+	<-gate.entered
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*200)
+	defer cancel()
+	shutdown := make(chan error, 1)
+	go func() { shutdown <- server.Shutdown(ctx) }()
+	for i := 0; !client.IsDraining(); i++ {
+		_assert(i < 100, "expect client to receive GOAWAY")
+		time.Sleep(time.Millisecond * 10)
+	}
+	_assert(!client.IsAvailable(), "expect draining client to be unavailable")
+	err = client.Call(context.Background(), "Gate.Enter", 2, new(int))
+	_assert(err == ErrDraining && CodeOf(err) == Unavailable, "expect ErrDraining, got %v", err)
+	// 请求没有完成，ctx 结束时强制关闭连接
+	err = <-shutdown
+	_assert(err == context.DeadlineExceeded, "expect Shutdown to wait for in-flight request, got %v", err)
+	<-call.Done
+	_assert(call.Error != nil, "expect in-flight call to fail after forced close")
+	_, err = Dial("tcp", l.Addr().String(), DefaultOption)
+	_assert(err != nil, "expect listener to be closed")
+	close(gate.release)
+}
+
+// delayConn 在每次写入前等待 delay，模拟发出时仍在传输中的请求
+type delayConn struct {
+	net.Conn
+	delay time.Duration
+}
+
+func (c *delayConn) Write(p []byte) (int, error) {
+	time.Sleep(c.delay)
+	return c.Conn.Write(p)
+}
+
+func TestServer_ShutdownRace(t *testing.T) {
+	server := NewServer()
+	_ = server.Register(Echo{})
+	c1, c2 := net.Pipe()
+	go server.handleConn(c2)
+	client, err := NewClient(&delayConn{Conn: c1, delay: time.Millisecond * 50}, DefaultOption)
+	_assert(err == nil, "failed to create client: %v", err)
+	defer func() { _ = client.Close() }()
+
+	// 请求在客户端收到 GOAWAY 前发出，在 GOAWAY 之后才到达服务端
+	calls := make(chan *Call, 1)
+	go func() { calls <- client.Go("Echo.Repeat", 2, new(string), nil) }()
+	time.Sleep(time.Millisecond * 10)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	start := time.Now()
+	err = server.Shutdown(ctx)
+	_assert(err == nil, "expect Shutdown to return nil, got %v", err)
+	call := <-calls
+	<-call.Done
+	_assert(call.Error == nil && *call.Reply.(*string) == "xx", "expect request racing GOAWAY to be served, got %v", call.Error)
+	_assert(time.Since(start) < shutdownDrainGrace, "expect drained client to close the connection, took %v", time.Since(start))
+	_assert(client.State() == StateClosed, "expect drained client to be closed, got %s", client.State())
+
+	// 关闭期间新的连接在握手时被拒绝
+	c1, c2 = net.Pipe()
+	go server.handleConn(c2)
+	_, err = NewClient(c1, DefaultOption)
+	_assert(CodeOf(err) == Unavailable && strings.Contains(err.Error(), "shutting down"), "expect handshake to be rejected during shutdown, got %v", err)
+}
+
+type Ledger struct{ total int32 }
+
+func (l *Ledger) Add(n int, reply *int) error {
+	*reply = int(atomic.AddInt32(&l.total, int32(n)))
+	return nil
+}
+
+// Timeout 的第一次调用返回 DeadlineExceeded，之后正常返回
+type Timeout struct{ calls int32 }
+
+func (t *Timeout) Once(n int, reply *int) error {
+	if atomic.AddInt32(&t.calls, 1) == 1 {
+		return Errorf(DeadlineExceeded, "timeout")
+	}
+	*reply = n
+	return nil
+}
+
+func TestServer_Idempotency(t *testing.T) {
+	ledger := &Ledger{}
+	gate := &Gate{entered: make(chan int, 2), release: make(chan struct{})}
+	server := NewServer(WithIdempotency(2, time.Minute))
+	_ = server.Register(ledger)
+	_ = server.Register(gate)
+	_ = server.Register(&Timeout{})
+	client := pipeClient(t, server, DefaultOption)
+	defer func() { _ = client.Close() }()
+	ctx := context.Background()
+
+	var r1, r2 int
+	err := client.Call(ctx, "Ledger.Add", 5, &r1, WithIdempotencyKey("a"))
+	_assert(err == nil && r1 == 5, "expect 5, got %d, %v", r1, err)
+	err = client.Call(ctx, "Ledger.Add", 5, &r2, WithIdempotencyKey("a"))
+	_assert(err == nil && r2 == 5 && atomic.LoadInt32(&ledger.total) == 5, "expect retried request to return the original reply, got %d", r2)
+
+	_ = client.Call(ctx, "Ledger.Add", 5, &r2, WithIdempotencyKey("b"))
+	_ = client.Call(ctx, "Ledger.Add", 5, &r2)
+	_assert(r2 == 15, "expect requests with a new key or without key to execute, got %d", r2)
+
+	// 超出容量后淘汰最久未使用的结果
+	_ = client.Call(ctx, "Ledger.Add", 1, new(int), WithIdempotencyKey("c"))
+	_ = client.Call(ctx, "Ledger.Add", 1, new(int), WithIdempotencyKey("d"))
+	_ = client.Call(ctx, "Ledger.Add", 5, &r2, WithIdempotencyKey("a"))
+	_assert(r2 == 22, "expect evicted key to execute again, got %d", r2)
+
+	// 相同的键用于不同的参数时拒绝，而不是返回第一次的结果
+	err = client.Call(ctx, "Ledger.Add", 6, &r2, WithIdempotencyKey("a"))
+	_assert(CodeOf(err) == InvalidArgument && atomic.LoadInt32(&ledger.total) == 22, "expect InvalidArgument for reused key, got %v", err)
+	// 不同调用方的相同键互不影响
+	r2 = 0
+	err = client.Call(ctx, "Ledger.Add", 5, &r2, WithIdempotencyKey("a"), WithCredentials("bob"))
+	_assert(err == nil && r2 == 27, "expect key of another caller to execute, got %d, %v", r2, err)
+	// 超时的结果不缓存，重试时重新执行
+	err = client.Call(ctx, "Timeout.Once", 3, &r2, WithIdempotencyKey("t"))
+	_assert(CodeOf(err) == DeadlineExceeded, "expect DeadlineExceeded, got %v", err)
+	err = client.Call(ctx, "Timeout.Once", 3, &r2, WithIdempotencyKey("t"))
+	_assert(err == nil && r2 == 3, "expect timed out request to execute again, got %d, %v", r2, err)
+
+	// 正在执行的请求被重试时等待第一次执行的结果
+	first := client.Go("Gate.Enter", 7, new(int), nil, WithIdempotencyKey("g"))
+	<-gate.entered
+	retry := client.Go("Gate.Enter", 7, new(int), nil, WithIdempotencyKey("g"))
+	time.Sleep(time.Millisecond * 50)
+	_assert(len(gate.entered) == 0, "expect retried request not to execute")
+	close(gate.release)
+	<-first.Done
+	<-retry.Done
+	_assert(retry.Error == nil && *retry.Reply.(*int) == 7, "expect retried request to share the reply, got %v", retry.Error)
+}
+
+func TestServer_Jobs(t *testing.T) {
+	gate := &Gate{entered: make(chan int, 1), release: make(chan struct{})}
+	baz := &Baz{cancelled: make(chan error, 1)}
+	server := NewServer(WithJobs(2, time.Minute))
+	_ = server.Register(gate)
+	_ = server.Register(baz)
+	client := pipeClient(t, server, &Option{MagicNumber: MagicNumber, CodecType: DefaultOption.CodecType,
+		HandleTimeout: time.Millisecond * 50})
+	defer func() { _ = client.Close() }()
+	ctx := context.Background()
+
+	// 执行时间超过 HandleTimeout 的任务
+	id, err := client.SubmitJob(ctx, "Gate.Enter", 7)
+	_assert(err == nil && id != "", "failed to submit job: %v", err)
+	<-gate.entered
+	time.Sleep(time.Millisecond * 100)
+	var reply int
+	state, err := client.PollJob(ctx, id, &reply)
+	_assert(err == nil && state == JobRunning, "expect job to be running, got %s, %v", state, err)
+	close(gate.release)
+	err = client.AwaitJob(ctx, id, &reply, time.Millisecond*10)
+	_assert(err == nil && reply == 7, "expect job reply 7, got %d, %v", reply, err)
+
+	// 取消任务
+	id, err = client.SubmitJob(ctx, "Baz.Wait", 1)
+	_assert(err == nil, "failed to submit job: %v", err)
+	canceled, err := client.CancelJob(ctx, id)
+	_assert(err == nil && canceled, "expect job to be canceled, got %v", err)
+	<-baz.cancelled
+	_, err = client.PollJob(ctx, id, nil)
+	_assert(CodeOf(err) == Canceled, "expect canceled job, got %v", err)
+
+	// 最多保留 2 个任务
+	_, err = client.SubmitJob(ctx, "Gate.Enter", 1)
+	_assert(CodeOf(err) == ResourceExhausted, "expect too many jobs, got %v", err)
+	_, err = client.PollJob(ctx, "missing", nil)
+	_assert(CodeOf(err) == NotFound, "expect unknown job, got %v", err)
+}
+
+func TestServer_ChunkedReplies(t *testing.T) {
+	gate := &Gate{entered: make(chan int, 1), release: make(chan struct{})}
+	server := NewServer(WithChunkedReplies(1024), WithMaxMessageSize(4096))
+	_ = server.Register(Echo{})
+	_ = server.Register(gate)
+	client := pipeClient(t, server, &Option{MagicNumber: MagicNumber, CodecType: DefaultOption.CodecType,
+		MaxChunkedReplySize: 64 << 10})
+	defer func() { _ = client.Close() }()
+	ctx := context.Background()
+
+	// 超过 WithMaxMessageSize 的响应分块发送
+	var reply string
+	err := client.Call(ctx, "Echo.Repeat", 50000, &reply)
+	_assert(err == nil && reply == strings.Repeat("x", 50000), "expect chunked reply, got %d bytes, %v", len(reply), err)
+
+	// 分块响应与其他调用交替发送
+	big := client.Go("Echo.Repeat", 60000, new(string), nil)
+	err = client.Call(ctx, "Echo.Repeat", 3, &reply)
+	_assert(err == nil && reply == "xxx", "expect small call to succeed, got %v", err)
+	<-big.Done
+	_assert(big.Error == nil && len(*big.Reply.(*string)) == 60000, "expect big reply, got %v", big.Error)
+
+	// 超过 MaxChunkedReplySize 时调用失败，连接仍可使用
+	err = client.Call(ctx, "Echo.Repeat", 100000, &reply)
+	_assert(CodeOf(err) == ResourceExhausted, "expect chunked reply to exceed limit, got %v", err)
+	go func() { gate.release <- struct{}{} }()
+	var n int
+	err = client.Call(ctx, "Gate.Enter", 1, &n)
+	_assert(err == nil && n == 1, "expect client to keep working, got %v", err)
+}
+
+type Task struct{}
+
+func (Task) Run(ctx context.Context, steps int, reply *int) error {
+	for i := 1; i <= steps; i++ {
+		if !ReportProgress(ctx, float64(i*100/steps), "step "+strconv.Itoa(i)) {
+			return nil
+		}
+		*reply = i
+	}
+	return nil
+}
+
+func TestServer_ReportProgress(t *testing.T) {
+	server := NewServer()
+	_ = server.Register(Task{})
+	client := pipeClient(t, server, DefaultOption)
+	defer func() { _ = client.Close() }()
+
+	var progress []Progress
+	var reply int
+	err := client.Call(context.Background(), "Task.Run", 4, &reply, WithProgress(func(p Progress) {
+		progress = append(progress, p)
+	}))
+	_assert(err == nil && reply == 4, "expect 4 steps, got %d, %v", reply, err)
+	_assert(len(progress) == 4 && progress[3].Percent == 100 && progress[0].Message == "step 1", "unexpected progress: %v", progress)
+
+	// 未接收进度时 ReportProgress 返回 false
+	reply = 0
+	err = client.Call(context.Background(), "Task.Run", 4, &reply)
+	_assert(err == nil && reply == 0, "expect progress to be disabled, got %d, %v", reply, err)
+}
+
+type Hub struct{}
+
+// Greet 反向调用客户端的 Agent.Hello
+func (Hub) Greet(ctx context.Context, name string, reply *string) error {
+	rc, ok := ReverseClientFromContext(ctx)
+	if !ok {
+		return Errorf(Internal, "no reverse client")
+	}
+	return rc.Call(ctx, "Agent.Hello", name, reply)
+}
+
+type Agent struct{}
+
+func (Agent) Hello(name string, reply *string) error {
+	*reply = "hello " + name
+	return nil
+}
+
+func TestServer_ReverseCall(t *testing.T) {
+	server := NewServer()
+	_ = server.Register(Hub{})
+	client := pipeClient(t, server, DefaultOption)
+	defer func() { _ = client.Close() }()
+	ctx := context.Background()
+
+	var reply string
+	err := client.Call(ctx, "Hub.Greet", "agent", &reply)
+	_assert(CodeOf(err) == Unimplemented, "expect callbacks to be disabled, got %v", err)
+
+	callbacks := NewServer()
+	_ = callbacks.Register(Agent{})
+	client.HandleCallbacks(callbacks)
+	err = client.Call(ctx, "Hub.Greet", "agent", &reply)
+	_assert(err == nil && reply == "hello agent", "expect reverse call to reach the client, got %q, %v", reply, err)
+}
@@ -1,6 +1,7 @@
 package geerpc
 
 import (
+	"context"
 	"go/ast"
 	"log"
 	"reflect"
@@ -12,12 +13,20 @@ type methodType struct {
 	ArgType   reflect.Type   // 第一个参数的类型
 	ReplyType reflect.Type   // 第二个参数的类型
 	numCalls  uint64
+	hasCtx    bool // 第一个参数是否为 context.Context
+	stream    bool // 是否为服务端流式方法
+	latency   latencyStats
 }
 
 func (m *methodType) NumCalls() uint64 {
 	return atomic.LoadUint64(&m.numCalls)
 }
 
+// Latency 返回该方法的请求数、错误数与处理耗时的统计
+func (m *methodType) Latency() latencySnapshot {
+	return m.latency.snapshot()
+}
+
 func (m *methodType) newArgv() reflect.Value {
 	var argv reflect.Value
 	if m.ArgType.Kind() == reflect.Ptr {
@@ -44,19 +53,24 @@ type service struct {
 	typ    reflect.Type           // 结构体的类型
 	rcvr   reflect.Value          // 结构体的实例本身
 	method map[string]*methodType // 存储映射的结构体的所有符合条件的方法
+	logger Logger
 }
 
 func newService(rcvr interface{}) *service {
-	s := new(service)
-
-	s.rcvr = reflect.ValueOf(rcvr)
-	s.name = reflect.Indirect(s.rcvr).Type().Name()
-	s.typ = reflect.TypeOf(rcvr)
+	name := reflect.Indirect(reflect.ValueOf(rcvr)).Type().Name()
 
 	// 结构体是否为可导出的
-	if !ast.IsExported(s.name) {
-		log.Fatalf("rpc server: %s is not a valid service name", s.name)
+	if !ast.IsExported(name) {
+		log.Fatalf("rpc server: %s is not a valid service name", name)
 	}
+	return newNamedService(name, rcvr)
+}
+
+// 使用 name 作为服务名，rcvr 的类型可以是未导出的
+func newNamedService(name string, rcvr interface{}) *service {
+	s := &service{name: name, logger: NopLogger}
+	s.rcvr = reflect.ValueOf(rcvr)
+	s.typ = reflect.TypeOf(rcvr)
 	s.registerMethods()
 	return s
 }
@@ -69,8 +83,13 @@ func (s *service) registerMethods() {
 		method := s.typ.Method(i)
 		mType := method.Type
 
-		// 判断是否为 RPC 调用的形式
-		if mType.NumIn() != 3 || mType.NumOut() != 1 {
+		// 判断是否为 RPC 调用的形式：
+		// Method(args, reply) error 或 Method(ctx context.Context, args, reply) error
+		if (mType.NumIn() != 3 && mType.NumIn() != 4) || mType.NumOut() != 1 {
+			continue
+		}
+		hasCtx := mType.NumIn() == 4
+		if hasCtx && mType.In(1) != typeOfContext {
 			continue
 		}
 
@@ -79,7 +98,7 @@ func (s *service) registerMethods() {
 			continue
 		}
 
-		argType, replyType := mType.In(1), mType.In(2)
+		argType, replyType := mType.In(mType.NumIn()-2), mType.In(mType.NumIn()-1)
 		// 判断参数是否为导出的，而且包路径为空
 		if !isExportedOrBuiltinType(argType) || !isExportedOrBuiltinType(replyType) {
 			continue
@@ -89,8 +108,9 @@ func (s *service) registerMethods() {
 			method:    method,
 			ArgType:   argType,
 			ReplyType: replyType,
+			hasCtx:    hasCtx,
+			stream:    replyType == typeOfServerStream,
 		}
-		log.Printf("rpc server: register %s.%s\n", s.name, method.Name)
 	}
 }
 
@@ -98,11 +118,22 @@ func isExportedOrBuiltinType(t reflect.Type) bool {
 	return ast.IsExported(t.Name()) || t.PkgPath() == ""
 }
 
-func (s *service) call(m *methodType, argv, replyv reflect.Value) error {
+var typeOfContext = reflect.TypeOf((*context.Context)(nil)).Elem()
+
+// 返回方法 m 的完整名称，服务名为注册时的名称而不是别名
+func (s *service) methodName(m *methodType) string {
+	return s.name + "." + m.method.Name
+}
+
+func (s *service) call(ctx context.Context, m *methodType, argv, replyv reflect.Value) (err error) {
 	atomic.AddUint64(&m.numCalls, 1)
 	f := m.method.Func
 	// 调用函数
-	returnValues := f.Call([]reflect.Value{s.rcvr, argv, replyv})
+	in := []reflect.Value{s.rcvr, argv, replyv}
+	if m.hasCtx {
+		in = []reflect.Value{s.rcvr, reflect.ValueOf(ctx), argv, replyv}
+	}
+	returnValues := f.Call(in)
 	if errInter := returnValues[0].Interface(); errInter != nil {
 		return errInter.(error)
 	}
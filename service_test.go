@@ -1,9 +1,12 @@
 package geerpc
 
 import (
+	"context"
 	"fmt"
 	"reflect"
+	"strings"
 	"testing"
+	"time"
 )
 
 type Foo int
@@ -15,6 +18,15 @@ func (f Foo) Sum(args Args, reply *int) error {
 	return nil
 }
 
+func (f Foo) Deadline(ctx context.Context, args Args, reply *bool) error {
+	_, *reply = ctx.Deadline()
+	return nil
+}
+
+func (f Foo) Panic(args Args, reply *int) error {
+	panic("boom")
+}
+
 func (f Foo) sum(args Args, reply *int) error {
 	*reply = args.Num1 + args.Num2
 	return nil
@@ -29,9 +41,11 @@ func _assert(condition bool, msg string, v ...interface{}) {
 func TestNewService(t *testing.T) {
 	var foo Foo
 	s := newService(&foo)
-	_assert(len(s.method) == 1, "wrong service Method, expect 1, but got %d", len(s.method))
+	_assert(len(s.method) == 3, "wrong service Method, expect 3, but got %d", len(s.method))
 	mType := s.method["Sum"]
 	_assert(mType != nil, "wrong Method, Sum shouldn't nil")
+	mType = s.method["Deadline"]
+	_assert(mType != nil && mType.hasCtx, "wrong Method, Deadline should accept a context")
 }
 
 func TestMethodType_Call(t *testing.T) {
@@ -42,6 +56,40 @@ func TestMethodType_Call(t *testing.T) {
 	argv := mType.newArgv()
 	replyv := mType.newReply()
 	argv.Set(reflect.ValueOf(Args{Num1: 1, Num2: 3}))
-	err := s.call(mType, argv, replyv)
+	err := s.call(context.Background(), mType, argv, replyv)
 	_assert(err == nil && *replyv.Interface().(*int) == 4 && mType.NumCalls() == 1, "failed to call Foo.Sum")
+
+	mType = s.method["Deadline"]
+	replyv = mType.newReply()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	err = s.call(ctx, mType, mType.newArgv(), replyv)
+	_assert(err == nil && *replyv.Interface().(*bool), "failed to pass context to Foo.Deadline")
+
+	mType = s.method["Panic"]
+	err = NewServer().call(context.Background(), s, mType, argv, mType.newReply())
+	_assert(err != nil && strings.Contains(err.Error(), "internal error"), "expect panic to be recovered, got %v", err)
+}
+
+func TestLatencyStats(t *testing.T) {
+	var l latencyStats
+	_assert(l.snapshot() == latencySnapshot{}, "expect empty snapshot")
+	for i := 1; i <= 100; i++ {
+		var err error
+		if i%10 == 0 {
+			err = fmt.Errorf("fail")
+		}
+		l.record(time.Duration(i)*time.Millisecond, err)
+	}
+	s := l.snapshot()
+	_assert(s.Count == 100 && s.Errors == 10, "unexpected counts: %+v", s)
+	_assert(s.Mean == 50500*time.Microsecond && s.P50 == 50*time.Millisecond && s.P99 == 99*time.Millisecond,
+		"unexpected latency: %+v", s)
+
+	// 只保留最近的样本
+	for i := 0; i < latencySamples; i++ {
+		l.record(time.Second, nil)
+	}
+	s = l.snapshot()
+	_assert(s.P50 == time.Second && s.Count == 100+latencySamples, "expect old samples to be dropped: %+v", s)
 }
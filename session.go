@@ -0,0 +1,182 @@
+package geerpc
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"sync"
+
+	"geerpc/mux"
+)
+
+// sessionConn 为多路复用会话上的一个流，peer 在建立会话时生成，所有的流共用
+type sessionConn struct {
+	*mux.Stream
+	peer *Peer
+}
+
+// AcceptSession 与 Accept 相同，但每个连接为一个多路复用会话（见 mux 包），会话上的每个流是一个独立的 RPC 连接，
+// 客户端使用 DialSession 连接
+func (s *Server) AcceptSession(list net.Listener) {
+	if s.configErr != nil || !s.trackListener(list, true) {
+		_ = list.Close()
+		return
+	}
+	defer s.trackListener(list, false)
+	for {
+		conn, err := list.Accept()
+		if err != nil {
+			return
+		}
+		if s.tlsConfig != nil {
+			conn = tls.Server(conn, s.tlsConfig)
+		}
+		go s.ServeSession(conn)
+	}
+}
+
+// ServeSession 在 conn 上建立多路复用会话，为对端打开的每个流提供服务，直到连接断开。
+// 每个流各自完成握手，各自计入连接数的限制
+func (s *Server) ServeSession(conn net.Conn) {
+	peer, err := newPeer(conn)
+	if err != nil {
+		s.logger.Errorf("rpc server: handshake with %s failed: %v", conn.RemoteAddr().String(), err)
+		_ = conn.Close()
+		return
+	}
+	session := mux.Server(conn, nil)
+	defer func() { _ = session.Close() }()
+	for {
+		stream, err := session.AcceptStream()
+		if err != nil {
+			return
+		}
+		go s.handleConn(&sessionConn{Stream: stream, peer: peer})
+	}
+}
+
+// SessionClient 在一个多路复用会话上为每个调用使用独立的流，一个调用的大消息不会阻塞其他调用。
+// 调用结束后流留作后续的调用复用，长时间占用流的功能（例如 Subscribe 与 SendFile）可以通过 OpenClient 使用单独的流
+type SessionClient struct {
+	session *mux.Session
+	opt     *Option
+
+	mu     sync.Mutex // protect following
+	idle   []*Client  // 没有调用在进行的流
+	closed bool
+}
+
+// NewSessionClient 在 conn 上建立多路复用会话，并打开第一个流完成握手
+func NewSessionClient(conn net.Conn, opt *Option) (*SessionClient, error) {
+	sc := &SessionClient{session: mux.Client(conn, nil), opt: opt}
+	client, err := sc.OpenClient()
+	if err != nil {
+		_ = sc.session.Close()
+		return nil, err
+	}
+	sc.idle = append(sc.idle, client)
+	return sc, nil
+}
+
+// DialSession 连接到使用 AcceptSession 或 ServeSession 的服务端，建立连接与握手受 ctx 与 ConnectTimeout 约束
+func DialSession(ctx context.Context, network, address string, opt *Option) (*SessionClient, error) {
+	ch := make(chan *SessionClient, 1)
+	_, err := dialContext(ctx, func(conn net.Conn, opt *Option) (*Client, error) {
+		sc, err := NewSessionClient(conn, opt)
+		if err != nil {
+			return nil, err
+		}
+		ch <- sc
+		return sc.idle[0], nil
+	}, network, address, opt)
+	if err != nil {
+		return nil, err
+	}
+	return <-ch, nil
+}
+
+// Session 返回底层的多路复用会话
+func (sc *SessionClient) Session() *mux.Session {
+	return sc.session
+}
+
+// OpenClient 在会话上打开一个新的流并完成握手，返回独占该流的 Client，Client 的 Close 只关闭该流
+func (sc *SessionClient) OpenClient() (*Client, error) {
+	stream, err := sc.session.Open()
+	if err != nil {
+		if sc.session.IsClosed() {
+			return nil, ErrShutdown
+		}
+		return nil, err
+	}
+	client, err := NewClient(stream, sc.opt)
+	if err != nil {
+		_ = stream.Close()
+		return nil, err
+	}
+	return client, nil
+}
+
+// Call 在一个空闲的流上调用，没有空闲的流时打开新的流
+func (sc *SessionClient) Call(ctx context.Context, serviceMethod string, args, reply interface{}, opts ...CallOption) error {
+	client, err := sc.get()
+	if err != nil {
+		return err
+	}
+	defer sc.put(client)
+	return client.Call(ctx, serviceMethod, args, reply, opts...)
+}
+
+func (sc *SessionClient) get() (*Client, error) {
+	sc.mu.Lock()
+	for len(sc.idle) > 0 && !sc.closed {
+		client := sc.idle[len(sc.idle)-1]
+		sc.idle = sc.idle[:len(sc.idle)-1]
+		if client.IsAvailable() {
+			sc.mu.Unlock()
+			return client, nil
+		}
+		_ = client.Close()
+	}
+	closed := sc.closed
+	sc.mu.Unlock()
+	if closed {
+		return nil, ErrShutdown
+	}
+	return sc.OpenClient()
+}
+
+// 归还调用结束的流，连接不可用或 sc 已关闭时关闭流
+func (sc *SessionClient) put(client *Client) {
+	sc.mu.Lock()
+	if sc.closed || !client.IsAvailable() {
+		sc.mu.Unlock()
+		_ = client.Close()
+		return
+	}
+	sc.idle = append(sc.idle, client)
+	sc.mu.Unlock()
+}
+
+// IsAvailable 返回会话是否可用
+func (sc *SessionClient) IsAvailable() bool {
+	return !sc.session.IsClosed()
+}
+
+// Close 关闭会话，所有的流上 pending 状态的 call 以 ErrShutdown 结束
+func (sc *SessionClient) Close() error {
+	sc.mu.Lock()
+	if sc.closed {
+		sc.mu.Unlock()
+		return ErrShutdown
+	}
+	sc.closed = true
+	idle := sc.idle
+	sc.idle = nil
+	sc.mu.Unlock()
+
+	for _, client := range idle {
+		_ = client.Close()
+	}
+	return sc.session.Close()
+}
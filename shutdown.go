@@ -0,0 +1,126 @@
+package geerpc
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+
+	"geerpc/codec"
+)
+
+// shutdownPollInterval 为 Shutdown 检查连接上是否还有正在处理的请求的间隔
+const shutdownPollInterval = 50 * time.Millisecond
+
+// shutdownDrainGrace 为发送 GOAWAY 后继续读取连接的最短时间。客户端在收到 GOAWAY 前发出的请求可能仍在传输中，
+// 连接在 GOAWAY 之后且最近一次收到消息之后空闲满该时间才关闭。客户端在连接上的调用全部结束后主动关闭连接，
+// 通常不需要等待该时间
+const shutdownDrainGrace = time.Second
+
+// serverConn 为 Shutdown 需要的连接状态
+type serverConn struct {
+	cc      codec.Codec
+	sending *sync.Mutex
+	reqs    *requestSet
+}
+
+// Shutdown 优雅地关闭 Server：关闭 Accept 使用的 listener，向所有连接发送 GOAWAY 控制帧，
+// 客户端收到后不再在该连接上发起新的调用，连接上的请求全部完成且客户端关闭连接或空闲超过 shutdownDrainGrace 后关闭连接。
+// 所有连接关闭后返回 nil，ctx 先结束时立即关闭剩余的连接并返回 ctx.Err()
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	s.shuttingDown = true
+	for l := range s.listeners {
+		_ = l.Close()
+	}
+	conns := make([]*serverConn, 0, len(s.activeConns))
+	for sc := range s.activeConns {
+		conns = append(conns, sc)
+	}
+	s.mu.Unlock()
+
+	s.logger.Infof("rpc server: shutting down, draining %d connections", len(conns))
+	for _, sc := range conns {
+		_, _ = s.sendResponse(sc.cc, &codec.Header{Flags: codec.FlagGoAway}, nil, sc.sending)
+	}
+	goaway := time.Now()
+
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+	for {
+		if s.closeIdleConns(goaway) {
+			return nil
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			s.mu.Lock()
+			for sc := range s.activeConns {
+				_ = sc.cc.Close()
+				delete(s.activeConns, sc)
+			}
+			s.mu.Unlock()
+			return ctx.Err()
+		}
+	}
+}
+
+// 关闭没有正在处理的请求的连接，返回是否所有连接均已关闭。在 goaway 之后以及最近一次收到消息之后
+// 不满 shutdownDrainGrace 的连接继续读取，避免丢弃客户端在收到 GOAWAY 前发出的请求
+func (s *Server) closeIdleConns(goaway time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if time.Since(goaway) < shutdownDrainGrace {
+		return len(s.activeConns) == 0
+	}
+	for sc := range s.activeConns {
+		if sc.reqs.idle() >= shutdownDrainGrace {
+			_ = sc.cc.Close()
+			delete(s.activeConns, sc)
+		}
+	}
+	return len(s.activeConns) == 0
+}
+
+// 返回是否已经调用了 Shutdown，此时拒绝新的连接
+func (s *Server) isShuttingDown() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.shuttingDown
+}
+
+// 登记或移除 Accept 使用的 listener，正在关闭时拒绝登记并返回 false
+func (s *Server) trackListener(l net.Listener, add bool) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !add {
+		delete(s.listeners, l)
+		return true
+	}
+	if s.shuttingDown {
+		return false
+	}
+	if s.listeners == nil {
+		s.listeners = make(map[net.Listener]struct{})
+	}
+	s.listeners[l] = struct{}{}
+	return true
+}
+
+// 登记或移除连接，正在关闭时拒绝登记并返回 false
+func (s *Server) trackConn(sc *serverConn, add bool) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !add {
+		delete(s.activeConns, sc)
+		return true
+	}
+	if s.shuttingDown {
+		return false
+	}
+	if s.activeConns == nil {
+		s.activeConns = make(map[*serverConn]struct{})
+	}
+	s.activeConns[sc] = struct{}{}
+	return true
+}
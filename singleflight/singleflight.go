@@ -0,0 +1,94 @@
+// Package singleflight 提供合并重复调用的客户端拦截器：同一时刻方法、参数与 reply 类型都相同的调用
+// 只发出一个请求，其余调用等待该请求结束并共享它的 reply 与错误，适用于读多且重复查询较多的场景
+package singleflight
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"fmt"
+	"reflect"
+	"sync"
+
+	"geerpc"
+)
+
+// Config 为合并调用的配置
+type Config struct {
+	// Methods 为允许合并的方法，key 为 Service.Method，为空时合并所有方法。只应合并没有副作用的方法
+	Methods map[string]bool
+	// Key 返回调用的合并键，ok 为 false 时不合并该调用。为 nil 时使用方法名、reply 类型与 gob 编码后参数的哈希
+	Key func(serviceMethod string, args, reply interface{}) (key string, ok bool)
+}
+
+// 一个正在进行的请求
+type flight struct {
+	done  chan struct{}
+	reply interface{}
+	err   error
+}
+
+type group struct {
+	cfg Config
+
+	mu      sync.Mutex
+	flights map[string]*flight
+}
+
+// Interceptor 返回按 cfg 合并重复调用的客户端拦截器。
+// 合并的调用共享发出请求的调用的 ctx 与 CallOption，reply 为浅拷贝，其中的 map、slice 等引用类型在调用之间共享；
+// 等待中的调用的 ctx 结束时该调用返回 ctx 的错误，不影响正在进行的请求
+func Interceptor(cfg Config) geerpc.ClientInterceptor {
+	g := &group{cfg: cfg, flights: make(map[string]*flight)}
+	return func(ctx context.Context, serviceMethod string, args, reply interface{}, invoker geerpc.Invoker, opts ...geerpc.CallOption) error {
+		key, ok := g.key(serviceMethod, args, reply)
+		if !ok {
+			return invoker(ctx, serviceMethod, args, reply, opts...)
+		}
+
+		g.mu.Lock()
+		if f, ok := g.flights[key]; ok {
+			g.mu.Unlock()
+			select {
+			case <-f.done:
+			case <-ctx.Done():
+				return geerpc.Errorf(geerpc.CodeOf(ctx.Err()), "singleflight: wait for %s: %v", serviceMethod, ctx.Err())
+			}
+			if f.err == nil && reply != nil {
+				reflect.ValueOf(reply).Elem().Set(reflect.ValueOf(f.reply).Elem())
+			}
+			return f.err
+		}
+		f := &flight{done: make(chan struct{}), reply: reply}
+		g.flights[key] = f
+		g.mu.Unlock()
+
+		f.err = invoker(ctx, serviceMethod, args, reply, opts...)
+
+		g.mu.Lock()
+		delete(g.flights, key)
+		g.mu.Unlock()
+		close(f.done)
+		return f.err
+	}
+}
+
+func (g *group) key(serviceMethod string, args, reply interface{}) (string, bool) {
+	if len(g.cfg.Methods) > 0 && !g.cfg.Methods[serviceMethod] {
+		return "", false
+	}
+	if g.cfg.Key != nil {
+		return g.cfg.Key(serviceMethod, args, reply)
+	}
+	if reply != nil && reflect.TypeOf(reply).Kind() != reflect.Ptr {
+		return "", false
+	}
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(args); err != nil {
+		return "", false
+	}
+	sum := sha256.Sum256(buf.Bytes())
+	return serviceMethod + "|" + fmt.Sprintf("%T", reply) + "|" + hex.EncodeToString(sum[:]), true
+}
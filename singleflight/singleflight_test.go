@@ -0,0 +1,74 @@
+package singleflight
+
+import (
+	"context"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"geerpc"
+)
+
+type Lookup struct{ calls int32 }
+
+func (l *Lookup) Get(key string, reply *string) error {
+	atomic.AddInt32(&l.calls, 1)
+	time.Sleep(50 * time.Millisecond)
+	*reply = "value of " + key
+	return nil
+}
+
+func TestInterceptor(t *testing.T) {
+	lookup := &Lookup{}
+	server := geerpc.NewServer()
+	_ = server.Register(lookup)
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	defer func() { _ = l.Close() }()
+	go server.Accept(l)
+
+	client, err := geerpc.Dial("tcp", l.Addr().String(), geerpc.DefaultOption)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = client.Close() }()
+	client.Use(Interceptor(Config{}))
+
+	var wg sync.WaitGroup
+	replies := make([]string, 10)
+	for i := range replies {
+		wg.Add(1)
+		key := "a"
+		if i%2 == 1 {
+			key = "b"
+		}
+		go func(i int, key string) {
+			defer wg.Done()
+			if err := client.Call(context.Background(), "Lookup.Get", key, &replies[i]); err != nil {
+				t.Error(err)
+			}
+		}(i, key)
+	}
+	wg.Wait()
+
+	for i, reply := range replies {
+		want := "value of a"
+		if i%2 == 1 {
+			want = "value of b"
+		}
+		if reply != want {
+			t.Fatalf("reply %d: expect %q, got %q", i, want, reply)
+		}
+	}
+	if calls := atomic.LoadInt32(&lookup.calls); calls != 2 {
+		t.Fatalf("expect 2 requests for 2 distinct keys, got %d", calls)
+	}
+
+	// 请求结束后不再合并
+	var reply string
+	_ = client.Call(context.Background(), "Lookup.Get", "a", &reply)
+	if calls := atomic.LoadInt32(&lookup.calls); calls != 3 {
+		t.Fatalf("expect a new request after the first one finished, got %d", calls)
+	}
+}
@@ -0,0 +1,106 @@
+package geerpc
+
+import "fmt"
+
+// ConnState 为连接的状态，状态只会按照 StateConnecting、StateReady、StateDraining、StateClosed 的顺序前进
+type ConnState int
+
+const (
+	// StateConnecting 表示正在握手，Transport 在握手完成后才返回，因此只通过 Option.OnStateChange 通知
+	StateConnecting ConnState = iota
+	// StateReady 表示连接可以发起调用
+	StateReady
+	// StateDraining 表示服务端正在关闭连接（见 Server.Shutdown）或 Client 正在优雅关闭，新的调用会失败，已发出的调用不受影响
+	StateDraining
+	// StateClosed 表示连接已关闭或断开
+	StateClosed
+)
+
+func (s ConnState) String() string {
+	switch s {
+	case StateConnecting:
+		return "Connecting"
+	case StateReady:
+		return "Ready"
+	case StateDraining:
+		return "Draining"
+	case StateClosed:
+		return "Closed"
+	}
+	return fmt.Sprintf("ConnState(%d)", int(s))
+}
+
+// State 返回连接的状态
+func (t *Transport) State() ConnState {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.state
+}
+
+// OnStateChange 在连接的状态变化时调用 f，f 在引起变化的 goroutine 中同步调用，不能阻塞
+func (t *Transport) OnStateChange(f func(ConnState)) {
+	t.mu.Lock()
+	t.watchers = append(t.watchers, f)
+	t.mu.Unlock()
+}
+
+// NumPending 返回连接上尚未结束的调用数
+func (t *Transport) NumPending() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.pending)
+}
+
+// 根据连接的标记更新状态，状态前进时通知 Option.OnStateChange 与 OnStateChange 注册的回调，调用方不能持有 t.mu
+func (t *Transport) updateState() {
+	t.mu.Lock()
+	state := StateReady
+	switch {
+	case t.closing || t.shutdown:
+		state = StateClosed
+	case t.draining:
+		state = StateDraining
+	}
+	if state <= t.state {
+		t.mu.Unlock()
+		return
+	}
+	t.state = state
+	watchers := t.watchers
+	t.mu.Unlock()
+
+	t.logger.Debugf("rpc client: connection to %s is %s", t.target, state)
+	if t.opt.OnStateChange != nil {
+		t.opt.OnStateChange(t.target, state)
+	}
+	for _, f := range watchers {
+		f(state)
+	}
+}
+
+// State 返回 client 的状态：client 已关闭时为 StateClosed，正在优雅关闭时为 StateDraining，否则为连接的状态
+func (client *Client) State() ConnState {
+	client.mu.Lock()
+	closing, closed := client.closing, client.closed
+	client.mu.Unlock()
+	state := client.t.State()
+	switch {
+	case closed:
+		return StateClosed
+	case closing && state < StateDraining:
+		return StateDraining
+	}
+	return state
+}
+
+// OnStateChange 在 client 使用的连接的状态变化时调用 f，见 Transport.OnStateChange
+func (client *Client) OnStateChange(f func(ConnState)) {
+	client.t.OnStateChange(f)
+}
+
+// NumPending 返回经由 client 发出且尚未结束的调用数
+func (client *Client) NumPending() int {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	return len(client.pending)
+}
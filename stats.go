@@ -0,0 +1,12 @@
+package geerpc
+
+import "time"
+
+// StatsHandler 在客户端每次调用的开始与结束时被调用，用于采集指标，见 geerpc/metrics。
+// target 为服务端地址，通过 Option.StatsHandler 设置
+type StatsHandler interface {
+	CallStart(target, serviceMethod string)
+	CallEnd(target, serviceMethod string, err error, elapsed time.Duration)
+	// Reconnect 在 XClient 因连接不可用而重新连接 target 时调用
+	Reconnect(target string)
+}
@@ -0,0 +1,482 @@
+package geerpc
+
+import (
+	"context"
+	"errors"
+	"geerpc/codec"
+	"io"
+	"reflect"
+	"sync"
+	"sync/atomic"
+)
+
+// 流控窗口：每个方向上最多允许对端有 streamWindowSize 条未被消费的消息，
+// 接收方每消费 streamWindowSize/2 条消息后向对端发送窗口更新
+const streamWindowSize = 64
+
+var typeOfServerStream = reflect.TypeOf((*ServerStream)(nil))
+
+// ErrStreamClosed 表示在本地关闭的流上接收消息
+var ErrStreamClosed = errors.New("rpc client: stream closed")
+
+// streamQueue 缓存已解码但尚未被消费的消息
+type streamQueue struct {
+	mu     sync.Mutex
+	queue  []reflect.Value
+	err    error
+	notify chan struct{}
+	done   chan struct{}
+}
+
+func newStreamQueue() *streamQueue {
+	return &streamQueue{
+		notify: make(chan struct{}, 1),
+		done:   make(chan struct{}),
+	}
+}
+
+func (q *streamQueue) push(v reflect.Value) {
+	q.mu.Lock()
+	q.queue = append(q.queue, v)
+	q.mu.Unlock()
+	q.wakeup()
+}
+
+// 阻塞直到取得下一条消息，队列结束后返回结束时的错误
+func (q *streamQueue) pop() (reflect.Value, error) {
+	for {
+		q.mu.Lock()
+		if len(q.queue) > 0 {
+			v := q.queue[0]
+			q.queue = q.queue[1:]
+			q.mu.Unlock()
+			return v, nil
+		}
+		err := q.err
+		q.mu.Unlock()
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		<-q.notify
+	}
+}
+
+// 结束队列，err 为 nil 表示对端正常结束，此后 pop 在取完缓存的消息后返回 io.EOF
+func (q *streamQueue) finish(err error) {
+	q.mu.Lock()
+	if q.err != nil {
+		q.mu.Unlock()
+		return
+	}
+	if err == nil {
+		err = io.EOF
+	}
+	q.err = err
+	close(q.done)
+	q.mu.Unlock()
+	q.wakeup()
+}
+
+func (q *streamQueue) wakeup() {
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+}
+
+// streamWindow 为发送方剩余的流控窗口
+type streamWindow struct {
+	mu     sync.Mutex
+	avail  int
+	notify chan struct{}
+}
+
+func newStreamWindow() *streamWindow {
+	return &streamWindow{avail: streamWindowSize, notify: make(chan struct{}, 1)}
+}
+
+// 占用一条消息的窗口，窗口耗尽时阻塞直到收到窗口更新、ctx 结束或 done 被关闭
+func (w *streamWindow) acquire(ctx context.Context, done <-chan struct{}) error {
+	for {
+		w.mu.Lock()
+		if w.avail > 0 {
+			w.avail--
+			left := w.avail
+			w.mu.Unlock()
+			if left > 0 {
+				w.wakeup()
+			}
+			return nil
+		}
+		w.mu.Unlock()
+
+		select {
+		case <-w.notify:
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-done:
+			return io.EOF
+		}
+	}
+}
+
+func (w *streamWindow) release(n int) {
+	w.mu.Lock()
+	w.avail += n
+	w.mu.Unlock()
+	w.wakeup()
+}
+
+func (w *streamWindow) wakeup() {
+	select {
+	case w.notify <- struct{}{}:
+	default:
+	}
+}
+
+// 记录已消费的消息数，达到阈值时返回需要归还给对端的窗口大小
+type streamConsumer struct {
+	mu       sync.Mutex
+	consumed int
+}
+
+func (c *streamConsumer) consume() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.consumed++
+	if c.consumed < streamWindowSize/2 {
+		return 0
+	}
+	n := c.consumed
+	c.consumed = 0
+	return n
+}
+
+// ServerStream 由流式方法使用，方法形式为
+// Method(args T, stream *geerpc.ServerStream) error 或 Method(ctx context.Context, args T, stream *geerpc.ServerStream) error。
+// args 为客户端发送的第一条消息，之后的消息（同为类型 T）通过 Recv 读取；
+// 方法返回即表示流结束，返回的 error 会随结束标记传递给客户端
+type ServerStream struct {
+	ctx     context.Context
+	server  *Server
+	cc      codec.Codec
+	h       codec.Header
+	sending *sync.Mutex
+	mtype   *methodType
+	req     *Request
+
+	recv     *streamQueue
+	window   *streamWindow
+	consumer streamConsumer
+}
+
+func newServerStream(s *Server, cc codec.Codec, req *Request, sending *sync.Mutex) *ServerStream {
+	ss := &ServerStream{
+		server: s,
+		cc:     cc,
+		h: codec.Header{
+			ServiceMethod: req.H.ServiceMethod,
+			Seq:           req.H.Seq,
+			Flags:         codec.FlagStream,
+		},
+		sending: sending,
+		mtype:   req.mtype,
+		req:     req,
+		recv:    newStreamQueue(),
+		window:  newStreamWindow(),
+	}
+	// 客户端只发送一条消息
+	if req.H.Flags&codec.FlagEndStream != 0 {
+		ss.recv.finish(nil)
+	}
+	return ss
+}
+
+// Context 返回该流的 context，客户端断开或超时后会被取消
+func (ss *ServerStream) Context() context.Context {
+	return ss.ctx
+}
+
+// Send 向客户端发送一条消息，客户端未及时消费时会阻塞
+func (ss *ServerStream) Send(v interface{}) error {
+	if err := ss.window.acquire(ss.ctx, nil); err != nil {
+		return err
+	}
+	h := ss.h
+	n, err := ss.server.sendResponse(ss.cc, &h, v, ss.sending)
+	atomic.AddInt64(&ss.req.bytesOut, n)
+	if errors.Is(err, codec.ErrMessageTooLarge) {
+		// 消息未发送，归还窗口
+		ss.window.release(1)
+	}
+	return codecError(err)
+}
+
+// Recv 将客户端发送的下一条消息写入 v，客户端调用 CloseSend 后返回 io.EOF
+func (ss *ServerStream) Recv(v interface{}) error {
+	msg, err := ss.recv.pop()
+	if err != nil {
+		return err
+	}
+	setValue(v, msg)
+	if n := ss.consumer.consume(); n > 0 {
+		h := ss.h
+		h.Flags |= codec.FlagWindowUpdate
+		_, _ = ss.server.sendResponse(ss.cc, &h, uint32(n), ss.sending)
+	}
+	return nil
+}
+
+// 处理客户端发往已建立的流的消息
+func (ss *ServerStream) receive(cc codec.Codec, header *codec.Header) error {
+	switch {
+	case header.Flags&codec.FlagWindowUpdate != 0:
+		var n uint32
+		if err := cc.ReadBody(&n); err != nil {
+			return err
+		}
+		ss.window.release(int(n))
+	case header.Flags&codec.FlagEndStream != 0:
+		ss.recv.finish(nil)
+		return cc.ReadBody(nil)
+	default:
+		argv := ss.mtype.newArgv()
+		args := argv.Interface()
+		if argv.Kind() != reflect.Ptr {
+			args = argv.Addr().Interface()
+		}
+		if err := cc.ReadBody(args); err != nil {
+			if errors.Is(err, codec.ErrMessageTooLarge) {
+				// 消息已被丢弃，结束接收并由 Recv 返回错误，连接继续使用
+				ss.recv.finish(codecError(err))
+				return nil
+			}
+			return err
+		}
+		ss.recv.push(reflect.ValueOf(args))
+	}
+	return nil
+}
+
+// 执行流式方法，并在方法返回后发送流结束标记
+func (s *Server) handleStream(ctx context.Context, req *Request, rmd *responseMetadata) error {
+	ss := req.stream
+	ss.ctx = ctx
+	err := s.call(ctx, req.svc, req.mtype, req.Arg, reflect.ValueOf(ss))
+	ss.recv.finish(ErrStreamClosed)
+
+	h := ss.h
+	h.Flags |= codec.FlagEndStream
+	h.Metadata = rmd.get()
+	if err != nil {
+		setHeaderError(&h, err)
+	}
+	n, _ := s.sendResponse(ss.cc, &h, invalidRequest, ss.sending)
+	atomic.AddInt64(&req.bytesOut, n)
+	return err
+}
+
+// ClientStream 为客户端一侧的流
+type ClientStream struct {
+	ctx       context.Context
+	client    *Client
+	call      *Call
+	replyType reflect.Type
+
+	sendMu   sync.Mutex
+	opened   bool // 第一条消息（即请求）是否已发送
+	recv     *streamQueue
+	window   *streamWindow
+	consumer streamConsumer
+}
+
+func (client *Client) newClientStream(ctx context.Context, serviceMethod string, reply interface{}, opts []CallOption) (*ClientStream, error) {
+	replyType := reflect.TypeOf(reply)
+	if replyType == nil || replyType.Kind() != reflect.Ptr {
+		return nil, errors.New("rpc client: stream reply must be a pointer")
+	}
+
+	cs := &ClientStream{
+		ctx:       ctx,
+		client:    client,
+		replyType: replyType,
+		recv:      newStreamQueue(),
+		window:    newStreamWindow(),
+	}
+	cs.call = &Call{
+		ServerMethod: serviceMethod,
+		Done:         make(chan *Call, 1),
+		stream:       cs,
+	}
+	cs.call.deadline, _ = ctx.Deadline()
+	for _, opt := range opts {
+		opt(cs.call)
+	}
+	return cs, nil
+}
+
+// 流结束前 ctx 被取消时结束流
+func (cs *ClientStream) watch() {
+	select {
+	case <-cs.ctx.Done():
+		if cs.client.t.removeCall(cs.call.Seq) != nil {
+			cs.client.t.cancelCall(cs.call.Seq)
+			cs.call.Error = &Error{Code: CodeOf(cs.ctx.Err()), Message: "rpc client: stream failed: " + cs.ctx.Err().Error()}
+			cs.call.done()
+		}
+	case <-cs.recv.done:
+	}
+}
+
+// Stream 调用服务端流式方法，args 为唯一的请求消息，reply 为服务端消息类型的指针，例如 new(int)，仅用于确定消息的类型
+func (client *Client) Stream(ctx context.Context, serviceMethod string, args, reply interface{}, opts ...CallOption) (*ClientStream, error) {
+	cs, err := client.newClientStream(ctx, serviceMethod, reply, opts)
+	if err != nil {
+		return nil, err
+	}
+	cs.opened = true
+	cs.call.Args = args
+	cs.call.flags |= codec.FlagEndStream
+	if client.send(ctx, cs.call) == nil {
+		go cs.watch()
+	}
+	return cs, nil
+}
+
+// NewStream 建立客户端流或双向流，第一次 Send 的消息作为方法的 args 发送，
+// reply 为服务端消息类型的指针，例如 new(int)，仅用于确定消息的类型
+func (client *Client) NewStream(ctx context.Context, serviceMethod string, reply interface{}, opts ...CallOption) (*ClientStream, error) {
+	return client.newClientStream(ctx, serviceMethod, reply, opts)
+}
+
+// Send 向服务端发送一条消息，服务端未及时消费时会阻塞
+func (cs *ClientStream) Send(v interface{}) error {
+	cs.sendMu.Lock()
+	defer cs.sendMu.Unlock()
+
+	if !cs.opened {
+		cs.opened = true
+		cs.call.Args = v
+		if cs.client.send(cs.ctx, cs.call) == nil {
+			go cs.watch()
+		}
+		return cs.sendErr()
+	}
+
+	if err := cs.window.acquire(cs.ctx, cs.recv.done); err != nil {
+		return cs.sendErr()
+	}
+	err := cs.client.t.write(&codec.Header{Seq: cs.call.Seq, Flags: codec.FlagStream}, v)
+	if errors.Is(err, codec.ErrMessageTooLarge) {
+		cs.window.release(1)
+	}
+	return codecError(err)
+}
+
+// CloseSend 通知服务端不再发送消息，服务端的 Recv 将返回 io.EOF
+func (cs *ClientStream) CloseSend() error {
+	cs.sendMu.Lock()
+	defer cs.sendMu.Unlock()
+
+	if !cs.opened {
+		return errors.New("rpc client: stream closed before sending any message")
+	}
+	return cs.client.t.write(&codec.Header{Seq: cs.call.Seq, Flags: codec.FlagStream | codec.FlagEndStream}, invalidRequest)
+}
+
+// 流已结束时返回结束原因
+func (cs *ClientStream) sendErr() error {
+	select {
+	case <-cs.recv.done:
+		if cs.call.Error != nil {
+			return cs.call.Error
+		}
+		return io.EOF
+	default:
+		return nil
+	}
+}
+
+// Recv 将服务端发送的下一条消息写入 reply，服务端正常结束流后返回 io.EOF
+func (cs *ClientStream) Recv(reply interface{}) error {
+	v, err := cs.recv.pop()
+	if err != nil {
+		return err
+	}
+	setValue(reply, v)
+	if n := cs.consumer.consume(); n > 0 {
+		_ = cs.client.t.write(&codec.Header{Seq: cs.call.Seq, Flags: codec.FlagStream | codec.FlagWindowUpdate}, uint32(n))
+	}
+	return nil
+}
+
+// Header 返回服务端在流结束时返回的 metadata
+func (cs *ClientStream) Header() Metadata {
+	<-cs.recv.done
+	return cs.call.ResponseMetadata
+}
+
+// Close 在本地关闭流，之后到达的消息会被丢弃
+func (cs *ClientStream) Close() error {
+	cs.sendMu.Lock()
+	opened := cs.opened
+	cs.sendMu.Unlock()
+	if !opened {
+		cs.recv.finish(ErrStreamClosed)
+		return nil
+	}
+	if cs.client.t.removeCall(cs.call.Seq) != nil {
+		cs.client.t.cancelCall(cs.call.Seq)
+		cs.call.Error = ErrStreamClosed
+		cs.call.done()
+	}
+	return nil
+}
+
+// 将 src（指针）指向的值写入 dst（指针）
+func setValue(dst interface{}, src reflect.Value) {
+	reflect.ValueOf(dst).Elem().Set(src.Elem())
+}
+
+// 处理流消息
+func (t *Transport) receiveStream(header *codec.Header) {
+	var call *Call
+	if header.Flags&codec.FlagEndStream != 0 {
+		call = t.removeCall(header.Seq)
+	} else {
+		t.mu.Lock()
+		call = t.pending[header.Seq]
+		t.mu.Unlock()
+	}
+	if call == nil || call.stream == nil {
+		_ = t.cc.ReadBody(nil)
+		return
+	}
+
+	cs := call.stream
+	switch {
+	case header.Flags&codec.FlagWindowUpdate != 0:
+		var n uint32
+		if err := t.cc.ReadBody(&n); err == nil {
+			cs.window.release(int(n))
+		}
+	case header.Flags&codec.FlagEndStream != 0:
+		_ = t.cc.ReadBody(nil)
+		call.ResponseMetadata = header.Metadata
+		if call.responseMetadata != nil {
+			*call.responseMetadata = header.Metadata
+		}
+		call.Error = headerError(header)
+		call.done()
+	default:
+		v := reflect.New(cs.replyType.Elem())
+		if err := t.cc.ReadBody(v.Interface()); err != nil {
+			if t.removeCall(header.Seq) != nil {
+				call.Error = readBodyError(err)
+				call.done()
+			}
+			return
+		}
+		cs.recv.push(v)
+	}
+}
@@ -0,0 +1,227 @@
+package geerpc
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"sync"
+	"testing"
+	"time"
+)
+
+type Counter int
+
+func (c Counter) Count(n int, stream *ServerStream) error {
+	for i := 0; i < n; i++ {
+		if err := stream.Send(i); err != nil {
+			return err
+		}
+	}
+	if n < 0 {
+		return Errorf(InvalidArgument, "negative count %d", n)
+	}
+	return nil
+}
+
+func (c Counter) Forever(ctx context.Context, n int, stream *ServerStream) error {
+	for i := 0; ; i++ {
+		if err := stream.Send(i); err != nil {
+			return err
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestClient_Stream(t *testing.T) {
+	server := NewServer()
+	_ = server.Register(new(Counter))
+	client := pipeClient(t, server, DefaultOption)
+	defer func() { _ = client.Close() }()
+
+	t.Run("recv all", func(t *testing.T) {
+		stream, err := client.Stream(context.Background(), "Counter.Count", 5, new(int))
+		_assert(err == nil, "failed to open stream: %v", err)
+		var got []int
+		for {
+			var n int
+			if err = stream.Recv(&n); err != nil {
+				break
+			}
+			got = append(got, n)
+		}
+		_assert(err == io.EOF && len(got) == 5 && got[4] == 4, "expect 5 messages and io.EOF, got %v, err: %v", got, err)
+	})
+
+	t.Run("error", func(t *testing.T) {
+		stream, _ := client.Stream(context.Background(), "Counter.Count", -1, new(int))
+		err := stream.Recv(new(int))
+		_assert(CodeOf(err) == InvalidArgument, "expect InvalidArgument, got %v", err)
+	})
+
+	t.Run("context cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		stream, _ := client.Stream(ctx, "Counter.Forever", 0, new(int))
+		var n int
+		_assert(stream.Recv(&n) == nil, "failed to recv first message")
+		cancel()
+		var err error
+		for err == nil {
+			err = stream.Recv(&n)
+		}
+		_assert(CodeOf(err) == Canceled, "expect Canceled, got %v", err)
+	})
+}
+
+// Sum 累加客户端发送的所有数字，并在每次累加后返回当前的和
+func (c Counter) Sum(n int, stream *ServerStream) error {
+	sum := n
+	if err := stream.Send(sum); err != nil {
+		return err
+	}
+	for {
+		if err := stream.Recv(&n); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		sum += n
+		if err := stream.Send(sum); err != nil {
+			return err
+		}
+	}
+}
+
+func TestClient_BidiStream(t *testing.T) {
+	server := NewServer()
+	_ = server.Register(new(Counter))
+	client := pipeClient(t, server, DefaultOption)
+	defer func() { _ = client.Close() }()
+
+	stream, err := client.NewStream(context.Background(), "Counter.Sum", new(int))
+	_assert(err == nil, "failed to open stream: %v", err)
+
+	// 发送超过窗口大小的消息，验证窗口更新
+	const total = streamWindowSize * 3
+	done := make(chan error, 1)
+	go func() {
+		for i := 1; i <= total; i++ {
+			if err := stream.Send(i); err != nil {
+				done <- err
+				return
+			}
+		}
+		done <- stream.CloseSend()
+	}()
+
+	var sum, last int
+	for {
+		if err = stream.Recv(&sum); err != nil {
+			break
+		}
+		last = sum
+	}
+	_assert(err == io.EOF && last == total*(total+1)/2, "expect sum %d, got %d, err: %v", total*(total+1)/2, last, err)
+	_assert(<-done == nil, "failed to send")
+}
+
+func TestStreamWindow(t *testing.T) {
+	w := newStreamWindow()
+	for i := 0; i < streamWindowSize; i++ {
+		_assert(w.acquire(context.Background(), nil) == nil, "failed to acquire window")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*10)
+	defer cancel()
+	_assert(w.acquire(ctx, nil) == context.DeadlineExceeded, "expect acquire to block on exhausted window")
+
+	w.release(1)
+	_assert(w.acquire(context.Background(), nil) == nil, "expect acquire to succeed after release")
+}
+
+type Files struct {
+	mu    sync.Mutex
+	files map[string][]byte
+}
+
+func (f *Files) Upload(args FileChunk, stream *ServerStream) error {
+	f.mu.Lock()
+	buf := bytes.NewBuffer(f.files[args.Name])
+	f.mu.Unlock()
+	_, err := stream.RecvFile(buf, int64(buf.Len()))
+	f.mu.Lock()
+	f.files[args.Name] = buf.Bytes()
+	f.mu.Unlock()
+	return err
+}
+
+func (f *Files) Download(args FileChunk, stream *ServerStream) error {
+	f.mu.Lock()
+	data := f.files[args.Name]
+	f.mu.Unlock()
+	_, err := stream.SendFile(bytes.NewReader(data), args.Offset)
+	return err
+}
+
+func TestClient_FileTransfer(t *testing.T) {
+	content := make([]byte, FileChunkSize*3+100)
+	for i := range content {
+		content[i] = byte(i)
+	}
+	// 服务端已经收到了一部分数据
+	files := &Files{files: map[string][]byte{
+		"a": append([]byte(nil), content[:FileChunkSize+10]...),
+		"b": append([]byte(nil), content[:50]...),
+	}}
+	server := NewServer()
+	_ = server.Register(files)
+	client := pipeClient(t, server, DefaultOption)
+	defer func() { _ = client.Close() }()
+	ctx := context.Background()
+
+	n, err := client.SendFile(ctx, "Files.Upload", "a", bytes.NewReader(content))
+	_assert(err == nil && n == int64(len(content)), "failed to upload: %d, %v", n, err)
+	_assert(bytes.Equal(files.files["a"], content), "expect upload to resume from server offset")
+
+	// 不支持 Seek 的 reader 跳过已传输的数据
+	n, err = client.SendFile(ctx, "Files.Upload", "b", io.MultiReader(bytes.NewReader(content)))
+	_assert(err == nil && n == int64(len(content)) && bytes.Equal(files.files["b"], content), "failed to upload b: %v", err)
+
+	// 从本地已有的位置续传下载
+	var local bytes.Buffer
+	local.Write(content[:100])
+	n, err = client.RecvFile(ctx, "Files.Download", "a", &local, 100)
+	_assert(err == nil && n == int64(len(content)), "failed to download: %d, %v", n, err)
+	_assert(bytes.Equal(local.Bytes(), content), "expect downloaded content to match")
+}
+
+func TestClient_Subscribe(t *testing.T) {
+	p := NewPublisher()
+	server := NewServer(WithPublisher(p))
+	_ = server.Register(Echo{})
+	client := pipeClient(t, server, DefaultOption)
+	defer func() { _ = client.Close() }()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	news, err := client.Subscribe(ctx, "news", new(string))
+	_assert(err == nil, "failed to subscribe: %v", err)
+	for i := 0; i < 100 && p.Subscribers("news") == 0; i++ {
+		time.Sleep(time.Millisecond * 10)
+	}
+	_assert(p.Publish("news", "hello") == 1 && p.Publish("sports", "ignored") == 0, "expect one subscriber")
+
+	// 订阅与普通调用共用连接
+	var reply string
+	err = client.Call(context.Background(), "Echo.Repeat", 2, &reply)
+	_assert(err == nil && reply == "xx", "expect call to succeed alongside subscription, got %v", err)
+
+	msg := <-news
+	_assert(*msg.(*string) == "hello", "expect hello, got %v", msg)
+
+	cancel()
+	for range news {
+	}
+	for i := 0; i < 100 && p.Subscribers("news") > 0; i++ {
+		time.Sleep(time.Millisecond * 10)
+	}
+	_assert(p.Subscribers("news") == 0, "expect subscriber to be removed after cancel")
+}
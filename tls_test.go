@@ -0,0 +1,218 @@
+package geerpc
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// 生成仅用于测试的自签名证书
+func selfSignedCert(t *testing.T, commonName string) (tls.Certificate, *x509.CertPool) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: commonName},
+		DNSNames:              []string{commonName},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, _ := x509.ParseCertificate(der)
+	pool := x509.NewCertPool()
+	pool.AddCert(leaf)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}, pool
+}
+
+func TestDialTLS(t *testing.T) {
+	cert, pool := selfSignedCert(t, "localhost")
+
+	var foo Foo
+	server := NewServer(WithTLSConfig(&tls.Config{Certificates: []tls.Certificate{cert}}))
+	_ = server.Register(&foo)
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	go server.Accept(l)
+
+	client, err := DialTLS("tcp", l.Addr().String(), &tls.Config{RootCAs: pool}, DefaultOption)
+	_assert(err == nil, "failed to dial tls: %v", err)
+	defer func() { _ = client.Close() }()
+
+	var reply int
+	err = client.Call(context.Background(), "Foo.Sum", &Args{Num1: 1, Num2: 2}, &reply)
+	_assert(err == nil && reply == 3, "failed to call Foo.Sum over tls: %v", err)
+
+	_, err = DialTLS("tcp", l.Addr().String(), &tls.Config{}, DefaultOption)
+	_assert(err != nil, "expect an unknown authority error")
+}
+
+func TestMutualTLS(t *testing.T) {
+	serverCert, serverPool := selfSignedCert(t, "localhost")
+	clientCert, clientPool := selfSignedCert(t, "alice")
+
+	var foo Foo
+	server := NewServer(
+		WithTLSConfig(&tls.Config{Certificates: []tls.Certificate{serverCert}}),
+		WithClientCAs(clientPool),
+	)
+	_ = server.Register(&foo)
+	_ = server.Register(Whoami{})
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	go server.Accept(l)
+
+	t.Run("without client cert", func(t *testing.T) {
+		client, err := DialTLS("tcp", l.Addr().String(), &tls.Config{RootCAs: serverPool}, DefaultOption)
+		if err == nil {
+			// TLS 1.3 中服务端的拒绝在首次读取时才会被客户端感知
+			var reply int
+			err = client.Call(context.Background(), "Foo.Sum", &Args{Num1: 1, Num2: 2}, &reply)
+		}
+		_assert(err != nil, "expect a bad certificate error")
+	})
+
+	t.Run("with client cert", func(t *testing.T) {
+		config := &tls.Config{RootCAs: serverPool, Certificates: []tls.Certificate{clientCert}}
+		client, err := DialTLS("tcp", l.Addr().String(), config, DefaultOption)
+		_assert(err == nil, "failed to dial mutual tls: %v", err)
+		defer func() { _ = client.Close() }()
+
+		var reply int
+		err = client.Call(context.Background(), "Foo.Sum", &Args{Num1: 1, Num2: 2}, &reply)
+		_assert(err == nil && reply == 3, "failed to call Foo.Sum over mutual tls: %v", err)
+	})
+
+	t.Run("peer identity", func(t *testing.T) {
+		config := &tls.Config{RootCAs: serverPool, Certificates: []tls.Certificate{clientCert}}
+		client, err := DialTLS("tcp", l.Addr().String(), config, DefaultOption)
+		_assert(err == nil, "failed to dial mutual tls: %v", err)
+		defer func() { _ = client.Close() }()
+
+		var identity string
+		err = client.Call(context.Background(), "Whoami.Identity", 0, &identity)
+		_assert(err == nil && identity == "alice", "expect handler to see identity alice, got %q, err: %v", identity, err)
+	})
+}
+
+func TestMutualTLS_OptionOrder(t *testing.T) {
+	serverCert, serverPool := selfSignedCert(t, "localhost")
+	clientCert, clientPool := selfSignedCert(t, "alice")
+
+	// WithClientCAs 在 WithTLSConfig 之前时同样要求客户端证书
+	server := NewServer(
+		WithClientCAs(clientPool),
+		WithTLSConfig(&tls.Config{Certificates: []tls.Certificate{serverCert}}),
+	)
+	_ = server.Register(Whoami{})
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	go server.Accept(l)
+
+	client, err := DialTLS("tcp", l.Addr().String(), &tls.Config{RootCAs: serverPool}, DefaultOption)
+	if err == nil {
+		err = client.Call(context.Background(), "Whoami.Identity", 0, new(string))
+	}
+	_assert(err != nil, "expect client without certificate to be rejected")
+	config := &tls.Config{RootCAs: serverPool, Certificates: []tls.Certificate{clientCert}}
+	client, err = DialTLS("tcp", l.Addr().String(), config, DefaultOption)
+	_assert(err == nil, "failed to dial mutual tls: %v", err)
+	defer func() { _ = client.Close() }()
+	var identity string
+	err = client.Call(context.Background(), "Whoami.Identity", 0, &identity)
+	_assert(err == nil && identity == "alice", "expect identity alice, got %q, err: %v", identity, err)
+
+	// 没有 TLS 配置时拒绝提供服务
+	server = NewServer(WithClientCAs(clientPool))
+	l, _ = net.Listen("tcp", "127.0.0.1:0")
+	server.Accept(l)
+	_, err = Dial("tcp", l.Addr().String(), DefaultOption)
+	_assert(err != nil, "expect listener to be closed without tls config")
+	c1, c2 := net.Pipe()
+	go server.handleConn(c2)
+	_, err = NewClient(c1, DefaultOption)
+	_assert(CodeOf(err) == Internal && strings.Contains(err.Error(), "WithClientCAs requires WithTLSConfig"), "expect handshake to be rejected, got %v", err)
+}
+
+// Whoami 返回调用方的客户端证书的身份
+type Whoami struct{}
+
+func (Whoami) Identity(ctx context.Context, _ int, reply *string) error {
+	peer, ok := PeerFromContext(ctx)
+	if !ok {
+		return Errorf(Internal, "no peer in context")
+	}
+	*reply = peer.Identity
+	return nil
+}
+
+func TestDialHTTP2(t *testing.T) {
+	var foo Foo
+	server := NewServer()
+	_ = server.Register(&foo)
+	mux := http.NewServeMux()
+	mux.Handle(defaultRPCPath, server)
+	mux.HandleFunc("/hello", func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = io.WriteString(w, "hello")
+	})
+
+	call := func(t *testing.T, client *Client) {
+		defer func() { _ = client.Close() }()
+		var reply int
+		err := client.Call(context.Background(), "Foo.Sum", &Args{Num1: 1, Num2: 2}, &reply)
+		_assert(err == nil && reply == 3, "failed to call Foo.Sum over http2: %v", err)
+	}
+
+	t.Run("tls", func(t *testing.T) {
+		ts := httptest.NewUnstartedServer(mux)
+		ts.EnableHTTP2 = true
+		ts.StartTLS()
+		defer ts.Close()
+		pool := x509.NewCertPool()
+		pool.AddCert(ts.Certificate())
+
+		client, err := DialHTTP2(context.Background(), ts.URL, &tls.Config{RootCAs: pool}, DefaultOption)
+		_assert(err == nil, "failed to dial http2: %v", err)
+		call(t, client)
+
+		// 与其他 HTTP/2 服务共用端口
+		resp, err := ts.Client().Get(ts.URL + "/hello")
+		_assert(err == nil && resp.ProtoMajor == 2, "expect other handlers to be served over http2: %v", err)
+		_ = resp.Body.Close()
+
+		_, err = DialHTTP2(context.Background(), ts.URL+"/hello", &tls.Config{RootCAs: pool}, DefaultOption)
+		_assert(err != nil, "expect non-rpc endpoint to be rejected")
+	})
+
+	t.Run("h2c", func(t *testing.T) {
+		srv := &http.Server{Handler: mux}
+		if err := EnableH2C(srv); err != nil {
+			t.Skip(err)
+		}
+		l, _ := net.Listen("tcp", "127.0.0.1:0")
+		go func() { _ = srv.Serve(l) }()
+		defer func() { _ = srv.Close() }()
+
+		client, err := XDial("h2c@"+l.Addr().String(), DefaultOption)
+		_assert(err == nil, "failed to dial h2c: %v", err)
+		call(t, client)
+	})
+}
@@ -0,0 +1,543 @@
+package geerpc
+
+import (
+	"context"
+	"crypto/cipher"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"geerpc/codec"
+)
+
+// Transport 为到服务端的一个连接，按 seq 多路复用连接上的调用，可以由多个 Client 共享，
+// 见 Transport.NewClient 与 TransportPool
+type Transport struct {
+	cc     codec.Codec
+	opt    *Option
+	target string // 服务端地址，用于指标采集
+	logger Logger
+
+	sending sync.Mutex
+
+	mu       sync.Mutex
+	seq      uint64
+	pending  map[uint64]*Call
+	chunks   map[uint64]*chunkBuffer // 正在接收的分块响应
+	closing  bool                    // 调用了 Close
+	shutdown bool                    // 连接已断开
+	draining bool                    // 收到了服务端的 GOAWAY，不再发起新的调用
+	state    ConnState               // 由以上标记得到的状态，见 updateState
+	watchers []func(ConnState)       // OnStateChange 注册的回调
+	// 处理服务端发起的反向调用，见 HandleCallbacks
+	callbacks *Server
+
+	lastRead int64         // 上次收到消息的 Unix 纳秒时间戳，需原子访问
+	done     chan struct{} // 接收循环退出时关闭
+	inflight chan struct{} // 容量为 MaxInFlightCalls 的信号量，为 nil 时不限制
+}
+
+// NewTransport 在 conn 上完成握手，返回可以由多个 Client 共享的 Transport。
+// 握手开始前以 StateConnecting 调用 Option.OnStateChange，握手失败时以 StateClosed 调用
+func NewTransport(conn net.Conn, opt *Option) (_ *Transport, err error) {
+	f, ok := codec.NewCodecFuncMap[opt.CodecType]
+	if !ok {
+		return nil, errors.New(string("unknown codec " + opt.CodecType))
+	}
+	if opt.Compressor != "" && codec.GetCompressor(opt.Compressor) == nil {
+		return nil, errors.New("unknown compressor " + opt.Compressor)
+	}
+
+	hs := handshake{Option: opt, Encrypted: opt.EncryptionKey != nil, Chunked: true}
+	if hs.Encrypted {
+		if _, err := codec.NewAESGCM(opt.EncryptionKey); err != nil {
+			return nil, fmt.Errorf("rpc client: invalid encryption key: %w", err)
+		}
+		if hs.Nonce, err = newHandshakeNonce(); err != nil {
+			return nil, err
+		}
+	}
+
+	target := conn.RemoteAddr().String()
+	if opt.OnStateChange != nil {
+		opt.OnStateChange(target, StateConnecting)
+		defer func() {
+			if err != nil {
+				opt.OnStateChange(target, StateClosed)
+			}
+		}()
+	}
+	if err := writeHandshake(conn, hs, opt.LegacyHandshake); err != nil {
+		return nil, err
+	}
+
+	// 等待服务端的握手应答，服务端拒绝时返回其原因
+	var ack handshakeAck
+	conn, _, err = readHandshake(conn, &ack)
+	if err != nil {
+		return nil, fmt.Errorf("rpc client: read handshake ack: %w", err)
+	}
+	if ack.Error != "" {
+		// 旧版本的服务端只在认证失败时返回错误码
+		code := ack.Code
+		if code == OK {
+			code = Unknown
+		}
+		return nil, &Error{Code: code, Message: "rpc client: handshake rejected: " + ack.Error}
+	}
+	if ack.Version != ProtocolVersion {
+		return nil, fmt.Errorf("rpc client: unsupported protocol version %d", ack.Version)
+	}
+	var seal, open cipher.AEAD
+	if hs.Encrypted {
+		// 连接的密钥由预共享密钥与双方的随机数派生
+		if len(ack.Nonce) != handshakeNonceSize {
+			return nil, errors.New("rpc client: server does not support per-connection encryption keys")
+		}
+		salt := append(append([]byte(nil), hs.Nonce...), ack.Nonce...)
+		if seal, open, err = codec.NewSessionAESGCM(opt.EncryptionKey, salt, true); err != nil {
+			return nil, fmt.Errorf("rpc client: invalid encryption key: %w", err)
+		}
+	}
+
+	cc := f(conn)
+	setBufferSizes(cc, opt.ReadBufferSize, opt.WriteBufferSize)
+	setFlushPolicy(cc, opt.FlushDelay, opt.FlushThreshold)
+	setMaxMessageSize(cc, opt.MaxMessageSize)
+	setCompressor(cc, ack.Compressor, opt.CompressThreshold)
+	setCipher(cc, seal, open)
+	setChecksum(cc, ack.Checksum)
+	setTimeouts(cc, opt.ReadTimeout, opt.WriteTimeout)
+	t := &Transport{
+		cc:       cc,
+		opt:      opt,
+		target:   target,
+		logger:   loggerOrNop(opt.Logger),
+		pending:  make(map[uint64]*Call),
+		chunks:   make(map[uint64]*chunkBuffer),
+		lastRead: time.Now().UnixNano(),
+		done:     make(chan struct{}),
+		state:    StateConnecting,
+	}
+	if opt.MaxInFlightCalls > 0 {
+		t.inflight = make(chan struct{}, opt.MaxInFlightCalls)
+	}
+
+	t.updateState()
+	go t.receive()
+	if opt.PingInterval > 0 {
+		go t.keepalive(opt.PingInterval)
+	}
+	return t, nil
+}
+
+// NewClient 返回共享 t 的 Client，Client 的 Close 只结束经由它发出的调用，不关闭连接
+func (t *Transport) NewClient() *Client {
+	return newClient(t, false)
+}
+
+// Close 关闭连接，所有共享 t 的 Client 上 pending 状态的 call 以 ErrShutdown 结束
+func (t *Transport) Close() error {
+	t.mu.Lock()
+	if t.closing {
+		t.mu.Unlock()
+		return ErrShutdown
+	}
+	if t.shutdown {
+		// 连接已经断开
+		t.mu.Unlock()
+		return nil
+	}
+	t.closing = true
+	t.mu.Unlock()
+	t.updateState()
+
+	err := t.cc.Close()
+	t.terminateCalls(ErrShutdown)
+	return err
+}
+
+// IsAvailable 返回连接是否仍可发起调用
+func (t *Transport) IsAvailable() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return !t.closing && !t.shutdown && !t.draining
+}
+
+// 返回是否调用了 Close
+func (t *Transport) isClosing() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.closing
+}
+
+// IsDraining 返回服务端是否已通知即将关闭该连接，此时新的调用以 ErrDraining 失败，已发出的调用不受影响
+func (t *Transport) IsDraining() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.draining
+}
+
+// 注册 RPC
+func (t *Transport) registerCall(call *Call) (uint64, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.closing || t.shutdown {
+		return 0, ErrShutdown
+	}
+	if t.draining {
+		return 0, ErrDraining
+	}
+
+	seq := t.seq
+	call.Seq = seq
+	if call.client != nil && !call.client.track(call) {
+		return 0, ErrShutdown
+	}
+	t.seq += 1
+	t.pending[seq] = call
+	return seq, nil
+}
+
+// 移除对应的 call，并返回
+func (t *Transport) removeCall(seq uint64) *Call {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	call, ok := t.pending[seq]
+	if !ok {
+		return nil
+	}
+
+	delete(t.pending, seq)
+	delete(t.chunks, seq)
+	return call
+}
+
+// 服务端或客户端发生错误时调用，将 shutdown 设置为 true，且将错误信息通知所有 pending 状态的 call
+func (t *Transport) terminateCalls(err error) {
+	defer t.updateState()
+	t.sending.Lock()
+	defer t.sending.Unlock()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.closing {
+		err = ErrShutdown
+	}
+	t.shutdown = true
+	for seq, call := range t.pending {
+		delete(t.pending, seq)
+		call.Error = err
+		call.done()
+	}
+	t.chunks = make(map[uint64]*chunkBuffer)
+}
+
+// 接收 RPC 响应
+func (t *Transport) receive() {
+	defer close(t.done)
+	// 每条消息复用同一个 Header，处理消息的函数不能在返回后继续引用它
+	header := new(codec.Header)
+	for {
+		err := t.cc.ReadHeader(header)
+		if err != nil {
+			if err != io.EOF && !t.isClosing() {
+				t.logger.Errorf("rpc client: read header from %s err: %v", t.target, err)
+			}
+			t.terminateCalls(err)
+			return
+		}
+		atomic.StoreInt64(&t.lastRead, time.Now().UnixNano())
+		if header.Flags&codec.FlagPong != 0 {
+			_ = t.cc.ReadBody(nil)
+			continue
+		}
+		if header.Flags&codec.FlagGoAway != 0 {
+			_ = t.cc.ReadBody(nil)
+			t.mu.Lock()
+			t.draining = true
+			t.mu.Unlock()
+			t.updateState()
+			t.logger.Infof("rpc client: %s is draining", t.target)
+			t.closeIfDrained()
+			continue
+		}
+		if header.Flags&codec.FlagStream != 0 {
+			t.receiveStream(header)
+			continue
+		}
+		if header.Flags&codec.FlagChunk != 0 {
+			t.receiveChunk(header)
+			continue
+		}
+		if header.Flags&codec.FlagProgress != 0 {
+			t.receiveProgress(header)
+			continue
+		}
+		if header.Flags&codec.FlagReverse != 0 {
+			t.receiveReverse(header)
+			continue
+		}
+
+		call := t.removeCall(header.Seq)
+		if call != nil {
+			call.ResponseMetadata = header.Metadata
+			if call.responseMetadata != nil {
+				*call.responseMetadata = header.Metadata
+			}
+		}
+		switch {
+		case call == nil:
+			// 通常表示操作被移除或失败
+			err = t.cc.ReadBody(nil)
+		case header.Code != 0 || header.Error != "":
+			call.Error = headerError(header)
+			err = t.cc.ReadBody(nil)
+			call.done()
+		default:
+			err = readReply(t.cc, call)
+			if err != nil {
+				call.Error = readBodyError(err)
+			}
+			call.done()
+		}
+		if call != nil {
+			t.closeIfDrained()
+		}
+	}
+}
+
+// 收到 GOAWAY 且没有尚未结束的调用时关闭连接，服务端据此得知客户端不会再发送请求，无需等待 shutdownDrainGrace
+func (t *Transport) closeIfDrained() {
+	t.mu.Lock()
+	drained := t.draining && len(t.pending) == 0
+	t.mu.Unlock()
+	if drained {
+		t.logger.Debugf("rpc client: %s drained, closing connection", t.target)
+		_ = t.Close()
+	}
+}
+
+// 获取一个 MaxInFlightCalls 的名额，阻塞模式下等待直到有名额、ctx 结束或连接断开
+func (t *Transport) acquire(ctx context.Context) error {
+	if t.inflight == nil {
+		return nil
+	}
+	if t.opt.InFlightFailFast {
+		select {
+		case t.inflight <- struct{}{}:
+			return nil
+		default:
+			return ErrTooManyCalls
+		}
+	}
+	select {
+	case t.inflight <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return &Error{Code: CodeOf(ctx.Err()), Message: "rpc client: call failed: " + ctx.Err().Error()}
+	case <-t.done:
+		return ErrShutdown
+	}
+}
+
+func (t *Transport) release() {
+	<-t.inflight
+}
+
+// 发送 call，ctx 仅用于等待 MaxInFlightCalls 的名额。call 未能登记时以错误结束 call 并返回该错误，
+// 此时 call 没有对应的 seq，调用方不能再移除或取消它
+func (t *Transport) send(ctx context.Context, call *Call) error {
+	if err := t.acquire(ctx); err != nil {
+		call.Error = err
+		call.done()
+		return err
+	}
+	if t.inflight != nil {
+		call.release = t.release
+	}
+
+	t.sending.Lock()
+	defer t.sending.Unlock()
+
+	if stats := t.opt.StatsHandler; stats != nil {
+		call.stats, call.target, call.start = stats, t.target, time.Now()
+		stats.CallStart(call.target, call.ServerMethod)
+	}
+
+	seq, err := t.registerCall(call)
+	if err != nil {
+		call.Error = err
+		call.done()
+		return err
+	}
+
+	h := getHeader()
+	*h = codec.Header{
+		ServiceMethod: call.ServerMethod,
+		Seq:           seq,
+		Metadata:      call.Metadata,
+		Deadline:      call.deadline,
+		Priority:      call.priority,
+		Flags:         call.flags,
+	}
+	if call.stream != nil {
+		h.Flags |= codec.FlagStream
+	} else {
+		h.ContentType = call.contentType
+	}
+	err = writeCall(t.cc, h, call)
+	putHeader(h)
+
+	if err != nil {
+		call := t.removeCall(seq)
+		if call != nil {
+			call.Error = writeError(err)
+			call.done()
+		}
+	}
+	return nil
+}
+
+// 连接空闲超过 interval 时发送 ping，发送后一个 interval 内没有收到任何消息时关闭连接
+func (t *Transport) keepalive(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	var pingAt int64 // 尚未收到应答的 ping 的发送时间
+	for {
+		select {
+		case <-ticker.C:
+		case <-t.done:
+			return
+		}
+		lastRead := atomic.LoadInt64(&t.lastRead)
+		if pingAt != 0 && lastRead < pingAt {
+			t.logger.Errorf("rpc client: ping %s timeout, closing connection", t.target)
+			_ = t.cc.Close()
+			return
+		}
+		pingAt = 0
+		if time.Since(time.Unix(0, lastRead)) >= interval {
+			pingAt = time.Now().UnixNano()
+			if err := t.write(&codec.Header{Flags: codec.FlagPing}, nil); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// 向连接写入一条不需要登记 call 的消息，例如流消息与控制帧
+func (t *Transport) write(h *codec.Header, body interface{}) error {
+	t.sending.Lock()
+	defer t.sending.Unlock()
+
+	t.mu.Lock()
+	shutdown := t.shutdown
+	t.mu.Unlock()
+	if shutdown {
+		return ErrShutdown
+	}
+	return t.cc.Write(h, body)
+}
+
+// 通知服务端取消 seq 对应的请求
+func (t *Transport) cancelCall(seq uint64) {
+	_ = t.write(&codec.Header{Seq: seq, Flags: codec.FlagCancel}, invalidRequest)
+}
+
+// TransportPool 为每个地址保持一个 Transport，通过 Client 返回的 Client 共享同一个地址的连接，
+// 用于减少大量 Client 或 XClient 访问同一组服务端时的连接数
+type TransportPool struct {
+	opt *Option
+
+	mu      sync.Mutex
+	clients map[string]*Client   // 每个地址独占连接的 Client
+	dials   map[string]*poolDial // 正在建立的连接，同一地址只建立一个
+	closed  bool
+}
+
+// 一次正在建立的连接，done 关闭后 c 与 err 才有效
+type poolDial struct {
+	done chan struct{}
+	c    *Client
+	err  error
+}
+
+// NewTransportPool 返回使用 opt 建立连接的 TransportPool，opt 为 nil 时使用 DefaultOption
+func NewTransportPool(opt *Option) *TransportPool {
+	if opt == nil {
+		opt = DefaultOption
+	}
+	return &TransportPool{opt: opt, clients: make(map[string]*Client), dials: make(map[string]*poolDial)}
+}
+
+// Client 返回共享 rpcAddr 连接的 Client，rpcAddr 的格式与 XDial 相同。
+// 连接不存在、已断开或服务端正在关闭时建立新的连接，建立连接时不阻塞其他地址的调用，
+// 同一地址的并发调用等待同一个连接
+func (p *TransportPool) Client(rpcAddr string) (*Client, error) {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil, ErrShutdown
+	}
+	c, ok := p.clients[rpcAddr]
+	if ok && !c.IsAvailable() {
+		// 正在关闭的连接由服务端在已发出的调用完成后关闭
+		if !c.IsDraining() {
+			_ = c.Close()
+		}
+		delete(p.clients, rpcAddr)
+		c = nil
+	}
+	if c != nil {
+		p.mu.Unlock()
+		return c.Transport().NewClient(), nil
+	}
+	d, dialing := p.dials[rpcAddr]
+	if dialing {
+		p.mu.Unlock()
+		<-d.done
+	} else {
+		d = &poolDial{done: make(chan struct{})}
+		p.dials[rpcAddr] = d
+		p.mu.Unlock()
+
+		d.c, d.err = XDial(rpcAddr, p.opt)
+		p.mu.Lock()
+		delete(p.dials, rpcAddr)
+		if d.err == nil {
+			if p.closed {
+				// 建立连接期间 pool 被关闭
+				_ = d.c.Close()
+				d.c, d.err = nil, ErrShutdown
+			} else {
+				p.clients[rpcAddr] = d.c
+			}
+		}
+		p.mu.Unlock()
+		close(d.done)
+	}
+	if d.err != nil {
+		return nil, d.err
+	}
+	return d.c.Transport().NewClient(), nil
+}
+
+// Close 关闭所有连接
+func (p *TransportPool) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.closed = true
+	for addr, c := range p.clients {
+		_ = c.Close()
+		delete(p.clients, addr)
+	}
+	return nil
+}
@@ -0,0 +1,117 @@
+package xclient
+
+import (
+	"sort"
+	"time"
+)
+
+// DiscoverySource 为 AggregateDiscovery 的一个服务列表来源，Priority 越小优先级越高
+type DiscoverySource struct {
+	Discovery Discovery
+	Priority  int
+}
+
+// AggregateDiscovery 合并多个 Discovery 的服务列表：使用优先级最高、且至少有一个来源返回了非空列表的一组来源，
+// 同一组内的列表合并并按地址去重（先出现的来源的实例信息优先）。例如两个注册中心的优先级为 0，
+// 静态列表（MultiServersDiscovery）的优先级为 1，注册中心均不可用时退化为静态列表而不是调用失败
+type AggregateDiscovery struct {
+	*MultiServersDiscovery
+	sources    []DiscoverySource
+	ttl        time.Duration
+	lastUpdate time.Time
+}
+
+// NewAggregateDiscovery 创建 AggregateDiscovery，ttl 为合并结果的缓存时间，0 表示 defaultUpdateTimeout。
+// 来源的服务列表变化在下一次 Refresh 时生效
+func NewAggregateDiscovery(ttl time.Duration, sources ...DiscoverySource) *AggregateDiscovery {
+	if ttl == 0 {
+		ttl = defaultUpdateTimeout
+	}
+	sorted := append([]DiscoverySource(nil), sources...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Priority < sorted[j].Priority })
+	return &AggregateDiscovery{
+		MultiServersDiscovery: NewMultiServersDiscovery(nil),
+		sources:               sorted,
+		ttl:                   ttl,
+	}
+}
+
+// Update 手动更新合并后的服务列表，下一次 Refresh 时被来源的列表覆盖
+func (d *AggregateDiscovery) Update(servers []string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.lastUpdate = time.Now()
+	d.setServers(toServerInfos(servers))
+	return nil
+}
+
+// Refresh 刷新所有来源并重新合并，距上次合并不足 ttl 时不做任何事。
+// 只有所有来源均失败时返回错误，此时保留原有的列表
+func (d *AggregateDiscovery) Refresh() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.lastUpdate.Add(d.ttl).After(time.Now()) {
+		return nil
+	}
+
+	var (
+		merged []ServerInfo
+		seen   = make(map[string]bool)
+		ok     bool // 至少有一个来源刷新成功
+		err    error
+	)
+	for i, src := range d.sources {
+		// 上一组来源已经得到了实例，不再使用优先级更低的来源
+		if i > 0 && src.Priority != d.sources[i-1].Priority && len(merged) > 0 {
+			break
+		}
+		servers, srcErr := refreshSource(src.Discovery)
+		if srcErr != nil {
+			logger.Errorf("rpc discovery: aggregate source %d (priority %d) err: %v", i, src.Priority, srcErr)
+			err = srcErr
+			continue
+		}
+		ok = true
+		for _, server := range servers {
+			if !seen[server.Addr] {
+				seen[server.Addr] = true
+				merged = append(merged, server)
+			}
+		}
+	}
+	if !ok && err != nil {
+		return err
+	}
+	d.setServers(merged)
+	d.lastUpdate = time.Now()
+	return nil
+}
+
+func refreshSource(d Discovery) ([]ServerInfo, error) {
+	if err := d.Refresh(); err != nil {
+		return nil, err
+	}
+	return d.GetServers()
+}
+
+func (d *AggregateDiscovery) Get(mode SelectMode) (string, error) {
+	if err := d.Refresh(); err != nil {
+		return "", err
+	}
+	return d.MultiServersDiscovery.Get(mode)
+}
+
+func (d *AggregateDiscovery) GetAll() ([]string, error) {
+	if err := d.Refresh(); err != nil {
+		return nil, err
+	}
+	return d.MultiServersDiscovery.GetAll()
+}
+
+func (d *AggregateDiscovery) GetServers() ([]ServerInfo, error) {
+	if err := d.Refresh(); err != nil {
+		return nil, err
+	}
+	return d.MultiServersDiscovery.GetServers()
+}
@@ -0,0 +1,159 @@
+package xclient
+
+import (
+	"context"
+	"geerpc"
+	"sync"
+)
+
+// BroadcastResult 为 BroadcastAll 对一个实例的调用结果
+type BroadcastResult struct {
+	Addr  string
+	Reply interface{} // 未通过 BroadcastReply 设置 reply 的类型时为 nil
+	Err   error
+}
+
+type broadcastOptions struct {
+	failFast    bool
+	concurrency int
+	newReply    func() interface{}
+}
+
+// BroadcastOption 用于配置 BroadcastAll
+type BroadcastOption func(*broadcastOptions)
+
+// BroadcastFailFast 使 BroadcastAll 在第一个调用失败后取消其他调用并返回该错误，
+// 被取消与尚未发起的调用的结果为 Canceled 错误
+func BroadcastFailFast() BroadcastOption {
+	return func(o *broadcastOptions) {
+		o.failFast = true
+	}
+}
+
+// BroadcastConcurrency 限制 BroadcastAll 同时进行的调用数，n <= 0 表示不限制
+func BroadcastConcurrency(n int) BroadcastOption {
+	return func(o *broadcastOptions) {
+		o.concurrency = n
+	}
+}
+
+// BroadcastReply 设置为每个实例创建 reply 的函数，例如 func() interface{} { return new(int) }，
+// 未设置时丢弃实例返回的 reply
+func BroadcastReply(newReply func() interface{}) BroadcastOption {
+	return func(o *broadcastOptions) {
+		o.newReply = newReply
+	}
+}
+
+// BroadcastAll 调用服务列表中的所有实例，按服务列表的顺序返回每个实例的结果。
+// 默认等待所有调用结束，此时实例的错误只记录在结果中，返回的错误仅表示获取服务列表失败
+func (xc *XClient) BroadcastAll(ctx context.Context, serviceMethod string, args interface{}, opts ...BroadcastOption) ([]BroadcastResult, error) {
+	var o broadcastOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	servers, err := xc.addrs(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	results := make([]BroadcastResult, len(servers))
+	limit := o.concurrency
+	if limit <= 0 || limit > len(servers) {
+		limit = len(servers)
+	}
+	sem := make(chan struct{}, limit)
+
+	var wg sync.WaitGroup
+	var once sync.Once
+	var firstErr error
+	for i, addr := range servers {
+		results[i].Addr = addr
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			results[i].Err = geerpc.Errorf(geerpc.CodeOf(ctx.Err()), "rpc xclient: broadcast %s: %v", addr, ctx.Err())
+			continue
+		}
+		wg.Add(1)
+		go func(r *BroadcastResult) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			if o.newReply != nil {
+				r.Reply = o.newReply()
+			}
+			r.Err = xc.call(r.Addr, ctx, serviceMethod, args, r.Reply)
+			if r.Err != nil && o.failFast {
+				once.Do(func() {
+					firstErr = r.Err
+					cancel()
+				})
+			}
+		}(&results[i])
+	}
+	wg.Wait()
+	return results, firstErr
+}
+
+// Map 将 argsPerServer 中的参数分别发送给对应的实例（key 为 rpcAddr），并在每个调用成功后
+// 依次以该实例的 reply 调用 reduce，reduce 不会被并发调用。BroadcastReply 设置 reply 的类型，
+// BroadcastConcurrency 限制同时进行的调用数，BroadcastFailFast 使第一个调用失败后取消其他调用。
+// reduce 返回错误时取消其他调用并返回该错误，否则返回第一个失败调用的错误
+func (xc *XClient) Map(ctx context.Context, serviceMethod string, argsPerServer map[string]interface{}, reduce func(addr string, reply interface{}) error, opts ...BroadcastOption) error {
+	var o broadcastOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	limit := o.concurrency
+	if limit <= 0 || limit > len(argsPerServer) {
+		limit = len(argsPerServer)
+	}
+	sem := make(chan struct{}, limit)
+	results := make(chan BroadcastResult, len(argsPerServer))
+	go func() {
+		for addr, args := range argsPerServer {
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+				results <- BroadcastResult{Addr: addr, Err: geerpc.Errorf(geerpc.CodeOf(ctx.Err()), "rpc xclient: map %s: %v", addr, ctx.Err())}
+				continue
+			}
+			go func(addr string, args interface{}) {
+				defer func() { <-sem }()
+				r := BroadcastResult{Addr: addr}
+				if o.newReply != nil {
+					r.Reply = o.newReply()
+				}
+				r.Err = xc.call(addr, ctx, serviceMethod, args, r.Reply)
+				results <- r
+			}(addr, args)
+		}
+	}()
+
+	var firstErr error
+	for i := 0; i < len(argsPerServer); i++ {
+		r := <-results
+		if r.Err != nil {
+			if firstErr == nil {
+				firstErr = r.Err
+				if o.failFast {
+					cancel()
+				}
+			}
+			continue
+		}
+		if firstErr != nil && o.failFast {
+			continue
+		}
+		if err := reduce(r.Addr, r.Reply); err != nil {
+			cancel()
+			return err
+		}
+	}
+	return firstErr
+}
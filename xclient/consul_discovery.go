@@ -0,0 +1,123 @@
+package xclient
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ConsulDiscovery 通过 Consul 的健康检查接口获取服务列表，只返回通过健康检查的实例
+type ConsulDiscovery struct {
+	*MultiServersDiscovery
+	consul     string
+	service    string
+	tags       []string
+	timeout    time.Duration
+	lastUpdate time.Time
+}
+
+// NewConsulDiscovery 创建 ConsulDiscovery，consulAddr 例如 http://127.0.0.1:8500，
+// tags 不为空时只返回包含所有 tag 的实例，timeout 为服务列表的过期时间
+func NewConsulDiscovery(consulAddr, service string, tags []string, timeout time.Duration) *ConsulDiscovery {
+	if timeout == 0 {
+		timeout = defaultUpdateTimeout
+	}
+	return &ConsulDiscovery{
+		MultiServersDiscovery: NewMultiServersDiscovery(nil),
+		consul:                strings.TrimSuffix(consulAddr, "/"),
+		service:               service,
+		tags:                  tags,
+		timeout:               timeout,
+	}
+}
+
+type consulServiceEntry struct {
+	Node struct {
+		Address string
+	}
+	Service struct {
+		Address string
+		Port    int
+		Weights struct {
+			Passing int
+		}
+	}
+}
+
+// Update 手动更新服务器列表
+func (d *ConsulDiscovery) Update(servers []string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.lastUpdate = time.Now()
+	d.setServers(toServerInfos(servers))
+	return nil
+}
+
+// Refresh 从 Consul 获取通过健康检查的实例
+func (d *ConsulDiscovery) Refresh() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.lastUpdate.Add(d.timeout).After(time.Now()) {
+		return nil
+	}
+
+	query := url.Values{"passing": {"true"}}
+	for _, tag := range d.tags {
+		query.Add("tag", tag)
+	}
+	resp, err := http.Get(d.consul + "/v1/health/service/" + url.PathEscape(d.service) + "?" + query.Encode())
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("rpc discovery: consul: unexpected status %s", resp.Status)
+	}
+
+	var entries []consulServiceEntry
+	if err := json.NewDecoder(resp.Body).Decode(&entries); err != nil {
+		return err
+	}
+	infos := make([]ServerInfo, 0, len(entries))
+	for _, entry := range entries {
+		// 服务未指定地址时使用节点地址
+		host := entry.Service.Address
+		if host == "" {
+			host = entry.Node.Address
+		}
+		infos = append(infos, ServerInfo{
+			Addr:   "tcp@" + net.JoinHostPort(host, strconv.Itoa(entry.Service.Port)),
+			Weight: entry.Service.Weights.Passing,
+		})
+	}
+	d.setServers(infos)
+	d.lastUpdate = time.Now()
+	return nil
+}
+
+func (d *ConsulDiscovery) Get(mode SelectMode) (string, error) {
+	if err := d.Refresh(); err != nil {
+		return "", err
+	}
+	return d.MultiServersDiscovery.Get(mode)
+}
+
+func (d *ConsulDiscovery) GetAll() ([]string, error) {
+	if err := d.Refresh(); err != nil {
+		return nil, err
+	}
+	return d.MultiServersDiscovery.GetAll()
+}
+
+func (d *ConsulDiscovery) GetServers() ([]ServerInfo, error) {
+	if err := d.Refresh(); err != nil {
+		return nil, err
+	}
+	return d.MultiServersDiscovery.GetServers()
+}
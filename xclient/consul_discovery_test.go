@@ -0,0 +1,104 @@
+package xclient
+
+import (
+	"encoding/json"
+	"geerpc/registry"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeConsul 实现了测试所需的 Consul agent 与健康检查接口，critical 中的实例视为未通过健康检查
+type fakeConsul struct {
+	mu       sync.Mutex
+	services map[string]map[string]interface{}
+	critical map[string]bool
+}
+
+func (c *fakeConsul) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	switch {
+	case req.Method == http.MethodPut && req.URL.Path == "/v1/agent/service/register":
+		var reg map[string]interface{}
+		_ = json.NewDecoder(req.Body).Decode(&reg)
+		c.services[reg["ID"].(string)] = reg
+	case req.Method == http.MethodPut && strings.HasPrefix(req.URL.Path, "/v1/agent/service/deregister/"):
+		delete(c.services, strings.TrimPrefix(req.URL.Path, "/v1/agent/service/deregister/"))
+	case req.Method == http.MethodGet && strings.HasPrefix(req.URL.Path, "/v1/health/service/"):
+		name := strings.TrimPrefix(req.URL.Path, "/v1/health/service/")
+		entries := []map[string]interface{}{}
+		for id, reg := range c.services {
+			if reg["Name"] != name || (req.URL.Query().Get("passing") == "true" && c.critical[id]) {
+				continue
+			}
+			if !hasTags(reg["Tags"], req.URL.Query()["tag"]) {
+				continue
+			}
+			entries = append(entries, map[string]interface{}{
+				"Node":    map[string]string{"Address": "10.0.0.1"},
+				"Service": reg,
+			})
+		}
+		_ = json.NewEncoder(w).Encode(entries)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func hasTags(got interface{}, want []string) bool {
+	tags, _ := got.([]interface{})
+	for _, w := range want {
+		found := false
+		for _, tag := range tags {
+			found = found || tag == w
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+func TestConsulDiscovery(t *testing.T) {
+	fake := &fakeConsul{services: make(map[string]map[string]interface{}), critical: make(map[string]bool)}
+	consul := httptest.NewServer(fake)
+	defer consul.Close()
+
+	services := []registry.ConsulService{
+		{Name: "geerpc", Tags: []string{"v1"}, Address: "127.0.0.1", Port: 9001, Weight: 3},
+		{Name: "geerpc", Tags: []string{"v1"}, Port: 9002},
+		{Name: "geerpc", Tags: []string{"v2"}, Address: "127.0.0.1", Port: 9003},
+		{ID: "down", Name: "geerpc", Tags: []string{"v1"}, Address: "127.0.0.1", Port: 9004},
+	}
+	for _, s := range services {
+		if err := registry.RegisterConsul(consul.URL, s); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fake.critical["down"] = true
+
+	d := NewConsulDiscovery(consul.URL, "geerpc", []string{"v1"}, time.Nanosecond)
+	servers, err := d.GetServers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Slice(servers, func(i, j int) bool { return servers[i].Addr < servers[j].Addr })
+	if len(servers) != 2 || !reflect.DeepEqual(servers, []ServerInfo{{Addr: "tcp@10.0.0.1:9002"}, {Addr: "tcp@127.0.0.1:9001", Weight: 3}}) {
+		t.Fatalf("unexpected servers %v", servers)
+	}
+
+	if err := registry.DeregisterConsul(consul.URL, "geerpc-127.0.0.1-9001"); err != nil {
+		t.Fatal(err)
+	}
+	all, err := d.GetAll()
+	if err != nil || len(all) != 1 || all[0] != "tcp@10.0.0.1:9002" {
+		t.Fatalf("unexpected servers %v, err: %v", all, err)
+	}
+}
@@ -11,33 +11,82 @@ import (
 type SelectMode int
 
 const (
-	RandomSelect     SelectMode = iota // select randomly
-	RoundRobinSelect                   // select using Robbin algorithm
+	RandomSelect             SelectMode = iota // select randomly
+	RoundRobinSelect                           // select using Robbin algorithm
+	WeightedRoundRobinSelect                   // select using smooth weighted round robin
+	ConsistentHashSelect                       // select by hash key, see XClient.CallWithKey
+	LeastActiveSelect                          // select the server with the fewest in-flight calls of XClient
 )
 
+// ErrNoAvailableServers 表示服务列表为空
+var ErrNoAvailableServers = errors.New("rpc discovery: no available servers")
+
+// ServerInfo 描述一个可用的服务实例
+type ServerInfo struct {
+	Addr     string
+	Weight   int // 权重，小于等于 0 时视为 1
+	Zone     string
+	Version  string
+	Codecs   []string // 实例支持的编解码方式
+	Metadata map[string]string
+}
+
+func (s ServerInfo) weight() int {
+	if s.Weight <= 0 {
+		return 1
+	}
+	return s.Weight
+}
+
 type Discovery interface {
 	Refresh() error // refresh from remote registry
 	Update(servers []string) error
 	Get(mode SelectMode) (string, error)
 	GetAll() ([]string, error)
+	GetServers() ([]ServerInfo, error) // 返回包含权重等信息的服务列表
+	// Watch 返回一个 channel，服务列表变化时推送新的地址列表，订阅者来不及接收时只保留最新的列表
+	Watch() <-chan []string
 }
 
 type MultiServersDiscovery struct {
-	r       *rand.Rand   // generate random number
-	mu      sync.RWMutex // protect following
-	servers []string
-	index   int // record the selected position for robin algorithm
+	r       *rand.Rand // generate random number
+	mu      sync.Mutex // protect following
+	servers []ServerInfo
+	index   int   // record the selected position for robin algorithm
+	current []int // current weights for weighted robin algorithm
+
+	ejectBase time.Duration
+	ejectMax  time.Duration
+	ejected   map[string]*ejection // 因连接失败被暂时摘除的实例
+	watchers  []chan []string
 }
 
 func NewMultiServersDiscovery(servers []string) *MultiServersDiscovery {
 	m := &MultiServersDiscovery{
-		servers: servers,
-		r:       rand.New(rand.NewSource(time.Now().UnixNano())),
+		r:         rand.New(rand.NewSource(time.Now().UnixNano())),
+		ejectBase: DefaultEjectionBase,
+		ejectMax:  DefaultEjectionMax,
 	}
+	m.setServers(toServerInfos(servers))
 	m.index = m.r.Intn(math.MaxInt32 - 1)
 	return m
 }
 
+// NewWeightedServersDiscovery 使用带权重的服务列表创建 MultiServersDiscovery
+func NewWeightedServersDiscovery(servers []ServerInfo) *MultiServersDiscovery {
+	m := NewMultiServersDiscovery(nil)
+	m.setServers(servers)
+	return m
+}
+
+func toServerInfos(servers []string) []ServerInfo {
+	infos := make([]ServerInfo, 0, len(servers))
+	for _, addr := range servers {
+		infos = append(infos, ServerInfo{Addr: addr, Weight: 1})
+	}
+	return infos
+}
+
 func (m *MultiServersDiscovery) Refresh() error {
 	//TODO implement me
 	return nil
@@ -45,31 +94,127 @@ func (m *MultiServersDiscovery) Refresh() error {
 
 func (m *MultiServersDiscovery) Update(servers []string) error {
 	m.mu.Lock()
-	defer m.mu.RLock()
-	m.servers = servers
+	defer m.mu.Unlock()
+	m.setServers(toServerInfos(servers))
+	return nil
+}
+
+// UpdateServers 使用带权重的服务列表更新
+func (m *MultiServersDiscovery) UpdateServers(servers []ServerInfo) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.setServers(servers)
 	return nil
 }
 
+// 调用方需持有 m.mu
+func (m *MultiServersDiscovery) setServers(servers []ServerInfo) {
+	changed := len(servers) != len(m.servers)
+	for i := 0; !changed && i < len(servers); i++ {
+		changed = servers[i].Addr != m.servers[i].Addr
+	}
+	m.servers = servers
+	m.current = make([]int, len(servers))
+	if changed {
+		m.notify()
+	}
+}
+
+// Watch 返回一个推送服务列表变化的 channel，通过 Update、Refresh 或注册中心的 watch 更新列表时均会推送
+func (m *MultiServersDiscovery) Watch() <-chan []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	ch := make(chan []string, 1)
+	m.watchers = append(m.watchers, ch)
+	return ch
+}
+
+// 取消 Watch 的订阅
+func (m *MultiServersDiscovery) unwatch(ch <-chan []string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i, w := range m.watchers {
+		if w == ch {
+			m.watchers = append(m.watchers[:i], m.watchers[i+1:]...)
+			return
+		}
+	}
+}
+
+// 向所有订阅者推送当前的地址列表，调用方需持有 m.mu
+func (m *MultiServersDiscovery) notify() {
+	for _, ch := range m.watchers {
+		addrs := make([]string, 0, len(m.servers))
+		for _, server := range m.servers {
+			addrs = append(addrs, server.Addr)
+		}
+		// 丢弃订阅者尚未接收的旧列表
+		select {
+		case <-ch:
+		default:
+		}
+		ch <- addrs
+	}
+}
+
 func (m *MultiServersDiscovery) Get(mode SelectMode) (string, error) {
-	m.mu.RLock()
-	defer m.mu.RUnlock()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if len(m.servers) == 0 {
+		return "", ErrNoAvailableServers
+	}
+	idx := m.available()
+	n := len(idx)
 
 	switch mode {
 	case RandomSelect:
-		return m.servers[m.r.Intn(len(m.servers))], nil
+		return m.servers[idx[m.r.Intn(n)]].Addr, nil
 	case RoundRobinSelect:
-		m.index = (m.index + 1) % len(m.servers)
-		return m.servers[m.index], nil
+		m.index = (m.index + 1) % n
+		return m.servers[idx[m.index]].Addr, nil
+	case WeightedRoundRobinSelect:
+		return m.servers[m.nextWeighted(idx)].Addr, nil
+	case ConsistentHashSelect:
+		return "", errors.New("rpc discovery: consistent hash select requires a key, use XClient.CallWithKey")
 	}
 
-	return "", errors.New("invalid server")
+	return "", errors.New("rpc discovery: not supported select mode")
+}
+
+// 平滑加权轮询：每次为 idx 中的实例加上自身权重，选出当前权重最大的实例并减去总权重
+func (m *MultiServersDiscovery) nextWeighted(idx []int) int {
+	total, best := 0, idx[0]
+	for _, i := range idx {
+		w := m.servers[i].weight()
+		total += w
+		m.current[i] += w
+		if m.current[i] > m.current[best] {
+			best = i
+		}
+	}
+	m.current[best] -= total
+	return best
 }
 
 func (m *MultiServersDiscovery) GetAll() ([]string, error) {
-	m.mu.RLock()
-	defer m.mu.RUnlock()
+	m.mu.Lock()
+	defer m.mu.Unlock()
 
-	ret := make([]string, len(m.servers), len(m.servers))
-	copy(ret, m.servers)
+	ret := make([]string, 0, len(m.servers))
+	for _, i := range m.available() {
+		ret = append(ret, m.servers[i].Addr)
+	}
+	return ret, nil
+}
+
+func (m *MultiServersDiscovery) GetServers() ([]ServerInfo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	ret := make([]ServerInfo, 0, len(m.servers))
+	for _, i := range m.available() {
+		ret = append(ret, m.servers[i])
+	}
 	return ret, nil
 }
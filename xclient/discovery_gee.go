@@ -1,64 +1,368 @@
 package xclient
 
 import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
 	"net/http"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
-const defaultUpdateTimeout = time.Second * 10
+const (
+	defaultUpdateTimeout  = time.Second * 10
+	defaultRequestTimeout = time.Second * 5 // 从注册中心获取服务列表的默认超时时间
+)
 
 type GeeRegistryDiscovery struct {
 	*MultiServersDiscovery
-	registry   string
+	registries []string
+	current    int32 // 当前使用的注册中心，请求失败时切换到下一个
 	timeout    time.Duration
 	lastUpdate time.Time
+	refresh    RefreshConfig
+	refreshMu  sync.Mutex // 保证同一时间只有一个刷新在请求注册中心
+	refreshing int32      // 正在后台刷新，需原子访问
+	service    string     // 只获取提供该服务的实例，为空时获取所有实例
+
+	fallback      FallbackConfig
+	failures      int  // 连续失败或返回空列表的次数
+	usingFallback bool // 正在使用 fallback.Servers
+}
+
+// FallbackConfig 为 GeeRegistryDiscovery 在注册中心不可用时使用的静态服务列表
+type FallbackConfig struct {
+	Servers []string
+	// Threshold 为刷新连续失败或返回空列表多少次后使用 Servers，小于等于 0 时为 1
+	Threshold int
+	// OnChange 在开始（active 为 true，err 为最后一次刷新的错误，返回空列表时为 nil）或停止使用 Servers 时调用，
+	// 可用于采集指标。调用时持有 discovery 的锁，不能调用 discovery 的方法
+	OnChange func(active bool, err error)
 }
 
+// RefreshConfig 为 GeeRegistryDiscovery 刷新服务列表的方式
+type RefreshConfig struct {
+	// RequestTimeout 为一次请求注册中心的超时时间，小于等于 0 时为 5s
+	RequestTimeout time.Duration
+	// Jitter 为 StartRefresh 的刷新间隔的随机抖动比例，取值为 [0, 1)，避免大量客户端同时请求注册中心
+	Jitter float64
+	// StaleWhileRefresh 为 true 时，Get 等方法在服务列表过期后仍立即返回旧的列表，并在后台刷新，
+	// 只有还没有得到过服务列表时才等待刷新完成
+	StaleWhileRefresh bool
+}
+
+// NewGeeRegistryDiscovery 创建 GeeRegistryDiscovery，registerAddr 可以是逗号分隔的多个注册中心地址，
+// timeout 为服务列表的缓存时间，过期后的下一次 Get 重新从注册中心获取，小于等于 0 时为 10s
 func NewGeeRegistryDiscovery(registerAddr string, timeout time.Duration) *GeeRegistryDiscovery {
+	if timeout <= 0 {
+		timeout = defaultUpdateTimeout
+	}
+	var registries []string
+	for _, addr := range strings.Split(registerAddr, ",") {
+		registries = append(registries, strings.TrimSpace(addr))
+	}
 	return &GeeRegistryDiscovery{
 		MultiServersDiscovery: NewMultiServersDiscovery([]string{}),
-		registry:              registerAddr,
+		registries:            registries,
 		timeout:               timeout,
 		lastUpdate:            time.Time{},
 	}
 }
 
+// 向当前的注册中心发送 GET 请求，失败时依次尝试其他注册中心
+func (d *GeeRegistryDiscovery) get(ctx context.Context, path string) (*http.Response, error) {
+	d.mu.Lock()
+	service := d.service
+	d.mu.Unlock()
+
+	var err error
+	for i := 0; i < len(d.registries); i++ {
+		current := atomic.LoadInt32(&d.current)
+		req, _ := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(d.registries[current], "/")+path, nil)
+		if service != "" {
+			q := req.URL.Query()
+			q.Set("service", service)
+			req.URL.RawQuery = q.Encode()
+		}
+		var resp *http.Response
+		if resp, err = http.DefaultClient.Do(req); err == nil && resp.StatusCode == http.StatusOK {
+			return resp, nil
+		}
+		if err == nil {
+			_ = resp.Body.Close()
+			err = fmt.Errorf("rpc discovery: registry %s: unexpected status %s", d.registries[current], resp.Status)
+		}
+		if ctx.Err() != nil {
+			return nil, err
+		}
+		atomic.CompareAndSwapInt32(&d.current, current, (current+1)%int32(len(d.registries)))
+	}
+	return nil, err
+}
+
 // Update 更新服务器列表
 func (d *GeeRegistryDiscovery) Update(servers []string) error {
 	d.mu.Lock()
 	defer d.mu.Unlock()
 
 	d.lastUpdate = time.Now()
-	d.servers = servers
+	d.setServers(toServerInfos(servers))
 	return nil
 }
 
-// Refresh 从注册中心获取可用的服务器
-func (d *GeeRegistryDiscovery) Refresh() error {
+// SetService 设置只从注册中心获取提供 service 服务的实例（见 registry.ServerItem.Service），
+// 一个注册中心上注册了多个服务时使用，下一次 Get 时重新获取服务列表
+func (d *GeeRegistryDiscovery) SetService(service string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.service = service
+	d.lastUpdate = time.Time{}
+}
+
+// SetRefreshConfig 设置刷新服务列表的方式
+func (d *GeeRegistryDiscovery) SetRefreshConfig(cfg RefreshConfig) {
 	d.mu.Lock()
 	defer d.mu.Unlock()
-	if d.lastUpdate.Add(d.timeout).After(time.Now()) {
+	if cfg.RequestTimeout <= 0 {
+		cfg.RequestTimeout = defaultRequestTimeout
+	}
+	if cfg.Jitter < 0 || cfg.Jitter >= 1 {
+		cfg.Jitter = 0
+	}
+	d.refresh = cfg
+}
+
+// RefreshTimeout 返回服务列表的缓存时间
+func (d *GeeRegistryDiscovery) RefreshTimeout() time.Duration {
+	return d.timeout
+}
+
+// StartRefresh 在后台每隔缓存时间（加上 RefreshConfig.Jitter 的随机抖动）从注册中心刷新服务列表，直到 ctx 结束，
+// 服务列表在 Get 之前通常已经是新的
+func (d *GeeRegistryDiscovery) StartRefresh(ctx context.Context) {
+	go func() {
+		for {
+			d.mu.Lock()
+			interval := jittered(d.timeout, d.refresh.Jitter)
+			d.mu.Unlock()
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(interval):
+			}
+			if err := d.doRefresh(true); err != nil {
+				logger.Errorf("rpc discovery: background refresh err: %v", err)
+			}
+		}
+	}()
+}
+
+// 返回在 d 上下浮动 jitter 比例的随机时长
+func jittered(d time.Duration, jitter float64) time.Duration {
+	if jitter <= 0 {
+		return d
+	}
+	return d + time.Duration((rand.Float64()*2-1)*jitter*float64(d))
+}
+
+// SetFallback 设置注册中心不可用时的静态服务列表：刷新连续失败或返回空列表 cfg.Threshold 次后，
+// 使用 cfg.Servers 代替注册中心的列表，Get 不再返回错误，之后的刷新从注册中心得到非空的列表时恢复。
+// cfg.Servers 为空时取消设置
+func (d *GeeRegistryDiscovery) SetFallback(cfg FallbackConfig) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if cfg.Threshold <= 0 {
+		cfg.Threshold = 1
+	}
+	d.fallback = cfg
+}
+
+// UsingFallback 返回是否正在使用 SetFallback 设置的静态服务列表
+func (d *GeeRegistryDiscovery) UsingFallback() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.usingFallback
+}
+
+// Refresh 从注册中心获取可用的服务器，距上次获取不足缓存时间时不做任何事
+func (d *GeeRegistryDiscovery) Refresh() error {
+	return d.doRefresh(false)
+}
+
+// 从注册中心刷新服务列表，force 为 false 时服务列表未过期则不刷新。请求注册中心时不持有 d.mu，不阻塞 Get
+func (d *GeeRegistryDiscovery) doRefresh(force bool) error {
+	d.refreshMu.Lock()
+	defer d.refreshMu.Unlock()
+
+	d.mu.Lock()
+	fresh := !force && d.lastUpdate.Add(d.timeout).After(time.Now())
+	timeout := d.refresh.RequestTimeout
+	d.mu.Unlock()
+	if fresh {
 		return nil
 	}
+	if timeout <= 0 {
+		timeout = defaultRequestTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	infos, err := d.fetch(ctx)
 
-	resp, err := http.Get(d.registry)
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.apply(infos, err)
+}
+
+// Get 等方法使用服务列表之前调用，设置了 RefreshConfig.StaleWhileRefresh 且已有服务列表时在后台刷新
+func (d *GeeRegistryDiscovery) ensureFresh() error {
+	d.mu.Lock()
+	async := d.refresh.StaleWhileRefresh && len(d.servers) > 0
+	stale := !d.lastUpdate.Add(d.timeout).After(time.Now())
+	d.mu.Unlock()
+	if !async {
+		return d.Refresh()
+	}
+	if stale && atomic.CompareAndSwapInt32(&d.refreshing, 0, 1) {
+		go func() {
+			defer atomic.StoreInt32(&d.refreshing, 0)
+			if err := d.Refresh(); err != nil {
+				logger.Errorf("rpc discovery: refresh err: %v", err)
+			}
+		}()
+	}
+	return nil
+}
+
+// 根据注册中心的刷新结果更新服务列表，连续失败或返回空列表达到阈值时改用静态列表，调用方需持有 d.mu
+func (d *GeeRegistryDiscovery) apply(infos []ServerInfo, err error) error {
+	if err == nil && len(infos) > 0 {
+		d.failures = 0
+		if d.usingFallback {
+			d.usingFallback = false
+			logger.Infof("rpc discovery: registry recovered, stop using fallback servers")
+			if d.fallback.OnChange != nil {
+				d.fallback.OnChange(false, nil)
+			}
+		}
+	} else if len(d.fallback.Servers) > 0 {
+		if d.failures++; d.failures >= d.fallback.Threshold {
+			if !d.usingFallback {
+				d.usingFallback = true
+				logger.Errorf("rpc discovery: registry failed %d times (last err: %v), using fallback servers %v", d.failures, err, d.fallback.Servers)
+				if d.fallback.OnChange != nil {
+					d.fallback.OnChange(true, err)
+				}
+			}
+			infos, err = toServerInfos(d.fallback.Servers), nil
+		}
+	}
 	if err != nil {
 		return err
 	}
+	d.setServers(infos)
+	d.lastUpdate = time.Now()
+	return nil
+}
+
+// 从注册中心获取服务实例
+func (d *GeeRegistryDiscovery) fetch(ctx context.Context) ([]ServerInfo, error) {
+	resp, err := d.get(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if strings.HasPrefix(resp.Header.Get("Content-Type"), "application/json") {
+		return decodeRegistryServers(resp.Body)
+	}
+	// 旧版本的注册中心只通过 header 返回地址列表
 	servers := strings.Split(resp.Header.Get("X-Geerpc-Servers"), ",")
-	d.servers = make([]string, 0, len(servers))
+	infos := make([]ServerInfo, 0, len(servers))
 	for _, server := range servers {
 		if strings.TrimSpace(server) != "" {
-			d.servers = append(d.servers, strings.TrimSpace(server))
+			infos = append(infos, ServerInfo{Addr: strings.TrimSpace(server), Weight: 1})
 		}
 	}
-	d.lastUpdate = time.Now()
-	return nil
+	return infos, nil
+}
+
+// WatchRegistry 在后台长轮询注册中心的 /watch 接口，服务列表变化时立即更新并通知 Watch 的订阅者，直到 ctx 结束
+func (d *GeeRegistryDiscovery) WatchRegistry(ctx context.Context) {
+	go func() {
+		var version string
+		for ctx.Err() == nil {
+			registry := atomic.LoadInt32(&d.current)
+			v, err := d.watchOnce(ctx, version)
+			if err == nil && registry == atomic.LoadInt32(&d.current) {
+				version = v
+				continue
+			}
+			// 不同注册中心的版本号不可比较，切换注册中心后重新开始
+			version = ""
+			if err == nil {
+				continue
+			}
+			if ctx.Err() != nil {
+				return
+			}
+			logger.Errorf("rpc discovery: registry watch err: %v", err)
+			select {
+			case <-ctx.Done():
+			case <-time.After(time.Second):
+			}
+		}
+	}()
+}
+
+// 等待注册中心的服务列表版本与 version 不同后更新服务列表，返回新的版本
+func (d *GeeRegistryDiscovery) watchOnce(ctx context.Context, version string) (string, error) {
+	resp, err := d.get(ctx, "/watch?version="+version)
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	infos, err := decodeRegistryServers(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	_ = d.apply(infos, nil)
+	return resp.Header.Get("X-Geerpc-Registry-Version"), nil
+}
+
+// 解析注册中心以 JSON 返回的服务实例，字段与 registry.ServerItem 对应。
+// 同一地址注册了多个服务时只保留一个实例
+func decodeRegistryServers(r io.Reader) ([]ServerInfo, error) {
+	var items []struct {
+		Addr     string            `json:"addr"`
+		Weight   int               `json:"weight"`
+		Zone     string            `json:"zone"`
+		Version  string            `json:"version"`
+		Codecs   []string          `json:"codecs"`
+		Metadata map[string]string `json:"metadata"`
+	}
+	if err := json.NewDecoder(r).Decode(&items); err != nil {
+		return nil, err
+	}
+	infos := make([]ServerInfo, 0, len(items))
+	seen := make(map[string]bool)
+	for _, item := range items {
+		if !seen[item.Addr] {
+			seen[item.Addr] = true
+			infos = append(infos, ServerInfo(item))
+		}
+	}
+	return infos, nil
 }
 
 func (d *GeeRegistryDiscovery) Get(mode SelectMode) (string, error) {
-	if err := d.Refresh(); err != nil {
+	if err := d.ensureFresh(); err != nil {
 		return "", err
 	}
 
@@ -66,8 +370,15 @@ func (d *GeeRegistryDiscovery) Get(mode SelectMode) (string, error) {
 }
 
 func (d *GeeRegistryDiscovery) GetAll() ([]string, error) {
-	if err := d.Refresh(); err != nil {
+	if err := d.ensureFresh(); err != nil {
 		return nil, err
 	}
 	return d.MultiServersDiscovery.GetAll()
 }
+
+func (d *GeeRegistryDiscovery) GetServers() ([]ServerInfo, error) {
+	if err := d.ensureFresh(); err != nil {
+		return nil, err
+	}
+	return d.MultiServersDiscovery.GetServers()
+}
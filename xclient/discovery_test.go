@@ -0,0 +1,341 @@
+package xclient
+
+import (
+	"context"
+	"geerpc/registry"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMultiServersDiscovery_WeightedRoundRobin(t *testing.T) {
+	d := NewWeightedServersDiscovery([]ServerInfo{
+		{Addr: "a", Weight: 5},
+		{Addr: "b", Weight: 1},
+		{Addr: "c", Weight: 1},
+	})
+
+	counts := make(map[string]int)
+	var seq string
+	for i := 0; i < 7; i++ {
+		addr, err := d.Get(WeightedRoundRobinSelect)
+		if err != nil {
+			t.Fatal(err)
+		}
+		counts[addr]++
+		seq += addr
+	}
+	if counts["a"] != 5 || counts["b"] != 1 || counts["c"] != 1 {
+		t.Fatalf("unexpected distribution %v", counts)
+	}
+	// 平滑加权轮询不会连续选中高权重的实例太多次
+	if seq != "aabacaa" {
+		t.Fatalf("unexpected sequence %s", seq)
+	}
+
+	if _, err := NewMultiServersDiscovery(nil).Get(RoundRobinSelect); err == nil {
+		t.Fatal("expect an error when no servers are available")
+	}
+}
+
+func TestHashRing(t *testing.T) {
+	servers := []ServerInfo{{Addr: "a"}, {Addr: "b"}, {Addr: "c"}}
+	r := newHashRing(defaultReplicas, servers)
+
+	owners := make(map[string]string)
+	for i := 0; i < 100; i++ {
+		key := "key" + strconv.Itoa(i)
+		owners[key] = r.get(key)
+		if r.get(key) != owners[key] {
+			t.Fatalf("key %s is not stable", key)
+		}
+	}
+
+	// 移除一个实例后，只有原本属于该实例的 key 会迁移
+	r = newHashRing(defaultReplicas, servers[:2])
+	for key, owner := range owners {
+		if owner != "c" && r.get(key) != owner {
+			t.Fatalf("key %s moved from %s to %s", key, owner, r.get(key))
+		}
+	}
+}
+
+func TestGeeRegistryDiscovery_Metadata(t *testing.T) {
+	ts := httptest.NewServer(registry.NewGeeRegistry(time.Minute))
+	defer ts.Close()
+	registry.HeartbeatServer(ts.URL, registry.ServerItem{Addr: "tcp@a", Weight: 2, Zone: "z1", Version: "v1", Metadata: map[string]string{"k": "v"}}, time.Hour)
+	registry.Heartbeat(ts.URL, "tcp@b", time.Hour)
+
+	d := NewGeeRegistryDiscovery(ts.URL, time.Nanosecond)
+	servers, err := d.GetServers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []ServerInfo{{Addr: "tcp@a", Weight: 2, Zone: "z1", Version: "v1", Metadata: map[string]string{"k": "v"}}, {Addr: "tcp@b"}}
+	if !reflect.DeepEqual(servers, want) {
+		t.Fatalf("expect %+v, got %+v", want, servers)
+	}
+}
+
+func TestGeeRegistryDiscovery_Watch(t *testing.T) {
+	ts := httptest.NewServer(registry.NewGeeRegistry(time.Minute))
+	defer ts.Close()
+	registry.Heartbeat(ts.URL, "tcp@a", time.Hour)
+
+	// 过期时间足够长，服务列表只能通过 watch 更新
+	d := NewGeeRegistryDiscovery(ts.URL, time.Hour)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	d.WatchRegistry(ctx)
+
+	expect := func(want string) {
+		t.Helper()
+		deadline := time.Now().Add(time.Second * 2)
+		for {
+			servers, _ := d.MultiServersDiscovery.GetAll()
+			if got := strings.Join(servers, ","); got == want {
+				return
+			} else if time.Now().After(deadline) {
+				t.Fatalf("expect servers %q, got %q", want, got)
+			}
+			time.Sleep(time.Millisecond * 10)
+		}
+	}
+	expect("tcp@a")
+
+	registry.Heartbeat(ts.URL, "tcp@b", time.Hour)
+	expect("tcp@a,tcp@b")
+	if err := registry.Deregister(ts.URL, "tcp@a"); err != nil {
+		t.Fatal(err)
+	}
+	expect("tcp@b")
+}
+
+func TestGeeRegistryDiscovery_MultipleRegistries(t *testing.T) {
+	ts := httptest.NewServer(registry.NewGeeRegistry(time.Minute))
+	defer ts.Close()
+	dead := httptest.NewServer(http.NotFoundHandler())
+	dead.Close()
+	registry.Heartbeat(dead.URL+","+ts.URL, "tcp@a", time.Hour)
+
+	d := NewGeeRegistryDiscovery(dead.URL+","+ts.URL, time.Nanosecond)
+	servers, err := d.GetAll()
+	if err != nil || strings.Join(servers, ",") != "tcp@a" {
+		t.Fatalf("expect tcp@a from the second registry, got %v, err: %v", servers, err)
+	}
+}
+
+func TestMultiServersDiscovery_Ejection(t *testing.T) {
+	d := NewMultiServersDiscovery([]string{"a", "b", "c"})
+	d.SetEjection(50*time.Millisecond, 80*time.Millisecond)
+
+	d.ReportFailure("a")
+	for i := 0; i < 6; i++ {
+		if addr, _ := d.Get(RoundRobinSelect); addr == "a" {
+			t.Fatal("expect ejected server not to be selected")
+		}
+	}
+	if all, _ := d.GetAll(); len(all) != 2 {
+		t.Fatalf("expect 2 available servers, got %v", all)
+	}
+
+	// 摘除期满后重新参与选择，再次失败时摘除时长翻倍，且不超过 max
+	time.Sleep(60 * time.Millisecond)
+	if all, _ := d.GetAll(); len(all) != 3 {
+		t.Fatalf("expect ejected server to be probed again, got %v", all)
+	}
+	d.ReportFailure("a")
+	d.mu.Lock()
+	if e := d.ejected["a"]; e.failures != 2 || time.Until(e.until) <= 60*time.Millisecond {
+		t.Fatalf("expect ejection to back off, got %+v", e)
+	}
+	d.mu.Unlock()
+	d.ReportSuccess("a")
+	if all, _ := d.GetAll(); len(all) != 3 {
+		t.Fatalf("expect server to recover after success, got %v", all)
+	}
+
+	// 所有实例都被摘除时仍然返回全部实例
+	for _, addr := range []string{"a", "b", "c"} {
+		d.ReportFailure(addr)
+	}
+	if addr, err := d.Get(WeightedRoundRobinSelect); err != nil || addr == "" {
+		t.Fatalf("expect a server when all are ejected, got %q, %v", addr, err)
+	}
+}
+
+func TestMultiServersDiscovery_Watch(t *testing.T) {
+	d := NewMultiServersDiscovery([]string{"a"})
+	updates := d.Watch()
+
+	_ = d.Update([]string{"a"})
+	select {
+	case servers := <-updates:
+		t.Fatalf("expect no update when server list is unchanged, got %v", servers)
+	default:
+	}
+	// 未接收的旧列表被新的列表替换
+	_ = d.Update([]string{"a", "b"})
+	_ = d.Update([]string{"b"})
+	if servers := <-updates; !reflect.DeepEqual(servers, []string{"b"}) {
+		t.Fatalf("expect latest server list, got %v", servers)
+	}
+
+	d.unwatch(updates)
+	_ = d.Update([]string{"c"})
+	select {
+	case servers := <-updates:
+		t.Fatalf("expect no update after unwatch, got %v", servers)
+	default:
+	}
+}
+
+func TestAggregateDiscovery(t *testing.T) {
+	r1 := httptest.NewServer(registry.NewGeeRegistry(time.Minute))
+	defer r1.Close()
+	r2 := httptest.NewServer(registry.NewGeeRegistry(time.Minute))
+	defer r2.Close()
+	registry.Heartbeat(r1.URL, "tcp@a", time.Hour)
+	registry.Heartbeat(r1.URL, "tcp@b", time.Hour)
+	registry.Heartbeat(r2.URL, "tcp@b", time.Hour)
+	registry.Heartbeat(r2.URL, "tcp@c", time.Hour)
+
+	d := NewAggregateDiscovery(time.Nanosecond,
+		DiscoverySource{Discovery: NewMultiServersDiscovery([]string{"tcp@static"}), Priority: 1},
+		DiscoverySource{Discovery: NewGeeRegistryDiscovery(r1.URL, time.Nanosecond)},
+		DiscoverySource{Discovery: NewGeeRegistryDiscovery(r2.URL, time.Nanosecond)},
+	)
+	expect := func(want string) {
+		t.Helper()
+		servers, err := d.GetAll()
+		if got := strings.Join(servers, ","); err != nil || got != want {
+			t.Fatalf("expect servers %q, got %q, err: %v", want, got, err)
+		}
+	}
+	expect("tcp@a,tcp@b,tcp@c")
+
+	// 一个注册中心不可用时使用另一个，均不可用时退化为静态列表
+	r1.Close()
+	expect("tcp@b,tcp@c")
+	r2.Close()
+	expect("tcp@static")
+}
+
+func TestGeeRegistryDiscovery_Fallback(t *testing.T) {
+	ts := httptest.NewServer(registry.NewGeeRegistry(time.Minute))
+	defer ts.Close()
+
+	var changes []bool
+	d := NewGeeRegistryDiscovery(ts.URL, time.Nanosecond)
+	d.SetFallback(FallbackConfig{Servers: []string{"tcp@static"}, Threshold: 2, OnChange: func(active bool, err error) {
+		changes = append(changes, active)
+	}})
+	expect := func(want string) {
+		t.Helper()
+		servers, err := d.GetAll()
+		if got := strings.Join(servers, ","); err != nil || got != want {
+			t.Fatalf("expect servers %q, got %q, err: %v", want, got, err)
+		}
+	}
+
+	// 连续两次返回空列表后使用静态列表
+	expect("")
+	expect("tcp@static")
+	if !d.UsingFallback() {
+		t.Fatal("expect discovery to use fallback servers")
+	}
+	registry.Heartbeat(ts.URL, "tcp@a", time.Hour)
+	expect("tcp@a")
+
+	ts.Close()
+	if _, err := d.GetAll(); err == nil {
+		t.Fatal("expect error before reaching the threshold")
+	}
+	expect("tcp@static")
+	if !reflect.DeepEqual(changes, []bool{true, false, true}) {
+		t.Fatalf("unexpected fallback changes %v", changes)
+	}
+}
+
+func TestGeeRegistryDiscovery_StaleWhileRefresh(t *testing.T) {
+	ts := httptest.NewServer(registry.NewGeeRegistry(time.Minute))
+	defer ts.Close()
+	registry.Heartbeat(ts.URL, "tcp@a", time.Hour)
+
+	d := NewGeeRegistryDiscovery(ts.URL, 50*time.Millisecond)
+	d.SetRefreshConfig(RefreshConfig{Jitter: 0.2, StaleWhileRefresh: true})
+	if d.RefreshTimeout() != 50*time.Millisecond {
+		t.Fatalf("unexpected refresh timeout %v", d.RefreshTimeout())
+	}
+	// 还没有服务列表时等待刷新完成
+	if servers, err := d.GetAll(); err != nil || strings.Join(servers, ",") != "tcp@a" {
+		t.Fatalf("expect [tcp@a], got %v, err: %v", servers, err)
+	}
+
+	// 服务列表过期后先返回旧的列表，后台刷新完成后返回新的列表
+	registry.Heartbeat(ts.URL, "tcp@b", time.Hour)
+	time.Sleep(60 * time.Millisecond)
+	if servers, err := d.GetAll(); err != nil || strings.Join(servers, ",") != "tcp@a" {
+		t.Fatalf("expect stale [tcp@a], got %v, err: %v", servers, err)
+	}
+	deadline := time.Now().Add(time.Second)
+	for {
+		servers, _ := d.GetAll()
+		if len(servers) == 2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expect refreshed servers, got %v", servers)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestGeeRegistryDiscovery_StartRefresh(t *testing.T) {
+	ts := httptest.NewServer(registry.NewGeeRegistry(time.Minute))
+	defer ts.Close()
+
+	d := NewGeeRegistryDiscovery(ts.URL, 20*time.Millisecond)
+	if _, err := d.GetAll(); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	d.StartRefresh(ctx)
+
+	// 后台刷新在 Get 之前更新服务列表
+	registry.Heartbeat(ts.URL, "tcp@a", time.Hour)
+	deadline := time.Now().Add(time.Second)
+	for {
+		servers, _ := d.MultiServersDiscovery.GetAll()
+		if len(servers) == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("expect background refresh to update servers")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestGeeRegistryDiscovery_Service(t *testing.T) {
+	ts := httptest.NewServer(registry.NewGeeRegistry(time.Minute))
+	defer ts.Close()
+	registry.HeartbeatServer(ts.URL, registry.ServerItem{Service: "Foo", Addr: "tcp@a"}, time.Hour)
+	registry.HeartbeatServer(ts.URL, registry.ServerItem{Service: "Bar", Addr: "tcp@a"}, time.Hour)
+	registry.HeartbeatServer(ts.URL, registry.ServerItem{Service: "Bar", Addr: "tcp@b"}, time.Hour)
+
+	d := NewGeeRegistryDiscovery(ts.URL, time.Hour)
+	// 不区分服务时同一地址只出现一次
+	if servers, err := d.GetAll(); err != nil || strings.Join(servers, ",") != "tcp@a,tcp@b" {
+		t.Fatalf("unexpected servers %v, err: %v", servers, err)
+	}
+	d.SetService("Foo")
+	if servers, err := d.GetAll(); err != nil || strings.Join(servers, ",") != "tcp@a" {
+		t.Fatalf("unexpected Foo servers %v, err: %v", servers, err)
+	}
+}
@@ -0,0 +1,112 @@
+package xclient
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Resolver 为 DNSDiscovery 使用的 DNS 解析器，*net.Resolver 实现了该接口
+type Resolver interface {
+	LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error)
+	LookupHost(ctx context.Context, host string) ([]string, error)
+}
+
+// DNSDiscovery 定期解析 DNS 记录获取服务列表，适用于没有独立注册中心的环境。
+// port 为 0 时解析 name 的 SRV 记录（例如 _geerpc._tcp.example.com），否则解析 A/AAAA 记录并使用 port
+type DNSDiscovery struct {
+	*MultiServersDiscovery
+	name       string
+	port       int
+	ttl        time.Duration
+	resolver   Resolver
+	lastUpdate time.Time
+}
+
+// NewDNSDiscovery 创建 DNSDiscovery，ttl 为解析结果的缓存时间，resolver 为 nil 时使用 net.DefaultResolver
+func NewDNSDiscovery(name string, port int, ttl time.Duration, resolver Resolver) *DNSDiscovery {
+	if ttl == 0 {
+		ttl = defaultUpdateTimeout
+	}
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+	return &DNSDiscovery{
+		MultiServersDiscovery: NewMultiServersDiscovery(nil),
+		name:                  name,
+		port:                  port,
+		ttl:                   ttl,
+		resolver:              resolver,
+	}
+}
+
+// Update 手动更新服务器列表
+func (d *DNSDiscovery) Update(servers []string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.lastUpdate = time.Now()
+	d.setServers(toServerInfos(servers))
+	return nil
+}
+
+// Refresh 解析 DNS 记录更新服务列表，距上次解析不足 ttl 时不做任何事
+func (d *DNSDiscovery) Refresh() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.lastUpdate.Add(d.ttl).After(time.Now()) {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	var infos []ServerInfo
+	if d.port == 0 {
+		_, records, err := d.resolver.LookupSRV(ctx, "", "", d.name)
+		if err != nil {
+			return err
+		}
+		for _, srv := range records {
+			host := strings.TrimSuffix(srv.Target, ".")
+			infos = append(infos, ServerInfo{
+				Addr:   "tcp@" + net.JoinHostPort(host, strconv.Itoa(int(srv.Port))),
+				Weight: int(srv.Weight),
+			})
+		}
+	} else {
+		hosts, err := d.resolver.LookupHost(ctx, d.name)
+		if err != nil {
+			return err
+		}
+		for _, host := range hosts {
+			infos = append(infos, ServerInfo{Addr: "tcp@" + net.JoinHostPort(host, strconv.Itoa(d.port)), Weight: 1})
+		}
+	}
+	d.setServers(infos)
+	d.lastUpdate = time.Now()
+	return nil
+}
+
+func (d *DNSDiscovery) Get(mode SelectMode) (string, error) {
+	if err := d.Refresh(); err != nil {
+		return "", err
+	}
+	return d.MultiServersDiscovery.Get(mode)
+}
+
+func (d *DNSDiscovery) GetAll() ([]string, error) {
+	if err := d.Refresh(); err != nil {
+		return nil, err
+	}
+	return d.MultiServersDiscovery.GetAll()
+}
+
+func (d *DNSDiscovery) GetServers() ([]ServerInfo, error) {
+	if err := d.Refresh(); err != nil {
+		return nil, err
+	}
+	return d.MultiServersDiscovery.GetServers()
+}
@@ -0,0 +1,49 @@
+package xclient
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type fakeResolver struct {
+	srv     []*net.SRV
+	hosts   []string
+	lookups int
+}
+
+func (r *fakeResolver) LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error) {
+	r.lookups++
+	return name, r.srv, nil
+}
+
+func (r *fakeResolver) LookupHost(ctx context.Context, host string) ([]string, error) {
+	r.lookups++
+	return r.hosts, nil
+}
+
+func TestDNSDiscovery(t *testing.T) {
+	r := &fakeResolver{srv: []*net.SRV{{Target: "a.example.com.", Port: 9001, Weight: 2}, {Target: "b.example.com.", Port: 9002}}}
+	d := NewDNSDiscovery("_geerpc._tcp.example.com", 0, time.Minute, r)
+	servers, err := d.GetServers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(servers) != 2 || !reflect.DeepEqual(servers[0], ServerInfo{Addr: "tcp@a.example.com:9001", Weight: 2}) || servers[1].Addr != "tcp@b.example.com:9002" {
+		t.Fatalf("unexpected servers %v", servers)
+	}
+	_, _ = d.GetAll()
+	if r.lookups != 1 {
+		t.Fatalf("expect result to be cached within ttl, got %d lookups", r.lookups)
+	}
+
+	r = &fakeResolver{hosts: []string{"10.0.0.1", "::1"}}
+	d = NewDNSDiscovery("geerpc.example.com", 9000, time.Nanosecond, r)
+	all, err := d.GetAll()
+	if err != nil || strings.Join(all, ",") != "tcp@10.0.0.1:9000,tcp@[::1]:9000" {
+		t.Fatalf("unexpected servers %v, err: %v", all, err)
+	}
+}
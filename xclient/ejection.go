@@ -0,0 +1,93 @@
+package xclient
+
+import (
+	"time"
+
+	"geerpc"
+)
+
+// 默认的摘除时长，见 MultiServersDiscovery.SetEjection
+const (
+	DefaultEjectionBase = time.Second
+	DefaultEjectionMax  = 30 * time.Second
+)
+
+// FailureReporter 为 Discovery 的可选扩展，XClient 在连接实例失败时调用 ReportFailure，
+// 调用得到实例的响应时调用 ReportSuccess，Discovery 据此暂时摘除不可用的实例
+type FailureReporter interface {
+	ReportFailure(addr string)
+	ReportSuccess(addr string)
+}
+
+// ejection 为一个被摘除的实例
+type ejection struct {
+	failures int       // 连续失败的次数
+	until    time.Time // 摘除的截止时间，之后重新参与选择，作为恢复探测
+}
+
+// SetEjection 设置实例连接失败后被摘除的时长：连续第 n 次失败后摘除 base * 2^(n-1)，最长为 max，
+// 摘除期满后实例重新参与选择，成功一次即恢复。base <= 0 表示不摘除
+func (m *MultiServersDiscovery) SetEjection(base, max time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.ejectBase, m.ejectMax = base, max
+	if base <= 0 {
+		m.ejected = nil
+	}
+}
+
+// ReportFailure 记录一次到 addr 的连接失败并摘除 addr
+func (m *MultiServersDiscovery) ReportFailure(addr string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.ejectBase <= 0 {
+		return
+	}
+	if m.ejected == nil {
+		m.ejected = make(map[string]*ejection)
+	}
+	e, ok := m.ejected[addr]
+	if !ok {
+		e = &ejection{}
+		m.ejected[addr] = e
+	}
+	e.failures++
+	d := m.ejectBase
+	for i := 1; i < e.failures && d < m.ejectMax; i++ {
+		d *= 2
+	}
+	if m.ejectMax > 0 && d > m.ejectMax {
+		d = m.ejectMax
+	}
+	e.until = time.Now().Add(d)
+	logger.Infof("rpc discovery: eject %s for %s after %d failures", addr, d, e.failures)
+}
+
+// ReportSuccess 恢复 addr
+func (m *MultiServersDiscovery) ReportSuccess(addr string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.ejected, addr)
+}
+
+// 返回可以选择的实例的下标，所有实例均被摘除时返回全部实例，调用方需持有 m.mu
+func (m *MultiServersDiscovery) available() []int {
+	idx := make([]int, 0, len(m.servers))
+	now := time.Now()
+	for i, server := range m.servers {
+		if e, ok := m.ejected[server.Addr]; !ok || !now.Before(e.until) {
+			idx = append(idx, i)
+		}
+	}
+	if len(idx) == 0 {
+		for i := range m.servers {
+			idx = append(idx, i)
+		}
+	}
+	return idx
+}
+
+// 调用实例的错误是否表示实例不可用，应用返回的其他错误说明实例仍可访问
+func isConnFailure(err error) bool {
+	return geerpc.CodeOf(err) == geerpc.Unavailable
+}
@@ -0,0 +1,172 @@
+package xclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// EtcdDiscovery 从 etcd 中读取 prefix 下的所有服务实例，并通过 watch 实时更新服务列表。
+// key 的格式为 prefix+addr，value 为服务地址，见 registry.RegisterEtcd
+type EtcdDiscovery struct {
+	*MultiServersDiscovery
+	endpoint string
+	prefix   string
+	cancel   context.CancelFunc
+}
+
+type etcdKeyValue struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+// NewEtcdDiscovery 创建 EtcdDiscovery，endpoint 为 etcd 的 HTTP 地址，例如 http://127.0.0.1:2379
+func NewEtcdDiscovery(endpoint, prefix string) (*EtcdDiscovery, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	d := &EtcdDiscovery{
+		MultiServersDiscovery: NewMultiServersDiscovery(nil),
+		endpoint:              strings.TrimSuffix(endpoint, "/"),
+		prefix:                prefix,
+		cancel:                cancel,
+	}
+	revision, err := d.load()
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	go d.watch(ctx, revision)
+	return d, nil
+}
+
+// Refresh 从 etcd 重新读取服务列表，通常不需要调用，服务列表由 watch 自动更新
+func (d *EtcdDiscovery) Refresh() error {
+	_, err := d.load()
+	return err
+}
+
+// Close 停止 watch
+func (d *EtcdDiscovery) Close() error {
+	d.cancel()
+	return nil
+}
+
+// 读取 prefix 下的所有服务实例，返回读取时的 revision
+func (d *EtcdDiscovery) load() (int64, error) {
+	req := map[string]string{
+		"key":       base64.StdEncoding.EncodeToString([]byte(d.prefix)),
+		"range_end": base64.StdEncoding.EncodeToString(prefixRangeEnd(d.prefix)),
+	}
+	var resp struct {
+		Header struct {
+			Revision string `json:"revision"`
+		} `json:"header"`
+		Kvs []etcdKeyValue `json:"kvs"`
+	}
+	body, _ := json.Marshal(req)
+	res, err := http.Post(d.endpoint+"/v3/kv/range", "application/json", bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = res.Body.Close() }()
+	if res.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("rpc discovery: etcd range: unexpected status %s", res.Status)
+	}
+	if err := json.NewDecoder(res.Body).Decode(&resp); err != nil {
+		return 0, err
+	}
+
+	servers := make([]string, 0, len(resp.Kvs))
+	for _, kv := range resp.Kvs {
+		addr, err := base64.StdEncoding.DecodeString(kv.Value)
+		if err != nil {
+			return 0, err
+		}
+		servers = append(servers, string(addr))
+	}
+	revision, _ := strconv.ParseInt(resp.Header.Revision, 10, 64)
+
+	d.mu.Lock()
+	d.setServers(toServerInfos(servers))
+	d.mu.Unlock()
+	return revision, nil
+}
+
+// 监听 prefix 下的变化，收到事件后重新读取服务列表，连接断开时重新 load 并 watch
+func (d *EtcdDiscovery) watch(ctx context.Context, revision int64) {
+	for ctx.Err() == nil {
+		err := d.watchOnce(ctx, revision)
+		if ctx.Err() != nil {
+			return
+		}
+		logger.Errorf("rpc discovery: etcd watch err: %v", err)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Second):
+		}
+		if r, err := d.load(); err == nil {
+			revision = r
+		}
+	}
+}
+
+func (d *EtcdDiscovery) watchOnce(ctx context.Context, revision int64) error {
+	req := map[string]interface{}{
+		"create_request": map[string]interface{}{
+			"key":            base64.StdEncoding.EncodeToString([]byte(d.prefix)),
+			"range_end":      base64.StdEncoding.EncodeToString(prefixRangeEnd(d.prefix)),
+			"start_revision": revision + 1,
+		},
+	}
+	body, _ := json.Marshal(req)
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, d.endpoint+"/v3/watch", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	res, err := http.DefaultClient.Do(httpReq)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = res.Body.Close() }()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("rpc discovery: etcd watch: unexpected status %s", res.Status)
+	}
+
+	dec := json.NewDecoder(res.Body)
+	for {
+		var msg struct {
+			Result struct {
+				Events []json.RawMessage `json:"events"`
+			} `json:"result"`
+		}
+		if err := dec.Decode(&msg); err != nil {
+			return err
+		}
+		if len(msg.Result.Events) == 0 {
+			continue
+		}
+		if _, err := d.load(); err != nil {
+			return err
+		}
+	}
+}
+
+// 返回 prefix 对应的 range_end，即将 prefix 的最后一个非 0xff 字节加一
+func prefixRangeEnd(prefix string) []byte {
+	end := []byte(prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+	// prefix 为空或全为 0xff 时表示读取所有 key
+	return []byte{0}
+}
@@ -0,0 +1,175 @@
+package xclient
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"geerpc/registry"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeEtcd 实现了测试所需的 etcd gRPC gateway 接口的最小子集
+type fakeEtcd struct {
+	mu       sync.Mutex
+	revision int64
+	kvs      map[string]string // key -> value
+	leases   map[string][]string
+	changed  chan struct{} // 数据变化时关闭并替换
+}
+
+func newFakeEtcd() *fakeEtcd {
+	return &fakeEtcd{
+		kvs:     make(map[string]string),
+		leases:  make(map[string][]string),
+		changed: make(chan struct{}),
+	}
+}
+
+// 调用时需持有 mu
+func (e *fakeEtcd) notify() {
+	e.revision++
+	close(e.changed)
+	e.changed = make(chan struct{})
+}
+
+func decodeKey(s string) string {
+	b, _ := base64.StdEncoding.DecodeString(s)
+	return string(b)
+}
+
+func (e *fakeEtcd) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	var body map[string]interface{}
+	_ = json.NewDecoder(req.Body).Decode(&body)
+	str := func(k string) string { s, _ := body[k].(string); return s }
+
+	e.mu.Lock()
+	switch req.URL.Path {
+	case "/v3/lease/grant":
+		id := strconv.Itoa(len(e.leases) + 1)
+		e.leases[id] = nil
+		e.mu.Unlock()
+		_ = json.NewEncoder(w).Encode(map[string]string{"ID": id, "TTL": "1"})
+	case "/v3/lease/keepalive":
+		_, ok := e.leases[str("ID")]
+		e.mu.Unlock()
+		ttl := "0"
+		if ok {
+			ttl = "1"
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"result": map[string]string{"TTL": ttl}})
+	case "/v3/lease/revoke":
+		for _, key := range e.leases[str("ID")] {
+			delete(e.kvs, key)
+		}
+		delete(e.leases, str("ID"))
+		e.notify()
+		e.mu.Unlock()
+		_, _ = w.Write([]byte("{}"))
+	case "/v3/kv/put":
+		key := decodeKey(str("key"))
+		e.kvs[key] = str("value")
+		e.leases[str("lease")] = append(e.leases[str("lease")], key)
+		e.notify()
+		e.mu.Unlock()
+		_, _ = w.Write([]byte("{}"))
+	case "/v3/kv/range":
+		prefix := decodeKey(str("key"))
+		var keys []string
+		for key := range e.kvs {
+			if strings.HasPrefix(key, prefix) {
+				keys = append(keys, key)
+			}
+		}
+		sort.Strings(keys)
+		kvs := make([]etcdKeyValue, 0, len(keys))
+		for _, key := range keys {
+			kvs = append(kvs, etcdKeyValue{Key: base64.StdEncoding.EncodeToString([]byte(key)), Value: e.kvs[key]})
+		}
+		revision := e.revision
+		e.mu.Unlock()
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"header": map[string]string{"revision": strconv.FormatInt(revision, 10)},
+			"kvs":    kvs,
+		})
+	case "/v3/watch":
+		changed := e.changed
+		e.mu.Unlock()
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"result": map[string]bool{"created": true}})
+		w.(http.Flusher).Flush()
+		for {
+			select {
+			case <-req.Context().Done():
+				return
+			case <-changed:
+			}
+			e.mu.Lock()
+			changed = e.changed
+			e.mu.Unlock()
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{"result": map[string]interface{}{"events": []string{"PUT"}}})
+			w.(http.Flusher).Flush()
+		}
+	default:
+		e.mu.Unlock()
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func TestEtcdDiscovery(t *testing.T) {
+	etcd := httptest.NewServer(newFakeEtcd())
+	defer etcd.Close()
+
+	a, err := registry.RegisterEtcd(etcd.URL, "/geerpc/", "tcp@a", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := NewEtcdDiscovery(etcd.URL, "/geerpc/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = d.Close() }()
+
+	expect := func(want string) {
+		t.Helper()
+		deadline := time.Now().Add(time.Second * 2)
+		for {
+			servers, _ := d.GetAll()
+			sort.Strings(servers)
+			got := strings.Join(servers, ",")
+			if got == want {
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("expect servers %q, got %q", want, got)
+			}
+			time.Sleep(time.Millisecond * 10)
+		}
+	}
+	expect("tcp@a")
+
+	b, err := registry.RegisterEtcd(etcd.URL, "/geerpc/", "tcp@b", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = b.Close() }()
+	expect("tcp@a,tcp@b")
+
+	if err := a.Close(); err != nil {
+		t.Fatal(err)
+	}
+	expect("tcp@b")
+}
+
+func TestPrefixRangeEnd(t *testing.T) {
+	if end := string(prefixRangeEnd("/geerpc/")); end != "/geerpc0" {
+		t.Fatalf("expect /geerpc0, got %s", end)
+	}
+	if end := prefixRangeEnd("a\xff"); string(end) != "b" {
+		t.Fatalf("expect b, got %q", end)
+	}
+}
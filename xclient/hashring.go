@@ -0,0 +1,41 @@
+package xclient
+
+import (
+	"hash/crc32"
+	"sort"
+	"strconv"
+)
+
+// 每个实例在哈希环上的虚拟节点数
+const defaultReplicas = 100
+
+// hashRing 为一致性哈希环，相同的 key 总是映射到同一个实例
+type hashRing struct {
+	replicas int
+	keys     []uint32 // 已排序的虚拟节点哈希值
+	nodes    map[uint32]string
+}
+
+func newHashRing(replicas int, servers []ServerInfo) *hashRing {
+	r := &hashRing{replicas: replicas, nodes: make(map[uint32]string)}
+	for _, server := range servers {
+		// 权重越大虚拟节点越多
+		for i := 0; i < r.replicas*server.weight(); i++ {
+			h := crc32.ChecksumIEEE([]byte(strconv.Itoa(i) + server.Addr))
+			r.keys = append(r.keys, h)
+			r.nodes[h] = server.Addr
+		}
+	}
+	sort.Slice(r.keys, func(i, j int) bool { return r.keys[i] < r.keys[j] })
+	return r
+}
+
+// get 返回 key 顺时针方向上的第一个实例
+func (r *hashRing) get(key string) string {
+	if len(r.keys) == 0 {
+		return ""
+	}
+	h := crc32.ChecksumIEEE([]byte(key))
+	idx := sort.Search(len(r.keys), func(i int) bool { return r.keys[i] >= h })
+	return r.nodes[r.keys[idx%len(r.keys)]]
+}
@@ -0,0 +1,69 @@
+package xclient
+
+import (
+	"context"
+	"reflect"
+	"time"
+)
+
+type hedgeResult struct {
+	reply interface{}
+	err   error
+}
+
+// CallHedged 先向一个实例发起调用，若 hedgeDelay 内仍未返回，则向另一个实例再发起一次调用，
+// 采用最先成功的结果并取消另一个调用
+func (xc *XClient) CallHedged(ctx context.Context, serviceMethod string, args, reply interface{}, hedgeDelay time.Duration) error {
+	first, err := xc.selectServer(ctx)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	results := make(chan hedgeResult, 2)
+	start := func(rpcAddr string) {
+		var clonedReply interface{}
+		if reply != nil {
+			clonedReply = reflect.New(reflect.ValueOf(reply).Elem().Type()).Interface()
+		}
+		go func() {
+			err := xc.call(rpcAddr, ctx, serviceMethod, args, clonedReply)
+			results <- hedgeResult{clonedReply, err}
+		}()
+	}
+	start(first)
+
+	timer := time.NewTimer(hedgeDelay)
+	defer timer.Stop()
+
+	inflight := 1
+	for {
+		select {
+		case <-timer.C:
+			// 第二个实例优先选择与第一个不同的实例
+			second, err := xc.selectServer(ctx)
+			if err != nil {
+				continue
+			}
+			if second == first {
+				second = xc.untried(ctx, first, map[string]bool{first: true})
+			}
+			start(second)
+			inflight++
+		case result := <-results:
+			inflight--
+			if result.err == nil {
+				if reply != nil {
+					reflect.ValueOf(reply).Elem().Set(reflect.ValueOf(result.reply).Elem())
+				}
+				return nil
+			}
+			// 所有调用均失败时返回最后一个错误，第一个调用在对冲之前失败时不再发起对冲
+			if inflight == 0 {
+				return result.err
+			}
+		}
+	}
+}
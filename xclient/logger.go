@@ -0,0 +1,13 @@
+package xclient
+
+import "geerpc"
+
+var logger = geerpc.NopLogger
+
+// SetLogger 设置 xclient 包输出日志使用的 Logger，默认不输出日志，需在使用其他函数之前调用
+func SetLogger(l geerpc.Logger) {
+	if l == nil {
+		l = geerpc.NopLogger
+	}
+	logger = l
+}
@@ -0,0 +1,359 @@
+package xclient
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"geerpc"
+)
+
+// PoolConfig 为 XClient 到每个实例的连接池配置。同一个连接上的消息按顺序编码与发送，
+// 较大的请求会阻塞同一连接上的其他调用，使用多个连接可以提高到单个实例的吞吐量
+type PoolConfig struct {
+	// MinConns 为每个实例保持的最少连接数，不足时每次调用建立一个新的连接
+	MinConns int
+	// MaxConns 为每个实例的最大连接数，已有的连接都有正在进行的调用时才建立新的连接，小于等于 1 时只使用一个连接
+	MaxConns int
+	// IdleTimeout 为超过 MinConns 的连接在没有调用后保持的时长，0 表示不关闭空闲的连接
+	IdleTimeout time.Duration
+}
+
+// WithConnPool 使 XClient 到每个实例使用多个连接，调用在连接之间轮询
+func WithConnPool(cfg PoolConfig) XClientOption {
+	return func(xc *XClient) {
+		xc.poolCfg = cfg
+	}
+}
+
+// WithWarmUp 使 NewXClient 在返回前连接服务列表中的所有实例，每个实例建立 MinConns 个（至少一个）连接，
+// 避免第一次调用时建立连接的延迟，连接失败的实例在调用时重新连接
+func WithWarmUp() XClientOption {
+	return func(xc *XClient) {
+		xc.warmUp = true
+	}
+}
+
+// WithIdleReaping 在后台关闭超过 idle 没有调用的连接，包括 MinConns 以内的连接，
+// 用于释放到不再访问的实例的连接，再次调用时重新连接
+func WithIdleReaping(idle time.Duration) XClientOption {
+	return func(xc *XClient) {
+		xc.reapIdle = idle
+	}
+}
+
+// PoolEventType 为连接池事件的类型
+type PoolEventType int
+
+const (
+	ConnOpened PoolEventType = iota // 建立了新的连接
+	ConnClosed                      // 关闭了连接，原因见 PoolEvent.Reason
+	DialFailed                      // 建立连接失败，错误见 PoolEvent.Err
+)
+
+// 连接关闭的原因
+const (
+	ReasonIdle        = "idle"        // 空闲超过 PoolConfig.IdleTimeout 或 WithIdleReaping 的时长
+	ReasonUnavailable = "unavailable" // 连接已断开
+	ReasonDraining    = "draining"    // 服务端正在关闭
+	ReasonRemoved     = "removed"     // 实例已从服务列表中移除
+)
+
+// PoolEvent 描述到一个实例的连接池的变化
+type PoolEvent struct {
+	Type   PoolEventType
+	Addr   string
+	Conns  int    // 变化后到该实例的连接数
+	Reason string // ConnClosed 的原因
+	Err    error  // DialFailed 的错误
+}
+
+// WithPoolHook 在连接池发生变化时调用 hook，用于观察连接池的状态，hook 在锁外调用，不应阻塞
+func WithPoolHook(hook func(PoolEvent)) XClientOption {
+	return func(xc *XClient) {
+		xc.poolHook = hook
+	}
+}
+
+// PoolStats 为到一个实例的连接池的状态
+type PoolStats struct {
+	Addr   string
+	Conns  int // 连接数
+	Active int // 正在进行的调用数
+}
+
+// PoolStats 返回到每个实例的连接池的状态，按地址排序
+func (xc *XClient) PoolStats() []PoolStats {
+	xc.mu.Lock()
+	defer xc.mu.Unlock()
+	stats := make([]PoolStats, 0, len(xc.conns))
+	for addr, p := range xc.conns {
+		s := PoolStats{Addr: addr, Conns: len(p.conns)}
+		for _, pc := range p.conns {
+			s.Active += pc.active
+		}
+		stats = append(stats, s)
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Addr < stats[j].Addr })
+	return stats
+}
+
+// WarmUp 连接服务列表中的所有实例，每个实例建立 MinConns 个（至少一个）连接，返回第一个连接错误
+func (xc *XClient) WarmUp(ctx context.Context) error {
+	servers, err := xc.d.GetAll()
+	if err != nil {
+		return err
+	}
+	want := xc.poolCfg.MinConns
+	if want < 1 {
+		want = 1
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, len(servers))
+	for _, addr := range servers {
+		wg.Add(1)
+		go func(addr string) {
+			defer wg.Done()
+			for ctx.Err() == nil && xc.connCount(addr) < want {
+				if err := xc.openConn(ctx, addr); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}(addr)
+	}
+	wg.Wait()
+	close(errs)
+	if err := <-errs; err != nil {
+		return err
+	}
+	return ctx.Err()
+}
+
+func (xc *XClient) connCount(rpcAddr string) int {
+	xc.mu.Lock()
+	defer xc.mu.Unlock()
+	if p, ok := xc.conns[rpcAddr]; ok {
+		return len(p.conns)
+	}
+	return 0
+}
+
+// 在锁外建立一个到 rpcAddr 的连接并加入连接池
+func (xc *XClient) openConn(ctx context.Context, rpcAddr string) error {
+	c, err := xc.newClient(ctx, rpcAddr)
+	if err != nil {
+		xc.notify([]PoolEvent{{Type: DialFailed, Addr: rpcAddr, Conns: xc.connCount(rpcAddr), Err: err}})
+		return err
+	}
+	xc.mu.Lock()
+	p := xc.connPool(rpcAddr)
+	p.conns = append(p.conns, &pooledConn{client: c, lastUsed: time.Now()})
+	event := PoolEvent{Type: ConnOpened, Addr: rpcAddr, Conns: len(p.conns)}
+	xc.mu.Unlock()
+	xc.notify([]PoolEvent{event})
+	return nil
+}
+
+// 建立到 rpcAddr 的连接，设置了 TransportPool 时共享其中的连接
+func (xc *XClient) newClient(ctx context.Context, rpcAddr string) (*geerpc.Client, error) {
+	if xc.pool != nil {
+		return xc.pool.Client(rpcAddr)
+	}
+	return geerpc.XDialContext(ctx, rpcAddr, xc.opt)
+}
+
+// 返回 rpcAddr 的连接池，不存在时创建，在 xc.mu 内调用
+func (xc *XClient) connPool(rpcAddr string) *connPool {
+	p, ok := xc.conns[rpcAddr]
+	if !ok {
+		p = &connPool{}
+		xc.conns[rpcAddr] = p
+	}
+	return p
+}
+
+// 每隔 idle / 2 关闭超过 idle 没有调用的连接，直到 XClient 关闭
+func (xc *XClient) reap(idle time.Duration) {
+	ticker := time.NewTicker(idle / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-xc.stop:
+			return
+		}
+		var events []PoolEvent
+		xc.mu.Lock()
+		now := time.Now()
+		for addr, p := range xc.conns {
+			p.evictIdle(0, idle, now, xc.closed(addr, &events))
+			if len(p.conns) == 0 {
+				delete(xc.conns, addr)
+			}
+		}
+		xc.mu.Unlock()
+		xc.notify(events)
+	}
+}
+
+// 服务列表变化时关闭到已移除实例的连接，有调用正在进行的连接在调用完成后关闭，直到 XClient 关闭
+func (xc *XClient) watch(updates <-chan []string) {
+	if d, ok := xc.d.(interface{ unwatch(<-chan []string) }); ok {
+		defer d.unwatch(updates)
+	}
+	for {
+		var servers []string
+		select {
+		case servers = <-updates:
+		case <-xc.stop:
+			return
+		}
+		current := make(map[string]bool, len(servers))
+		for _, addr := range servers {
+			current[addr] = true
+		}
+
+		var events []PoolEvent
+		xc.mu.Lock()
+		for addr, p := range xc.conns {
+			if current[addr] {
+				continue
+			}
+			for i, pc := range p.conns {
+				if pc.active > 0 {
+					go func(c *geerpc.Client) { _ = c.CloseGracefully(context.Background()) }(pc.client)
+				} else {
+					_ = pc.client.Close()
+				}
+				xc.closed(addr, &events)(ReasonRemoved, len(p.conns)-i-1)
+			}
+			delete(xc.conns, addr)
+		}
+		xc.mu.Unlock()
+		xc.notify(events)
+	}
+}
+
+// 返回记录 rpcAddr 的连接关闭事件的函数，在 xc.mu 内调用
+func (xc *XClient) closed(rpcAddr string, events *[]PoolEvent) func(reason string, conns int) {
+	return func(reason string, conns int) {
+		if xc.poolHook != nil {
+			*events = append(*events, PoolEvent{Type: ConnClosed, Addr: rpcAddr, Conns: conns, Reason: reason})
+		}
+	}
+}
+
+func (xc *XClient) notify(events []PoolEvent) {
+	if xc.poolHook == nil {
+		return
+	}
+	for _, e := range events {
+		xc.poolHook(e)
+	}
+}
+
+type pooledConn struct {
+	client   *geerpc.Client
+	active   int       // 正在进行的调用数
+	lastUsed time.Time // 最近一次调用结束的时间
+}
+
+// connPool 为到一个实例的所有连接，在 XClient.mu 内访问
+type connPool struct {
+	conns []*pooledConn
+	next  int
+}
+
+// 移除不可用的连接，每关闭一个连接以原因与剩余的连接数调用 closed，返回移除的连接数
+func (p *connPool) prune(closed func(reason string, conns int)) int {
+	n := len(p.conns)
+	conns := p.conns[:0]
+	for _, pc := range p.conns {
+		switch pc.client.State() {
+		case geerpc.StateDraining:
+			// 等待已发出的调用完成后关闭，服务端在这些调用完成后也会关闭连接
+			go func(c *geerpc.Client) { _ = c.CloseGracefully(context.Background()) }(pc.client)
+			n--
+			closed(ReasonDraining, n)
+		case geerpc.StateClosed:
+			_ = pc.client.Close()
+			n--
+			closed(ReasonUnavailable, n)
+		default:
+			conns = append(conns, pc)
+		}
+	}
+	removed := len(p.conns) - len(conns)
+	p.conns = conns
+	return removed
+}
+
+// 关闭没有调用超过 timeout 的连接，至少保留 min 个连接，closed 同 prune
+func (p *connPool) evictIdle(min int, timeout time.Duration, now time.Time, closed func(reason string, conns int)) {
+	if timeout <= 0 {
+		return
+	}
+	conns := p.conns[:0]
+	for i, pc := range p.conns {
+		// 剩余的连接数为已保留的与尚未检查的连接数之和
+		remaining := len(conns) + len(p.conns) - i
+		if remaining > min && pc.active == 0 && now.Sub(pc.lastUsed) >= timeout {
+			_ = pc.client.Close()
+			closed(ReasonIdle, remaining-1)
+			continue
+		}
+		conns = append(conns, pc)
+	}
+	p.conns = conns
+}
+
+// 轮询选择一个连接，需要建立新的连接时返回 nil
+func (p *connPool) pick(cfg PoolConfig) *pooledConn {
+	n := len(p.conns)
+	if n == 0 || n < cfg.MinConns {
+		return nil
+	}
+	if n < cfg.MaxConns && p.busy() {
+		return nil
+	}
+	return p.roundRobin()
+}
+
+func (p *connPool) roundRobin() *pooledConn {
+	pc := p.conns[p.next%len(p.conns)]
+	p.next = (p.next + 1) % len(p.conns)
+	return pc
+}
+
+// 返回是否所有连接都有正在进行的调用
+func (p *connPool) busy() bool {
+	for _, pc := range p.conns {
+		if pc.active == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func (p *connPool) draining() bool {
+	for _, pc := range p.conns {
+		if pc.client.State() == geerpc.StateDraining {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *connPool) close() error {
+	var err error
+	for _, pc := range p.conns {
+		if e := pc.client.Close(); e != nil && err == nil {
+			err = e
+		}
+	}
+	p.conns = nil
+	return err
+}
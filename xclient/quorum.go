@@ -0,0 +1,87 @@
+package xclient
+
+import (
+	"context"
+	"geerpc"
+	"reflect"
+)
+
+type quorumResult struct {
+	reply interface{}
+	err   error
+}
+
+// 一组相同的 reply
+type quorumGroup struct {
+	reply interface{}
+	n     int
+}
+
+// CallQuorum 调用服务列表中的所有实例，有 quorum 个实例返回相同（reflect.DeepEqual）的 reply 时
+// 将该 reply 写入 reply 并取消其余调用。quorum <= 0 时取多数派，即实例数的一半加一。
+// 剩余的实例不足以凑齐 quorum 时立即返回错误：各实例的 reply 不一致时错误码为 Aborted，否则使用最后一个失败调用的错误码
+func (xc *XClient) CallQuorum(ctx context.Context, serviceMethod string, args, reply interface{}, quorum int) error {
+	servers, err := xc.addrs(ctx)
+	if err != nil {
+		return err
+	}
+	if quorum <= 0 {
+		quorum = len(servers)/2 + 1
+	}
+	if quorum > len(servers) {
+		return geerpc.Errorf(geerpc.FailedPrecondition, "rpc xclient: quorum %d exceeds %d servers", quorum, len(servers))
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	results := make(chan quorumResult, len(servers))
+	replyType := reflect.ValueOf(reply).Elem().Type()
+	for _, s := range servers {
+		go func(s string) {
+			clonedReply := reflect.New(replyType).Interface()
+			err := xc.call(s, ctx, serviceMethod, args, clonedReply)
+			results <- quorumResult{reply: clonedReply, err: err}
+		}(s)
+	}
+
+	var groups []*quorumGroup
+	var lastErr error
+	best := 0 // 最大的一组 reply 的数量
+	for remaining := len(servers); remaining > 0; remaining-- {
+		r := <-results
+		if r.err != nil {
+			lastErr = r.err
+		} else {
+			g := findGroup(groups, r.reply)
+			if g == nil {
+				g = &quorumGroup{reply: r.reply}
+				groups = append(groups, g)
+			}
+			g.n++
+			if g.n >= quorum {
+				reflect.ValueOf(reply).Elem().Set(reflect.ValueOf(g.reply).Elem())
+				return nil
+			}
+			if g.n > best {
+				best = g.n
+			}
+		}
+		if best+remaining-1 < quorum {
+			break
+		}
+	}
+
+	if len(groups) > 1 || lastErr == nil {
+		return geerpc.Errorf(geerpc.Aborted, "rpc xclient: quorum %d not reached, %d distinct replies", quorum, len(groups))
+	}
+	return geerpc.Errorf(geerpc.CodeOf(lastErr), "rpc xclient: quorum %d not reached: %v", quorum, lastErr)
+}
+
+func findGroup(groups []*quorumGroup, reply interface{}) *quorumGroup {
+	for _, g := range groups {
+		if reflect.DeepEqual(g.reply, reply) {
+			return g
+		}
+	}
+	return nil
+}
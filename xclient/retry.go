@@ -0,0 +1,82 @@
+package xclient
+
+import (
+	"context"
+	"geerpc"
+	"time"
+)
+
+// RetryPolicy 为 XClient.Call 的故障转移策略，调用失败且错误可重试时换一个实例重新调用
+type RetryPolicy struct {
+	MaxAttempts     int           // 最大尝试次数（包括第一次），小于等于 1 时不重试
+	Backoff         time.Duration // 两次尝试之间的等待时间
+	RetryableErrors []geerpc.Code // 可重试的错误码，为空时仅重试 Unavailable（包括连接失败）
+}
+
+func (p *RetryPolicy) retryable(err error) bool {
+	code := geerpc.CodeOf(err)
+	if len(p.RetryableErrors) == 0 {
+		return code == geerpc.Unavailable
+	}
+	for _, c := range p.RetryableErrors {
+		if c == code {
+			return true
+		}
+	}
+	return false
+}
+
+// XClientOption 用于在 NewXClient 时配置 XClient
+type XClientOption func(*XClient)
+
+// WithRetryPolicy 为 XClient 设置故障转移策略
+func WithRetryPolicy(policy RetryPolicy) XClientOption {
+	return func(xc *XClient) {
+		xc.retry = &policy
+	}
+}
+
+// 按照重试策略调用，每次重试优先选择尚未尝试过的实例
+func (xc *XClient) callWithRetry(ctx context.Context, serviceMethod string, args, reply interface{}) error {
+	tried := make(map[string]bool)
+	var err error
+	for attempt := 0; attempt < xc.retry.MaxAttempts; attempt++ {
+		if attempt > 0 && xc.retry.Backoff > 0 {
+			select {
+			case <-time.After(xc.retry.Backoff):
+			case <-ctx.Done():
+				return err
+			}
+		}
+
+		var rpcAddr string
+		if rpcAddr, err = xc.selectServer(ctx); err != nil {
+			return err
+		}
+		if tried[rpcAddr] {
+			rpcAddr = xc.untried(ctx, rpcAddr, tried)
+		}
+		rpcAddr = xc.avoidDraining(ctx, rpcAddr)
+		tried[rpcAddr] = true
+
+		err = xc.call(rpcAddr, ctx, serviceMethod, args, reply)
+		if err == nil || !xc.retry.retryable(err) || ctx.Err() != nil {
+			return err
+		}
+	}
+	return err
+}
+
+// 返回一个尚未尝试过的实例，全部尝试过时返回 rpcAddr
+func (xc *XClient) untried(ctx context.Context, rpcAddr string, tried map[string]bool) string {
+	servers, err := xc.addrs(ctx)
+	if err != nil {
+		return rpcAddr
+	}
+	for _, server := range servers {
+		if !tried[server] {
+			return server
+		}
+	}
+	return rpcAddr
+}
@@ -0,0 +1,85 @@
+package xclient
+
+import "fmt"
+
+const defaultSplitKey = "tag"
+
+// TrafficSplit 为按实例的元数据分配流量的策略，例如 95% 的调用发往 tag=stable 的实例，5% 发往 tag=canary 的实例，
+// 用于在一小部分生产流量上验证新版本的服务端
+type TrafficSplit struct {
+	// Key 为用于分组的元数据（ServerInfo.Metadata，注册时的 registry.ServerItem.Metadata）的键，默认为 tag
+	Key string
+	// Routes 为每一组实例的流量权重，元数据不属于任何一组的实例不会被选中
+	Routes []SplitRoute
+}
+
+// SplitRoute 为 TrafficSplit 中的一组实例
+type SplitRoute struct {
+	Value  string // 元数据 Key 的值为 Value 的实例属于该组
+	Weight int    // 该组分到的流量权重，占所有组权重之和的比例即流量的比例
+}
+
+// SetTrafficSplit 设置 XClient 的流量分配策略，可以在运行时随时调整，split 为 nil 时取消。
+// 每次调用先按权重随机选择一组，再在组内按 SelectMode 与 zone 策略选择实例，没有可用实例的组不参与选择，
+// 所有组均没有实例时在所有实例中选择。只影响 Call、CallHedged 等按 SelectMode 选择实例的调用，
+// CallWithKey、CallTo 与 Broadcast 不受影响
+func (xc *XClient) SetTrafficSplit(split *TrafficSplit) error {
+	if split != nil {
+		total := 0
+		for _, route := range split.Routes {
+			if route.Weight < 0 {
+				return fmt.Errorf("rpc xclient: negative weight %d for traffic split route %q", route.Weight, route.Value)
+			}
+			total += route.Weight
+		}
+		if total == 0 {
+			return fmt.Errorf("rpc xclient: traffic split has no route with positive weight")
+		}
+		s := *split
+		s.Routes = append([]SplitRoute(nil), split.Routes...)
+		if s.Key == "" {
+			s.Key = defaultSplitKey
+		}
+		split = &s
+	}
+	xc.mu.Lock()
+	xc.split = split
+	xc.mu.Unlock()
+	return nil
+}
+
+// 按流量分配策略选择一组实例，没有设置策略或所有组均没有实例时返回 servers
+func (xc *XClient) splitFilter(servers []ServerInfo) []ServerInfo {
+	xc.mu.Lock()
+	defer xc.mu.Unlock()
+	split := xc.split
+	if split == nil {
+		return servers
+	}
+
+	groups := make([][]ServerInfo, len(split.Routes))
+	total := 0
+	for i, route := range split.Routes {
+		for _, server := range servers {
+			if server.Metadata[split.Key] == route.Value {
+				groups[i] = append(groups[i], server)
+			}
+		}
+		if len(groups[i]) > 0 {
+			total += route.Weight
+		}
+	}
+	if total == 0 {
+		return servers
+	}
+	n := xc.r.Intn(total)
+	for i, route := range split.Routes {
+		if len(groups[i]) == 0 {
+			continue
+		}
+		if n -= route.Weight; n < 0 {
+			return groups[i]
+		}
+	}
+	return servers
+}
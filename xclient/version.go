@@ -0,0 +1,228 @@
+package xclient
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// VersionConstraint 为调用对实例版本（ServerInfo.Version，注册时的 registry.ServerItem.Version）的要求，
+// 由逗号分隔的条件组成，实例需满足所有条件。每个条件为：
+//
+//	v1.2.3、=1.2.3   版本相同，版本号为数字时 1.2 与 1.2.0 相同，否则按字符串比较，例如 canary
+//	1.2.*、v1.*      版本号以 1.2、1 开头，* 匹配任意版本
+//	!=1.2.3          版本不同
+//	>=1.2、>1.2、<=2、<2  按数字版本号比较，版本号不是数字的实例不满足条件
+//
+// 版本号可以带 v 前缀，-、+ 之后的预发布与构建信息在比较时忽略。零值表示不限制版本
+type VersionConstraint struct {
+	raw     string
+	clauses []versionClause
+}
+
+type versionClause struct {
+	op       string // =、!=、>=、>、<=、<
+	version  string
+	num      []int // version 为数字版本号时的各段，否则为 nil
+	wildcard bool  // 形如 1.2.*，num 为前缀
+}
+
+var versionOps = []string{">=", "<=", "!=", ">", "<", "="}
+
+// ParseVersionConstraint 解析版本要求，空字符串表示不限制版本
+func ParseVersionConstraint(s string) (VersionConstraint, error) {
+	c := VersionConstraint{raw: strings.TrimSpace(s)}
+	if c.raw == "" {
+		return c, nil
+	}
+	for _, part := range strings.Split(c.raw, ",") {
+		part = strings.TrimSpace(part)
+		clause := versionClause{op: "="}
+		for _, op := range versionOps {
+			if strings.HasPrefix(part, op) {
+				clause.op, part = op, strings.TrimSpace(part[len(op):])
+				break
+			}
+		}
+		if part == "" {
+			return VersionConstraint{}, fmt.Errorf("rpc xclient: invalid version constraint %q", s)
+		}
+		if part == "*" || strings.HasSuffix(part, ".*") {
+			clause.wildcard = true
+			part = strings.TrimSuffix(strings.TrimSuffix(part, "*"), ".")
+		}
+		clause.version = part
+		clause.num, _ = parseVersion(part)
+		switch {
+		case clause.wildcard && clause.op != "=",
+			clause.wildcard && part != "" && clause.num == nil:
+			return VersionConstraint{}, fmt.Errorf("rpc xclient: invalid wildcard in version constraint %q", s)
+		case clause.op != "=" && clause.op != "!=" && clause.num == nil:
+			return VersionConstraint{}, fmt.Errorf("rpc xclient: version constraint %q requires a numeric version", s)
+		}
+		c.clauses = append(c.clauses, clause)
+	}
+	return c, nil
+}
+
+// MustParseVersionConstraint 与 ParseVersionConstraint 相同，解析失败时 panic，用于常量形式的版本要求
+func MustParseVersionConstraint(s string) VersionConstraint {
+	c, err := ParseVersionConstraint(s)
+	if err != nil {
+		panic(err)
+	}
+	return c
+}
+
+// String 返回解析前的版本要求
+func (c VersionConstraint) String() string {
+	return c.raw
+}
+
+// Match 返回版本 version 是否满足要求
+func (c VersionConstraint) Match(version string) bool {
+	num, numeric := parseVersion(version)
+	for _, clause := range c.clauses {
+		if !clause.match(version, num, numeric) {
+			return false
+		}
+	}
+	return true
+}
+
+func (v versionClause) match(version string, num []int, numeric bool) bool {
+	if v.wildcard {
+		if len(v.num) == 0 {
+			return true
+		}
+		if !numeric || len(num) < len(v.num) {
+			return false
+		}
+		return compareVersion(num[:len(v.num)], v.num) == 0
+	}
+	switch v.op {
+	case "=", "!=":
+		equal := version == v.version
+		if numeric && v.num != nil {
+			equal = compareVersion(num, v.num) == 0
+		}
+		return equal == (v.op == "=")
+	}
+	if !numeric {
+		return false
+	}
+	cmp := compareVersion(num, v.num)
+	switch v.op {
+	case ">=":
+		return cmp >= 0
+	case ">":
+		return cmp > 0
+	case "<=":
+		return cmp <= 0
+	}
+	return cmp < 0
+}
+
+// 解析形如 v1.2.3-rc.1 的版本号，返回各段的数字，忽略 v 前缀与 -、+ 之后的部分
+func parseVersion(s string) ([]int, bool) {
+	s = strings.TrimPrefix(strings.TrimPrefix(s, "v"), "V")
+	if i := strings.IndexAny(s, "-+"); i >= 0 {
+		s = s[:i]
+	}
+	if s == "" {
+		return nil, false
+	}
+	parts := strings.Split(s, ".")
+	num := make([]int, len(parts))
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return nil, false
+		}
+		num[i] = n
+	}
+	return num, true
+}
+
+// 比较两个数字版本号，缺少的段视为 0
+func compareVersion(a, b []int) int {
+	for i := 0; i < len(a) || i < len(b); i++ {
+		var x, y int
+		if i < len(a) {
+			x = a[i]
+		}
+		if i < len(b) {
+			y = b[i]
+		}
+		switch {
+		case x < y:
+			return -1
+		case x > y:
+			return 1
+		}
+	}
+	return 0
+}
+
+// WithVersion 使 XClient 只调用版本满足 c 的实例，可以通过 WithCallVersion 为单次调用覆盖。
+// 没有满足要求的实例时调用返回 ErrNoAvailableServers
+func WithVersion(c VersionConstraint) XClientOption {
+	return func(xc *XClient) {
+		xc.version = c
+	}
+}
+
+type versionKey struct{}
+
+// WithCallVersion 返回覆盖 XClient 版本要求的 ctx，使用该 ctx 的调用只选择版本满足 c 的实例，
+// 例如将部分请求发往新版本的实例以灰度发布新的方法。c 为零值时不限制版本
+func WithCallVersion(ctx context.Context, c VersionConstraint) context.Context {
+	return context.WithValue(ctx, versionKey{}, c)
+}
+
+// 返回 ctx 指定的版本要求，未指定时返回 xc.version
+func (xc *XClient) versionConstraint(ctx context.Context) VersionConstraint {
+	if c, ok := ctx.Value(versionKey{}).(VersionConstraint); ok {
+		return c
+	}
+	return xc.version
+}
+
+// 返回服务列表中满足版本要求的实例
+func (xc *XClient) servers(ctx context.Context) ([]ServerInfo, error) {
+	servers, err := xc.d.GetServers()
+	if err != nil {
+		return nil, err
+	}
+	c := xc.versionConstraint(ctx)
+	if len(c.clauses) == 0 {
+		return servers, nil
+	}
+	filtered := make([]ServerInfo, 0, len(servers))
+	for _, server := range servers {
+		if c.Match(server.Version) {
+			filtered = append(filtered, server)
+		}
+	}
+	if len(filtered) == 0 && len(servers) > 0 {
+		return nil, fmt.Errorf("%w: no server matches version %q", ErrNoAvailableServers, c)
+	}
+	return filtered, nil
+}
+
+// 返回服务列表中满足版本要求的实例的地址
+func (xc *XClient) addrs(ctx context.Context) ([]string, error) {
+	if len(xc.versionConstraint(ctx).clauses) == 0 {
+		return xc.d.GetAll()
+	}
+	servers, err := xc.servers(ctx)
+	if err != nil {
+		return nil, err
+	}
+	addrs := make([]string, 0, len(servers))
+	for _, server := range servers {
+		addrs = append(addrs, server.Addr)
+	}
+	return addrs, nil
+}
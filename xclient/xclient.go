@@ -3,80 +3,321 @@ package xclient
 import (
 	"context"
 	"geerpc"
+	"math/rand"
 	"reflect"
+	"strconv"
+	"strings"
 	"sync"
+	"time"
 )
 
 type XClient struct {
 	d       Discovery
 	mode    SelectMode
 	opt     *geerpc.Option
-	mu      sync.Mutex // protect following
-	clients map[string]*geerpc.Client
+	poolCfg PoolConfig
+	mu      sync.Mutex           // protect following
+	conns   map[string]*connPool // 每个实例的连接
+	ring    *hashRing            // 一致性哈希环，服务列表变化时重建
+	ringKey string               // 构建 ring 时的服务列表
+	active  map[string]int       // 每个实例正在进行的调用数
+	r       *rand.Rand
+	rr      int            // 按 zone 策略选择实例时的轮询位置
+	current map[string]int // 按 zone 策略选择实例时每个实例的平滑加权轮询的当前权重
+	retry   *RetryPolicy
+	pool    *geerpc.TransportPool
+	zone    *ZonePolicy
+	version VersionConstraint
+	split   *TrafficSplit
+
+	warmUp   bool
+	reapIdle time.Duration
+	poolHook func(PoolEvent)
+	stop     chan struct{} // XClient 关闭时关闭，用于停止后台的 reap
 }
 
-func NewXClient(d Discovery, mode SelectMode, opt *geerpc.Option) *XClient {
+// WithTransportPool 使 XClient 通过 pool 共享到每个实例的连接，此时连接使用 pool 的 Option，
+// 多个使用同一个 pool 的 XClient 到同一个实例只建立一个连接，XClient.Close 不会关闭 pool 中的连接
+func WithTransportPool(pool *geerpc.TransportPool) XClientOption {
+	return func(xc *XClient) {
+		xc.pool = pool
+	}
+}
+
+func NewXClient(d Discovery, mode SelectMode, opt *geerpc.Option, opts ...XClientOption) *XClient {
 	if opt == nil {
 		opt = geerpc.DefaultOption
 	}
-	return &XClient{d: d, mode: mode, opt: opt, clients: make(map[string]*geerpc.Client)}
+	xc := &XClient{
+		d:       d,
+		mode:    mode,
+		opt:     opt,
+		conns:   make(map[string]*connPool),
+		active:  make(map[string]int),
+		current: make(map[string]int),
+		r:       rand.New(rand.NewSource(time.Now().UnixNano())),
+		stop:    make(chan struct{}),
+	}
+	for _, o := range opts {
+		o(xc)
+	}
+	if xc.warmUp {
+		ctx := context.Background()
+		if opt.ConnectTimeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, opt.ConnectTimeout)
+			defer cancel()
+		}
+		if err := xc.WarmUp(ctx); err != nil {
+			logger.Errorf("rpc xclient: warm up: %v", err)
+		}
+	}
+	if xc.reapIdle > 0 {
+		go xc.reap(xc.reapIdle)
+	}
+	go xc.watch(d.Watch())
+	return xc
 }
 
 func (xc *XClient) Close() error {
 	xc.mu.Lock()
 	defer xc.mu.Unlock()
 
-	for _, client := range xc.clients {
-		if err := client.Close(); err != nil {
+	select {
+	case <-xc.stop:
+	default:
+		close(xc.stop)
+	}
+
+	for _, p := range xc.conns {
+		if err := p.close(); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
-// 根据 rpcAddr 返回 rpcClient
-func (xc *XClient) dial(rpcAddr string) (*geerpc.Client, error) {
+// 从 rpcAddr 的连接池中取得一个连接，调用结束后通过 release 归还
+func (xc *XClient) dial(rpcAddr string) (*pooledConn, error) {
+	var events []PoolEvent
+	// 在释放锁之后通知
+	defer func() { xc.notify(events) }()
 	xc.mu.Lock()
 	defer xc.mu.Unlock()
 
-	c, ok := xc.clients[rpcAddr]
-	if ok && c.IsAvailable() {
-		_ = c.Close()
-		c = nil
+	p := xc.connPool(rpcAddr)
+	closed := xc.closed(rpcAddr, &events)
+	if p.prune(closed) > 0 && xc.opt.StatsHandler != nil {
+		xc.opt.StatsHandler.Reconnect(rpcAddr)
 	}
+	p.evictIdle(xc.poolCfg.MinConns, xc.poolCfg.IdleTimeout, time.Now(), closed)
 
-	if c == nil {
-		var err error
-		c, err = geerpc.XDial(rpcAddr, xc.opt)
-		if err != nil {
-			return nil, err
+	pc := p.pick(xc.poolCfg)
+	if pc == nil {
+		c, err := xc.newClient(context.Background(), rpcAddr)
+		switch {
+		case err == nil:
+			pc = &pooledConn{client: c}
+			p.conns = append(p.conns, pc)
+			events = append(events, PoolEvent{Type: ConnOpened, Addr: rpcAddr, Conns: len(p.conns)})
+		case len(p.conns) > 0:
+			// 无法建立更多的连接时使用已有的连接
+			events = append(events, PoolEvent{Type: DialFailed, Addr: rpcAddr, Conns: len(p.conns), Err: err})
+			pc = p.roundRobin()
+		default:
+			events = append(events, PoolEvent{Type: DialFailed, Addr: rpcAddr, Err: err})
+			return nil, geerpc.Errorf(geerpc.Unavailable, "rpc xclient: dial %s: %v", rpcAddr, err)
 		}
-		xc.clients[rpcAddr] = c
 	}
+	pc.active++
+	return pc, nil
+}
 
-	return c, nil
+func (xc *XClient) release(pc *pooledConn) {
+	xc.mu.Lock()
+	defer xc.mu.Unlock()
+	pc.active--
+	pc.lastUsed = time.Now()
 }
 
 func (xc *XClient) call(rpcAddr string, ctx context.Context, serviceMethod string, args, reply interface{}) error {
-	c, err := xc.dial(rpcAddr)
+	pc, err := xc.dial(rpcAddr)
 	if err != nil {
+		xc.report(rpcAddr, err)
 		return err
 	}
+	defer xc.release(pc)
+
+	xc.addActive(rpcAddr, 1)
+	defer xc.addActive(rpcAddr, -1)
+	err = pc.client.Call(ctx, serviceMethod, args, reply)
+	xc.report(rpcAddr, err)
+	return err
+}
+
+// Discovery 实现了 FailureReporter 时报告调用 rpcAddr 的结果，调用方取消或超时的调用不能说明实例的状态
+func (xc *XClient) report(rpcAddr string, err error) {
+	reporter, ok := xc.d.(FailureReporter)
+	if code := geerpc.CodeOf(err); !ok || code == geerpc.Canceled || code == geerpc.DeadlineExceeded {
+		return
+	}
+	if isConnFailure(err) {
+		reporter.ReportFailure(rpcAddr)
+	} else {
+		reporter.ReportSuccess(rpcAddr)
+	}
+}
+
+func (xc *XClient) addActive(rpcAddr string, delta int) {
+	xc.mu.Lock()
+	defer xc.mu.Unlock()
+	xc.active[rpcAddr] += delta
+	if xc.active[rpcAddr] <= 0 {
+		delete(xc.active, rpcAddr)
+	}
+}
+
+type selectModeKey struct{}
 
-	return c.Call(ctx, serviceMethod, args, reply)
+// WithSelectMode 返回覆盖 XClient 选择实例策略的 ctx，使用该 ctx 的 Call、CallHedged 以 mode 选择实例，
+// 其余调用仍使用 NewXClient 时指定的 SelectMode
+func WithSelectMode(ctx context.Context, mode SelectMode) context.Context {
+	return context.WithValue(ctx, selectModeKey{}, mode)
+}
+
+// 返回 ctx 指定的选择策略，未指定时返回 xc.mode
+func (xc *XClient) selectMode(ctx context.Context) SelectMode {
+	if mode, ok := ctx.Value(selectModeKey{}).(SelectMode); ok {
+		return mode
+	}
+	return xc.mode
+}
+
+// 根据 SelectMode、版本要求、流量分配与 zone 策略选择实例，ctx 通过 WithSelectMode 指定了策略时使用该策略
+func (xc *XClient) selectServer(ctx context.Context) (string, error) {
+	mode := xc.selectMode(ctx)
+	xc.mu.Lock()
+	split := xc.split
+	xc.mu.Unlock()
+	if mode != LeastActiveSelect && xc.zone == nil && split == nil && len(xc.versionConstraint(ctx).clauses) == 0 {
+		return xc.d.Get(mode)
+	}
+
+	servers, err := xc.servers(ctx)
+	if err != nil {
+		return "", err
+	}
+	if len(servers) == 0 {
+		return "", ErrNoAvailableServers
+	}
+	servers = xc.splitFilter(servers)
+	if xc.zone != nil {
+		servers = xc.zoneFilter(servers)
+	}
+	return xc.pick(servers, mode)
+}
+
+// 返回正在进行的调用数最少的实例，存在多个时随机选择
+func (xc *XClient) leastActive(servers []ServerInfo) string {
+	xc.mu.Lock()
+	defer xc.mu.Unlock()
+
+	var candidates []string
+	least := -1
+	for _, server := range servers {
+		n := xc.active[server.Addr]
+		switch {
+		case least == -1 || n < least:
+			least = n
+			candidates = append(candidates[:0], server.Addr)
+		case n == least:
+			candidates = append(candidates, server.Addr)
+		}
+	}
+	return candidates[xc.r.Intn(len(candidates))]
 }
 
 func (xc *XClient) Call(ctx context.Context, serviceMethod string, args, reply interface{}) error {
-	rpcAddr, err := xc.d.Get(xc.mode)
+	if xc.retry != nil && xc.retry.MaxAttempts > 1 {
+		return xc.callWithRetry(ctx, serviceMethod, args, reply)
+	}
+	rpcAddr, err := xc.selectServer(ctx)
 	if err != nil {
 		return err
 	}
+	return xc.call(xc.avoidDraining(ctx, rpcAddr), ctx, serviceMethod, args, reply)
+}
+
+// CallTo 在实例 rpcAddr 上调用，用于调用方已经确定目标实例的场景（例如按 key 路由的分片），
+// 与其他调用共用 XClient 的连接池。rpcAddr 不需要在服务列表中，不进行重试，也不会因为实例正在关闭而改选其他实例
+func (xc *XClient) CallTo(ctx context.Context, rpcAddr, serviceMethod string, args, reply interface{}) error {
 	return xc.call(rpcAddr, ctx, serviceMethod, args, reply)
 }
 
+// 返回 rpcAddr 的连接是否收到了服务端的 GOAWAY
+func (xc *XClient) isDraining(rpcAddr string) bool {
+	xc.mu.Lock()
+	defer xc.mu.Unlock()
+	p, ok := xc.conns[rpcAddr]
+	return ok && p.draining()
+}
+
+// rpcAddr 正在关闭时刷新服务列表并重新选择实例，优先使用 SelectMode 选出的实例，
+// 其仍在关闭时选择任意一个没有在关闭的实例，均不存在时返回 rpcAddr
+func (xc *XClient) avoidDraining(ctx context.Context, rpcAddr string) string {
+	if !xc.isDraining(rpcAddr) {
+		return rpcAddr
+	}
+	_ = xc.d.Refresh()
+	if addr, err := xc.selectServer(ctx); err == nil && !xc.isDraining(addr) {
+		return addr
+	}
+	servers, err := xc.addrs(ctx)
+	if err != nil {
+		return rpcAddr
+	}
+	for _, server := range servers {
+		if !xc.isDraining(server) {
+			return server
+		}
+	}
+	return rpcAddr
+}
+
+// CallWithKey 使用一致性哈希选择实例，相同 key 的调用会落到同一个实例上
+func (xc *XClient) CallWithKey(ctx context.Context, key, serviceMethod string, args, reply interface{}) error {
+	servers, err := xc.servers(ctx)
+	if err != nil {
+		return err
+	}
+	if len(servers) == 0 {
+		return ErrNoAvailableServers
+	}
+	if xc.zone != nil {
+		servers = xc.zoneFilter(servers)
+	}
+	return xc.call(xc.avoidDraining(ctx, xc.hashRing(servers).get(key)), ctx, serviceMethod, args, reply)
+}
+
+// 返回 servers 对应的哈希环，服务列表未变化时复用上次构建的结果
+func (xc *XClient) hashRing(servers []ServerInfo) *hashRing {
+	var sb strings.Builder
+	for _, server := range servers {
+		sb.WriteString(server.Addr + "#" + strconv.Itoa(server.weight()) + ",")
+	}
+
+	xc.mu.Lock()
+	defer xc.mu.Unlock()
+	if xc.ring == nil || xc.ringKey != sb.String() {
+		xc.ring = newHashRing(defaultReplicas, servers)
+		xc.ringKey = sb.String()
+	}
+	return xc.ring
+}
+
 // Broadcast invokes the named function for every server registered in discovery
 func (xc *XClient) Broadcast(ctx context.Context, serviceMethod string, args, reply interface{}) error {
-	servers, err := xc.d.GetAll()
+	servers, err := xc.addrs(ctx)
 	if err != nil {
 		return err
 	}
@@ -87,6 +328,7 @@ func (xc *XClient) Broadcast(ctx context.Context, serviceMethod string, args, re
 	var e error
 	var replyDone bool
 	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
 
 	for _, s := range servers {
 		wg.Add(1)
@@ -0,0 +1,613 @@
+package xclient
+
+import (
+	"context"
+	"errors"
+	"geerpc"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestXClient_LeastActive(t *testing.T) {
+	d := NewMultiServersDiscovery([]string{"a", "b", "c"})
+	xc := NewXClient(d, LeastActiveSelect, nil)
+
+	xc.addActive("a", 2)
+	xc.addActive("b", 1)
+	xc.addActive("c", 1)
+	for i := 0; i < 10; i++ {
+		addr, err := xc.selectServer(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if addr != "b" && addr != "c" {
+			t.Fatalf("expect b or c, got %s", addr)
+		}
+	}
+
+	xc.addActive("b", -1)
+	if addr, _ := xc.selectServer(context.Background()); addr != "b" {
+		t.Fatalf("expect b, got %s", addr)
+	}
+
+	// 单次调用覆盖选择策略
+	rr := NewXClient(d, RandomSelect, nil)
+	defer func() { _ = rr.Close() }()
+	rr.addActive("a", 1)
+	rr.addActive("c", 1)
+	ctx := WithSelectMode(context.Background(), LeastActiveSelect)
+	for i := 0; i < 10; i++ {
+		if addr, _ := rr.selectServer(ctx); addr != "b" {
+			t.Fatalf("expect overridden mode to select b, got %s", addr)
+		}
+	}
+}
+
+func TestXClient_CallTo(t *testing.T) {
+	listed, other := startArith(t, 0), startArith(t, 0)
+	xc := NewXClient(NewMultiServersDiscovery([]string{listed}), RandomSelect, nil)
+	defer func() { _ = xc.Close() }()
+
+	var reply int
+	if err := xc.CallTo(context.Background(), other, "Arith.Add", [2]int{1, 2}, &reply); err != nil || reply != 3 {
+		t.Fatalf("expect 3 from %s, got %d: %v", other, reply, err)
+	}
+	xc.mu.Lock()
+	_, ok := xc.conns[other]
+	xc.mu.Unlock()
+	if !ok {
+		t.Fatal("expect connection to the target to be pooled")
+	}
+}
+
+type Arith struct {
+	delay time.Duration
+}
+
+func (a *Arith) Add(args [2]int, reply *int) error {
+	time.Sleep(a.delay)
+	*reply = args[0] + args[1]
+	return nil
+}
+
+// 启动一个每次调用耗时 delay 的服务端
+func startArith(t *testing.T, delay time.Duration) string {
+	server := geerpc.NewServer()
+	_ = server.Register(&Arith{delay: delay})
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = l.Close() })
+	go server.Accept(l)
+	return "tcp@" + l.Addr().String()
+}
+
+func TestXClient_Retry(t *testing.T) {
+	addr := startArith(t, 0)
+
+	// 关闭后的端口用作不可用的实例
+	dead, _ := net.Listen("tcp", "127.0.0.1:0")
+	_ = dead.Close()
+
+	d := NewMultiServersDiscovery([]string{"tcp@" + dead.Addr().String(), addr})
+	xc := NewXClient(d, RoundRobinSelect, nil, WithRetryPolicy(RetryPolicy{MaxAttempts: 2}))
+	defer func() { _ = xc.Close() }()
+
+	for i := 0; i < 4; i++ {
+		var reply int
+		if err := xc.Call(context.Background(), "Arith.Add", [2]int{i, 1}, &reply); err != nil {
+			t.Fatalf("expect call to fail over, got %v", err)
+		}
+		if reply != i+1 {
+			t.Fatalf("expect %d, got %d", i+1, reply)
+		}
+	}
+
+	// 使用新的 Discovery，上面的调用已经摘除了不可用的实例
+	d = NewMultiServersDiscovery([]string{"tcp@" + dead.Addr().String(), addr})
+	strict := NewXClient(d, RoundRobinSelect, nil, WithRetryPolicy(RetryPolicy{MaxAttempts: 2, RetryableErrors: []geerpc.Code{geerpc.Internal}}))
+	defer func() { _ = strict.Close() }()
+	var failed bool
+	for i := 0; i < 2; i++ {
+		err := strict.Call(context.Background(), "Arith.Add", [2]int{i, 1}, new(int))
+		failed = failed || geerpc.CodeOf(err) == geerpc.Unavailable
+	}
+	if !failed {
+		t.Fatal("expect Unavailable not to be retried")
+	}
+}
+
+func TestXClient_CallHedged(t *testing.T) {
+	d := NewMultiServersDiscovery([]string{startArith(t, time.Second), startArith(t, 0)})
+	xc := NewXClient(d, RoundRobinSelect, nil)
+	defer func() { _ = xc.Close() }()
+
+	for i := 0; i < 4; i++ {
+		var reply int
+		start := time.Now()
+		err := xc.CallHedged(context.Background(), "Arith.Add", [2]int{i, 1}, &reply, time.Millisecond*50)
+		if err != nil || reply != i+1 {
+			t.Fatalf("expect %d, got %d, err: %v", i+1, reply, err)
+		}
+		if elapsed := time.Since(start); elapsed > time.Millisecond*500 {
+			t.Fatalf("expect hedged call to skip the slow server, took %s", elapsed)
+		}
+	}
+}
+
+type Slow struct {
+	started chan struct{}
+	release chan struct{}
+}
+
+func (s *Slow) Wait(_ int, reply *int) error {
+	s.started <- struct{}{}
+	<-s.release
+	return nil
+}
+
+func TestXClient_Draining(t *testing.T) {
+	slow := &Slow{started: make(chan struct{}, 1), release: make(chan struct{})}
+	server := geerpc.NewServer()
+	_ = server.Register(&Arith{})
+	_ = server.Register(slow)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Accept(l)
+	draining := "tcp@" + l.Addr().String()
+	addr := startArith(t, 0)
+
+	xc := NewXClient(NewMultiServersDiscovery([]string{draining, addr}), RoundRobinSelect, nil)
+	defer func() { _ = xc.Close() }()
+
+	// 关闭期间仍在处理的调用
+	inflight := make(chan error, 1)
+	go func() {
+		inflight <- xc.call(draining, context.Background(), "Slow.Wait", 0, new(int))
+	}()
+	<-slow.started
+	shutdown := make(chan error, 1)
+	go func() { shutdown <- server.Shutdown(context.Background()) }()
+	for i := 0; !xc.isDraining(draining); i++ {
+		if i == 100 {
+			t.Fatal("expect client to receive GOAWAY")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	for i := 0; i < 4; i++ {
+		var reply int
+		if err := xc.Call(context.Background(), "Arith.Add", [2]int{i, 1}, &reply); err != nil || reply != i+1 {
+			t.Fatalf("expect call to avoid the draining server, got %d, %v", reply, err)
+		}
+	}
+	close(slow.release)
+	if err := <-inflight; err != nil {
+		t.Fatalf("expect in-flight call to complete, got %v", err)
+	}
+	if err := <-shutdown; err != nil {
+		t.Fatalf("expect Shutdown to return nil, got %v", err)
+	}
+}
+
+func TestXClient_TransportPool(t *testing.T) {
+	addr := startArith(t, 0)
+	pool := geerpc.NewTransportPool(nil)
+	defer func() { _ = pool.Close() }()
+
+	d := NewMultiServersDiscovery([]string{addr})
+	xc1 := NewXClient(d, RandomSelect, nil, WithTransportPool(pool))
+	xc2 := NewXClient(d, RandomSelect, nil, WithTransportPool(pool))
+	for _, xc := range []*XClient{xc1, xc2} {
+		var reply int
+		if err := xc.Call(context.Background(), "Arith.Add", [2]int{1, 2}, &reply); err != nil || reply != 3 {
+			t.Fatalf("expect 3, got %d, %v", reply, err)
+		}
+	}
+	if xc1.conns[addr].conns[0].client.Transport() != xc2.conns[addr].conns[0].client.Transport() {
+		t.Fatal("expect XClients to share one connection per address")
+	}
+
+	// 关闭一个 XClient 不影响共享连接的其他 XClient
+	_ = xc1.Close()
+	var reply int
+	if err := xc2.Call(context.Background(), "Arith.Add", [2]int{2, 2}, &reply); err != nil || reply != 4 {
+		t.Fatalf("expect 4 after closing the other XClient, got %d, %v", reply, err)
+	}
+	_ = xc2.Close()
+}
+
+func TestXClient_ConnPool(t *testing.T) {
+	slow := &Slow{started: make(chan struct{}, 2), release: make(chan struct{})}
+	server := geerpc.NewServer()
+	_ = server.Register(&Arith{})
+	_ = server.Register(slow)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = l.Close() }()
+	go server.Accept(l)
+	addr := "tcp@" + l.Addr().String()
+
+	xc := NewXClient(NewMultiServersDiscovery([]string{addr}), RandomSelect, nil,
+		WithConnPool(PoolConfig{MinConns: 1, MaxConns: 2, IdleTimeout: 50 * time.Millisecond}))
+	defer func() { _ = xc.Close() }()
+	conns := func() int {
+		xc.mu.Lock()
+		defer xc.mu.Unlock()
+		return len(xc.conns[addr].conns)
+	}
+
+	// 唯一的连接有正在进行的调用时建立第二个连接
+	done := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() { done <- xc.Call(context.Background(), "Slow.Wait", 0, new(int)) }()
+		<-slow.started
+	}
+	if n := conns(); n != 2 {
+		t.Fatalf("expect 2 connections while busy, got %d", n)
+	}
+	var reply int
+	if err := xc.Call(context.Background(), "Arith.Add", [2]int{1, 2}, &reply); err != nil || reply != 3 {
+		t.Fatalf("expect call to use existing connections at MaxConns, got %d, %v", reply, err)
+	}
+	if n := conns(); n != 2 {
+		t.Fatalf("expect at most 2 connections, got %d", n)
+	}
+	close(slow.release)
+	for i := 0; i < 2; i++ {
+		if err := <-done; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 空闲超过 IdleTimeout 的连接被关闭，保留 MinConns 个
+	time.Sleep(60 * time.Millisecond)
+	if err := xc.Call(context.Background(), "Arith.Add", [2]int{1, 2}, &reply); err != nil {
+		t.Fatal(err)
+	}
+	if n := conns(); n != 1 {
+		t.Fatalf("expect idle connection to be evicted, got %d connections", n)
+	}
+}
+
+func TestXClient_WarmUpAndReaping(t *testing.T) {
+	addrs := []string{startArith(t, 0), startArith(t, 0)}
+	events := make(chan PoolEvent, 10)
+	xc := NewXClient(NewMultiServersDiscovery(addrs), RoundRobinSelect, nil,
+		WithWarmUp(), WithIdleReaping(50*time.Millisecond), WithPoolHook(func(e PoolEvent) { events <- e }))
+	defer func() { _ = xc.Close() }()
+
+	// 返回前已经连接所有实例
+	stats := xc.PoolStats()
+	if len(stats) != 2 || stats[0].Conns != 1 || stats[1].Conns != 1 {
+		t.Fatalf("expect one connection to each server after warm up, got %+v", stats)
+	}
+	for i := 0; i < 2; i++ {
+		if e := <-events; e.Type != ConnOpened || e.Conns != 1 {
+			t.Fatalf("expect ConnOpened, got %+v", e)
+		}
+	}
+
+	var reply int
+	if err := xc.Call(context.Background(), "Arith.Add", [2]int{1, 2}, &reply); err != nil || reply != 3 {
+		t.Fatalf("expect 3, got %d, %v", reply, err)
+	}
+	if len(events) != 0 {
+		t.Fatalf("expect call to reuse warmed up connection, got %+v", <-events)
+	}
+
+	// 空闲的连接被后台关闭
+	for i := 0; i < 2; i++ {
+		select {
+		case e := <-events:
+			if e.Type != ConnClosed || e.Reason != ReasonIdle || e.Conns != 0 {
+				t.Fatalf("expect idle connection to be closed, got %+v", e)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("expect idle connections to be reaped")
+		}
+	}
+	if stats := xc.PoolStats(); len(stats) != 0 {
+		t.Fatalf("expect no connections after reaping, got %+v", stats)
+	}
+}
+
+func TestXClient_EjectFailedServer(t *testing.T) {
+	addr := startArith(t, 0)
+	dead, _ := net.Listen("tcp", "127.0.0.1:0")
+	_ = dead.Close()
+
+	xc := NewXClient(NewMultiServersDiscovery([]string{"tcp@" + dead.Addr().String(), addr}), RoundRobinSelect, nil)
+	defer func() { _ = xc.Close() }()
+	var failures int
+	for i := 0; i < 6; i++ {
+		if err := xc.Call(context.Background(), "Arith.Add", [2]int{i, 1}, new(int)); err != nil {
+			failures++
+		}
+	}
+	if failures != 1 {
+		t.Fatalf("expect only the first call to the dead server to fail, got %d failures", failures)
+	}
+}
+
+func TestXClient_WatchRemovesServers(t *testing.T) {
+	addrs := []string{startArith(t, 0), startArith(t, 0)}
+	d := NewMultiServersDiscovery(addrs)
+	events := make(chan PoolEvent, 10)
+	xc := NewXClient(d, RoundRobinSelect, nil, WithWarmUp(), WithPoolHook(func(e PoolEvent) { events <- e }))
+	defer func() { _ = xc.Close() }()
+	<-events
+	<-events
+
+	_ = d.Update(addrs[:1])
+	select {
+	case e := <-events:
+		if e.Type != ConnClosed || e.Reason != ReasonRemoved || e.Addr != addrs[1] {
+			t.Fatalf("expect connection to removed server to be closed, got %+v", e)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expect XClient to watch server list changes")
+	}
+	if stats := xc.PoolStats(); len(stats) != 1 || stats[0].Addr != addrs[0] {
+		t.Fatalf("expect only the remaining server in pool, got %+v", stats)
+	}
+}
+
+func TestXClient_ZonePolicy(t *testing.T) {
+	local, remote := startArith(t, 0), startArith(t, 0)
+	d := NewWeightedServersDiscovery([]ServerInfo{{Addr: local, Zone: "z1"}, {Addr: remote, Zone: "z2"}})
+	xc := NewXClient(d, RoundRobinSelect, nil, WithZonePolicy(ZonePolicy{Zone: "z1"}))
+	defer func() { _ = xc.Close() }()
+
+	for i := 0; i < 4; i++ {
+		if err := xc.Call(context.Background(), "Arith.Add", [2]int{i, 1}, new(int)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if stats := xc.PoolStats(); len(stats) != 1 || stats[0].Addr != local {
+		t.Fatalf("expect calls to stay in the local zone, got %+v", stats)
+	}
+
+	// 同一 zone 的实例不可用时溢出到其他 zone
+	dead, _ := net.Listen("tcp", "127.0.0.1:0")
+	_ = dead.Close()
+	_ = d.UpdateServers([]ServerInfo{{Addr: "tcp@" + dead.Addr().String(), Zone: "z1"}, {Addr: remote, Zone: "z2"}})
+	var failures int
+	for i := 0; i < 4; i++ {
+		if err := xc.Call(context.Background(), "Arith.Add", [2]int{i, 1}, new(int)); err != nil {
+			failures++
+		}
+	}
+	if failures != 1 {
+		t.Fatalf("expect to spill over to other zones after the local server failed, got %d failures", failures)
+	}
+}
+
+func TestXClient_BroadcastAll(t *testing.T) {
+	dead, _ := net.Listen("tcp", "127.0.0.1:0")
+	_ = dead.Close()
+	servers := []string{startArith(t, 0), "tcp@" + dead.Addr().String(), startArith(t, 0)}
+	xc := NewXClient(NewMultiServersDiscovery(servers), RandomSelect, nil)
+	defer func() { _ = xc.Close() }()
+
+	results, err := xc.BroadcastAll(context.Background(), "Arith.Add", [2]int{1, 2},
+		BroadcastConcurrency(1), BroadcastReply(func() interface{} { return new(int) }))
+	if err != nil || len(results) != 3 {
+		t.Fatalf("broadcast all: %v, %d results", err, len(results))
+	}
+	for i, r := range results {
+		if r.Addr != servers[i] {
+			t.Fatalf("result %d is for %s, want %s", i, r.Addr, servers[i])
+		}
+		if i == 1 {
+			if r.Err == nil {
+				t.Fatal("expect error from dead server")
+			}
+			continue
+		}
+		if r.Err != nil || *r.Reply.(*int) != 3 {
+			t.Fatalf("result %d: %v, %v", i, r.Reply, r.Err)
+		}
+	}
+
+	// 失败的实例已被摘除，使用新的服务列表
+	xc2 := NewXClient(NewMultiServersDiscovery(servers), RandomSelect, nil)
+	defer func() { _ = xc2.Close() }()
+	_, err = xc2.BroadcastAll(context.Background(), "Arith.Add", [2]int{1, 2}, BroadcastFailFast())
+	if err == nil {
+		t.Fatal("expect fail fast error")
+	}
+}
+
+func TestXClient_CallQuorum(t *testing.T) {
+	dead, _ := net.Listen("tcp", "127.0.0.1:0")
+	_ = dead.Close()
+	servers := []string{startArith(t, 0), startArith(t, time.Second), startArith(t, 0), "tcp@" + dead.Addr().String()}
+	xc := NewXClient(NewMultiServersDiscovery(servers), RandomSelect, nil)
+	defer func() { _ = xc.Close() }()
+
+	start := time.Now()
+	var reply int
+	if err := xc.CallQuorum(context.Background(), "Arith.Add", [2]int{1, 2}, &reply, 2); err != nil || reply != 3 {
+		t.Fatalf("expect 3, got %d, err: %v", reply, err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Millisecond*500 {
+		t.Fatalf("expect quorum call to skip the slow server, took %s", elapsed)
+	}
+
+	xc2 := NewXClient(NewMultiServersDiscovery(servers), RandomSelect, nil)
+	defer func() { _ = xc2.Close() }()
+	if err := xc2.CallQuorum(context.Background(), "Arith.Add", [2]int{1, 2}, &reply, 4); err == nil {
+		t.Fatal("expect quorum error with a dead server")
+	}
+}
+
+func TestXClient_Map(t *testing.T) {
+	a, b := startArith(t, 0), startArith(t, 0)
+	xc := NewXClient(NewMultiServersDiscovery([]string{a, b}), RandomSelect, nil)
+	defer func() { _ = xc.Close() }()
+
+	sum := 0
+	shards := map[string]interface{}{a: [2]int{1, 2}, b: [2]int{3, 4}}
+	err := xc.Map(context.Background(), "Arith.Add", shards, func(addr string, reply interface{}) error {
+		sum += *reply.(*int)
+		return nil
+	}, BroadcastReply(func() interface{} { return new(int) }))
+	if err != nil || sum != 10 {
+		t.Fatalf("expect 10, got %d, err: %v", sum, err)
+	}
+
+	stop := errors.New("stop")
+	err = xc.Map(context.Background(), "Arith.Add", shards, func(string, interface{}) error {
+		return stop
+	}, BroadcastConcurrency(1))
+	if err != stop {
+		t.Fatalf("expect reducer error, got %v", err)
+	}
+}
+
+func TestVersionConstraint(t *testing.T) {
+	tests := []struct {
+		constraint string
+		match      []string
+		mismatch   []string
+	}{
+		{"", []string{"", "v1", "canary"}, nil},
+		{"v1.2", []string{"1.2", "v1.2.0", "1.2.0-rc.1"}, []string{"1.2.1", "canary", ""}},
+		{"canary", []string{"canary"}, []string{"1.0", ""}},
+		{"1.*", []string{"1", "v1.9.3"}, []string{"2.0", "canary"}},
+		{"*", []string{"1.0", "canary", ""}, nil},
+		{">=1.2, <2", []string{"1.2", "1.10.1"}, []string{"1.1.9", "2.0", "canary"}},
+		{"!=1.3", []string{"1.2", "canary"}, []string{"v1.3.0"}},
+	}
+	for _, tt := range tests {
+		c, err := ParseVersionConstraint(tt.constraint)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, v := range tt.match {
+			if !c.Match(v) {
+				t.Fatalf("expect %q to match %q", v, tt.constraint)
+			}
+		}
+		for _, v := range tt.mismatch {
+			if c.Match(v) {
+				t.Fatalf("expect %q not to match %q", v, tt.constraint)
+			}
+		}
+	}
+	for _, s := range []string{">=canary", ">1.*", "1.2,", "x.*"} {
+		if _, err := ParseVersionConstraint(s); err == nil {
+			t.Fatalf("expect %q to be invalid", s)
+		}
+	}
+}
+
+func TestXClient_Version(t *testing.T) {
+	stable, canary := startArith(t, 0), startArith(t, 0)
+	d := NewWeightedServersDiscovery([]ServerInfo{{Addr: stable, Version: "v1.4.2"}, {Addr: canary, Version: "v1.5.0"}})
+	xc := NewXClient(d, RoundRobinSelect, nil, WithVersion(MustParseVersionConstraint("<1.5")))
+	defer func() { _ = xc.Close() }()
+
+	for i := 0; i < 4; i++ {
+		if addr, err := xc.selectServer(context.Background()); err != nil || addr != stable {
+			t.Fatalf("expect %s, got %s: %v", stable, addr, err)
+		}
+	}
+	// 单次调用覆盖版本要求
+	ctx := WithCallVersion(context.Background(), MustParseVersionConstraint("1.5.*"))
+	if err := xc.Call(ctx, "Arith.Add", [2]int{1, 2}, new(int)); err != nil {
+		t.Fatal(err)
+	}
+	if results, err := xc.BroadcastAll(ctx, "Arith.Add", [2]int{1, 2}); err != nil || len(results) != 1 || results[0].Addr != canary {
+		t.Fatalf("expect broadcast to reach only %s, got %+v: %v", canary, results, err)
+	}
+
+	ctx = WithCallVersion(context.Background(), MustParseVersionConstraint(">=2"))
+	if err := xc.Call(ctx, "Arith.Add", [2]int{1, 2}, new(int)); !errors.Is(err, ErrNoAvailableServers) {
+		t.Fatalf("expect ErrNoAvailableServers, got %v", err)
+	}
+}
+
+func TestXClient_VersionSelectMode(t *testing.T) {
+	d := NewWeightedServersDiscovery([]ServerInfo{
+		{Addr: "a", Weight: 5, Version: "v1.4"},
+		{Addr: "b", Weight: 1, Version: "v1.4"},
+		{Addr: "c", Weight: 1, Version: "v1.4"},
+		{Addr: "d", Weight: 9, Version: "v2.0"},
+	})
+	xc := NewXClient(d, WeightedRoundRobinSelect, nil, WithVersion(MustParseVersionConstraint("<2")))
+	defer func() { _ = xc.Close() }()
+
+	// 版本过滤后仍按平滑加权轮询选择
+	var seq string
+	for i := 0; i < 7; i++ {
+		addr, err := xc.selectServer(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		seq += addr
+	}
+	if seq != "aabacaa" {
+		t.Fatalf("expect smooth weighted round robin sequence aabacaa, got %s", seq)
+	}
+
+	ctx := WithSelectMode(context.Background(), ConsistentHashSelect)
+	if _, err := xc.selectServer(ctx); err == nil || !strings.Contains(err.Error(), "requires a key") {
+		t.Fatalf("expect consistent hash select to require a key, got %v", err)
+	}
+}
+
+func TestXClient_TrafficSplit(t *testing.T) {
+	d := NewWeightedServersDiscovery([]ServerInfo{
+		{Addr: "s1", Metadata: map[string]string{"tag": "stable"}},
+		{Addr: "s2", Metadata: map[string]string{"tag": "stable"}},
+		{Addr: "c1", Metadata: map[string]string{"tag": "canary"}},
+		{Addr: "x"},
+	})
+	xc := NewXClient(d, RoundRobinSelect, nil)
+	defer func() { _ = xc.Close() }()
+
+	if err := xc.SetTrafficSplit(&TrafficSplit{Routes: []SplitRoute{{Value: "stable", Weight: 0}}}); err == nil {
+		t.Fatal("expect error for traffic split without positive weight")
+	}
+	if err := xc.SetTrafficSplit(&TrafficSplit{Routes: []SplitRoute{{Value: "stable", Weight: 90}, {Value: "canary", Weight: 10}}}); err != nil {
+		t.Fatal(err)
+	}
+	counts := make(map[string]int)
+	for i := 0; i < 1000; i++ {
+		addr, err := xc.selectServer(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		counts[addr]++
+	}
+	if counts["x"] != 0 || counts["c1"] < 50 || counts["c1"] > 150 {
+		t.Fatalf("unexpected distribution %v", counts)
+	}
+
+	// 没有实例的组不参与选择
+	_ = xc.SetTrafficSplit(&TrafficSplit{Routes: []SplitRoute{{Value: "stable", Weight: 1}, {Value: "missing", Weight: 99}}})
+	for i := 0; i < 10; i++ {
+		if addr, _ := xc.selectServer(context.Background()); addr != "s1" && addr != "s2" {
+			t.Fatalf("expect stable servers, got %s", addr)
+		}
+	}
+	// 取消后在所有实例中选择
+	_ = xc.SetTrafficSplit(nil)
+	counts = make(map[string]int)
+	for i := 0; i < 8; i++ {
+		addr, _ := xc.selectServer(context.Background())
+		counts[addr]++
+	}
+	if len(counts) != 4 {
+		t.Fatalf("expect all servers to be selected, got %v", counts)
+	}
+}
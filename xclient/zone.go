@@ -0,0 +1,82 @@
+package xclient
+
+import "errors"
+
+// ZonePolicy 为优先选择同一 zone 的实例的策略，用于降低跨可用区调用的延迟与流量费用
+type ZonePolicy struct {
+	// Zone 为客户端所在的 zone，与实例注册时的 ServerInfo.Zone 比较
+	Zone string
+	// MinHealthy 为同一 zone 可用实例数的下限，低于该值时在所有 zone 的可用实例中选择，默认为 1。
+	// 因连接失败被摘除或正在关闭的实例视为不可用
+	MinHealthy int
+}
+
+// WithZonePolicy 使 XClient 按 policy 优先选择同一 zone 的实例。同一 zone 的实例中按 SelectMode 选择，
+// ConsistentHashSelect 见 CallWithKey
+func WithZonePolicy(policy ZonePolicy) XClientOption {
+	return func(xc *XClient) {
+		if policy.MinHealthy <= 0 {
+			policy.MinHealthy = 1
+		}
+		xc.zone = &policy
+	}
+}
+
+// 返回按 zone 策略参与选择的实例，同一 zone 的可用实例不足 MinHealthy 时返回所有可用实例
+func (xc *XClient) zoneFilter(servers []ServerInfo) []ServerInfo {
+	healthy := make([]ServerInfo, 0, len(servers))
+	local := make([]ServerInfo, 0, len(servers))
+	for _, server := range servers {
+		if xc.isDraining(server.Addr) {
+			continue
+		}
+		healthy = append(healthy, server)
+		if server.Zone == xc.zone.Zone {
+			local = append(local, server)
+		}
+	}
+	switch {
+	case len(local) >= xc.zone.MinHealthy:
+		return local
+	case len(healthy) > 0:
+		return healthy
+	}
+	return servers
+}
+
+// 按 mode 在 servers 中选择一个实例，servers 不能为空，选择策略的语义与 Discovery.Get 相同
+func (xc *XClient) pick(servers []ServerInfo, mode SelectMode) (string, error) {
+	if mode == LeastActiveSelect {
+		return xc.leastActive(servers), nil
+	}
+
+	xc.mu.Lock()
+	defer xc.mu.Unlock()
+	switch mode {
+	case RandomSelect:
+		return servers[xc.r.Intn(len(servers))].Addr, nil
+	case RoundRobinSelect:
+		xc.rr++
+		return servers[xc.rr%len(servers)].Addr, nil
+	case WeightedRoundRobinSelect:
+		return xc.nextWeighted(servers), nil
+	case ConsistentHashSelect:
+		return "", errors.New("rpc xclient: consistent hash select requires a key, use XClient.CallWithKey")
+	}
+	return "", errors.New("rpc xclient: not supported select mode")
+}
+
+// 在 servers 中平滑加权轮询，与 MultiServersDiscovery 相同，当前权重按地址记录，调用方需持有 xc.mu
+func (xc *XClient) nextWeighted(servers []ServerInfo) string {
+	total, best := 0, servers[0].Addr
+	for _, server := range servers {
+		w := server.weight()
+		total += w
+		xc.current[server.Addr] += w
+		if xc.current[server.Addr] > xc.current[best] {
+			best = server.Addr
+		}
+	}
+	xc.current[best] -= total
+	return best
+}